@@ -27,6 +27,7 @@ import {
   DEFAULT_RUNTIME_RESOURCE_IDS,
   DEFAULT_STASH_TRANSFER_AMOUNTS,
   RuntimeBlockDelta,
+  RuntimeCraftRecipeDefinition,
   RuntimeSpawnHint,
   WORLD_SHARED_CONTAINER_ID,
   WorldRuntimeClient,
@@ -35,6 +36,7 @@ import {
   clampTransferAmountIndex,
   cycleRuntimeResourceIndex,
   cycleTransferAmountIndex,
+  craftRecipesFromContentCatalog,
   createRuntimeClient,
   createAnimationState,
   formatRuntimeResourceLabel,
@@ -380,7 +382,6 @@ const HOTBAR_KEY_TO_INDEX = new Map(
   Array.from({ length: HOTBAR_SLOTS.length }, (_, index) => [String(index + 1), index]),
 );
 const HOTBAR_SLOT_BY_ID = new Map(HOTBAR_SLOTS.map((slot) => [slot.id, slot]));
-const CRAFT_RECIPE_BY_ID = new Map(DEFAULT_RUNTIME_CRAFT_RECIPES.map((recipe) => [recipe.id, recipe]));
 const DEFAULT_MAX_HEALTH = 10;
 
 const initialCombatHud: CombatHudState = {
@@ -464,12 +465,13 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
     );
   }, [hotbarStackCounts]);
   const [selectedHotbarIndex, setSelectedHotbarIndex] = useState(0);
+  const [craftRecipes, setCraftRecipes] = useState<RuntimeCraftRecipeDefinition[]>(DEFAULT_RUNTIME_CRAFT_RECIPES);
   const [selectedCraftRecipeIndex, setSelectedCraftRecipeIndex] = useState(0);
   const [selectedStashResourceIndex, setSelectedStashResourceIndex] = useState(0);
   const [selectedTransferAmountIndex, setSelectedTransferAmountIndex] = useState(0);
   const selectedCraftRecipe = useMemo(
-    () => resolveCraftRecipeByIndex(selectedCraftRecipeIndex),
-    [selectedCraftRecipeIndex],
+    () => resolveCraftRecipeByIndex(selectedCraftRecipeIndex, craftRecipes),
+    [craftRecipes, selectedCraftRecipeIndex],
   );
   const selectedStashResourceId = useMemo(
     () => resolveRuntimeResourceId(selectedStashResourceIndex),
@@ -497,6 +499,7 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
   const cameraModeRef = useRef<CameraMode>(profile.preferredCamera);
   const menuOpenRef = useRef(menuOpen);
   const selectedHotbarRef = useRef(0);
+  const craftRecipesRef = useRef<RuntimeCraftRecipeDefinition[]>(DEFAULT_RUNTIME_CRAFT_RECIPES);
   const selectedCraftRecipeIndexRef = useRef(0);
   const selectedStashResourceIndexRef = useRef(0);
   const selectedTransferAmountIndexRef = useRef(0);
@@ -538,7 +541,7 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
   }
 
   function handleCraftRecipeSelect(index: number): void {
-    setSelectedCraftRecipeIndex(clampCraftRecipeIndex(index));
+    setSelectedCraftRecipeIndex(clampCraftRecipeIndex(index, craftRecipesRef.current));
   }
 
   function handleStashResourceSelect(index: number): void {
@@ -583,6 +586,11 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
     }));
   }, [hotbarSlots, selectedHotbarIndex]);
 
+  useEffect(() => {
+    craftRecipesRef.current = craftRecipes;
+    setSelectedCraftRecipeIndex((previous) => clampCraftRecipeIndex(previous, craftRecipes));
+  }, [craftRecipes]);
+
   useEffect(() => {
     selectedCraftRecipeIndexRef.current = selectedCraftRecipeIndex;
   }, [selectedCraftRecipeIndex]);
@@ -1273,11 +1281,25 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
       });
     });
 
+    const runtimeContentCatalogUnsubscribe = runtimeClient.subscribeContentCatalogs((catalog) => {
+      setCraftRecipes(craftRecipesFromContentCatalog(catalog));
+    });
+
+    const runtimeErrorUnsubscribe = runtimeClient.subscribeErrors((error) => {
+      const messageType = error.messageType ?? "message";
+      pushHudToast(`Server rejected ${messageType}: ${error.code}`, "error");
+      updateCombatStatus({
+        lastAction: "server_error",
+        status: `Server rejected ${messageType} (${error.code})`,
+      });
+    });
+
     const runtimeCraftUnsubscribe = runtimeClient.subscribeCraftResults((result) => {
       if (result.playerId !== profile.id) {
         return;
       }
-      const recipeLabel = CRAFT_RECIPE_BY_ID.get(result.recipeId)?.label ?? result.recipeId;
+      const recipeLabel =
+        craftRecipesRef.current.find((recipe) => recipe.id === result.recipeId)?.label ?? result.recipeId;
       if (!result.accepted) {
         const reason = formatCraftRejectReason(result.reason);
         pushHudToast(`Craft failed: ${reason}`, "error");
@@ -2540,11 +2562,11 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
         event.preventDefault();
         return;
       }
-      const craftRecipeIndex = resolveCraftRecipeIndexForKey(key);
+      const craftRecipeIndex = resolveCraftRecipeIndexForKey(key, craftRecipesRef.current);
       if (craftRecipeIndex !== undefined) {
-        const nextCraftIndex = clampCraftRecipeIndex(craftRecipeIndex);
+        const nextCraftIndex = clampCraftRecipeIndex(craftRecipeIndex, craftRecipesRef.current);
         setSelectedCraftRecipeIndex(nextCraftIndex);
-        const recipe = resolveCraftRecipeByIndex(nextCraftIndex);
+        const recipe = resolveCraftRecipeByIndex(nextCraftIndex, craftRecipesRef.current);
         updateCombatStatus({
           lastAction: "craft_select",
           status: `Craft selected: ${recipe.label} (${recipe.summary})`,
@@ -2553,7 +2575,7 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
         return;
       }
       if (key === "r") {
-        const selectedRecipe = resolveCraftRecipeByIndex(selectedCraftRecipeIndexRef.current);
+        const selectedRecipe = resolveCraftRecipeByIndex(selectedCraftRecipeIndexRef.current, craftRecipesRef.current);
         runtimeClient.submitCraftRequest(profile.id, {
           actionId: createEventId(),
           recipeId: selectedRecipe.id,
@@ -3118,6 +3140,8 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
       runtimeContainerStateUnsubscribe();
       runtimeCombatUnsubscribe();
       runtimeInteractUnsubscribe();
+      runtimeContentCatalogUnsubscribe();
+      runtimeErrorUnsubscribe();
       runtimeCraftUnsubscribe();
       runtimeContainerResultUnsubscribe();
       pendingCombatActions.clear();
@@ -3282,7 +3306,7 @@ export function WorldCanvas({ profile }: WorldCanvasProps) {
               <section className="hud-menu-section">
                 <h3>Crafting</h3>
                 <div className="craft-strip menu-craft-strip" aria-label="craft recipes">
-                  {DEFAULT_RUNTIME_CRAFT_RECIPES.map((recipe, index) => (
+                  {craftRecipes.map((recipe, index) => (
                     <button
                       key={recipe.id}
                       type="button"
@@ -2,6 +2,7 @@ import { describe, expect, it } from "vitest";
 import {
   DEFAULT_RUNTIME_CRAFT_RECIPES,
   clampCraftRecipeIndex,
+  craftRecipesFromContentCatalog,
   resolveCraftRecipeByIndex,
   resolveCraftRecipeIndexForKey,
 } from "@/lib/runtime/crafting-catalog";
@@ -24,4 +25,24 @@ describe("crafting catalog", () => {
     expect(resolveCraftRecipeByIndex(0).id).toBe("craft-bandage");
     expect(resolveCraftRecipeByIndex(999).id).toBe("craft-iron-ingot");
   });
+
+  it("builds recipes from the server content catalog", () => {
+    const recipes = craftRecipesFromContentCatalog({
+      version: "custom",
+      hash: "abc123",
+      recipes: [
+        { id: "craft-glass", label: "Glass", keybind: "6", summary: "sand -> glass" },
+        { id: "craft-furnace" },
+      ],
+    });
+
+    expect(recipes).toEqual([
+      { id: "craft-glass", label: "Glass", keybind: "6", summary: "sand -> glass" },
+      { id: "craft-furnace", label: "craft-furnace", keybind: "", summary: "" },
+    ]);
+    expect(resolveCraftRecipeIndexForKey("6", recipes)).toBe(0);
+    expect(resolveCraftRecipeIndexForKey("7", recipes)).toBeUndefined();
+    expect(resolveCraftRecipeByIndex(999, recipes).id).toBe("craft-furnace");
+    expect(clampCraftRecipeIndex(5, [])).toBe(0);
+  });
 });
@@ -1,3 +1,5 @@
+import type { RuntimeContentCatalog } from "@/lib/runtime/protocol";
+
 export interface RuntimeCraftRecipeDefinition {
   id: string;
   label: string;
@@ -5,6 +7,9 @@ export interface RuntimeCraftRecipeDefinition {
   summary: string;
 }
 
+// DEFAULT_RUNTIME_CRAFT_RECIPES mirrors the server's builtin content pack. It
+// is used until the server sends its `content_catalog`, and by the local
+// runtime, which has no server.
 export const DEFAULT_RUNTIME_CRAFT_RECIPES: RuntimeCraftRecipeDefinition[] = [
   {
     id: "craft-bandage",
@@ -32,21 +37,40 @@ export const DEFAULT_RUNTIME_CRAFT_RECIPES: RuntimeCraftRecipeDefinition[] = [
   },
 ];
 
-const CRAFT_RECIPE_INDEX_BY_KEY = new Map(
-  DEFAULT_RUNTIME_CRAFT_RECIPES.map((recipe, index) => [recipe.keybind, index]),
-);
+export function craftRecipesFromContentCatalog(catalog: RuntimeContentCatalog): RuntimeCraftRecipeDefinition[] {
+  return catalog.recipes.map((recipe) => ({
+    id: recipe.id,
+    label: recipe.label ?? recipe.id,
+    keybind: recipe.keybind ?? "",
+    summary: recipe.summary ?? "",
+  }));
+}
 
-export function clampCraftRecipeIndex(index: number): number {
-  if (DEFAULT_RUNTIME_CRAFT_RECIPES.length <= 0) {
+export function clampCraftRecipeIndex(
+  index: number,
+  recipes: RuntimeCraftRecipeDefinition[] = DEFAULT_RUNTIME_CRAFT_RECIPES,
+): number {
+  if (recipes.length <= 0) {
     return 0;
   }
-  return Math.max(0, Math.min(index, DEFAULT_RUNTIME_CRAFT_RECIPES.length - 1));
+  return Math.max(0, Math.min(index, recipes.length - 1));
 }
 
-export function resolveCraftRecipeIndexForKey(key: string): number | undefined {
-  return CRAFT_RECIPE_INDEX_BY_KEY.get(key.toLowerCase());
+export function resolveCraftRecipeIndexForKey(
+  key: string,
+  recipes: RuntimeCraftRecipeDefinition[] = DEFAULT_RUNTIME_CRAFT_RECIPES,
+): number | undefined {
+  const normalizedKey = key.toLowerCase();
+  if (normalizedKey === "") {
+    return undefined;
+  }
+  const index = recipes.findIndex((recipe) => recipe.keybind.toLowerCase() === normalizedKey);
+  return index >= 0 ? index : undefined;
 }
 
-export function resolveCraftRecipeByIndex(index: number): RuntimeCraftRecipeDefinition {
-  return DEFAULT_RUNTIME_CRAFT_RECIPES[clampCraftRecipeIndex(index)] ?? DEFAULT_RUNTIME_CRAFT_RECIPES[0];
+export function resolveCraftRecipeByIndex(
+  index: number,
+  recipes: RuntimeCraftRecipeDefinition[] = DEFAULT_RUNTIME_CRAFT_RECIPES,
+): RuntimeCraftRecipeDefinition {
+  return recipes[clampCraftRecipeIndex(index, recipes)] ?? DEFAULT_RUNTIME_CRAFT_RECIPES[0];
 }
@@ -629,6 +629,14 @@ export class LocalRuntimeClient implements WorldRuntimeClient {
     };
   }
 
+  subscribeContentCatalogs(): () => void {
+    return () => {};
+  }
+
+  subscribeErrors(): () => void {
+    return () => {};
+  }
+
   dispose(): void {
     window.clearInterval(this.intervalId);
     this.listeners.clear();
@@ -5,6 +5,44 @@ export interface JoinRuntimeRequest {
   playerId: string;
   startX: number;
   startZ: number;
+  resumeToken?: string;
+}
+
+export interface RuntimeSessionGrant {
+  playerId: string;
+  resumeToken: string;
+  graceTicks: number;
+  tick: number;
+}
+
+export const RUNTIME_PROTOCOL_VERSION = "1.0";
+
+export interface RuntimeHello {
+  protocolVersion: string;
+  client?: string;
+  encodings?: string[];
+  features?: string[];
+}
+
+export interface RuntimeClientError {
+  code: string;
+  messageType?: string;
+  requestId?: string;
+}
+
+export interface RuntimeContentRecipe {
+  id: string;
+  label?: string;
+  keybind?: string;
+  summary?: string;
+  durationTicks?: number;
+  station?: string;
+}
+
+export interface RuntimeContentCatalog {
+  version: string;
+  hash: string;
+  recipes: RuntimeContentRecipe[];
 }
 
 export interface RuntimeInputState {
@@ -230,5 +268,7 @@ export interface WorldRuntimeClient {
   subscribeWorldEvents(listener: (event: RuntimeWorldEvent) => void): () => void;
   subscribeCombatResults(listener: (result: RuntimeCombatResult) => void): () => void;
   subscribeInteractResults(listener: (result: RuntimeInteractResult) => void): () => void;
+  subscribeContentCatalogs(listener: (catalog: RuntimeContentCatalog) => void): () => void;
+  subscribeErrors(listener: (error: RuntimeClientError) => void): () => void;
   dispose(): void;
 }
@@ -660,9 +660,46 @@ describe("WsRuntimeClient", () => {
 
     secondSocket?.emitOpen();
 
-    expect(secondSocket?.sent).toHaveLength(2);
-    expect(secondSocket?.sent[0]).toContain("\"type\":\"join\"");
-    expect(secondSocket?.sent[1]).toContain("\"type\":\"input\"");
+    expect(secondSocket?.sent).toHaveLength(3);
+    expect(secondSocket?.sent[0]).toContain("\"type\":\"hello\"");
+    expect(secondSocket?.sent[1]).toContain("\"type\":\"join\"");
+    expect(secondSocket?.sent[2]).toContain("\"type\":\"input\"");
+
+    client.dispose();
+  });
+
+  it("rejoins with the resume token from the latest session grant", () => {
+    vi.useFakeTimers();
+
+    const client = new WsRuntimeClient({
+      worldSeed: "seed-a",
+      url: "ws://localhost:8787/ws",
+      reconnectDelayMs: 25,
+    });
+    const firstSocket = FakeWebSocket.instances[0];
+    client.join({
+      worldSeed: "seed-a",
+      playerId: "player-1",
+      startX: 3,
+      startZ: -2,
+    });
+    expect(JSON.parse(firstSocket?.sent[0] ?? "{}").payload.resumeToken).toBeUndefined();
+
+    firstSocket?.emitMessage(
+      JSON.stringify({
+        type: "session",
+        payload: { playerId: "player-1", resumeToken: "token-1", graceTicks: 600, tick: 4 },
+      }),
+    );
+    firstSocket?.emitClose();
+
+    vi.advanceTimersByTime(25);
+    const secondSocket = FakeWebSocket.instances[1];
+    secondSocket?.emitOpen();
+
+    const rejoin = JSON.parse(secondSocket?.sent[1] ?? "{}");
+    expect(rejoin.type).toBe("join");
+    expect(rejoin.payload).toMatchObject({ playerId: "player-1", resumeToken: "token-1" });
 
     client.dispose();
   });
@@ -684,8 +721,59 @@ describe("WsRuntimeClient", () => {
     expect(socket?.sent).toHaveLength(0);
 
     socket?.emitOpen();
-    expect(socket?.sent).toHaveLength(1);
-    expect(socket?.sent[0]).toContain("\"type\":\"join\"");
+    expect(socket?.sent).toHaveLength(2);
+    expect(JSON.parse(socket?.sent[0] ?? "{}")).toEqual({
+      type: "hello",
+      payload: { protocolVersion: "1.0", client: "web", encodings: ["json"] },
+    });
+    expect(socket?.sent[1]).toContain("\"type\":\"join\"");
+
+    client.dispose();
+  });
+
+  it("hands the latest content catalog to late subscribers", () => {
+    const client = new WsRuntimeClient({
+      worldSeed: "seed-a",
+      url: "ws://localhost:8787/ws",
+    });
+    const socket = FakeWebSocket.instances[0];
+    const catalog = {
+      version: "builtin",
+      hash: "abc123",
+      recipes: [{ id: "craft-furnace", label: "Furnace", summary: "stone + coal -> furnace" }],
+    };
+
+    socket?.emitMessage(JSON.stringify({ type: "content_catalog", payload: catalog }));
+
+    const catalogs: unknown[] = [];
+    const unsubscribe = client.subscribeContentCatalogs((next) => catalogs.push(next));
+    expect(catalogs).toEqual([catalog]);
+
+    socket?.emitMessage(JSON.stringify({ type: "content_catalog", payload: { ...catalog, hash: "def456" } }));
+    expect(catalogs).toHaveLength(2);
+
+    unsubscribe();
+    client.dispose();
+  });
+
+  it("forwards error envelopes to error subscribers", () => {
+    const client = new WsRuntimeClient({
+      worldSeed: "seed-a",
+      url: "ws://localhost:8787/ws",
+    });
+    const socket = FakeWebSocket.instances[0];
+    const errors: unknown[] = [];
+    client.subscribeErrors((error) => errors.push(error));
+
+    socket?.emitMessage(
+      JSON.stringify({
+        type: "error",
+        requestId: "req-7",
+        payload: { code: "player_not_owned", messageType: "craft_request", requestId: "req-7" },
+      }),
+    );
+
+    expect(errors).toEqual([{ code: "player_not_owned", messageType: "craft_request", requestId: "req-7" }]);
 
     client.dispose();
   });
@@ -696,6 +784,17 @@ describe("wsRuntimeClientTestUtils", () => {
     expect(wsRuntimeClientTestUtils.safeParseServerMessage("{oops")).toBeNull();
   });
 
+  it("rejects content catalogs and errors with missing fields", () => {
+    expect(
+      wsRuntimeClientTestUtils.safeParseServerMessage(
+        JSON.stringify({ type: "content_catalog", payload: { version: "builtin", hash: "abc", recipes: [{}] } }),
+      ),
+    ).toBeNull();
+    expect(
+      wsRuntimeClientTestUtils.safeParseServerMessage(JSON.stringify({ type: "error", payload: { code: "" } })),
+    ).toBeNull();
+  });
+
   it("accepts monotonic snapshots only for the same world seed", () => {
     const current = { worldSeed: "seed-a", tick: 12, players: {} };
 
@@ -4,6 +4,10 @@ import {
   RuntimeCombatActionRequest,
   RuntimeCombatActionKind,
   RuntimeCombatResult,
+  RuntimeClientError,
+  RuntimeContentCatalog,
+  RuntimeContentRecipe,
+  RuntimeHello,
   RuntimeInteractRequest,
   RuntimeInteractResult,
   RuntimeDirectiveState,
@@ -20,10 +24,14 @@ import {
   JoinRuntimeRequest,
   RuntimeInputState,
   RuntimeMode,
+  RuntimeSessionGrant,
+  RUNTIME_PROTOCOL_VERSION,
   WorldRuntimeClient,
   WorldRuntimeSnapshot,
 } from "@/lib/runtime/protocol";
 
+const RESUME_TOKEN_STORAGE_PREFIX = "monster-mash.resume-token.v1.";
+
 interface WsRuntimeClientConfig {
   worldSeed: string;
   url: string;
@@ -61,6 +69,12 @@ export class WsRuntimeClient implements WorldRuntimeClient {
 
   private readonly worldDirectiveStateListeners = new Set<(state: RuntimeDirectiveState) => void>();
 
+  private readonly contentCatalogListeners = new Set<(catalog: RuntimeContentCatalog) => void>();
+
+  private readonly errorListeners = new Set<(error: RuntimeClientError) => void>();
+
+  private contentCatalog: RuntimeContentCatalog | null = null;
+
   private socket: WebSocket | null = null;
 
   private readonly socketUrl: string;
@@ -75,6 +89,8 @@ export class WsRuntimeClient implements WorldRuntimeClient {
 
   private readonly playerInputs = new Map<string, RuntimeInputState>();
 
+  private readonly resumeTokens = new Map<string, string>();
+
   private fallbackSnapshot: WorldRuntimeSnapshot;
 
   private fallbackWorldFlagState: RuntimeWorldFlagState;
@@ -105,10 +121,7 @@ export class WsRuntimeClient implements WorldRuntimeClient {
 
   join(request: JoinRuntimeRequest): void {
     this.joinedPlayers.set(request.playerId, request);
-    this.send({
-      type: "join",
-      payload: request,
-    });
+    this.sendJoin(request);
   }
 
   leave(playerId: string): void {
@@ -283,6 +296,25 @@ export class WsRuntimeClient implements WorldRuntimeClient {
     };
   }
 
+  // The catalog arrives once per join, usually before the canvas subscribes,
+  // so late subscribers get the last one straight away.
+  subscribeContentCatalogs(listener: (catalog: RuntimeContentCatalog) => void): () => void {
+    this.contentCatalogListeners.add(listener);
+    if (this.contentCatalog) {
+      listener(this.contentCatalog);
+    }
+    return () => {
+      this.contentCatalogListeners.delete(listener);
+    };
+  }
+
+  subscribeErrors(listener: (error: RuntimeClientError) => void): () => void {
+    this.errorListeners.add(listener);
+    return () => {
+      this.errorListeners.delete(listener);
+    };
+  }
+
   dispose(): void {
     this.disposed = true;
     if (this.reconnectTimer !== null) {
@@ -308,6 +340,8 @@ export class WsRuntimeClient implements WorldRuntimeClient {
     this.interactListeners.clear();
     this.worldFlagStateListeners.clear();
     this.worldDirectiveStateListeners.clear();
+    this.contentCatalogListeners.clear();
+    this.errorListeners.clear();
   }
 
   private connect(): void {
@@ -323,6 +357,7 @@ export class WsRuntimeClient implements WorldRuntimeClient {
         if (this.socket !== socket || this.disposed) {
           return;
         }
+        this.sendHello();
         this.replaySessionState();
       });
 
@@ -403,6 +438,23 @@ export class WsRuntimeClient implements WorldRuntimeClient {
         if (parsed.type === "world_directive_state") {
           this.fallbackWorldDirectiveState = parsed.payload;
           this.worldDirectiveStateListeners.forEach((listener) => listener(parsed.payload));
+          return;
+        }
+
+        if (parsed.type === "content_catalog") {
+          this.contentCatalog = parsed.payload;
+          this.contentCatalogListeners.forEach((listener) => listener(parsed.payload));
+          return;
+        }
+
+        if (parsed.type === "error") {
+          this.errorListeners.forEach((listener) => listener(parsed.payload));
+          return;
+        }
+
+        if (parsed.type === "session") {
+          this.resumeTokens.set(parsed.payload.playerId, parsed.payload.resumeToken);
+          storeResumeToken(parsed.payload.playerId, parsed.payload.resumeToken);
         }
       });
 
@@ -433,6 +485,32 @@ export class WsRuntimeClient implements WorldRuntimeClient {
     this.socket.send(JSON.stringify(payload));
   }
 
+  // The server only hands an existing player to a join that presents the
+  // player's last resume token, so rejoins after a reconnect or a page reload
+  // carry the token from the latest session grant.
+  private sendJoin(request: JoinRuntimeRequest): void {
+    const resumeToken =
+      request.resumeToken ?? this.resumeTokens.get(request.playerId) ?? loadStoredResumeToken(request.playerId);
+    this.send({
+      type: "join",
+      payload: resumeToken ? { ...request, resumeToken } : request,
+    });
+  }
+
+  // hello opens every connection so a server speaking another protocol major
+  // refuses us up front instead of misreading later messages.
+  private sendHello(): void {
+    const hello: RuntimeHello = {
+      protocolVersion: RUNTIME_PROTOCOL_VERSION,
+      client: "web",
+      encodings: ["json"],
+    };
+    this.send({
+      type: "hello",
+      payload: hello,
+    });
+  }
+
   private scheduleReconnect(): void {
     if (this.disposed || this.reconnectTimer !== null) {
       return;
@@ -451,10 +529,7 @@ export class WsRuntimeClient implements WorldRuntimeClient {
       if (!request) {
         continue;
       }
-      this.send({
-        type: "join",
-        payload: request,
-      });
+      this.sendJoin(request);
     }
 
     const inputPlayerIds = Array.from(this.playerInputs.keys()).sort();
@@ -487,7 +562,10 @@ type ParsedServerMessage =
   | { type: "combat_result"; payload: RuntimeCombatResult }
   | { type: "interact_result"; payload: RuntimeInteractResult }
   | { type: "world_flag_state"; payload: RuntimeWorldFlagState }
-  | { type: "world_directive_state"; payload: RuntimeDirectiveState };
+  | { type: "world_directive_state"; payload: RuntimeDirectiveState }
+  | { type: "session"; payload: RuntimeSessionGrant }
+  | { type: "content_catalog"; payload: RuntimeContentCatalog }
+  | { type: "error"; payload: RuntimeClientError };
 
 function safeParseServerMessage(raw: unknown): ParsedServerMessage | null {
   if (typeof raw !== "string") {
@@ -591,6 +669,27 @@ function safeParseServerMessage(raw: unknown): ParsedServerMessage | null {
       };
     }
 
+    if (decoded.type === "session" && isSessionGrant(decoded.payload)) {
+      return {
+        type: "session",
+        payload: decoded.payload,
+      };
+    }
+
+    if (decoded.type === "content_catalog" && isContentCatalog(decoded.payload)) {
+      return {
+        type: "content_catalog",
+        payload: decoded.payload,
+      };
+    }
+
+    if (decoded.type === "error" && isClientError(decoded.payload)) {
+      return {
+        type: "error",
+        payload: decoded.payload,
+      };
+    }
+
     if (isRuntimeSnapshot(decoded)) {
       return {
         type: "snapshot",
@@ -773,6 +872,83 @@ function isContainerResult(value: unknown): value is RuntimeContainerActionResul
   );
 }
 
+function isSessionGrant(value: unknown): value is RuntimeSessionGrant {
+  if (!value || typeof value !== "object") {
+    return false;
+  }
+  const payload = value as Partial<RuntimeSessionGrant>;
+  return (
+    typeof payload.playerId === "string" &&
+    typeof payload.resumeToken === "string" &&
+    payload.resumeToken.length > 0 &&
+    typeof payload.graceTicks === "number" &&
+    typeof payload.tick === "number"
+  );
+}
+
+function isContentCatalog(value: unknown): value is RuntimeContentCatalog {
+  if (!value || typeof value !== "object") {
+    return false;
+  }
+  const payload = value as Partial<RuntimeContentCatalog>;
+  return (
+    typeof payload.version === "string" &&
+    typeof payload.hash === "string" &&
+    Array.isArray(payload.recipes) &&
+    payload.recipes.every((recipe) => isContentRecipe(recipe))
+  );
+}
+
+function isContentRecipe(value: unknown): value is RuntimeContentRecipe {
+  if (!value || typeof value !== "object") {
+    return false;
+  }
+  const payload = value as Partial<RuntimeContentRecipe>;
+  return (
+    typeof payload.id === "string" &&
+    payload.id.length > 0 &&
+    (payload.label === undefined || typeof payload.label === "string") &&
+    (payload.keybind === undefined || typeof payload.keybind === "string") &&
+    (payload.summary === undefined || typeof payload.summary === "string")
+  );
+}
+
+function isClientError(value: unknown): value is RuntimeClientError {
+  if (!value || typeof value !== "object") {
+    return false;
+  }
+  const payload = value as Partial<RuntimeClientError>;
+  return (
+    typeof payload.code === "string" &&
+    payload.code.length > 0 &&
+    (payload.messageType === undefined || typeof payload.messageType === "string") &&
+    (payload.requestId === undefined || typeof payload.requestId === "string")
+  );
+}
+
+function loadStoredResumeToken(playerId: string): string | undefined {
+  if (typeof window === "undefined") {
+    return undefined;
+  }
+  try {
+    return window.localStorage.getItem(RESUME_TOKEN_STORAGE_PREFIX + playerId) ?? undefined;
+  } catch {
+    return undefined;
+  }
+}
+
+function storeResumeToken(playerId: string, resumeToken: string): void {
+  if (typeof window === "undefined") {
+    return;
+  }
+  try {
+    window.localStorage.setItem(RESUME_TOKEN_STORAGE_PREFIX + playerId, resumeToken);
+  } catch {
+    // Storage can be unavailable (private mode, quota); the in-memory token
+    // still covers reconnects within this page.
+  }
+}
+
 function isCombatActionKind(value: unknown): value is RuntimeCombatActionKind {
   return value === "melee" || value === "spell" || value === "item";
 }
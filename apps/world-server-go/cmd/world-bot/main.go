@@ -32,6 +32,22 @@ type serverEnvelope struct {
 	Payload json.RawMessage `json:"payload"`
 }
 
+// botProtocolVersion is the world-server protocol version the bot speaks.
+const botProtocolVersion = "1.0"
+
+type helloPayload struct {
+	ProtocolVersion string   `json:"protocolVersion"`
+	Client          string   `json:"client,omitempty"`
+	Encodings       []string `json:"encodings,omitempty"`
+}
+
+type runtimeWelcome struct {
+	ProtocolVersion string   `json:"protocolVersion"`
+	ServerBuild     string   `json:"serverBuild"`
+	Encoding        string   `json:"encoding"`
+	Features        []string `json:"features"`
+}
+
 type runtimeInputState struct {
 	MoveX   float64 `json:"moveX"`
 	MoveZ   float64 `json:"moveZ"`
@@ -223,7 +239,7 @@ func main() {
 		fail(fmt.Errorf("hotbar select: %w", err))
 	}
 
-	woodCoords := findBreakCoords(0, 29, 2)
+	woodCoords := findBreakCoords(0, 29, 2, botBlockReach)
 	if len(woodCoords) < 2 {
 		fail(fmt.Errorf("failed to locate wood break coordinates"))
 	}
@@ -285,6 +301,10 @@ func newBotClient(ctx context.Context, wsURL, playerID, worldSeed string, startX
 	}
 	go client.readLoop()
 
+	if err := client.handshake(ctx); err != nil {
+		client.close()
+		return nil, err
+	}
 	if err := client.send("join", joinRuntimeRequest{
 		WorldSeed: worldSeed,
 		PlayerID:  playerID,
@@ -297,6 +317,30 @@ func newBotClient(ctx context.Context, wsURL, playerID, worldSeed string, startX
 	return client, nil
 }
 
+// handshake exchanges hello/welcome so a server on an incompatible protocol
+// fails the run with its close reason instead of a confusing timeout.
+func (c *botClient) handshake(ctx context.Context) error {
+	if err := c.send("hello", helloPayload{
+		ProtocolVersion: botProtocolVersion,
+		Client:          "world-bot",
+		Encodings:       []string{"json"},
+	}); err != nil {
+		return err
+	}
+	envelope, err := c.waitFor(ctx, func(envelope serverEnvelope) bool {
+		return envelope.Type == "welcome"
+	})
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+	var welcome runtimeWelcome
+	if err := json.Unmarshal(envelope.Payload, &welcome); err != nil {
+		return fmt.Errorf("decode welcome: %w", err)
+	}
+	fmt.Printf("world-bot: %s connected to server %s (protocol %s)\n", c.id, welcome.ServerBuild, welcome.ProtocolVersion)
+	return nil
+}
+
 func (c *botClient) close() {
 	if c.conn != nil {
 		_ = c.conn.Close()
@@ -346,6 +390,14 @@ func (c *botClient) handleEnvelope(envelope serverEnvelope) {
 			c.hotbar = state
 			c.mu.Unlock()
 		}
+	case "server_shutdown":
+		var notice struct {
+			Reason           string `json:"reason"`
+			ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+		}
+		if json.Unmarshal(envelope.Payload, &notice) == nil {
+			fmt.Printf("world-bot: %s server shutting down (%s), reconnect after %dms\n", c.id, notice.Reason, notice.ReconnectAfterMs)
+		}
 	}
 }
 
@@ -685,6 +737,12 @@ func (c *botClient) waitFor(ctx context.Context, predicate func(serverEnvelope)
 	}
 }
 
+const (
+	chunkGridCells = 16
+	worldChunkSize = 64.0
+	botBlockReach  = 8.0
+)
+
 type blockCoord struct {
 	ChunkX int
 	ChunkZ int
@@ -693,10 +751,16 @@ type blockCoord struct {
 	Z      int
 }
 
-func findBreakCoords(minRoll, maxRoll int, count int) []blockCoord {
+// findBreakCoords scans chunk 0 for blocks within maxDistance of the origin so
+// the bots (which spawn near the origin) stay inside the server's block reach.
+func findBreakCoords(minRoll, maxRoll int, count int, maxDistance float64) []blockCoord {
 	results := make([]blockCoord, 0, count)
-	for x := 1; x <= 18; x++ {
-		for z := 1; z <= 18; z++ {
+	for x := 0; x < chunkGridCells; x++ {
+		for z := 0; z < chunkGridCells; z++ {
+			worldX, worldZ := blockWorldPosition(0, 0, x, z)
+			if math.Hypot(worldX, worldZ) > maxDistance {
+				continue
+			}
 			roll := breakResourceRoll(0, 0, x, 1, z)
 			if roll >= minRoll && roll <= maxRoll {
 				results = append(results, blockCoord{
@@ -715,6 +779,14 @@ func findBreakCoords(minRoll, maxRoll int, count int) []blockCoord {
 	return results
 }
 
+func blockWorldPosition(chunkX int, chunkZ int, x int, z int) (float64, float64) {
+	blockSize := worldChunkSize / chunkGridCells
+	halfChunk := worldChunkSize * 0.5
+	worldX := (float64(chunkX) * worldChunkSize) - halfChunk + ((float64(x) + 0.5) * blockSize)
+	worldZ := (float64(chunkZ) * worldChunkSize) - halfChunk + ((float64(z) + 0.5) * blockSize)
+	return worldX, worldZ
+}
+
 func breakResourceRoll(chunkX int, chunkZ int, x int, y int, z int) int {
 	value := (chunkX * 73856093) ^ (chunkZ * 19349663) ^ (x * 83492791) ^ (y * 1237) ^ (z * 29791)
 	if value < 0 {
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes an API key can be granted for the admin endpoints.
+const (
+	scopeEventsRead      = "events:read"
+	scopeDirectivesWrite = "directives:write"
+	scopeAdminState      = "admin:state"
+)
+
+// maxSignatureSkew is how far an HMAC-signed request's timestamp may be from
+// the server clock, bounding how long a captured request can be replayed.
+const maxSignatureSkew = 5 * time.Minute
+
+// maxAdminBodyBytes bounds admin request bodies, which are read in full to
+// hash them for signing and auditing. World state uploads are the largest.
+const maxAdminBodyBytes = 32 << 20
+
+type apiKey struct {
+	ID     string   `json:"id"`
+	Secret string   `json:"secret"`
+	Scopes []string `json:"scopes"`
+}
+
+type apiKeyFile struct {
+	Keys []apiKey `json:"keys"`
+}
+
+// apiKeyReloadInterval throttles how often the key file is checked for
+// changes.
+const apiKeyReloadInterval = time.Second
+
+// adminAuth guards the OpenClaw and debug endpoints. Requests authenticate
+// either with "Authorization: ApiKey <id>:<secret>" or by signing: headers
+// X-Key-Id, X-Timestamp (unix seconds) and X-Signature, the hex HMAC-SHA256
+// of method, request URI, timestamp and hex SHA-256 of the body, joined by
+// newlines, keyed with the secret. With no keys configured every request is
+// refused unless allowWithoutKeys is set, which is only meant for local
+// development or an admin listener nobody else can reach.
+type adminAuth struct {
+	now              func() time.Time
+	audit            *auditLog
+	logger           *slog.Logger
+	allowWithoutKeys bool
+
+	mu         sync.Mutex
+	keys       map[string]apiKey
+	keyFile    string
+	keyModTime time.Time
+	checked    time.Time
+}
+
+func newAdminAuth(keys []apiKey, audit *auditLog) *adminAuth {
+	return &adminAuth{
+		keys:   indexAPIKeys(keys),
+		now:    time.Now,
+		audit:  audit,
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+}
+
+// newAdminAuthFromFile loads keys from path and reloads them when the file
+// changes, so keys can be rotated without a restart. A rewrite that fails to
+// load keeps the previous keys in service.
+func newAdminAuthFromFile(path string, audit *auditLog, logger *slog.Logger) (*adminAuth, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat api key file: %w", err)
+	}
+	keys, err := loadAPIKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	auth := newAdminAuth(keys, audit)
+	auth.logger = logger
+	auth.keyFile = path
+	auth.keyModTime = info.ModTime()
+	auth.checked = auth.now()
+	return auth, nil
+}
+
+func indexAPIKeys(keys []apiKey) map[string]apiKey {
+	byID := make(map[string]apiKey, len(keys))
+	for _, key := range keys {
+		byID[key.ID] = key
+	}
+	return byID
+}
+
+// currentKeys returns the keys in service, reloading the key file first if
+// it changed since the last check.
+func (a *adminAuth) currentKeys() map[string]apiKey {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	if a.keyFile == "" || now.Sub(a.checked) < apiKeyReloadInterval {
+		return a.keys
+	}
+	a.checked = now
+	info, err := os.Stat(a.keyFile)
+	if err != nil || info.ModTime().Equal(a.keyModTime) {
+		return a.keys
+	}
+	keys, err := loadAPIKeys(a.keyFile)
+	if err != nil {
+		a.logger.Error("api key reload failed, keeping previous keys", "path", a.keyFile, "err", err)
+		return a.keys
+	}
+	a.keys = indexAPIKeys(keys)
+	a.keyModTime = info.ModTime()
+	a.logger.Info("api keys reloaded", "path", a.keyFile, "count", len(keys))
+	return a.keys
+}
+
+// loadAPIKeys reads a key file and rejects keys that could never
+// authenticate or that grant unknown scopes.
+func loadAPIKeys(path string) ([]apiKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read api key file: %w", err)
+	}
+	var file apiKeyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("parse api key file: %w", err)
+	}
+	seen := make(map[string]struct{}, len(file.Keys))
+	for _, key := range file.Keys {
+		if key.ID == "" || len(key.Secret) < 16 {
+			return nil, fmt.Errorf("api key %q needs an id and a secret of at least 16 characters", key.ID)
+		}
+		if _, duplicate := seen[key.ID]; duplicate {
+			return nil, fmt.Errorf("duplicate api key id %q", key.ID)
+		}
+		seen[key.ID] = struct{}{}
+		for _, scope := range key.Scopes {
+			if scope != scopeEventsRead && scope != scopeDirectivesWrite && scope != scopeAdminState {
+				return nil, fmt.Errorf("api key %q has unknown scope %q", key.ID, scope)
+			}
+		}
+	}
+	return file.Keys, nil
+}
+
+// authenticate returns the calling key, or an error reason when the
+// credentials are missing or wrong.
+func (a *adminAuth) authenticate(keys map[string]apiKey, request *http.Request, bodyHash string) (apiKey, string) {
+	if credentials, ok := strings.CutPrefix(request.Header.Get("Authorization"), "ApiKey "); ok {
+		keyID, secret, _ := strings.Cut(credentials, ":")
+		key, known := keys[keyID]
+		if !known || !hmac.Equal([]byte(secret), []byte(key.Secret)) {
+			return apiKey{}, "invalid_api_key"
+		}
+		return key, ""
+	}
+
+	keyID := request.Header.Get("X-Key-Id")
+	if keyID == "" {
+		return apiKey{}, "missing_credentials"
+	}
+	key, known := keys[keyID]
+	if !known {
+		return apiKey{}, "invalid_signature"
+	}
+	timestamp, err := strconv.ParseInt(request.Header.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		return apiKey{}, "invalid_timestamp"
+	}
+	skew := a.now().Sub(time.Unix(timestamp, 0))
+	if skew > maxSignatureSkew || skew < -maxSignatureSkew {
+		return apiKey{}, "stale_timestamp"
+	}
+	expected := signAdminRequest(key.Secret, request.Method, request.URL.RequestURI(), timestamp, bodyHash)
+	if !hmac.Equal([]byte(request.Header.Get("X-Signature")), []byte(expected)) {
+		return apiKey{}, "invalid_signature"
+	}
+	return key, ""
+}
+
+func signAdminRequest(secret string, method string, requestURI string, timestamp int64, bodyHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + requestURI + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + bodyHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k apiKey) hasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// require wraps an admin handler: it authenticates the caller, checks the
+// scope, and audits the call whatever the outcome.
+func (a *adminAuth) require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxAdminBodyBytes))
+		if err != nil {
+			writer.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		bodySum := sha256.Sum256(body)
+		bodyHash := hex.EncodeToString(bodySum[:])
+
+		entry := auditEntry{
+			Method:      request.Method,
+			Path:        request.URL.Path,
+			Scope:       scope,
+			PayloadHash: bodyHash,
+			Remote:      request.RemoteAddr,
+		}
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+		defer func() {
+			entry.Time = a.now().UTC().Format(time.RFC3339Nano)
+			entry.Status = recorder.status
+			a.audit.write(entry)
+		}()
+
+		keys := a.currentKeys()
+		if len(keys) == 0 && a.keyFile == "" {
+			if !a.allowWithoutKeys {
+				entry.Denied = "api_keys_not_configured"
+				writeAuthError(recorder, http.StatusUnauthorized, "api_keys_not_configured")
+				return
+			}
+		} else {
+			key, reason := a.authenticate(keys, request, bodyHash)
+			if reason != "" {
+				entry.Denied = reason
+				writeAuthError(recorder, http.StatusUnauthorized, reason)
+				return
+			}
+			entry.KeyID = key.ID
+			if !key.hasScope(scope) {
+				entry.Denied = "missing_scope"
+				writeAuthError(recorder, http.StatusForbidden, "missing_scope")
+				return
+			}
+		}
+		next(recorder, request)
+	}
+}
+
+func writeAuthError(writer http.ResponseWriter, status int, reason string) {
+	if status == http.StatusUnauthorized {
+		writer.Header().Set("WWW-Authenticate", `ApiKey realm="world-server"`)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_ = json.NewEncoder(writer).Encode(map[string]string{"error": reason})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// auditEntry is one line of the admin audit log. The payload itself is not
+// logged, only its SHA-256, so state dumps and directives stay out of logs
+// while still being attributable.
+type auditEntry struct {
+	Time        string `json:"time"`
+	KeyID       string `json:"keyId,omitempty"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Scope       string `json:"scope"`
+	Status      int    `json:"status"`
+	Denied      string `json:"denied,omitempty"`
+	PayloadHash string `json:"payloadSha256"`
+	Remote      string `json:"remote"`
+}
+
+// auditLog appends JSON lines to a writer.
+type auditLog struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+func newAuditLog(writer io.Writer) *auditLog {
+	return &auditLog{writer: writer}
+}
+
+func (l *auditLog) write(entry auditEntry) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.writer.Write(append(encoded, '\n'))
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	chestBlockType         = "chest"
+	chestContainerPrefix   = "chest:"
+	chestContainerCapacity = 18
+	maxChestsPerPlayer     = 8
+	maxChestAllowedPlayers = 16
+)
+
+// runtimeChest is a placed chest block that doubles as a container. Access is
+// one of "private" (owner only), "party" (owner plus anyone sharing a land
+// claim with the owner), "public", or "players" (owner plus Allowed).
+type runtimeChest struct {
+	ContainerID string   `json:"containerId"`
+	OwnerID     string   `json:"ownerId"`
+	Access      string   `json:"access"`
+	Allowed     []string `json:"allowed,omitempty"`
+	ChunkX      int      `json:"chunkX"`
+	ChunkZ      int      `json:"chunkZ"`
+	X           int      `json:"x"`
+	Y           int      `json:"y"`
+	Z           int      `json:"z"`
+	PlacedTick  int64    `json:"placedTick"`
+}
+
+type containerACLPayload struct {
+	PlayerID    string   `json:"playerId"`
+	ActionID    string   `json:"actionId"`
+	ContainerID string   `json:"containerId"`
+	Access      string   `json:"access"`
+	Players     []string `json:"players,omitempty"`
+}
+
+type runtimeContainerACLResult struct {
+	ActionID    string `json:"actionId"`
+	PlayerID    string `json:"playerId"`
+	ContainerID string `json:"containerId"`
+	Access      string `json:"access"`
+	Accepted    bool   `json:"accepted"`
+	Reason      string `json:"reason,omitempty"`
+	Replayed    bool   `json:"replayed,omitempty"`
+	Tick        int64  `json:"tick"`
+}
+
+type runtimeContainerRemoved struct {
+	ContainerID string `json:"containerId"`
+	Reason      string `json:"reason"`
+	Tick        int64  `json:"tick"`
+}
+
+// chestRemoval is a queued container_removed notice and the players it goes
+// to.
+type chestRemoval struct {
+	Removed   runtimeContainerRemoved
+	PlayerIDs []string
+}
+
+func chestContainerID(chunkX int, chunkZ int, x int, y int, z int) string {
+	return chestContainerPrefix + blockKey(chunkX, chunkZ, x, y, z)
+}
+
+func isChestContainerID(containerID string) bool {
+	return strings.HasPrefix(containerID, chestContainerPrefix)
+}
+
+func isValidChestAccess(access string) bool {
+	switch access {
+	case "private", "party", "public", "players":
+		return true
+	default:
+		return false
+	}
+}
+
+func cloneChest(chest runtimeChest) runtimeChest {
+	chest.Allowed = append([]string(nil), chest.Allowed...)
+	return chest
+}
+
+func (h *worldHub) ownedChestCountLocked(playerID string) int {
+	count := 0
+	for _, chest := range h.chests {
+		if chest.OwnerID == playerID {
+			count++
+		}
+	}
+	return count
+}
+
+// placeChestLocked registers a private chest container for a freshly placed
+// chest block. The caller has already validated reach and claim rights.
+func (h *worldHub) placeChestLocked(playerID string, payload blockActionPayload) runtimeChest {
+	chest := runtimeChest{
+		ContainerID: chestContainerID(payload.ChunkX, payload.ChunkZ, payload.X, payload.Y, payload.Z),
+		OwnerID:     playerID,
+		Access:      "private",
+		ChunkX:      payload.ChunkX,
+		ChunkZ:      payload.ChunkZ,
+		X:           payload.X,
+		Y:           payload.Y,
+		Z:           payload.Z,
+		PlacedTick:  h.tick,
+	}
+	h.chests[chest.ContainerID] = chest
+	h.ensureContainerStateLocked(chest.ContainerID)
+	h.recordWorldEventLocked("chest_placed", playerID, map[string]any{
+		"containerId": chest.ContainerID,
+	})
+	return chest
+}
+
+// destroyChestLocked removes a chest whose block was broken and drops its
+// contents, and the chest item it was placed from, at the block as unreserved
+// ground items.
+func (h *worldHub) destroyChestLocked(containerID string, playerID string) {
+	chest, ok := h.chests[containerID]
+	if !ok {
+		return
+	}
+	h.chestRemovalOutbox = append(h.chestRemovalOutbox, chestRemoval{
+		Removed: runtimeContainerRemoved{
+			ContainerID: containerID,
+			Reason:      "destroyed",
+			Tick:        h.tick,
+		},
+		PlayerIDs: h.chestViewersLocked(chest, playerID),
+	})
+	blockX, blockZ := blockWorldPosition(chest.ChunkX, chest.ChunkZ, chest.X, chest.Z)
+	dropped := 0
+	if state, ok := h.containerStates[containerID]; ok {
+		for _, stack := range state.Slots {
+			if stack.isEmpty() {
+				continue
+			}
+			h.spawnGroundItemLocked(stack, blockX, blockZ, "container", playerID, "")
+			dropped++
+		}
+	}
+	if h.content.isKnownItem(chestBlockType) {
+		h.spawnGroundItemLocked(runtimeItemStack{ItemID: chestBlockType, Quantity: 1}, blockX, blockZ, "chest", playerID, "")
+	}
+	delete(h.chests, containerID)
+	delete(h.containerStates, containerID)
+	h.recordWorldEventLocked("chest_destroyed", playerID, map[string]any{
+		"containerId": containerID,
+		"ownerId":     chest.OwnerID,
+		"dropped":     dropped,
+	})
+	h.revalidateContainerSubscriptionsLocked()
+}
+
+// chestViewersLocked returns the players in the world who may know about a
+// chest: the player acting on it, its owner, players the ACL names or groups
+// with the owner, and anyone who has it open. A public chest names nobody, so
+// only its owner and subscribers hear about it.
+func (h *worldHub) chestViewersLocked(chest runtimeChest, actorID string) []string {
+	viewers := make([]string, 0, 4)
+	for playerID := range h.players {
+		if playerID == actorID || playerID == chest.OwnerID ||
+			h.openContainers[playerID] == chest.ContainerID ||
+			(chest.Access != "public" && h.chestAllowsPlayerLocked(chest, playerID)) {
+			viewers = append(viewers, playerID)
+		}
+	}
+	sort.Strings(viewers)
+	return viewers
+}
+
+func (h *worldHub) drainChestRemovals() []chestRemoval {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := h.chestRemovalOutbox
+	h.chestRemovalOutbox = nil
+	return drained
+}
+
+// flushChestRemovals tells each destroyed chest's viewers it is gone.
+func (h *worldHub) flushChestRemovals() {
+	for _, removal := range h.drainChestRemovals() {
+		h.sendToPlayersOwnedRecipients(removal.PlayerIDs, serverEnvelope{
+			Type:    "container_removed",
+			Payload: removal.Removed,
+		})
+	}
+}
+
+// chestSharesPartyLocked reports whether both players own or belong to the
+// same land claim. Claims are the only grouping the world tracks.
+func (h *worldHub) chestSharesPartyLocked(ownerID string, playerID string) bool {
+	for _, claim := range h.claims {
+		if claimAllowsPlayer(claim, ownerID) && claimAllowsPlayer(claim, playerID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *worldHub) chestAllowsPlayerLocked(chest runtimeChest, playerID string) bool {
+	if playerID == "" {
+		return false
+	}
+	if chest.OwnerID == playerID {
+		return true
+	}
+	switch chest.Access {
+	case "public":
+		return true
+	case "party":
+		return h.chestSharesPartyLocked(chest.OwnerID, playerID)
+	case "players":
+		for _, allowedID := range chest.Allowed {
+			if allowedID == playerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chestAccessReasonLocked checks ACL, claims and proximity for a chest
+// container.
+func (h *worldHub) chestAccessReasonLocked(playerID string, containerID string) string {
+	chest, ok := h.chests[containerID]
+	if !ok {
+		return "unknown_container"
+	}
+	if !h.chestAllowsPlayerLocked(chest, playerID) {
+		return "container_forbidden"
+	}
+	if !h.canUseContainerLocked(playerID, containerID) {
+		return "claim_protected"
+	}
+	player, ok := h.players[playerID]
+	if !ok {
+		return "player_not_found"
+	}
+	blockX, blockZ := blockWorldPosition(chest.ChunkX, chest.ChunkZ, chest.X, chest.Z)
+	if math.Hypot(blockX-player.X, blockZ-player.Z) > interactionRange {
+		return "container_out_of_range"
+	}
+	return ""
+}
+
+func (h *worldHub) applyContainerACL(payload containerACLPayload) (runtimeContainerACLResult, *runtimeContainerState) {
+	result := runtimeContainerACLResult{
+		ActionID:    payload.ActionID,
+		PlayerID:    payload.PlayerID,
+		ContainerID: payload.ContainerID,
+		Access:      payload.Access,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "container_acl", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "container_acl", payload.ActionID, result.Accepted, result)
+	}()
+
+	reject := func(reason string) (runtimeContainerACLResult, *runtimeContainerState) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordContainerACLEventLocked(result)
+		return result, nil
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ContainerID == "" {
+		return reject("invalid_payload")
+	}
+	if !isValidChestAccess(payload.Access) {
+		return reject("invalid_access")
+	}
+	chest, ok := h.chests[payload.ContainerID]
+	if !ok {
+		return reject("unknown_container")
+	}
+	if chest.OwnerID != payload.PlayerID {
+		return reject("container_forbidden")
+	}
+	var allowed []string
+	if payload.Access == "players" {
+		allowed = uniquePlayerIDs(chest.OwnerID, payload.Players)
+		if len(allowed) > maxChestAllowedPlayers {
+			return reject("too_many_players")
+		}
+	}
+
+	chest.Access = payload.Access
+	chest.Allowed = allowed
+	h.chests[chest.ContainerID] = chest
+	h.revalidateContainerSubscriptionsLocked()
+
+	result.Accepted = true
+	h.recordContainerACLEventLocked(result)
+	state := cloneContainerState(h.ensureContainerStateLocked(chest.ContainerID))
+	return result, &state
+}
+
+func (h *worldHub) recordContainerACLEventLocked(result runtimeContainerACLResult) {
+	eventType := "container_acl_rejected"
+	if result.Accepted {
+		eventType = "container_acl_updated"
+	}
+	payload := map[string]any{
+		"actionId":    result.ActionID,
+		"containerId": result.ContainerID,
+		"access":      result.Access,
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, payload)
+}
+
+func (h *worldHub) exportChestsLocked() []runtimeChest {
+	containerIDs := make([]string, 0, len(h.chests))
+	for containerID := range h.chests {
+		containerIDs = append(containerIDs, containerID)
+	}
+	sort.Strings(containerIDs)
+	chests := make([]runtimeChest, 0, len(containerIDs))
+	for _, containerID := range containerIDs {
+		chests = append(chests, cloneChest(h.chests[containerID]))
+	}
+	return chests
+}
+
+func importChests(chests []runtimeChest) map[string]runtimeChest {
+	imported := make(map[string]runtimeChest, len(chests))
+	for _, chest := range chests {
+		chest.OwnerID = strings.TrimSpace(chest.OwnerID)
+		if chest.OwnerID == "" || !isValidBlockCoordinate(chest.X, chest.Y, chest.Z) {
+			continue
+		}
+		chest.ContainerID = chestContainerID(chest.ChunkX, chest.ChunkZ, chest.X, chest.Y, chest.Z)
+		if !isValidChestAccess(chest.Access) {
+			chest.Access = "private"
+		}
+		allowed := chest.Allowed
+		chest.Allowed = nil
+		if chest.Access == "players" {
+			chest.Allowed = uniquePlayerIDs(chest.OwnerID, allowed)
+			if len(chest.Allowed) > maxChestAllowedPlayers {
+				chest.Allowed = chest.Allowed[:maxChestAllowedPlayers]
+			}
+		}
+		imported[chest.ContainerID] = chest
+	}
+	return imported
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+type runtimeLandClaim struct {
+	ClaimID     string   `json:"claimId"`
+	OwnerID     string   `json:"ownerId"`
+	Label       string   `json:"label,omitempty"`
+	Members     []string `json:"members"`
+	MinX        int      `json:"minX"`
+	MinZ        int      `json:"minZ"`
+	MaxX        int      `json:"maxX"`
+	MaxZ        int      `json:"maxZ"`
+	CreatedTick int64    `json:"createdTick"`
+}
+
+type runtimeClaimState struct {
+	Claims []runtimeLandClaim `json:"claims"`
+	Tick   int64              `json:"tick"`
+}
+
+type runtimeClaimResult struct {
+	ActionID  string `json:"actionId"`
+	PlayerID  string `json:"playerId"`
+	ClaimID   string `json:"claimId"`
+	Operation string `json:"operation"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	Replayed  bool   `json:"replayed,omitempty"`
+	Tick      int64  `json:"tick"`
+}
+
+type claimCreatePayload struct {
+	PlayerID  string   `json:"playerId"`
+	ActionID  string   `json:"actionId"`
+	ClaimID   string   `json:"claimId,omitempty"`
+	Label     string   `json:"label,omitempty"`
+	Alignment string   `json:"alignment"`
+	MinX      int      `json:"minX"`
+	MinZ      int      `json:"minZ"`
+	MaxX      int      `json:"maxX"`
+	MaxZ      int      `json:"maxZ"`
+	Members   []string `json:"members,omitempty"`
+}
+
+type claimMembersPayload struct {
+	PlayerID  string `json:"playerId"`
+	ActionID  string `json:"actionId"`
+	ClaimID   string `json:"claimId"`
+	Operation string `json:"operation"`
+	MemberID  string `json:"memberId"`
+}
+
+type claimRemovePayload struct {
+	PlayerID string `json:"playerId"`
+	ActionID string `json:"actionId"`
+	ClaimID  string `json:"claimId"`
+}
+
+type claimListPayload struct {
+	PlayerID string `json:"playerId"`
+}
+
+const (
+	maxClaimSpanCells  = 64
+	maxClaimsPerPlayer = 3
+	maxClaimMembers    = 16
+	campAnchorX        = 0.0
+	campAnchorZ        = 0.0
+)
+
+// maxClaimCell bounds every claim coordinate, in global block cells, so claim
+// arithmetic can never overflow. It is far beyond any reachable cell.
+const maxClaimCell = 1 << 30
+
+// resolveClaimBounds converts a claim request into inclusive global block-cell
+// bounds. Chunk-aligned claims cover every cell of the listed chunks. It
+// returns a rejection reason when the alignment is unknown or a coordinate
+// lies outside the claimable world.
+func resolveClaimBounds(alignment string, minX int, minZ int, maxX int, maxZ int) (int, int, int, int, string) {
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	if minZ > maxZ {
+		minZ, maxZ = maxZ, minZ
+	}
+	switch alignment {
+	case "chunk":
+		limit := maxClaimCell / chunkGridCells
+		if !claimCoordsWithin(limit, minX, minZ, maxX, maxZ) {
+			return 0, 0, 0, 0, "claim_out_of_bounds"
+		}
+		return minX * chunkGridCells, minZ * chunkGridCells, (maxX * chunkGridCells) + chunkGridCells - 1, (maxZ * chunkGridCells) + chunkGridCells - 1, ""
+	case "block", "":
+		if !claimCoordsWithin(maxClaimCell, minX, minZ, maxX, maxZ) {
+			return 0, 0, 0, 0, "claim_out_of_bounds"
+		}
+		return minX, minZ, maxX, maxZ, ""
+	default:
+		return 0, 0, 0, 0, "invalid_alignment"
+	}
+}
+
+func claimCoordsWithin(limit int, coords ...int) bool {
+	for _, coord := range coords {
+		if coord < -limit || coord > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// claimSpanAllowed reports whether bounds are in the world, ordered, and no
+// wider than maxClaimSpanCells. The span is compared without adding one so it
+// cannot overflow.
+func claimSpanAllowed(minX int, minZ int, maxX int, maxZ int) bool {
+	if !claimCoordsWithin(maxClaimCell, minX, minZ, maxX, maxZ) {
+		return false
+	}
+	return maxX >= minX && maxX-minX < maxClaimSpanCells && maxZ >= minZ && maxZ-minZ < maxClaimSpanCells
+}
+
+func claimContainsCell(claim runtimeLandClaim, cellX int, cellZ int) bool {
+	return cellX >= claim.MinX && cellX <= claim.MaxX && cellZ >= claim.MinZ && cellZ <= claim.MaxZ
+}
+
+func claimsOverlap(left runtimeLandClaim, right runtimeLandClaim) bool {
+	return left.MinX <= right.MaxX && right.MinX <= left.MaxX && left.MinZ <= right.MaxZ && right.MinZ <= left.MaxZ
+}
+
+func claimAllowsPlayer(claim runtimeLandClaim, playerID string) bool {
+	if playerID == "" {
+		return false
+	}
+	if claim.OwnerID == playerID {
+		return true
+	}
+	for _, memberID := range claim.Members {
+		if memberID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func globalBlockCell(chunkX int, chunkZ int, x int, z int) (int, int) {
+	return (chunkX * chunkGridCells) + x, (chunkZ * chunkGridCells) + z
+}
+
+func globalCellAtWorld(worldX float64, worldZ float64) (int, int) {
+	chunkX, chunkZ, cellX, cellZ := blockCellAtWorld(worldX, worldZ)
+	return globalBlockCell(chunkX, chunkZ, cellX, cellZ)
+}
+
+func cloneLandClaim(claim runtimeLandClaim) runtimeLandClaim {
+	claim.Members = append([]string{}, claim.Members...)
+	return claim
+}
+
+func normalizeClaimMembers(ownerID string, members []string) []string {
+	normalized := uniquePlayerIDs(ownerID, members)
+	if len(normalized) > maxClaimMembers {
+		normalized = normalized[:maxClaimMembers]
+	}
+	return normalized
+}
+
+// uniquePlayerIDs trims, de-duplicates and sorts player ids, leaving out
+// blanks and ownerID.
+func uniquePlayerIDs(ownerID string, members []string) []string {
+	seen := make(map[string]struct{}, len(members))
+	normalized := make([]string, 0, len(members))
+	for _, memberID := range members {
+		memberID = strings.TrimSpace(memberID)
+		if memberID == "" || memberID == ownerID {
+			continue
+		}
+		if _, exists := seen[memberID]; exists {
+			continue
+		}
+		seen[memberID] = struct{}{}
+		normalized = append(normalized, memberID)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func (h *worldHub) claimAtCellLocked(cellX int, cellZ int) (runtimeLandClaim, bool) {
+	for _, claim := range h.claims {
+		if claimContainsCell(claim, cellX, cellZ) {
+			return claim, true
+		}
+	}
+	return runtimeLandClaim{}, false
+}
+
+// canEditCellLocked reports whether playerID may change blocks in the given
+// global cell. Unclaimed cells are open to everyone.
+func (h *worldHub) canEditCellLocked(playerID string, cellX int, cellZ int) bool {
+	claim, claimed := h.claimAtCellLocked(cellX, cellZ)
+	if !claimed {
+		return true
+	}
+	return claimAllowsPlayer(claim, playerID)
+}
+
+// canUseContainerLocked applies claims to containers placed in the world: a
+// chest or the camp container inside a claim is only usable by its members.
+// Private stashes have no position and are never claim-protected.
+func (h *worldHub) canUseContainerLocked(playerID string, containerID string) bool {
+	if chest, ok := h.chests[containerID]; ok {
+		cellX, cellZ := globalBlockCell(chest.ChunkX, chest.ChunkZ, chest.X, chest.Z)
+		return h.canEditCellLocked(playerID, cellX, cellZ)
+	}
+	anchorX, anchorZ, placed := containerAnchor(containerID)
+	if !placed {
+		return true
+	}
+	cellX, cellZ := globalCellAtWorld(anchorX, anchorZ)
+	return h.canEditCellLocked(playerID, cellX, cellZ)
+}
+
+func (h *worldHub) claimStateLocked() runtimeClaimState {
+	claimIDs := make([]string, 0, len(h.claims))
+	for claimID := range h.claims {
+		claimIDs = append(claimIDs, claimID)
+	}
+	sort.Strings(claimIDs)
+	claims := make([]runtimeLandClaim, 0, len(claimIDs))
+	for _, claimID := range claimIDs {
+		claims = append(claims, cloneLandClaim(h.claims[claimID]))
+	}
+	return runtimeClaimState{
+		Claims: claims,
+		Tick:   h.tick,
+	}
+}
+
+func (h *worldHub) claimState() runtimeClaimState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.claimStateLocked()
+}
+
+// flushClaimState broadcasts the claims if a directive changed them since the
+// last flush.
+func (h *worldHub) flushClaimState() {
+	h.mu.Lock()
+	if !h.claimsChanged {
+		h.mu.Unlock()
+		return
+	}
+	h.claimsChanged = false
+	state := h.claimStateLocked()
+	h.mu.Unlock()
+	h.broadcast(serverEnvelope{
+		Type:    "claim_state",
+		Payload: state,
+	})
+}
+
+// validateClaimLocked checks the shape of a claim and that it does not overlap
+// any other claim. ignoreClaimID lets an existing claim be replaced in place.
+func (h *worldHub) validateClaimLocked(claim runtimeLandClaim, ignoreClaimID string) string {
+	if claim.ClaimID == "" || claim.OwnerID == "" {
+		return "invalid_payload"
+	}
+	if !claimCoordsWithin(maxClaimCell, claim.MinX, claim.MinZ, claim.MaxX, claim.MaxZ) {
+		return "claim_out_of_bounds"
+	}
+	if !claimSpanAllowed(claim.MinX, claim.MinZ, claim.MaxX, claim.MaxZ) {
+		return "claim_too_large"
+	}
+	for claimID, existing := range h.claims {
+		if claimID == ignoreClaimID {
+			continue
+		}
+		if claimsOverlap(existing, claim) {
+			return "claim_overlap"
+		}
+	}
+	return ""
+}
+
+func (h *worldHub) applyClaimCreate(payload claimCreatePayload) (runtimeClaimResult, bool) {
+	result := runtimeClaimResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		ClaimID:   strings.TrimSpace(payload.ClaimID),
+		Operation: "create",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "claim_create", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, false
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "claim_create", payload.ActionID, result.Accepted, result)
+	}()
+
+	if payload.PlayerID == "" || payload.ActionID == "" {
+		result.Reason = "invalid_payload"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	player, ok := h.players[payload.PlayerID]
+	if !ok {
+		result.Reason = "player_not_found"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	minX, minZ, maxX, maxZ, reason := resolveClaimBounds(payload.Alignment, payload.MinX, payload.MinZ, payload.MaxX, payload.MaxZ)
+	if reason != "" {
+		result.Reason = reason
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	if result.ClaimID == "" {
+		result.ClaimID = "claim:" + payload.PlayerID + ":" + payload.ActionID
+	}
+	if _, exists := h.claims[result.ClaimID]; exists {
+		result.Reason = "claim_exists"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	owned := 0
+	for _, claim := range h.claims {
+		if claim.OwnerID == payload.PlayerID {
+			owned++
+		}
+	}
+	if owned >= maxClaimsPerPlayer {
+		result.Reason = "claim_limit_reached"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+
+	claim := runtimeLandClaim{
+		ClaimID:     result.ClaimID,
+		OwnerID:     payload.PlayerID,
+		Label:       strings.TrimSpace(payload.Label),
+		Members:     normalizeClaimMembers(payload.PlayerID, payload.Members),
+		MinX:        minX,
+		MinZ:        minZ,
+		MaxX:        maxX,
+		MaxZ:        maxZ,
+		CreatedTick: h.tick,
+	}
+	playerCellX, playerCellZ := globalCellAtWorld(player.X, player.Z)
+	if !claimContainsCell(claim, playerCellX, playerCellZ) {
+		result.Reason = "claim_not_occupied"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	if reason := h.validateClaimLocked(claim, ""); reason != "" {
+		result.Reason = reason
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+
+	h.claims[claim.ClaimID] = claim
+	result.Accepted = true
+	h.recordClaimEventLocked(result)
+	return result, true
+}
+
+func (h *worldHub) applyClaimMembers(payload claimMembersPayload) (runtimeClaimResult, bool) {
+	result := runtimeClaimResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		ClaimID:   payload.ClaimID,
+		Operation: payload.Operation + "_member",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "claim_members", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, false
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "claim_members", payload.ActionID, result.Accepted, result)
+	}()
+
+	memberID := strings.TrimSpace(payload.MemberID)
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ClaimID == "" || memberID == "" {
+		result.Reason = "invalid_payload"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	if payload.Operation != "add" && payload.Operation != "remove" {
+		result.Reason = "invalid_operation"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	claim, ok := h.claims[payload.ClaimID]
+	if !ok {
+		result.Reason = "claim_not_found"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	if claim.OwnerID != payload.PlayerID {
+		result.Reason = "claim_forbidden"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+
+	members := make([]string, 0, len(claim.Members)+1)
+	for _, existing := range claim.Members {
+		if existing != memberID {
+			members = append(members, existing)
+		}
+	}
+	if payload.Operation == "add" {
+		if len(members) >= maxClaimMembers {
+			result.Reason = "claim_member_limit"
+			h.recordClaimEventLocked(result)
+			return result, false
+		}
+		members = append(members, memberID)
+	}
+	claim.Members = normalizeClaimMembers(claim.OwnerID, members)
+	h.claims[claim.ClaimID] = claim
+	result.Accepted = true
+	h.recordClaimEventLocked(result)
+	return result, true
+}
+
+func (h *worldHub) applyClaimRemove(payload claimRemovePayload) (runtimeClaimResult, bool) {
+	result := runtimeClaimResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		ClaimID:   payload.ClaimID,
+		Operation: "remove",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "claim_remove", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, false
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "claim_remove", payload.ActionID, result.Accepted, result)
+	}()
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ClaimID == "" {
+		result.Reason = "invalid_payload"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	claim, ok := h.claims[payload.ClaimID]
+	if !ok {
+		result.Reason = "claim_not_found"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	if claim.OwnerID != payload.PlayerID {
+		result.Reason = "claim_forbidden"
+		h.recordClaimEventLocked(result)
+		return result, false
+	}
+	delete(h.claims, payload.ClaimID)
+	result.Accepted = true
+	h.recordClaimEventLocked(result)
+	return result, true
+}
+
+// applyClaimDirectiveLocked lets OpenClaw manage claims on behalf of players.
+// Directives skip the per-player limits but still may not overlap claims.
+func (h *worldHub) applyClaimDirectiveLocked(directive openclawDirective) bool {
+	action, _ := directive.Payload["action"].(string)
+	action = strings.TrimSpace(strings.ToLower(action))
+	claimID, _ := directive.Payload["claimId"].(string)
+	claimID = strings.TrimSpace(claimID)
+	if claimID == "" {
+		claimID = "claim:openclaw:" + directive.DirectiveID
+	}
+
+	switch action {
+	case "remove":
+		if _, exists := h.claims[claimID]; !exists {
+			return false
+		}
+		delete(h.claims, claimID)
+		h.recordWorldEventLocked("claim_removed", "openclaw", map[string]any{
+			"claimId":     claimID,
+			"directiveId": directive.DirectiveID,
+		})
+		return true
+	case "add_member", "remove_member":
+		claim, exists := h.claims[claimID]
+		if !exists {
+			return false
+		}
+		memberID, _ := directive.Payload["memberId"].(string)
+		memberID = strings.TrimSpace(memberID)
+		if memberID == "" {
+			return false
+		}
+		members := make([]string, 0, len(claim.Members)+1)
+		for _, existing := range claim.Members {
+			if existing != memberID {
+				members = append(members, existing)
+			}
+		}
+		if action == "add_member" {
+			members = append(members, memberID)
+		}
+		claim.Members = normalizeClaimMembers(claim.OwnerID, members)
+		h.claims[claimID] = claim
+		h.recordWorldEventLocked("claim_updated", "openclaw", map[string]any{
+			"claimId":     claimID,
+			"operation":   action,
+			"memberId":    memberID,
+			"directiveId": directive.DirectiveID,
+		})
+		return true
+	case "create", "":
+		ownerID, _ := directive.Payload["ownerId"].(string)
+		ownerID = strings.TrimSpace(ownerID)
+		alignment, _ := directive.Payload["alignment"].(string)
+		minX, okMinX := intFromAny(directive.Payload["minX"])
+		minZ, okMinZ := intFromAny(directive.Payload["minZ"])
+		maxX, okMaxX := intFromAny(directive.Payload["maxX"])
+		maxZ, okMaxZ := intFromAny(directive.Payload["maxZ"])
+		if ownerID == "" || !okMinX || !okMinZ || !okMaxX || !okMaxZ {
+			return false
+		}
+		minX, minZ, maxX, maxZ, reason := resolveClaimBounds(strings.TrimSpace(alignment), minX, minZ, maxX, maxZ)
+		if reason != "" {
+			return false
+		}
+		label, _ := directive.Payload["label"].(string)
+		members := make([]string, 0)
+		if rawMembers, ok := directive.Payload["members"].([]any); ok {
+			for _, rawMember := range rawMembers {
+				if memberID, ok := rawMember.(string); ok {
+					members = append(members, memberID)
+				}
+			}
+		}
+		claim := runtimeLandClaim{
+			ClaimID:     claimID,
+			OwnerID:     ownerID,
+			Label:       strings.TrimSpace(label),
+			Members:     normalizeClaimMembers(ownerID, members),
+			MinX:        minX,
+			MinZ:        minZ,
+			MaxX:        maxX,
+			MaxZ:        maxZ,
+			CreatedTick: h.tick,
+		}
+		if existing, exists := h.claims[claimID]; exists {
+			claim.CreatedTick = existing.CreatedTick
+		}
+		if reason := h.validateClaimLocked(claim, claimID); reason != "" {
+			h.recordWorldEventLocked("claim_rejected", "openclaw", map[string]any{
+				"claimId":     claimID,
+				"reason":      reason,
+				"directiveId": directive.DirectiveID,
+			})
+			return false
+		}
+		h.claims[claimID] = claim
+		h.recordWorldEventLocked("claim_created", "openclaw", map[string]any{
+			"claimId":     claimID,
+			"ownerId":     ownerID,
+			"minX":        minX,
+			"minZ":        minZ,
+			"maxX":        maxX,
+			"maxZ":        maxZ,
+			"directiveId": directive.DirectiveID,
+		})
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *worldHub) recordClaimEventLocked(result runtimeClaimResult) {
+	eventType := "claim_rejected"
+	if result.Accepted {
+		switch result.Operation {
+		case "create":
+			eventType = "claim_created"
+		case "remove":
+			eventType = "claim_removed"
+		default:
+			eventType = "claim_updated"
+		}
+	}
+	payload := map[string]any{
+		"actionId":  result.ActionID,
+		"claimId":   result.ClaimID,
+		"operation": result.Operation,
+	}
+	if result.Accepted && result.Operation == "create" {
+		claim := h.claims[result.ClaimID]
+		payload["minX"] = claim.MinX
+		payload["minZ"] = claim.MinZ
+		payload["maxX"] = claim.MaxX
+		payload["maxZ"] = claim.MaxZ
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, payload)
+}
+
+func importLandClaims(claims []runtimeLandClaim, fallbackTick int64) map[string]runtimeLandClaim {
+	next := make(map[string]runtimeLandClaim, len(claims))
+	for _, claim := range claims {
+		claimID := strings.TrimSpace(claim.ClaimID)
+		ownerID := strings.TrimSpace(claim.OwnerID)
+		if claimID == "" || ownerID == "" {
+			continue
+		}
+		minX, minZ, maxX, maxZ, reason := resolveClaimBounds("block", claim.MinX, claim.MinZ, claim.MaxX, claim.MaxZ)
+		if reason != "" || !claimSpanAllowed(minX, minZ, maxX, maxZ) {
+			continue
+		}
+		createdTick := claim.CreatedTick
+		if createdTick < 0 {
+			createdTick = fallbackTick
+		}
+		candidate := runtimeLandClaim{
+			ClaimID:     claimID,
+			OwnerID:     ownerID,
+			Label:       strings.TrimSpace(claim.Label),
+			Members:     normalizeClaimMembers(ownerID, claim.Members),
+			MinX:        minX,
+			MinZ:        minZ,
+			MaxX:        maxX,
+			MaxZ:        maxZ,
+			CreatedTick: createdTick,
+		}
+		overlaps := false
+		for _, existing := range next {
+			if claimsOverlap(existing, candidate) {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+		next[claimID] = candidate
+	}
+	return next
+}
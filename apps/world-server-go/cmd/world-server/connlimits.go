@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Application close codes sent when the server drops a connection for
+// misbehaving. Clients should not reconnect in a tight loop after these.
+const (
+	idleTimeoutCloseCode = 4002
+	rateLimitedCloseCode = 4008
+)
+
+// messageRate is a token bucket configuration: Rate tokens refill per second
+// up to Burst.
+type messageRate struct {
+	Rate  float64
+	Burst float64
+}
+
+// messageTypeRateScales sets each client message type's rate as a multiple of
+// the base rate, so every type drains its own bucket. Movement input is sent
+// on every key change; session, trade, claim and ACL messages are rare.
+var messageTypeRateScales = map[string]float64{
+	"hello":                 0.25,
+	"join":                  0.25,
+	"resume":                0.25,
+	"leave":                 0.25,
+	"input":                 3,
+	"block_action":          1,
+	"combat_action":         1,
+	"interact_action":       0.5,
+	"drop_item":             0.5,
+	"hotbar_select":         1,
+	"hotbar_assign":         0.5,
+	"hotbar_swap":           0.5,
+	"craft_request":         0.5,
+	"craft_cancel":          0.5,
+	"container_action":      1,
+	"container_transaction": 0.5,
+	"container_open":        0.5,
+	"container_close":       0.5,
+	"container_acl":         0.25,
+	"inventory_slot_op":     1,
+	"trade_request":         0.25,
+	"trade_offer_update":    0.5,
+	"trade_confirm":         0.25,
+	"trade_cancel":          0.25,
+	"claim_create":          0.25,
+	"claim_members":         0.25,
+	"claim_remove":          0.25,
+	"claim_list":            0.25,
+}
+
+// messageTypeRates derives every known type's bucket from the base rate. Each
+// bucket bursts to two seconds of its rate.
+func messageTypeRates(base float64) map[string]messageRate {
+	rates := make(map[string]messageRate, len(messageTypeRateScales))
+	for messageType, scale := range messageTypeRateScales {
+		rate := base * scale
+		rates[messageType] = messageRate{Rate: rate, Burst: rate * 2}
+	}
+	return rates
+}
+
+// connectionLimits bounds what one WebSocket connection may cost the server.
+// TypeRates holds a bucket per known message type; DefaultRate is the one
+// bucket shared by types the server does not know.
+type connectionLimits struct {
+	HeartbeatInterval time.Duration
+	IdleTimeout       time.Duration
+	// WriteTimeout bounds every write, so a peer that stopped reading cannot
+	// stall the tick loop that writes to it.
+	WriteTimeout    time.Duration
+	MaxMessageBytes int64
+	DefaultRate     messageRate
+	TypeRates       map[string]messageRate
+}
+
+func defaultConnectionLimits() connectionLimits {
+	return connectionLimits{
+		HeartbeatInterval: 15 * time.Second,
+		IdleTimeout:       45 * time.Second,
+		WriteTimeout:      5 * time.Second,
+		MaxMessageBytes:   64 * 1024,
+		DefaultRate:       messageRate{Rate: 20, Burst: 40},
+		TypeRates:         messageTypeRates(20),
+	}
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// messageLimiter rate limits one connection with a token bucket per message
+// type. Unknown types share a single bucket so a client cannot grow the map by
+// inventing types.
+type messageLimiter struct {
+	limits  connectionLimits
+	buckets map[string]*tokenBucket
+}
+
+func newMessageLimiter(limits connectionLimits) *messageLimiter {
+	return &messageLimiter{
+		limits:  limits,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// bucketName returns the bucket a message type draws from: the type itself
+// when it is a known type, "*" otherwise. Unlike the raw type, it is always
+// a configured name, so it is safe to use as a metrics key or close reason.
+func (l *messageLimiter) bucketName(messageType string) string {
+	if _, ok := l.limits.TypeRates[messageType]; ok {
+		return messageType
+	}
+	return "*"
+}
+
+func (l *messageLimiter) allow(messageType string, now time.Time) bool {
+	name := l.bucketName(messageType)
+	rate, ok := l.limits.TypeRates[name]
+	if !ok {
+		rate = l.limits.DefaultRate
+	}
+	if rate.Rate <= 0 {
+		return true
+	}
+	bucket, ok := l.buckets[name]
+	if !ok {
+		bucket = &tokenBucket{tokens: rate.Burst, updated: now}
+		l.buckets[name] = bucket
+	}
+	bucket.tokens = min(rate.Burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate.Rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// connectionMetrics counts connection lifecycle outcomes for /debug/metrics.
+type connectionMetrics struct {
+	Open        int64            `json:"open"`
+	Accepted    int64            `json:"accepted"`
+	Closes      map[string]int64 `json:"closes"`
+	RateLimited map[string]int64 `json:"rateLimited"`
+}
+
+func (h *worldHub) recordConnectionOpened() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connMetrics.Open++
+	h.connMetrics.Accepted++
+}
+
+// recordConnectionClosed counts why a connection ended. Closes the server
+// forced are also written to the event log with the players involved.
+func (h *worldHub) recordConnectionClosed(client *clientConn, reason string, forced bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connMetrics.Open--
+	h.connMetrics.Closes[reason]++
+	if !forced {
+		return
+	}
+	playerIDs := make([]string, 0, len(client.playerIDs))
+	for playerID := range client.playerIDs {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	h.recordWorldEventLocked("connection_closed", "", map[string]any{
+		"reason":    reason,
+		"playerIds": playerIDs,
+	})
+}
+
+// recordRateLimited counts a rate-limited close under the limiter bucket
+// that ran dry.
+func (h *worldHub) recordRateLimited(bucketName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connMetrics.RateLimited[bucketName]++
+}
+
+func (h *worldHub) connectionMetricsSnapshot() connectionMetrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	closes := make(map[string]int64, len(h.connMetrics.Closes))
+	for reason, count := range h.connMetrics.Closes {
+		closes[reason] = count
+	}
+	rateLimited := make(map[string]int64, len(h.connMetrics.RateLimited))
+	for bucketName, count := range h.connMetrics.RateLimited {
+		rateLimited[bucketName] = count
+	}
+	return connectionMetrics{
+		Open:        h.connMetrics.Open,
+		Accepted:    h.connMetrics.Accepted,
+		Closes:      closes,
+		RateLimited: rateLimited,
+	}
+}
+
+// connWriteState tracks why writes to a connection stopped. The read loop
+// owns the close bookkeeping, so a failed write only marks the connection.
+type connWriteState struct {
+	timedOut atomic.Bool
+}
+
+// armWriteDeadline bounds the next write; callers hold writeMu.
+func (c *clientConn) armWriteDeadline() {
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+}
+
+// dropClientAfterWriteError closes a connection whose write failed. When the
+// write timed out, the read loop records the close as write_timeout through
+// the same metrics and event path as the other forced closes.
+func (h *worldHub) dropClientAfterWriteError(client *clientConn, messageType string, err error) {
+	var netError net.Error
+	if errors.As(err, &netError) && netError.Timeout() {
+		client.writeState.timedOut.Store(true)
+	}
+	h.logger.Warn("client write failed", "connId", client.id, "messageType", messageType, "err", err)
+	_ = client.conn.Close()
+	h.removeClient(client)
+}
+
+// readCloseReason classifies the error that ended a connection's read loop.
+func readCloseReason(err error) (string, bool) {
+	var netError net.Error
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		return "message_too_large", true
+	case errors.As(err, &netError) && netError.Timeout():
+		return "idle_timeout", true
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return "client_closed", false
+	default:
+		return "connection_lost", false
+	}
+}
+
+// startHeartbeat pings the connection every interval so half-open sockets hit
+// the idle timeout. The returned func stops it.
+func startHeartbeat(conn *websocket.Conn, interval time.Duration) func() {
+	stop := make(chan struct{})
+	if interval <= 0 {
+		return func() { close(stop) }
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+func buildMetricsHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"connections": hub.connectionMetricsSnapshot(),
+		})
+	}
+}
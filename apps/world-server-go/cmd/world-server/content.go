@@ -0,0 +1,721 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//go:embed content/default/*.json
+var builtinContentFiles embed.FS
+
+const (
+	contentItemsFile       = "items.json"
+	contentCombatSlotsFile = "combat_slots.json"
+	contentRecipesFile     = "recipes.json"
+)
+
+// lootResourceIDs are granted by the block and entity loot tables in code, so
+// every content pack must declare them.
+var lootResourceIDs = []string{
+	"salvage",
+	"wood",
+	"stone",
+	"fiber",
+	"coal",
+	"iron_ore",
+}
+
+type contentResource struct {
+	ID       string `json:"id"`
+	Label    string `json:"label,omitempty"`
+	MaxStack int    `json:"maxStack,omitempty"`
+}
+
+type contentCombatSlot struct {
+	ID             string  `json:"id"`
+	Label          string  `json:"label,omitempty"`
+	Kind           string  `json:"kind"`
+	CooldownTicks  int64   `json:"cooldownTicks"`
+	MaxRange       float64 `json:"maxRange"`
+	RequiresTarget bool    `json:"requiresTarget"`
+	Damage         int     `json:"damage,omitempty"`
+	Heal           int     `json:"heal,omitempty"`
+	DefaultStack   int     `json:"defaultStack,omitempty"`
+	MaxStack       int     `json:"maxStack,omitempty"`
+	MaxDurability  int     `json:"maxDurability,omitempty"`
+}
+
+type contentIngredient struct {
+	ResourceID string `json:"resourceId"`
+	Amount     int    `json:"amount"`
+}
+
+type contentRecipeOutput struct {
+	TargetSlotID string `json:"targetSlotId,omitempty"`
+	ResourceID   string `json:"resourceId,omitempty"`
+	RepairSlotID string `json:"repairSlotId,omitempty"`
+	Amount       int    `json:"amount"`
+}
+
+type contentRecipe struct {
+	ID            string              `json:"id"`
+	Label         string              `json:"label,omitempty"`
+	Keybind       string              `json:"keybind,omitempty"`
+	Summary       string              `json:"summary,omitempty"`
+	DurationTicks int64               `json:"durationTicks,omitempty"`
+	Station       string              `json:"station,omitempty"`
+	Ingredients   []contentIngredient `json:"ingredients"`
+	Output        contentRecipeOutput `json:"output"`
+}
+
+type contentItemsFileData struct {
+	Version   string            `json:"version"`
+	Resources []contentResource `json:"resources"`
+}
+
+type contentCombatSlotsFileData struct {
+	Slots         []contentCombatSlot `json:"slots"`
+	DefaultHotbar []string            `json:"defaultHotbar"`
+}
+
+type contentRecipesFileData struct {
+	Recipes []contentRecipe `json:"recipes"`
+}
+
+type contentPack struct {
+	Version       string
+	Resources     []contentResource
+	CombatSlots   []contentCombatSlot
+	DefaultHotbar []string
+	Recipes       []contentRecipe
+}
+
+// runtimeContentCatalog is the `content_catalog` envelope payload; clients
+// build hotbar and crafting UI from it instead of local literals.
+type runtimeContentCatalog struct {
+	Version       string              `json:"version"`
+	Hash          string              `json:"hash"`
+	Resources     []contentResource   `json:"resources"`
+	CombatSlots   []contentCombatSlot `json:"combatSlots"`
+	DefaultHotbar []string            `json:"defaultHotbar"`
+	Recipes       []contentRecipe     `json:"recipes"`
+}
+
+// runtimeContent is the compiled, validated form of a content pack. It is
+// never mutated after compileContentPack returns, so it can be shared freely.
+type runtimeContent struct {
+	combatSlots          map[string]combatSlotConfig
+	defaultStacks        map[string]int
+	defaultHotbarSlotIDs []string
+	recipes              map[string]craftRecipeConfig
+	resourceIDs          []string
+	itemMaxStacks        map[string]int
+	stations             map[string]struct{}
+	catalog              runtimeContentCatalog
+}
+
+type contentValidationError struct {
+	Problems []string
+}
+
+func (e *contentValidationError) Error() string {
+	return "invalid content pack: " + strings.Join(e.Problems, "; ")
+}
+
+var builtinContent = mustLoadBuiltinContent()
+
+func mustLoadBuiltinContent() *runtimeContent {
+	files, err := fs.Sub(builtinContentFiles, "content/default")
+	if err != nil {
+		panic(err)
+	}
+	content, err := loadRuntimeContentFS(files)
+	if err != nil {
+		panic(fmt.Sprintf("world-server: builtin content: %v", err))
+	}
+	return content
+}
+
+// loadRuntimeContent loads the pack in dir, or the builtin pack when dir is empty.
+func loadRuntimeContent(dir string) (*runtimeContent, error) {
+	if strings.TrimSpace(dir) == "" {
+		return builtinContent, nil
+	}
+	return loadRuntimeContentFS(os.DirFS(dir))
+}
+
+func loadRuntimeContentFS(files fs.FS) (*runtimeContent, error) {
+	pack, err := readContentPack(files)
+	if err != nil {
+		return nil, err
+	}
+	return compileContentPack(pack)
+}
+
+func readContentPack(files fs.FS) (contentPack, error) {
+	var items contentItemsFileData
+	if err := readContentFile(files, contentItemsFile, &items); err != nil {
+		return contentPack{}, err
+	}
+	var slots contentCombatSlotsFileData
+	if err := readContentFile(files, contentCombatSlotsFile, &slots); err != nil {
+		return contentPack{}, err
+	}
+	var recipes contentRecipesFileData
+	if err := readContentFile(files, contentRecipesFile, &recipes); err != nil {
+		return contentPack{}, err
+	}
+	return contentPack{
+		Version:       items.Version,
+		Resources:     items.Resources,
+		CombatSlots:   slots.Slots,
+		DefaultHotbar: slots.DefaultHotbar,
+		Recipes:       recipes.Recipes,
+	}, nil
+}
+
+func readContentFile(files fs.FS, name string, target any) error {
+	raw, err := fs.ReadFile(files, name)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", name, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return fmt.Errorf("decode %s: %w", name, err)
+	}
+	return nil
+}
+
+func compileContentPack(pack contentPack) (*runtimeContent, error) {
+	problems := validateContentPack(pack)
+	if len(problems) > 0 {
+		return nil, &contentValidationError{Problems: problems}
+	}
+
+	content := &runtimeContent{
+		combatSlots:          make(map[string]combatSlotConfig, len(pack.CombatSlots)),
+		defaultStacks:        make(map[string]int, len(pack.CombatSlots)),
+		defaultHotbarSlotIDs: append([]string{}, pack.DefaultHotbar...),
+		recipes:              make(map[string]craftRecipeConfig, len(pack.Recipes)),
+		resourceIDs:          make([]string, 0, len(pack.Resources)),
+		itemMaxStacks:        make(map[string]int, len(pack.Resources)+len(pack.CombatSlots)),
+		stations:             make(map[string]struct{}),
+	}
+	for _, resource := range pack.Resources {
+		content.resourceIDs = append(content.resourceIDs, resource.ID)
+		content.itemMaxStacks[resource.ID] = resource.MaxStack
+	}
+	for _, slot := range pack.CombatSlots {
+		content.combatSlots[slot.ID] = combatSlotConfig{
+			kind:           slot.Kind,
+			cooldownTicks:  slot.CooldownTicks,
+			maxRange:       slot.MaxRange,
+			requiresTarget: slot.RequiresTarget,
+			damage:         slot.Damage,
+			heal:           slot.Heal,
+			maxDurability:  slot.MaxDurability,
+		}
+		content.defaultStacks[slot.ID] = slot.DefaultStack
+		if slot.Kind == "item" {
+			content.itemMaxStacks[slot.ID] = slot.MaxStack
+		} else if slot.MaxDurability > 0 {
+			content.itemMaxStacks[slot.ID] = 1
+		}
+	}
+	for _, recipe := range pack.Recipes {
+		ingredients := make([]craftIngredient, 0, len(recipe.Ingredients))
+		for _, ingredient := range recipe.Ingredients {
+			ingredients = append(ingredients, craftIngredient{
+				resourceID: ingredient.ResourceID,
+				amount:     ingredient.Amount,
+			})
+		}
+		content.recipes[recipe.ID] = craftRecipeConfig{
+			id:          recipe.ID,
+			ingredients: ingredients,
+			output: craftOutput{
+				targetSlotID: recipe.Output.TargetSlotID,
+				resourceID:   recipe.Output.ResourceID,
+				repairSlotID: recipe.Output.RepairSlotID,
+				amount:       recipe.Output.Amount,
+			},
+			durationTicks: recipe.DurationTicks,
+			station:       recipe.Station,
+		}
+		if recipe.Station != "" {
+			content.stations[recipe.Station] = struct{}{}
+		}
+	}
+
+	content.catalog = runtimeContentCatalog{
+		Version:       pack.Version,
+		Resources:     append([]contentResource{}, pack.Resources...),
+		CombatSlots:   append([]contentCombatSlot{}, pack.CombatSlots...),
+		DefaultHotbar: append([]string{}, pack.DefaultHotbar...),
+		Recipes:       append([]contentRecipe{}, pack.Recipes...),
+	}
+	encoded, err := json.Marshal(content.catalog)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(encoded)
+	content.catalog.Hash = hex.EncodeToString(sum[:])
+	return content, nil
+}
+
+func validateContentPack(pack contentPack) []string {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	resources := make(map[string]struct{}, len(pack.Resources))
+	if len(pack.Resources) == 0 {
+		addProblem("no resources defined")
+	}
+	for index, resource := range pack.Resources {
+		if strings.TrimSpace(resource.ID) == "" {
+			addProblem("resource %d: missing id", index)
+			continue
+		}
+		if _, exists := resources[resource.ID]; exists {
+			addProblem("resource %q: duplicate id", resource.ID)
+		}
+		if resource.MaxStack < 0 {
+			addProblem("resource %q: negative maxStack", resource.ID)
+		}
+		resources[resource.ID] = struct{}{}
+	}
+	for _, resourceID := range lootResourceIDs {
+		if _, exists := resources[resourceID]; !exists {
+			addProblem("resource %q: required by loot tables but not defined", resourceID)
+		}
+	}
+
+	slots := make(map[string]struct{}, len(pack.CombatSlots))
+	durableSlots := make(map[string]struct{}, len(pack.CombatSlots))
+	for index, slot := range pack.CombatSlots {
+		if strings.TrimSpace(slot.ID) == "" {
+			addProblem("combat slot %d: missing id", index)
+			continue
+		}
+		if _, exists := slots[slot.ID]; exists {
+			addProblem("combat slot %q: duplicate id", slot.ID)
+		}
+		slots[slot.ID] = struct{}{}
+		switch slot.Kind {
+		case "melee", "spell", "item":
+		default:
+			addProblem("combat slot %q: unknown kind %q", slot.ID, slot.Kind)
+		}
+		if slot.CooldownTicks < 0 {
+			addProblem("combat slot %q: negative cooldownTicks", slot.ID)
+		}
+		if slot.MaxRange < 0 {
+			addProblem("combat slot %q: negative maxRange", slot.ID)
+		}
+		if slot.Damage < 0 || slot.Heal < 0 || slot.DefaultStack < 0 || slot.MaxStack < 0 || slot.MaxDurability < 0 {
+			addProblem("combat slot %q: negative damage, heal, defaultStack, maxStack or maxDurability", slot.ID)
+		}
+		if slot.MaxDurability > 0 {
+			if slot.Kind == "item" {
+				addProblem("combat slot %q: item slots cannot have maxDurability", slot.ID)
+			}
+			durableSlots[slot.ID] = struct{}{}
+		}
+		if slot.MaxStack > 0 && slot.DefaultStack > slot.MaxStack {
+			addProblem("combat slot %q: defaultStack exceeds maxStack", slot.ID)
+		}
+	}
+	if len(pack.DefaultHotbar) == 0 {
+		addProblem("default hotbar is empty")
+	}
+	if len(pack.DefaultHotbar) > maxHotbarSlots {
+		addProblem("default hotbar: %d slots exceeds limit %d", len(pack.DefaultHotbar), maxHotbarSlots)
+	}
+	hotbarSlots := make(map[string]struct{}, len(pack.DefaultHotbar))
+	for _, slotID := range pack.DefaultHotbar {
+		if _, exists := slots[slotID]; !exists {
+			addProblem("default hotbar: unknown combat slot %q", slotID)
+		}
+		if _, exists := hotbarSlots[slotID]; exists {
+			addProblem("default hotbar: duplicate combat slot %q", slotID)
+		}
+		hotbarSlots[slotID] = struct{}{}
+	}
+
+	recipes := make(map[string]struct{}, len(pack.Recipes))
+	for index, recipe := range pack.Recipes {
+		if strings.TrimSpace(recipe.ID) == "" {
+			addProblem("recipe %d: missing id", index)
+			continue
+		}
+		if _, exists := recipes[recipe.ID]; exists {
+			addProblem("recipe %q: duplicate id", recipe.ID)
+		}
+		recipes[recipe.ID] = struct{}{}
+		if recipe.DurationTicks < 0 {
+			addProblem("recipe %q: negative durationTicks", recipe.ID)
+		}
+		if recipe.Station != strings.TrimSpace(recipe.Station) {
+			addProblem("recipe %q: station %q has surrounding whitespace", recipe.ID, recipe.Station)
+		}
+		if _, exists := resources[recipe.Station]; recipe.Station != "" && !exists {
+			addProblem("recipe %q: station %q is not a defined resource", recipe.ID, recipe.Station)
+		}
+		if len(recipe.Ingredients) == 0 {
+			addProblem("recipe %q: no ingredients", recipe.ID)
+		}
+		for _, ingredient := range recipe.Ingredients {
+			if _, exists := resources[ingredient.ResourceID]; !exists {
+				addProblem("recipe %q: unknown ingredient resource %q", recipe.ID, ingredient.ResourceID)
+			}
+			if ingredient.Amount <= 0 {
+				addProblem("recipe %q: ingredient %q amount must be positive", recipe.ID, ingredient.ResourceID)
+			}
+		}
+		output := recipe.Output
+		targets := 0
+		for _, target := range []string{output.TargetSlotID, output.ResourceID, output.RepairSlotID} {
+			if target != "" {
+				targets++
+			}
+		}
+		switch {
+		case targets > 1:
+			addProblem("recipe %q: output must target exactly one of a slot, a resource or a repair", recipe.ID)
+		case output.RepairSlotID != "":
+			if _, exists := durableSlots[output.RepairSlotID]; !exists {
+				addProblem("recipe %q: repair targets combat slot %q without durability", recipe.ID, output.RepairSlotID)
+			}
+		case output.TargetSlotID != "":
+			if _, exists := slots[output.TargetSlotID]; !exists {
+				addProblem("recipe %q: output targets missing combat slot %q", recipe.ID, output.TargetSlotID)
+			}
+		case output.ResourceID != "":
+			if _, exists := resources[output.ResourceID]; !exists {
+				addProblem("recipe %q: output targets unknown resource %q", recipe.ID, output.ResourceID)
+			}
+		default:
+			addProblem("recipe %q: output has no target", recipe.ID)
+		}
+		if output.Amount <= 0 {
+			addProblem("recipe %q: output amount must be positive", recipe.ID)
+		}
+	}
+
+	return problems
+}
+
+func (c *runtimeContent) defaultHotbarStackCounts(slotIDs []string) []int {
+	counts := make([]int, len(slotIDs))
+	for index, slotID := range slotIDs {
+		counts[index] = c.defaultStacks[slotID]
+	}
+	return counts
+}
+
+func (c *runtimeContent) defaultResourceMap() map[string]int {
+	resources := make(map[string]int, len(c.resourceIDs))
+	for _, resourceID := range c.resourceIDs {
+		resources[resourceID] = 0
+	}
+	return resources
+}
+
+func (h *worldHub) contentCatalog() runtimeContentCatalog {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.content.catalog
+}
+
+type contentReloadAck struct {
+	Accepted        bool     `json:"accepted"`
+	Reason          string   `json:"reason,omitempty"`
+	Problems        []string `json:"problems,omitempty"`
+	Version         string   `json:"version,omitempty"`
+	Hash            string   `json:"hash,omitempty"`
+	MigratedPlayers []string `json:"migratedPlayers,omitempty"`
+	Tick            int64    `json:"tick"`
+}
+
+type contentSwapResult struct {
+	ack             contentReloadAck
+	hotbarStates    []runtimeHotbarState
+	inventoryStates []runtimeInventoryState
+	containerStates []runtimeContainerState
+}
+
+// reloadContent re-reads the configured content pack and, when it validates,
+// swaps it in under the hub lock so the change lands between ticks. A failed
+// load leaves the active content untouched.
+func (h *worldHub) reloadContent() contentReloadAck {
+	h.mu.Lock()
+	dir := h.contentDir
+	h.mu.Unlock()
+
+	content, err := loadRuntimeContent(dir)
+	if err != nil {
+		h.mu.Lock()
+		ack := contentReloadAck{
+			Accepted: false,
+			Reason:   "content_invalid",
+			Tick:     h.tick,
+		}
+		var validationErr *contentValidationError
+		if errors.As(err, &validationErr) {
+			ack.Problems = append([]string{}, validationErr.Problems...)
+		} else {
+			ack.Problems = []string{err.Error()}
+		}
+		h.recordWorldEventLocked("content_reload_failed", "", map[string]any{
+			"problems": ack.Problems,
+		})
+		h.mu.Unlock()
+		return ack
+	}
+
+	h.mu.Lock()
+	if problems := h.removedHeldItemsLocked(content); len(problems) > 0 {
+		ack := contentReloadAck{
+			Accepted: false,
+			Reason:   "content_removes_held_items",
+			Problems: problems,
+			Tick:     h.tick,
+		}
+		h.recordWorldEventLocked("content_reload_failed", "", map[string]any{
+			"reason":   ack.Reason,
+			"problems": append([]string{}, problems...),
+		})
+		h.mu.Unlock()
+		return ack
+	}
+	swap := h.swapContentLocked(content)
+	h.mu.Unlock()
+
+	h.broadcast(serverEnvelope{
+		Type:    "content_catalog",
+		Payload: content.catalog,
+	})
+	for _, hotbarState := range swap.hotbarStates {
+		h.sendToPlayerOwnedRecipients(hotbarState.PlayerID, serverEnvelope{
+			Type:    "hotbar_state",
+			Payload: hotbarState,
+		})
+	}
+	for _, inventoryState := range swap.inventoryStates {
+		h.sendToPlayerOwnedRecipients(inventoryState.PlayerID, serverEnvelope{
+			Type:    "inventory_state",
+			Payload: inventoryState,
+		})
+	}
+	for _, containerState := range swap.containerStates {
+		h.publishContainerState(containerState, "")
+	}
+	return swap.ack
+}
+
+func (h *worldHub) swapContentLocked(content *runtimeContent) contentSwapResult {
+	previous := h.content
+	h.content = content
+
+	result := contentSwapResult{
+		ack: contentReloadAck{
+			Accepted:        true,
+			Version:         content.catalog.Version,
+			Hash:            content.catalog.Hash,
+			MigratedPlayers: make([]string, 0),
+			Tick:            h.tick,
+		},
+	}
+
+	playerIDs := make([]string, 0, len(h.hotbarStates))
+	for playerID := range h.hotbarStates {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		migrated, changed := h.migrateHotbarStateLocked(h.hotbarStates[playerID])
+		if !changed {
+			continue
+		}
+		h.hotbarStates[playerID] = cloneHotbarState(migrated)
+		result.hotbarStates = append(result.hotbarStates, cloneHotbarState(migrated))
+		result.ack.MigratedPlayers = append(result.ack.MigratedPlayers, playerID)
+	}
+
+	resourcesChanged := !reflect.DeepEqual(previous.resourceIDs, content.resourceIDs)
+	if resourcesChanged {
+		inventoryIDs := make([]string, 0, len(h.inventoryStates))
+		for playerID := range h.inventoryStates {
+			inventoryIDs = append(inventoryIDs, playerID)
+		}
+		sort.Strings(inventoryIDs)
+		for _, playerID := range inventoryIDs {
+			state := h.inventoryStates[playerID]
+			state.Slots = content.normalizeItemSlots(state.Slots, playerInventoryCapacity)
+			h.syncInventoryLocked(&state)
+			result.inventoryStates = append(result.inventoryStates, cloneInventoryState(state))
+		}
+		containerIDs := make([]string, 0, len(h.containerStates))
+		for containerID := range h.containerStates {
+			containerIDs = append(containerIDs, containerID)
+		}
+		sort.Strings(containerIDs)
+		for _, containerID := range containerIDs {
+			state := h.containerStates[containerID]
+			state.Slots = content.normalizeItemSlots(state.Slots, containerCapacity(containerID))
+			h.syncContainerLocked(&state)
+			result.containerStates = append(result.containerStates, cloneContainerState(state))
+		}
+	}
+
+	// Parked players get back exactly what they left with, so their state
+	// follows the new content too. Nobody controls them, so nothing is sent.
+	parkedIDs := make([]string, 0, len(h.departedPlayers))
+	for playerID := range h.departedPlayers {
+		parkedIDs = append(parkedIDs, playerID)
+	}
+	sort.Strings(parkedIDs)
+	for _, playerID := range parkedIDs {
+		departed := h.departedPlayers[playerID]
+		if departed.Hotbar.PlayerID == playerID {
+			if migrated, changed := h.migrateHotbarStateLocked(departed.Hotbar); changed {
+				departed.Hotbar = cloneHotbarState(migrated)
+				result.ack.MigratedPlayers = append(result.ack.MigratedPlayers, playerID)
+			}
+		}
+		if resourcesChanged && departed.Inventory.PlayerID == playerID {
+			departed.Inventory.Slots = content.normalizeItemSlots(departed.Inventory.Slots, playerInventoryCapacity)
+			departed.Inventory.Resources = content.projectResources(departed.Inventory.Slots)
+		}
+		h.departedPlayers[playerID] = departed
+	}
+	sort.Strings(result.ack.MigratedPlayers)
+
+	h.reindexStationsLocked()
+
+	h.recordWorldEventLocked("content_reloaded", "", map[string]any{
+		"version":         content.catalog.Version,
+		"hash":            content.catalog.Hash,
+		"migratedPlayers": append([]string{}, result.ack.MigratedPlayers...),
+	})
+	return result
+}
+
+// removedHeldItemsLocked lists every holding of an item that content no
+// longer defines. Swapping such content in would delete those items, so the
+// reload is refused until the pack keeps the items or the holdings are gone.
+func (h *worldHub) removedHeldItemsLocked(content *runtimeContent) []string {
+	held := make(map[string]map[string]int)
+	hold := func(holder string, itemID string, quantity int) {
+		if itemID == "" || quantity <= 0 || content.isKnownItem(itemID) {
+			return
+		}
+		if held[holder] == nil {
+			held[holder] = make(map[string]int)
+		}
+		held[holder][itemID] += quantity
+	}
+	holdStacks := func(holder string, stacks []runtimeItemStack) {
+		for _, stack := range stacks {
+			hold(holder, stack.ItemID, stack.Quantity)
+		}
+	}
+
+	for playerID, state := range h.inventoryStates {
+		holdStacks(fmt.Sprintf("inventory of player %q", playerID), state.Slots)
+	}
+	for playerID, state := range h.hotbarStates {
+		holdStacks(fmt.Sprintf("hotbar of player %q", playerID), state.Items)
+	}
+	for playerID, departed := range h.departedPlayers {
+		holdStacks(fmt.Sprintf("inventory of parked player %q", playerID), departed.Inventory.Slots)
+		holdStacks(fmt.Sprintf("hotbar of parked player %q", playerID), departed.Hotbar.Items)
+	}
+	for containerID, state := range h.containerStates {
+		holdStacks(fmt.Sprintf("container %q", containerID), state.Slots)
+	}
+	for groundItemID, item := range h.groundItems {
+		hold(fmt.Sprintf("ground item %q", groundItemID), item.Stack.ItemID, item.Stack.Quantity)
+	}
+	for tradeID, session := range h.trades {
+		for _, offer := range session.Offers {
+			holdStacks(fmt.Sprintf("trade %q offer of player %q", tradeID, offer.PlayerID), offer.Items)
+		}
+	}
+	for playerID, jobs := range h.craftQueues {
+		for _, job := range jobs {
+			for itemID, quantity := range job.Ingredients {
+				hold(fmt.Sprintf("craft job %q of player %q", job.JobID, playerID), itemID, quantity)
+			}
+		}
+	}
+
+	problems := make([]string, 0)
+	for holder, items := range held {
+		for itemID, quantity := range items {
+			problems = append(problems, fmt.Sprintf("%s: holds %d of removed item %q", holder, quantity, itemID))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// migrateHotbarStateLocked drops slots the active content no longer defines,
+// keeping stack counts and the selection for surviving slots. Unequipped
+// positions are kept. A hotbar left empty falls back to the content's default
+// hotbar.
+func (h *worldHub) migrateHotbarStateLocked(state runtimeHotbarState) (runtimeHotbarState, bool) {
+	selectedSlotID := ""
+	if state.SelectedIndex >= 0 && state.SelectedIndex < len(state.SlotIDs) {
+		selectedSlotID = state.SlotIDs[state.SelectedIndex]
+	}
+
+	migrated := runtimeHotbarState{
+		PlayerID: state.PlayerID,
+		SlotIDs:  make([]string, 0, len(state.SlotIDs)),
+		Items:    make([]runtimeItemStack, 0, len(state.SlotIDs)),
+		Unlocked: append([]string(nil), state.Unlocked...),
+		Tick:     h.tick,
+	}
+	for index, slotID := range state.SlotIDs {
+		if _, ok := h.content.combatSlots[slotID]; !ok && slotID != "" {
+			if cooldowns, exists := h.combatCooldownTick[state.PlayerID]; exists {
+				delete(cooldowns, slotID)
+			}
+			continue
+		}
+		item := runtimeItemStack{}
+		if index < len(state.Items) {
+			item = cloneItemStack(state.Items[index])
+		}
+		migrated.SlotIDs = append(migrated.SlotIDs, slotID)
+		migrated.Items = append(migrated.Items, item)
+	}
+	if len(migrated.SlotIDs) == len(state.SlotIDs) {
+		return state, false
+	}
+	if len(migrated.SlotIDs) == 0 {
+		migrated.SlotIDs = append([]string{}, h.content.defaultHotbarSlotIDs...)
+		migrated.StackCounts = h.content.defaultHotbarStackCounts(migrated.SlotIDs)
+		migrated.Items = nil
+	}
+	migrated = h.normalizeHotbarItemsLocked(migrated)
+	migrated.SelectedIndex = hotbarSlotIndex(migrated, selectedSlotID)
+	if migrated.SelectedIndex < 0 {
+		migrated.SelectedIndex = 0
+	}
+	return migrated, true
+}
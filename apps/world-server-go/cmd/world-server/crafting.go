@@ -0,0 +1,393 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	maxCraftQueueLength        = 8
+	craftStationRange          = 8.0
+	craftProgressIntervalTicks = 10
+)
+
+type runtimeCraftJob struct {
+	JobID         string         `json:"jobId"`
+	RecipeID      string         `json:"recipeId"`
+	Count         int            `json:"count"`
+	Station       string         `json:"station,omitempty"`
+	DurationTicks int64          `json:"durationTicks"`
+	ProgressTicks int64          `json:"progressTicks"`
+	Ingredients   map[string]int `json:"ingredients"`
+	QueuedTick    int64          `json:"queuedTick"`
+	Stalled       bool           `json:"stalled,omitempty"`
+}
+
+type runtimeCraftQueueState struct {
+	PlayerID string            `json:"playerId"`
+	Jobs     []runtimeCraftJob `json:"jobs"`
+	Tick     int64             `json:"tick"`
+}
+
+// runtimeCraftProgress is the `craft_progress` envelope payload. State is one
+// of queued, in_progress, stalled, completed, failed, cancelled or
+// cancel_rejected.
+type runtimeCraftProgress struct {
+	PlayerID      string `json:"playerId"`
+	JobID         string `json:"jobId"`
+	RecipeID      string `json:"recipeId"`
+	Count         int    `json:"count"`
+	State         string `json:"state"`
+	ProgressTicks int64  `json:"progressTicks"`
+	DurationTicks int64  `json:"durationTicks"`
+	QueuePosition int    `json:"queuePosition"`
+	Reason        string `json:"reason,omitempty"`
+	Replayed      bool   `json:"replayed,omitempty"`
+	Tick          int64  `json:"tick"`
+}
+
+type craftCancelPayload struct {
+	PlayerID string `json:"playerId"`
+	ActionID string `json:"actionId"`
+	JobID    string `json:"jobId"`
+}
+
+func cloneCraftJob(job runtimeCraftJob) runtimeCraftJob {
+	cloned := job
+	cloned.Ingredients = cloneResourceMap(job.Ingredients)
+	return cloned
+}
+
+func cloneCraftJobs(jobs []runtimeCraftJob) []runtimeCraftJob {
+	cloned := make([]runtimeCraftJob, 0, len(jobs))
+	for _, job := range jobs {
+		cloned = append(cloned, cloneCraftJob(job))
+	}
+	return cloned
+}
+
+func craftJobProgress(playerID string, job runtimeCraftJob, state string, position int, tick int64) runtimeCraftProgress {
+	return runtimeCraftProgress{
+		PlayerID:      playerID,
+		JobID:         job.JobID,
+		RecipeID:      job.RecipeID,
+		Count:         job.Count,
+		State:         state,
+		ProgressTicks: job.ProgressTicks,
+		DurationTicks: job.DurationTicks,
+		QueuePosition: position,
+		Tick:          tick,
+	}
+}
+
+func (h *worldHub) enqueueCraftJobLocked(playerID string, job runtimeCraftJob) int {
+	h.craftQueues[playerID] = append(h.craftQueues[playerID], cloneCraftJob(job))
+	position := len(h.craftQueues[playerID]) - 1
+	h.craftProgressOutbox = append(h.craftProgressOutbox, craftJobProgress(playerID, job, "queued", position, h.tick))
+	return position
+}
+
+func (h *worldHub) refundCraftJobLocked(playerID string, job runtimeCraftJob) {
+	inventoryState := h.ensureInventoryStateLocked(playerID)
+	for _, resourceID := range sortedResourceKeys(job.Ingredients) {
+		amount := job.Ingredients[resourceID]
+		added := h.content.addItems(inventoryState.Slots, resourceID, amount)
+		h.dropInventoryOverflowLocked(playerID, resourceID, amount-added)
+	}
+	h.syncInventoryLocked(&inventoryState)
+}
+
+// refundCraftCountsLocked returns the ingredients of count of a job's
+// counts, such as repairs that found nothing left to restore.
+func (h *worldHub) refundCraftCountsLocked(playerID string, job runtimeCraftJob, count int) {
+	if count <= 0 || job.Count <= 0 {
+		return
+	}
+	unused := job
+	unused.Ingredients = make(map[string]int, len(job.Ingredients))
+	for resourceID, amount := range job.Ingredients {
+		unused.Ingredients[resourceID] = amount / job.Count * count
+	}
+	h.refundCraftJobLocked(playerID, unused)
+}
+
+// repairCountProblem rejects a repair with nothing equipped to repair, nothing
+// to restore, or more counts than it takes to restore the item fully, so no
+// ingredients are spent for nothing.
+func repairCountProblem(hotbar runtimeHotbarState, recipe craftRecipeConfig, count int) string {
+	instance := equippedInstance(hotbar, recipe.output.repairSlotID)
+	if instance == nil {
+		return "repair_target_missing"
+	}
+	missing := instance.MaxDurability - instance.Durability
+	if missing <= 0 {
+		return "repair_not_needed"
+	}
+	if recipe.output.amount*(count-1) >= missing {
+		return "repair_count_exceeds_need"
+	}
+	return ""
+}
+
+// advanceCraftQueuesLocked progresses the head job of every queue by one tick.
+// Jobs only progress while their owner is in the world and, for station
+// recipes, standing near the station.
+func (h *worldHub) advanceCraftQueuesLocked() {
+	playerIDs := make([]string, 0, len(h.craftQueues))
+	for playerID := range h.craftQueues {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+
+	for _, playerID := range playerIDs {
+		queue := h.craftQueues[playerID]
+		if len(queue) == 0 {
+			delete(h.craftQueues, playerID)
+			continue
+		}
+		if _, ok := h.players[playerID]; !ok {
+			continue
+		}
+		job := &queue[0]
+		if job.Station != "" && !h.stationNearPlayerLocked(playerID, job.Station) {
+			if !job.Stalled {
+				job.Stalled = true
+				progress := craftJobProgress(playerID, *job, "stalled", 0, h.tick)
+				progress.Reason = "station_required"
+				h.craftProgressOutbox = append(h.craftProgressOutbox, progress)
+			}
+			continue
+		}
+		job.Stalled = false
+		job.ProgressTicks++
+		if job.ProgressTicks < job.DurationTicks {
+			if job.ProgressTicks == 1 || job.ProgressTicks%craftProgressIntervalTicks == 0 {
+				h.craftProgressOutbox = append(h.craftProgressOutbox, craftJobProgress(playerID, *job, "in_progress", 0, h.tick))
+			}
+			continue
+		}
+
+		finished := cloneCraftJob(*job)
+		h.craftQueues[playerID] = queue[1:]
+		if len(h.craftQueues[playerID]) == 0 {
+			delete(h.craftQueues, playerID)
+		}
+		h.completeCraftJobLocked(playerID, finished)
+	}
+}
+
+func (h *worldHub) completeCraftJobLocked(playerID string, job runtimeCraftJob) {
+	progress := craftJobProgress(playerID, job, "completed", 0, h.tick)
+	recipe, ok := h.content.recipes[job.RecipeID]
+	hotbarState := h.ensureHotbarStateLocked(playerID)
+	slotIndex := -1
+	if ok && recipe.output.targetSlotID != "" {
+		slotIndex = hotbarSlotIndex(hotbarState, recipe.output.targetSlotID)
+	}
+	switch {
+	case !ok:
+		progress.State = "failed"
+		progress.Reason = "invalid_recipe"
+	case recipe.output.repairSlotID != "":
+		if equippedInstance(hotbarState, recipe.output.repairSlotID) == nil {
+			progress.State = "failed"
+			progress.Reason = "repair_target_missing"
+			break
+		}
+		restored := h.repairHotbarSlotLocked(&hotbarState, recipe.output.repairSlotID, recipe.output.amount*job.Count)
+		h.storeHotbarLocked(&hotbarState)
+		usedCount := (restored + recipe.output.amount - 1) / recipe.output.amount
+		h.refundCraftCountsLocked(playerID, job, job.Count-usedCount)
+	case slotIndex >= 0:
+		if !h.addToHotbarSlotLocked(&hotbarState, slotIndex, recipe.output.amount*job.Count) {
+			progress.State = "failed"
+			progress.Reason = "inventory_full"
+			break
+		}
+		h.storeHotbarLocked(&hotbarState)
+	case recipe.output.itemID() != "":
+		inventoryState := h.ensureInventoryStateLocked(playerID)
+		outputItemID := recipe.output.itemID()
+		outputAmount := recipe.output.amount * job.Count
+		if !h.content.canAddItems(inventoryState.Slots, outputItemID, outputAmount) {
+			progress.State = "failed"
+			progress.Reason = "inventory_full"
+			break
+		}
+		h.grantItemsLocked(inventoryState.Slots, outputItemID, outputAmount, "craft:"+job.RecipeID)
+		h.syncInventoryLocked(&inventoryState)
+	}
+	if progress.State == "failed" {
+		h.refundCraftJobLocked(playerID, job)
+	}
+
+	h.craftProgressOutbox = append(h.craftProgressOutbox, progress)
+	payload := map[string]any{
+		"actionId": job.JobID,
+		"recipeId": job.RecipeID,
+		"count":    job.Count,
+	}
+	eventType := "craft_completed"
+	if progress.Reason != "" {
+		eventType = "craft_failed"
+		payload["reason"] = progress.Reason
+	}
+	h.recordWorldEventLocked(eventType, playerID, payload)
+}
+
+func (h *worldHub) applyCraftCancel(payload craftCancelPayload) runtimeCraftProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	progress := runtimeCraftProgress{
+		PlayerID: payload.PlayerID,
+		JobID:    payload.JobID,
+		State:    "cancel_rejected",
+		Tick:     h.tick,
+	}
+	if h.replaySeenActionLocked(payload.PlayerID, "craft_cancel", payload.ActionID, &progress) {
+		progress.Replayed = true
+		return progress
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "craft_cancel", payload.ActionID, progress.State == "cancelled", progress)
+	}()
+	if payload.PlayerID == "" || payload.JobID == "" {
+		progress.Reason = "invalid_payload"
+		return progress
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		progress.Reason = "player_not_found"
+		return progress
+	}
+
+	queue := h.craftQueues[payload.PlayerID]
+	for index, job := range queue {
+		if job.JobID != payload.JobID {
+			continue
+		}
+		h.craftQueues[payload.PlayerID] = append(queue[:index:index], queue[index+1:]...)
+		if len(h.craftQueues[payload.PlayerID]) == 0 {
+			delete(h.craftQueues, payload.PlayerID)
+		}
+		h.refundCraftJobLocked(payload.PlayerID, job)
+		progress = craftJobProgress(payload.PlayerID, job, "cancelled", index, h.tick)
+		h.recordWorldEventLocked("craft_cancelled", payload.PlayerID, map[string]any{
+			"actionId": payload.ActionID,
+			"jobId":    job.JobID,
+			"recipeId": job.RecipeID,
+			"count":    job.Count,
+		})
+		return progress
+	}
+	progress.Reason = "craft_job_not_found"
+	return progress
+}
+
+func (h *worldHub) craftQueueState(playerID string) runtimeCraftQueueState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return runtimeCraftQueueState{
+		PlayerID: playerID,
+		Jobs:     cloneCraftJobs(h.craftQueues[playerID]),
+		Tick:     h.tick,
+	}
+}
+
+func (h *worldHub) craftQueueStatesLocked() []runtimeCraftQueueState {
+	playerIDs := make([]string, 0, len(h.craftQueues))
+	for playerID, jobs := range h.craftQueues {
+		if len(jobs) > 0 {
+			playerIDs = append(playerIDs, playerID)
+		}
+	}
+	sort.Strings(playerIDs)
+	states := make([]runtimeCraftQueueState, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		states = append(states, runtimeCraftQueueState{
+			PlayerID: playerID,
+			Jobs:     cloneCraftJobs(h.craftQueues[playerID]),
+			Tick:     h.tick,
+		})
+	}
+	return states
+}
+
+func (h *worldHub) drainCraftProgress() []runtimeCraftProgress {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := h.craftProgressOutbox
+	h.craftProgressOutbox = nil
+	return drained
+}
+
+// flushCraftProgress sends pending craft_progress envelopes to their owners,
+// followed by fresh inventory/hotbar state when a job finished or was undone.
+func (h *worldHub) flushCraftProgress() {
+	for _, progress := range h.drainCraftProgress() {
+		h.sendToPlayerOwnedRecipients(progress.PlayerID, serverEnvelope{
+			Type:    "craft_progress",
+			Payload: progress,
+		})
+		switch progress.State {
+		case "completed", "failed", "cancelled":
+		default:
+			continue
+		}
+		if inventoryState, ok := h.inventoryStateForPlayer(progress.PlayerID); ok {
+			h.sendToPlayerOwnedRecipients(progress.PlayerID, serverEnvelope{
+				Type:    "inventory_state",
+				Payload: inventoryState,
+			})
+		}
+		if hotbarState, ok := h.hotbarStateForPlayer(progress.PlayerID); ok {
+			h.sendToPlayerOwnedRecipients(progress.PlayerID, serverEnvelope{
+				Type:    "hotbar_state",
+				Payload: hotbarState,
+			})
+		}
+	}
+}
+
+func importCraftQueues(states []runtimeCraftQueueState, content *runtimeContent) map[string][]runtimeCraftJob {
+	queues := make(map[string][]runtimeCraftJob, len(states))
+	for _, state := range states {
+		playerID := strings.TrimSpace(state.PlayerID)
+		if playerID == "" {
+			continue
+		}
+		jobs := make([]runtimeCraftJob, 0, len(state.Jobs))
+		for _, job := range state.Jobs {
+			if strings.TrimSpace(job.JobID) == "" || job.Count <= 0 {
+				continue
+			}
+			if _, ok := content.recipes[job.RecipeID]; !ok {
+				continue
+			}
+			if job.DurationTicks < 1 {
+				job.DurationTicks = 1
+			}
+			if job.ProgressTicks < 0 {
+				job.ProgressTicks = 0
+			}
+			if job.ProgressTicks >= job.DurationTicks {
+				job.ProgressTicks = job.DurationTicks - 1
+			}
+			ingredients := make(map[string]int, len(job.Ingredients))
+			for resourceID, amount := range job.Ingredients {
+				if amount > 0 {
+					ingredients[resourceID] = amount
+				}
+			}
+			job.Ingredients = ingredients
+			jobs = append(jobs, job)
+			if len(jobs) >= maxCraftQueueLength {
+				break
+			}
+		}
+		if len(jobs) > 0 {
+			queues[playerID] = jobs
+		}
+	}
+	return queues
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// maxTickTraces is how many ticks of phase timings are kept, one minute at
+// the 20Hz tick rate.
+const maxTickTraces = 1200
+
+// tickTrace is where one tick spent its time. LockWait is how long the tick
+// loop waited for worldHub.mu, which is where contention with message
+// handling shows up. Simulation covers ground items, crafting, container
+// subscriptions and session expiry; Flush covers outboxes and directive
+// state broadcasts.
+type tickTrace struct {
+	Tick            int64   `json:"tick"`
+	StartedAt       string  `json:"startedAt"`
+	LockWaitMs      float64 `json:"lockWaitMs"`
+	MovementMs      float64 `json:"movementMs"`
+	SimulationMs    float64 `json:"simulationMs"`
+	DirectivesMs    float64 `json:"directivesMs"`
+	SnapshotBuildMs float64 `json:"snapshotBuildMs"`
+	FanoutMs        float64 `json:"fanoutMs"`
+	FlushMs         float64 `json:"flushMs"`
+	TotalMs         float64 `json:"totalMs"`
+	Players         int     `json:"players"`
+	Clients         int     `json:"clients"`
+}
+
+// phaseTimer measures consecutive phases of a tick.
+type phaseTimer struct {
+	last time.Time
+}
+
+func startPhaseTimer() phaseTimer {
+	return phaseTimer{last: time.Now()}
+}
+
+// lap returns the milliseconds since the previous lap and starts the next.
+func (t *phaseTimer) lap() float64 {
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	t.last = now
+	return float64(elapsed.Microseconds()) / 1000
+}
+
+// tickTraceRing keeps the most recent tick traces without reallocating.
+type tickTraceRing struct {
+	entries []tickTrace
+	next    int
+}
+
+func (r *tickTraceRing) push(trace tickTrace) {
+	if len(r.entries) < maxTickTraces {
+		r.entries = append(r.entries, trace)
+		return
+	}
+	r.entries[r.next] = trace
+	r.next = (r.next + 1) % maxTickTraces
+}
+
+// last returns up to limit traces, oldest first.
+func (r *tickTraceRing) last(limit int) []tickTrace {
+	ordered := make([]tickTrace, 0, len(r.entries))
+	ordered = append(ordered, r.entries[r.next:]...)
+	ordered = append(ordered, r.entries[:r.next]...)
+	if limit > 0 && limit < len(ordered) {
+		ordered = ordered[len(ordered)-limit:]
+	}
+	return ordered
+}
+
+// recordTickTrace completes the trace the tick's phases filled in under the
+// lock. snapshotsMs is the whole broadcastSnapshots call; what was not spent
+// building snapshots was spent writing them to clients.
+func (h *worldHub) recordTickTrace(started time.Time, snapshotsMs float64, flushMs float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	trace := h.pendingTrace
+	h.pendingTrace = tickTrace{}
+	trace.StartedAt = started.UTC().Format(time.RFC3339Nano)
+	trace.FanoutMs = max(snapshotsMs-trace.SnapshotBuildMs, 0)
+	trace.FlushMs = flushMs
+	trace.TotalMs = float64(time.Since(started).Microseconds()) / 1000
+	trace.Clients = len(h.clients)
+	h.tickTraces.push(trace)
+}
+
+func (h *worldHub) listTickTraces(limit int) []tickTrace {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.tickTraces.last(limit)
+}
+
+// profilingSettings are the runtime profiling rates. Both default to off
+// because they cost on every lock and channel operation.
+type profilingSettings struct {
+	MutexProfileFraction *int `json:"mutexProfileFraction,omitempty"`
+	BlockProfileRate     *int `json:"blockProfileRate,omitempty"`
+}
+
+// applyProfilingSettings changes whichever rates are set and returns the
+// rates now in effect. The runtime cannot report the block profile rate, so
+// the hub remembers it.
+func (h *worldHub) applyProfilingSettings(settings profilingSettings) profilingSettings {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if settings.MutexProfileFraction != nil {
+		runtime.SetMutexProfileFraction(max(*settings.MutexProfileFraction, 0))
+	}
+	if settings.BlockProfileRate != nil {
+		h.blockProfileRate = max(*settings.BlockProfileRate, 0)
+		runtime.SetBlockProfileRate(h.blockProfileRate)
+	}
+	mutexFraction := runtime.SetMutexProfileFraction(-1)
+	blockRate := h.blockProfileRate
+	return profilingSettings{
+		MutexProfileFraction: &mutexFraction,
+		BlockProfileRate:     &blockRate,
+	}
+}
+
+// mountDiagnosticsRoutes serves pprof, the profiling toggles and the tick
+// trace. They are only mounted on the separate admin listener, never on the
+// public one.
+func mountDiagnosticsRoutes(mux *http.ServeMux, hub *worldHub) {
+	auth := hub.adminAuth
+	mux.HandleFunc("/debug/pprof/", auth.require(scopeAdminState, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", auth.require(scopeAdminState, pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", auth.require(scopeAdminState, pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", auth.require(scopeAdminState, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", auth.require(scopeAdminState, pprof.Trace))
+	mux.HandleFunc("/debug/profiling", auth.require(scopeAdminState, buildProfilingHandler(hub)))
+	mux.HandleFunc("/debug/tick-trace", auth.require(scopeAdminState, buildTickTraceHandler(hub)))
+}
+
+func buildProfilingHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		var settings profilingSettings
+		switch request.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := json.NewDecoder(request.Body).Decode(&settings); err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(writer).Encode(map[string]string{
+					"error": "invalid_json",
+				})
+				return
+			}
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(writer).Encode(hub.applyProfilingSettings(settings))
+	}
+}
+
+func buildTickTraceHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 0
+		if rawLimit := request.URL.Query().Get("limit"); rawLimit != "" {
+			parsed, err := strconv.Atoi(rawLimit)
+			if err != nil || parsed < 1 || parsed > maxTickTraces {
+				writer.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(writer).Encode(map[string]string{
+					"error": "invalid_limit",
+				})
+				return
+			}
+			limit = parsed
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(writer).Encode(map[string]any{
+			"ticks": hub.listTickTraces(limit),
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+// Combat slots with durability are equipment: an item of its own, one per
+// stack, whose instance carries the wear. Equipping moves the item from the
+// inventory into the hotbar and unequipping moves it back, so durability
+// follows the item through containers, trades and drops.
+
+// equippedInstance returns the instance of the equipment equipped as slotID,
+// or nil when the slot is not equipped or holds no item.
+func equippedInstance(state runtimeHotbarState, slotID string) *runtimeItemInstance {
+	index := hotbarSlotIndex(state, slotID)
+	if index < 0 || index >= len(state.Items) || state.Items[index].isEmpty() {
+		return nil
+	}
+	return state.Items[index].Instance
+}
+
+// slotDurability returns the remaining durability of the equipment equipped
+// as slotID; 0 when nothing is equipped there.
+func slotDurability(state runtimeHotbarState, slotID string) int {
+	if instance := equippedInstance(state, slotID); instance != nil {
+		return instance.Durability
+	}
+	return 0
+}
+
+// normalizeHotbarDurability fits equipped equipment to the active content:
+// one item per stack, with an instance whose maximum is the slot's and whose
+// durability is clamped to it. An item that never had wear recorded, such as
+// a starter weapon or one granted as loot, is unworn.
+func (h *worldHub) normalizeHotbarDurability(state *runtimeHotbarState) {
+	for index, slotID := range state.SlotIDs {
+		maxDurability := h.content.combatSlots[slotID].maxDurability
+		if maxDurability <= 0 || state.Items[index].isEmpty() {
+			continue
+		}
+		instance := cloneItemInstance(state.Items[index].Instance)
+		if instance == nil {
+			instance = &runtimeItemInstance{InstanceID: h.nextItemInstanceIDLocked(), Origin: "equipment"}
+		}
+		if instance.MaxDurability <= 0 {
+			instance.Durability = maxDurability
+		}
+		instance.MaxDurability = maxDurability
+		instance.Durability = max(0, min(instance.Durability, maxDurability))
+		state.Items[index].Quantity = 1
+		state.Items[index].Instance = instance
+	}
+}
+
+// wearHotbarSlotLocked spends one point of durability on an accepted combat
+// action and records slot_broken when the item reaches zero.
+func (h *worldHub) wearHotbarSlotLocked(state *runtimeHotbarState, slotID string) {
+	instance := equippedInstance(*state, slotID)
+	if instance == nil || instance.Durability <= 0 {
+		return
+	}
+	instance.Durability--
+	if instance.Durability == 0 {
+		h.recordWorldEventLocked("slot_broken", state.PlayerID, map[string]any{
+			"slotId":     slotID,
+			"instanceId": instance.InstanceID,
+		})
+	}
+}
+
+// repairHotbarSlotLocked restores up to amount durability to the equipped
+// item and returns how much was restored.
+func (h *worldHub) repairHotbarSlotLocked(state *runtimeHotbarState, slotID string, amount int) int {
+	instance := equippedInstance(*state, slotID)
+	if instance == nil {
+		return 0
+	}
+	restored := min(amount, instance.MaxDurability-instance.Durability)
+	if restored <= 0 {
+		return 0
+	}
+	instance.Durability += restored
+	h.recordWorldEventLocked("slot_repaired", state.PlayerID, map[string]any{
+		"slotId":     slotID,
+		"instanceId": instance.InstanceID,
+		"restored":   restored,
+		"durability": instance.Durability,
+	})
+	return restored
+}
+
+// combatSlotWears reports whether accepted actions with slotID spend durability.
+func (h *worldHub) combatSlotWears(slotID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.content.combatSlots[slotID].maxDurability > 0
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	groundItemIDPrefix       = "ground-"
+	groundItemDespawnTicks   = 20 * 60 * 5
+	groundItemOwnershipTicks = 20 * 30
+	groundItemPickupRadius   = 1.25
+	maxGroundItems           = 512
+)
+
+// runtimeGroundItem is an item stack lying in the world. While the ownership
+// window is open only OwnerID may pick it up. Walking over an item picks it
+// up, except for the dropper's own manual and death drops, which must be
+// picked up through interact_action.
+type runtimeGroundItem struct {
+	GroundItemID   string           `json:"groundItemId"`
+	Stack          runtimeItemStack `json:"stack"`
+	X              float64          `json:"x"`
+	Z              float64          `json:"z"`
+	Source         string           `json:"source"`
+	DroppedBy      string           `json:"droppedBy,omitempty"`
+	OwnerID        string           `json:"ownerId,omitempty"`
+	OwnerUntilTick int64            `json:"ownerUntilTick,omitempty"`
+	DroppedTick    int64            `json:"droppedTick"`
+	DespawnTick    int64            `json:"despawnTick"`
+}
+
+type dropItemPayload struct {
+	PlayerID    string           `json:"playerId"`
+	ActionID    string           `json:"actionId"`
+	From        inventorySlotRef `json:"from"`
+	Quantity    int              `json:"quantity,omitempty"`
+	RecipientID string           `json:"recipientId,omitempty"`
+}
+
+type runtimeDropItemResult struct {
+	ActionID     string `json:"actionId"`
+	PlayerID     string `json:"playerId"`
+	Accepted     bool   `json:"accepted"`
+	Reason       string `json:"reason,omitempty"`
+	GroundItemID string `json:"groundItemId,omitempty"`
+	Tick         int64  `json:"tick"`
+	Replayed     bool   `json:"replayed,omitempty"`
+}
+
+func isGroundItemID(targetID string) bool {
+	return strings.HasPrefix(targetID, groundItemIDPrefix)
+}
+
+func cloneGroundItem(item runtimeGroundItem) runtimeGroundItem {
+	item.Stack = cloneItemStack(item.Stack)
+	return item
+}
+
+func (item runtimeGroundItem) reservedFor(playerID string, tick int64) bool {
+	return item.OwnerID != "" && item.OwnerID != playerID && tick < item.OwnerUntilTick
+}
+
+// spawnGroundItemLocked places stack at (x, z). ownerID reserves the item for
+// groundItemOwnershipTicks. The oldest item is evicted once maxGroundItems is
+// reached.
+func (h *worldHub) spawnGroundItemLocked(stack runtimeItemStack, x float64, z float64, source string, droppedBy string, ownerID string) runtimeGroundItem {
+	if len(h.groundItems) >= maxGroundItems {
+		h.evictOldestGroundItemLocked()
+	}
+	h.groundItemSeq++
+	item := runtimeGroundItem{
+		GroundItemID: groundItemIDPrefix + strconv.FormatInt(h.groundItemSeq, 10),
+		Stack:        cloneItemStack(stack),
+		X:            sanitizeNumber(x),
+		Z:            sanitizeNumber(z),
+		Source:       source,
+		DroppedBy:    droppedBy,
+		OwnerID:      ownerID,
+		DroppedTick:  h.tick,
+		DespawnTick:  h.tick + groundItemDespawnTicks,
+	}
+	if ownerID != "" {
+		item.OwnerUntilTick = h.tick + groundItemOwnershipTicks
+	}
+	h.groundItems[item.GroundItemID] = item
+	h.recordWorldEventLocked("ground_item_spawned", droppedBy, map[string]any{
+		"groundItemId": item.GroundItemID,
+		"itemId":       item.Stack.ItemID,
+		"quantity":     item.Stack.Quantity,
+		"source":       source,
+	})
+	return item
+}
+
+func (h *worldHub) evictOldestGroundItemLocked() {
+	oldestID := ""
+	for _, groundItemID := range h.sortedGroundItemIDsLocked() {
+		item := h.groundItems[groundItemID]
+		if oldestID == "" || item.DroppedTick < h.groundItems[oldestID].DroppedTick {
+			oldestID = groundItemID
+		}
+	}
+	if oldestID != "" {
+		h.removeGroundItemLocked(oldestID, "evicted")
+	}
+}
+
+func (h *worldHub) removeGroundItemLocked(groundItemID string, reason string) {
+	item, ok := h.groundItems[groundItemID]
+	if !ok {
+		return
+	}
+	delete(h.groundItems, groundItemID)
+	h.recordWorldEventLocked("ground_item_removed", "", map[string]any{
+		"groundItemId": groundItemID,
+		"itemId":       item.Stack.ItemID,
+		"reason":       reason,
+	})
+}
+
+// sortedGroundItemIDsLocked lists ground item ids in spawn order.
+func (h *worldHub) sortedGroundItemIDsLocked() []string {
+	groundItemIDs := make([]string, 0, len(h.groundItems))
+	for groundItemID := range h.groundItems {
+		groundItemIDs = append(groundItemIDs, groundItemID)
+	}
+	sort.Slice(groundItemIDs, func(left int, right int) bool {
+		leftSeq, rightSeq := groundItemSeqOf(groundItemIDs[left]), groundItemSeqOf(groundItemIDs[right])
+		if leftSeq != rightSeq {
+			return leftSeq < rightSeq
+		}
+		return groundItemIDs[left] < groundItemIDs[right]
+	})
+	return groundItemIDs
+}
+
+// groundItemSeqOf returns the spawn sequence number in a ground item id, or 0
+// when the id does not carry one.
+func groundItemSeqOf(groundItemID string) int64 {
+	seq, err := strconv.ParseInt(strings.TrimPrefix(groundItemID, groundItemIDPrefix), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+// dropInventoryOverflowLocked drops units that did not fit in a full
+// inventory at the player's feet, reserved for that player. Piles are split
+// at the item's max stack and non-stackable items are minted as instances.
+func (h *worldHub) dropInventoryOverflowLocked(playerID string, itemID string, quantity int) {
+	if quantity <= 0 {
+		return
+	}
+	groundItemIDs := make([]string, 0, 1)
+	if player, ok := h.players[playerID]; ok {
+		maxStack := h.content.maxStackForItem(itemID)
+		for remaining := quantity; remaining > 0; {
+			stack := runtimeItemStack{ItemID: itemID, Quantity: min(remaining, maxStack)}
+			if maxStack == 1 {
+				stack.Instance = &runtimeItemInstance{
+					InstanceID: h.nextItemInstanceIDLocked(),
+					Origin:     "overflow",
+				}
+			}
+			remaining -= stack.Quantity
+			item := h.spawnGroundItemLocked(stack, player.X, player.Z, "overflow", playerID, playerID)
+			groundItemIDs = append(groundItemIDs, item.GroundItemID)
+		}
+	}
+	h.recordWorldEventLocked("inventory_overflow", playerID, map[string]any{
+		"itemId":        itemID,
+		"quantity":      quantity,
+		"groundItemIds": groundItemIDs,
+	})
+}
+
+// dropPlayerInventoryOnDeathLocked empties a defeated player's inventory onto
+// the ground, reserved for the victim during the ownership window.
+func (h *worldHub) dropPlayerInventoryOnDeathLocked(playerID string) (runtimeInventoryState, []string) {
+	player, ok := h.players[playerID]
+	if !ok {
+		return runtimeInventoryState{}, nil
+	}
+	inventoryState := h.ensureInventoryStateLocked(playerID)
+	groundItemIDs := make([]string, 0)
+	for index, stack := range inventoryState.Slots {
+		if stack.isEmpty() {
+			continue
+		}
+		item := h.spawnGroundItemLocked(stack, player.X, player.Z, "death", playerID, playerID)
+		groundItemIDs = append(groundItemIDs, item.GroundItemID)
+		inventoryState.Slots[index] = runtimeItemStack{}
+	}
+	h.syncInventoryLocked(&inventoryState)
+	return cloneInventoryState(inventoryState), groundItemIDs
+}
+
+// applyDropItem moves a stack (or part of it) from the player's inventory or
+// hotbar onto the ground. A recipient reserves the drop for a teammate.
+func (h *worldHub) applyDropItem(payload dropItemPayload) (runtimeDropItemResult, inventorySlotOpUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := runtimeDropItemResult{
+		ActionID: payload.ActionID,
+		PlayerID: payload.PlayerID,
+		Tick:     h.tick,
+	}
+	if h.replaySeenActionLocked(payload.PlayerID, "drop_item", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, inventorySlotOpUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "drop_item", payload.ActionID, result.Accepted, result)
+	}()
+	reject := func(reason string) (runtimeDropItemResult, inventorySlotOpUpdates) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordWorldEventLocked("drop_item_rejected", payload.PlayerID, map[string]any{
+			"actionId": payload.ActionID,
+			"reason":   reason,
+		})
+		return result, inventorySlotOpUpdates{}
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.Quantity < 0 {
+		return reject("invalid_payload")
+	}
+	player, ok := h.players[payload.PlayerID]
+	if !ok {
+		return reject("player_not_found")
+	}
+	recipientID := strings.TrimSpace(payload.RecipientID)
+	if recipientID != "" {
+		if _, ok := h.players[recipientID]; !ok {
+			return reject("recipient_not_found")
+		}
+	}
+	if payload.From.Area != "inventory" && payload.From.Area != "hotbar" {
+		return reject("invalid_area")
+	}
+
+	areas := newSlotAreaSet(h, payload.PlayerID)
+	slots, reason := areas.slots(payload.From)
+	if reason != "" {
+		return reject(reason)
+	}
+	if payload.From.Index < 0 || payload.From.Index >= len(slots) {
+		return reject("invalid_slot")
+	}
+	stack := slots[payload.From.Index]
+	if stack.isEmpty() {
+		return reject("slot_empty")
+	}
+	quantity := payload.Quantity
+	if quantity == 0 {
+		quantity = stack.Quantity
+	}
+	if quantity > stack.Quantity {
+		return reject("insufficient_items")
+	}
+
+	dropped := cloneItemStack(stack)
+	dropped.Quantity = quantity
+	slots[payload.From.Index].Quantity -= quantity
+	if slots[payload.From.Index].Quantity <= 0 {
+		slots[payload.From.Index] = runtimeItemStack{}
+	}
+	updates := areas.commit()
+	item := h.spawnGroundItemLocked(dropped, player.X, player.Z, "drop", payload.PlayerID, recipientID)
+
+	result.Accepted = true
+	result.GroundItemID = item.GroundItemID
+	return result, updates
+}
+
+// pickupGroundItemLocked moves as much of a ground item into the player's
+// inventory as fits and returns how many units were taken.
+func (h *worldHub) pickupGroundItemLocked(playerID string, groundItemID string) (int, string) {
+	item, ok := h.groundItems[groundItemID]
+	if !ok {
+		return 0, "unknown_target"
+	}
+	if item.reservedFor(playerID, h.tick) {
+		return 0, "ground_item_reserved"
+	}
+	inventoryState := h.ensureInventoryStateLocked(playerID)
+	picked := h.content.addItemStack(inventoryState.Slots, item.Stack)
+	if picked <= 0 {
+		return 0, "inventory_full"
+	}
+	h.syncInventoryLocked(&inventoryState)
+	h.recordWorldEventLocked("ground_item_picked_up", playerID, map[string]any{
+		"groundItemId": groundItemID,
+		"itemId":       item.Stack.ItemID,
+		"quantity":     picked,
+	})
+	item.Stack.Quantity -= picked
+	if item.Stack.Quantity <= 0 {
+		delete(h.groundItems, groundItemID)
+	} else {
+		h.groundItems[groundItemID] = item
+	}
+	return picked, ""
+}
+
+// interactGroundItemLocked picks up a ground item targeted by interact_action.
+func (h *worldHub) interactGroundItemLocked(result runtimeInteractResult, player *playerState) runtimeInteractResult {
+	item, ok := h.groundItems[result.TargetID]
+	if !ok {
+		result.Accepted = false
+		result.Reason = "unknown_target"
+		return result
+	}
+	result.TargetWorldX = makeFloat64Ptr(item.X)
+	result.TargetWorldZ = makeFloat64Ptr(item.Z)
+	if result.TargetLabel == "" {
+		result.TargetLabel = item.Stack.ItemID
+	}
+	if math.Hypot(item.X-player.X, item.Z-player.Z) > interactionRange {
+		result.Accepted = false
+		result.Reason = "target_out_of_range"
+		return result
+	}
+	picked, reason := h.pickupGroundItemLocked(player.PlayerID, item.GroundItemID)
+	if reason != "" {
+		result.Accepted = false
+		result.Reason = reason
+		return result
+	}
+	result.Accepted = true
+	result.Message = "Picked up " + strconv.Itoa(picked) + " " + result.TargetLabel + "."
+	return result
+}
+
+// advanceGroundItemsLocked despawns expired items and lets players pick up
+// items they walk over.
+func (h *worldHub) advanceGroundItemsLocked() {
+	if len(h.groundItems) == 0 {
+		return
+	}
+	groundItemIDs := h.sortedGroundItemIDsLocked()
+	for _, groundItemID := range groundItemIDs {
+		if h.groundItems[groundItemID].DespawnTick <= h.tick {
+			h.removeGroundItemLocked(groundItemID, "despawned")
+		}
+	}
+
+	playerIDs := make([]string, 0, len(h.players))
+	for playerID := range h.players {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		player := h.players[playerID]
+		pickedAny := false
+		for _, groundItemID := range groundItemIDs {
+			item, ok := h.groundItems[groundItemID]
+			if !ok || item.DroppedBy == playerID && (item.Source == "drop" || item.Source == "death") {
+				continue
+			}
+			if math.Hypot(item.X-player.X, item.Z-player.Z) > groundItemPickupRadius {
+				continue
+			}
+			if picked, _ := h.pickupGroundItemLocked(playerID, groundItemID); picked > 0 {
+				pickedAny = true
+			}
+		}
+		if pickedAny {
+			h.groundPickupOutbox = append(h.groundPickupOutbox, playerID)
+		}
+	}
+}
+
+// groundItemsNearLocked lists ground items within radius of any anchor.
+func (h *worldHub) groundItemsNearLocked(anchors []*playerState, radius float64) []runtimeGroundItem {
+	if len(h.groundItems) == 0 {
+		return nil
+	}
+	items := make([]runtimeGroundItem, 0)
+	for _, groundItemID := range h.sortedGroundItemIDsLocked() {
+		item := h.groundItems[groundItemID]
+		if anchors == nil {
+			items = append(items, cloneGroundItem(item))
+			continue
+		}
+		for _, anchor := range anchors {
+			if math.Hypot(item.X-anchor.X, item.Z-anchor.Z) <= radius {
+				items = append(items, cloneGroundItem(item))
+				break
+			}
+		}
+	}
+	return items
+}
+
+// groundItemDelta reduces the ground items visible to client to those it was
+// not sent yet or whose quantity changed, and lists the ids it was sent that
+// are no longer visible. It records what the client now knows.
+func groundItemDelta(client *clientConn, visible []runtimeGroundItem) ([]runtimeGroundItem, []string) {
+	known := make(map[string]int, len(visible))
+	var changed []runtimeGroundItem
+	for _, item := range visible {
+		known[item.GroundItemID] = item.Stack.Quantity
+		if quantity, sent := client.groundItems[item.GroundItemID]; !sent || quantity != item.Stack.Quantity {
+			changed = append(changed, item)
+		}
+	}
+	var removed []string
+	for groundItemID := range client.groundItems {
+		if _, ok := known[groundItemID]; !ok {
+			removed = append(removed, groundItemID)
+		}
+	}
+	sort.Slice(removed, func(left int, right int) bool {
+		return groundItemSeqOf(removed[left]) < groundItemSeqOf(removed[right])
+	})
+	client.groundItems = known
+	return changed, removed
+}
+
+func (h *worldHub) drainGroundPickups() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := h.groundPickupOutbox
+	h.groundPickupOutbox = nil
+	return drained
+}
+
+// flushGroundPickups sends fresh inventory state to players who picked up
+// items by walking over them.
+func (h *worldHub) flushGroundPickups() {
+	for _, playerID := range h.drainGroundPickups() {
+		if inventoryState, ok := h.inventoryStateForPlayer(playerID); ok {
+			h.sendToPlayerOwnedRecipients(playerID, serverEnvelope{
+				Type:    "inventory_state",
+				Payload: inventoryState,
+			})
+		}
+	}
+}
+
+func (h *worldHub) exportGroundItemsLocked() []runtimeGroundItem {
+	items := make([]runtimeGroundItem, 0, len(h.groundItems))
+	for _, groundItemID := range h.sortedGroundItemIDsLocked() {
+		items = append(items, cloneGroundItem(h.groundItems[groundItemID]))
+	}
+	return items
+}
+
+func importGroundItems(items []runtimeGroundItem, content *runtimeContent) map[string]runtimeGroundItem {
+	imported := make(map[string]runtimeGroundItem, len(items))
+	for _, item := range items {
+		item.GroundItemID = strings.TrimSpace(item.GroundItemID)
+		if !isGroundItemID(item.GroundItemID) || item.Stack.isEmpty() || !content.isKnownItem(item.Stack.ItemID) {
+			continue
+		}
+		item.Stack = cloneItemStack(item.Stack)
+		item.Stack.Quantity = min(item.Stack.Quantity, content.maxStackForItem(item.Stack.ItemID))
+		item.X = sanitizeNumber(item.X)
+		item.Z = sanitizeNumber(item.Z)
+		imported[item.GroundItemID] = item
+		if len(imported) >= maxGroundItems {
+			break
+		}
+	}
+	return imported
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// protocolVersion is the wire protocol spoken on /ws as "major.minor". Minor
+// bumps only add optional fields and message types; a major bump breaks
+// existing clients, which are refused during the hello exchange.
+const protocolVersion = "1.0"
+
+// protocolMismatchCloseCode is the application close code sent when a client
+// cannot be served, with the reason text naming what was incompatible.
+const protocolMismatchCloseCode = 4001
+
+// serverBuild identifies the running binary. Release builds set it with
+// -ldflags "-X main.serverBuild=<version>".
+var serverBuild = "dev"
+
+// supportedEncodings lists wire encodings in server preference order.
+var supportedEncodings = []string{"json"}
+
+// protocolFeatures lists optional behaviour a client may rely on when the
+// server advertises it.
+var protocolFeatures = []string{
+	"action_replay",
+	"chests",
+	"container_subscriptions",
+	"container_transactions",
+	"craft_queue",
+	"land_claims",
+	"request_ids",
+	"session_resume",
+	"trading",
+}
+
+// helloPayload opens the handshake. It is optional so clients predating it
+// keep working; they simply never learn the server version.
+type helloPayload struct {
+	ProtocolVersion string   `json:"protocolVersion"`
+	Client          string   `json:"client,omitempty"`
+	Encodings       []string `json:"encodings,omitempty"`
+	Features        []string `json:"features,omitempty"`
+}
+
+type runtimeWelcome struct {
+	ProtocolVersion string   `json:"protocolVersion"`
+	ServerBuild     string   `json:"serverBuild"`
+	Encoding        string   `json:"encoding"`
+	Encodings       []string `json:"encodings"`
+	Features        []string `json:"features"`
+	Tick            int64    `json:"tick"`
+}
+
+// protocolMajor returns the major component of a "major.minor" version.
+func protocolMajor(version string) (int, bool) {
+	major, _, _ := strings.Cut(strings.TrimSpace(version), ".")
+	value, err := strconv.Atoi(major)
+	if err != nil || value < 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+// negotiateHello checks a client's hello against what this server speaks.
+// It returns the welcome to send, or a close reason when the client must be
+// disconnected.
+func (h *worldHub) negotiateHello(hello helloPayload) (runtimeWelcome, string) {
+	serverMajor, _ := protocolMajor(protocolVersion)
+	clientMajor, ok := protocolMajor(hello.ProtocolVersion)
+	if !ok {
+		return runtimeWelcome{}, "invalid_protocol_version"
+	}
+	if clientMajor != serverMajor {
+		return runtimeWelcome{}, "unsupported_protocol_version: server speaks " + protocolVersion
+	}
+
+	encoding := supportedEncodings[0]
+	if len(hello.Encodings) > 0 {
+		encoding = ""
+		for _, candidate := range hello.Encodings {
+			if containsString(supportedEncodings, candidate) {
+				encoding = candidate
+				break
+			}
+		}
+		if encoding == "" {
+			return runtimeWelcome{}, "unsupported_encoding: server speaks " + strings.Join(supportedEncodings, ",")
+		}
+	}
+
+	h.mu.Lock()
+	tick := h.tick
+	h.mu.Unlock()
+	return runtimeWelcome{
+		ProtocolVersion: protocolVersion,
+		ServerBuild:     serverBuild,
+		Encoding:        encoding,
+		Encodings:       append([]string(nil), supportedEncodings...),
+		Features:        append([]string(nil), protocolFeatures...),
+		Tick:            tick,
+	}, ""
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+// closeWithReason sends a close frame so the client sees why it was dropped
+// rather than a bare connection reset.
+func (c *clientConn) closeWithReason(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second),
+	)
+}
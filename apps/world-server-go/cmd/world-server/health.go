@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// tickInterval is the fixed simulation step; a tick that takes longer than
+// this is an overrun.
+const tickInterval = 50 * time.Millisecond
+
+// readinessLimits decide when /readyz stops admitting new players.
+type readinessLimits struct {
+	// MaxTickAge is how long ago the last tick may have finished.
+	MaxTickAge time.Duration
+	// MaxConsecutiveOverruns is how many ticks in a row may run over
+	// tickInterval before the server reports itself overloaded.
+	MaxConsecutiveOverruns int
+}
+
+func defaultReadinessLimits() readinessLimits {
+	return readinessLimits{
+		MaxTickAge:             time.Second,
+		MaxConsecutiveOverruns: 10,
+	}
+}
+
+// tickHealth is what the tick loop reports about itself.
+type tickHealth struct {
+	LastTickAt          time.Time
+	LastDuration        time.Duration
+	Overruns            int64
+	ConsecutiveOverruns int
+}
+
+func (h *worldHub) recordTickTiming(started time.Time, finished time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	duration := finished.Sub(started)
+	h.tickHealth.LastTickAt = finished
+	h.tickHealth.LastDuration = duration
+	if duration > tickInterval {
+		h.tickHealth.Overruns++
+		h.tickHealth.ConsecutiveOverruns++
+		return
+	}
+	h.tickHealth.ConsecutiveOverruns = 0
+}
+
+// markStateLoaded records that startup persistence (the state file, if any)
+// has been applied, so players joining now see the restored world.
+func (h *worldHub) markStateLoaded() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stateLoaded = true
+}
+
+type runtimeReadiness struct {
+	Ready               bool     `json:"ready"`
+	Problems            []string `json:"problems"`
+	Tick                int64    `json:"tick"`
+	LastTickAgeMs       int64    `json:"lastTickAgeMs"`
+	LastTickDurationMs  float64  `json:"lastTickDurationMs"`
+	ConsecutiveOverruns int      `json:"consecutiveOverruns"`
+	Overruns            int64    `json:"overruns"`
+}
+
+func (h *worldHub) readiness(now time.Time) runtimeReadiness {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := runtimeReadiness{
+		Problems:            make([]string, 0),
+		Tick:                h.tick,
+		LastTickDurationMs:  float64(h.tickHealth.LastDuration.Microseconds()) / 1000,
+		ConsecutiveOverruns: h.tickHealth.ConsecutiveOverruns,
+		Overruns:            h.tickHealth.Overruns,
+	}
+	if h.shuttingDown.Load() {
+		status.Problems = append(status.Problems, "shutting_down")
+	}
+	if !h.stateLoaded {
+		status.Problems = append(status.Problems, "state_not_loaded")
+	}
+	if h.tickHealth.LastTickAt.IsZero() {
+		status.Problems = append(status.Problems, "tick_not_started")
+	} else {
+		age := now.Sub(h.tickHealth.LastTickAt)
+		status.LastTickAgeMs = age.Milliseconds()
+		if age > h.readinessLimits.MaxTickAge {
+			status.Problems = append(status.Problems, "tick_stalled")
+		}
+	}
+	if h.tickHealth.ConsecutiveOverruns >= h.readinessLimits.MaxConsecutiveOverruns {
+		status.Problems = append(status.Problems, "tick_overrun")
+	}
+	status.Ready = len(status.Problems) == 0
+	return status
+}
+
+type runtimeVersionInfo struct {
+	ServerBuild     string  `json:"serverBuild"`
+	VCSRevision     string  `json:"vcsRevision,omitempty"`
+	GoVersion       string  `json:"goVersion"`
+	ProtocolVersion string  `json:"protocolVersion"`
+	ContentVersion  string  `json:"contentVersion"`
+	ContentHash     string  `json:"contentHash"`
+	WorldSeed       string  `json:"worldSeed"`
+	StartedAt       string  `json:"startedAt"`
+	UptimeSeconds   float64 `json:"uptimeSeconds"`
+}
+
+func (h *worldHub) versionInfo(now time.Time) runtimeVersionInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	info := runtimeVersionInfo{
+		ServerBuild:     serverBuild,
+		GoVersion:       runtime.Version(),
+		ProtocolVersion: protocolVersion,
+		ContentVersion:  h.content.catalog.Version,
+		ContentHash:     h.content.catalog.Hash,
+		WorldSeed:       h.worldSeed,
+		StartedAt:       h.startedAt.UTC().Format(time.RFC3339),
+		UptimeSeconds:   now.Sub(h.startedAt).Seconds(),
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			if setting.Key == "vcs.revision" {
+				info.VCSRevision = setting.Value
+			}
+		}
+	}
+	return info
+}
+
+// mountHealthRoutes serves the unauthenticated probe endpoints. They go on
+// every listener so supervisors and load balancers can reach them wherever
+// they are pointed.
+func mountHealthRoutes(mux *http.ServeMux, hub *worldHub) {
+	mux.HandleFunc("/healthz", buildHealthzHandler())
+	mux.HandleFunc("/readyz", buildReadyzHandler(hub))
+	mux.HandleFunc("/version", buildVersionHandler(hub))
+}
+
+func buildHealthzHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(writer).Encode(map[string]string{"status": "ok"})
+	}
+}
+
+func buildReadyzHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		status := hub.readiness(time.Now())
+		statusCode := http.StatusOK
+		if !status.Ready {
+			statusCode = http.StatusServiceUnavailable
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(statusCode)
+		_ = json.NewEncoder(writer).Encode(status)
+	}
+}
+
+func buildVersionHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(writer).Encode(hub.versionInfo(time.Now()))
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import "sort"
+
+// maxHotbarSlots bounds how many positions a customised hotbar may hold.
+const maxHotbarSlots = 10
+
+type hotbarAssignPayload struct {
+	PlayerID  string `json:"playerId"`
+	ActionID  string `json:"actionId"`
+	SlotIndex int    `json:"slotIndex"`
+	SlotID    string `json:"slotId,omitempty"`
+}
+
+type hotbarSwapPayload struct {
+	PlayerID  string `json:"playerId"`
+	ActionID  string `json:"actionId"`
+	FromIndex int    `json:"fromIndex"`
+	ToIndex   int    `json:"toIndex"`
+}
+
+type runtimeHotbarResult struct {
+	ActionID  string `json:"actionId"`
+	PlayerID  string `json:"playerId"`
+	Operation string `json:"operation"`
+	SlotIndex int    `json:"slotIndex"`
+	SlotID    string `json:"slotId,omitempty"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	Tick      int64  `json:"tick"`
+	Replayed  bool   `json:"replayed,omitempty"`
+}
+
+// applyHotbarAssign equips a combat slot into a hotbar position, or unequips
+// the position when SlotID is empty. Item slots pull their stack from the
+// player's inventory and return it there when unequipped.
+func (h *worldHub) applyHotbarAssign(payload hotbarAssignPayload) (runtimeHotbarResult, inventorySlotOpUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := runtimeHotbarResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		Operation: "assign",
+		SlotIndex: payload.SlotIndex,
+		SlotID:    payload.SlotID,
+		Tick:      h.tick,
+	}
+	if payload.SlotID == "" {
+		result.Operation = "unequip"
+	}
+	if h.replaySeenActionLocked(payload.PlayerID, "hotbar_assign", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, inventorySlotOpUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "hotbar_assign", payload.ActionID, result.Accepted, result)
+	}()
+	reject := func(reason string) (runtimeHotbarResult, inventorySlotOpUpdates) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordHotbarEventLocked(result)
+		return result, inventorySlotOpUpdates{}
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" {
+		return reject("invalid_payload")
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		return reject("player_not_found")
+	}
+	if payload.SlotIndex < 0 || payload.SlotIndex >= maxHotbarSlots {
+		return reject("invalid_slot")
+	}
+	if payload.SlotID != "" {
+		if _, ok := h.content.combatSlots[payload.SlotID]; !ok {
+			return reject("unknown_slot")
+		}
+	}
+
+	areas := newSlotAreaSet(h, payload.PlayerID)
+	areas.slots(inventorySlotRef{Area: "hotbar"})
+	inventorySlots, _ := areas.slots(inventorySlotRef{Area: "inventory"})
+	hotbar := areas.hotbar
+	itemID := h.hotbarItemID(payload.SlotID)
+	if payload.SlotID != "" && itemID == "" && !h.hotbarSlotUnlocked(*hotbar, payload.SlotID) {
+		return reject("slot_not_owned")
+	}
+	if payload.SlotID != "" {
+		if existing := hotbarSlotIndex(*hotbar, payload.SlotID); existing >= 0 && existing != payload.SlotIndex {
+			return reject("slot_already_equipped")
+		}
+	}
+	padHotbar(hotbar, payload.SlotIndex+1)
+	if hotbar.SlotIDs[payload.SlotIndex] == payload.SlotID {
+		return reject("slot_unchanged")
+	}
+
+	if current := hotbar.Items[payload.SlotIndex]; !current.isEmpty() {
+		if h.content.addItemStack(inventorySlots, current) != current.Quantity {
+			return reject("inventory_full")
+		}
+		hotbar.Items[payload.SlotIndex] = runtimeItemStack{}
+	}
+	if itemID != "" {
+		owned := countItems(inventorySlots, itemID)
+		if owned <= 0 {
+			return reject("item_not_owned")
+		}
+		quantity := min(owned, h.content.maxStackForItem(itemID))
+		taken, _ := takeItems(inventorySlots, itemID, quantity)
+		equipped := runtimeItemStack{ItemID: itemID, Quantity: quantity}
+		if len(taken) == 1 {
+			equipped = taken[0]
+		}
+		hotbar.Items[payload.SlotIndex] = equipped
+	}
+	hotbar.SlotIDs[payload.SlotIndex] = payload.SlotID
+
+	updates := areas.commit()
+	result.Accepted = true
+	h.recordHotbarEventLocked(result)
+	return result, updates
+}
+
+// hotbarSlotUnlocked reports whether the player may equip a non-item combat
+// slot: the default hotbar's slots belong to everyone, anything else must be
+// in their unlocked set. Item slots and equipment are owned by holding the
+// item instead.
+func (h *worldHub) hotbarSlotUnlocked(state runtimeHotbarState, slotID string) bool {
+	return containsString(h.content.defaultHotbarSlotIDs, slotID) || containsString(state.Unlocked, slotID)
+}
+
+// normalizeHotbarUnlocks keeps every equipped non-item slot in the unlocked
+// set, so unequipping a slot does not forfeit it.
+func (h *worldHub) normalizeHotbarUnlocks(state *runtimeHotbarState) {
+	for _, slotID := range state.SlotIDs {
+		if _, ok := h.content.combatSlots[slotID]; !ok || h.hotbarItemID(slotID) != "" || containsString(state.Unlocked, slotID) {
+			continue
+		}
+		state.Unlocked = append(state.Unlocked, slotID)
+	}
+	sort.Strings(state.Unlocked)
+}
+
+// applyHotbarSwap exchanges two hotbar positions. The selection follows the
+// slot it pointed at.
+func (h *worldHub) applyHotbarSwap(payload hotbarSwapPayload) (runtimeHotbarResult, *runtimeHotbarState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := runtimeHotbarResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		Operation: "swap",
+		SlotIndex: payload.ToIndex,
+		Tick:      h.tick,
+	}
+	if h.replaySeenActionLocked(payload.PlayerID, "hotbar_swap", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "hotbar_swap", payload.ActionID, result.Accepted, result)
+	}()
+	reject := func(reason string) (runtimeHotbarResult, *runtimeHotbarState) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordHotbarEventLocked(result)
+		return result, nil
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" {
+		return reject("invalid_payload")
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		return reject("player_not_found")
+	}
+	if payload.FromIndex < 0 || payload.FromIndex >= maxHotbarSlots ||
+		payload.ToIndex < 0 || payload.ToIndex >= maxHotbarSlots ||
+		payload.FromIndex == payload.ToIndex {
+		return reject("invalid_slot")
+	}
+
+	hotbar := cloneHotbarState(h.ensureHotbarStateLocked(payload.PlayerID))
+	padHotbar(&hotbar, max(payload.FromIndex, payload.ToIndex)+1)
+	from, to := payload.FromIndex, payload.ToIndex
+	hotbar.SlotIDs[from], hotbar.SlotIDs[to] = hotbar.SlotIDs[to], hotbar.SlotIDs[from]
+	hotbar.Items[from], hotbar.Items[to] = hotbar.Items[to], hotbar.Items[from]
+	switch hotbar.SelectedIndex {
+	case from:
+		hotbar.SelectedIndex = to
+	case to:
+		hotbar.SelectedIndex = from
+	}
+	result.SlotID = hotbar.SlotIDs[to]
+
+	h.storeHotbarLocked(&hotbar)
+	result.Accepted = true
+	h.recordHotbarEventLocked(result)
+	hotbarCopy := cloneHotbarState(hotbar)
+	return result, &hotbarCopy
+}
+
+// padHotbar extends the hotbar with unequipped positions up to length.
+func padHotbar(state *runtimeHotbarState, length int) {
+	for len(state.SlotIDs) < length {
+		state.SlotIDs = append(state.SlotIDs, "")
+		state.Items = append(state.Items, runtimeItemStack{})
+	}
+}
+
+func (h *worldHub) recordHotbarEventLocked(result runtimeHotbarResult) {
+	eventType := "hotbar_rejected"
+	if result.Accepted {
+		eventType = "hotbar_" + result.Operation
+	}
+	payload := map[string]any{
+		"actionId":  result.ActionID,
+		"operation": result.Operation,
+		"slotIndex": result.SlotIndex,
+	}
+	if result.SlotID != "" {
+		payload["slotId"] = result.SlotID
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, payload)
+}
@@ -0,0 +1,697 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	playerInventoryCapacity  = 24
+	sharedContainerCapacity  = 48
+	privateContainerCapacity = 24
+	defaultItemMaxStack      = 64
+	maxItemCustomNameLength  = 32
+)
+
+// runtimeItemInstance carries per-item metadata. Items with a max stack of one
+// always get an InstanceID; stackable items only carry an instance when they
+// have been customised, and only merge with stacks carrying equal metadata.
+type runtimeItemInstance struct {
+	InstanceID    string `json:"instanceId,omitempty"`
+	Durability    int    `json:"durability,omitempty"`
+	MaxDurability int    `json:"maxDurability,omitempty"`
+	CustomName    string `json:"customName,omitempty"`
+	Origin        string `json:"origin,omitempty"`
+}
+
+// runtimeItemStack is one inventory, hotbar or container slot. An empty slot
+// has an empty ItemID.
+type runtimeItemStack struct {
+	ItemID   string               `json:"itemId,omitempty"`
+	Quantity int                  `json:"quantity,omitempty"`
+	Instance *runtimeItemInstance `json:"instance,omitempty"`
+}
+
+type inventorySlotRef struct {
+	Area        string `json:"area"`
+	ContainerID string `json:"containerId,omitempty"`
+	Index       int    `json:"index"`
+}
+
+type inventorySlotOpPayload struct {
+	PlayerID   string           `json:"playerId"`
+	ActionID   string           `json:"actionId"`
+	Operation  string           `json:"operation"`
+	From       inventorySlotRef `json:"from"`
+	To         inventorySlotRef `json:"to"`
+	Quantity   int              `json:"quantity,omitempty"`
+	CustomName string           `json:"customName,omitempty"`
+}
+
+type runtimeInventorySlotResult struct {
+	ActionID  string `json:"actionId"`
+	PlayerID  string `json:"playerId"`
+	Operation string `json:"operation"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	Tick      int64  `json:"tick"`
+	Replayed  bool   `json:"replayed,omitempty"`
+}
+
+// inventorySlotOpUpdates lists the states touched by an accepted slot operation.
+type inventorySlotOpUpdates struct {
+	inventory *runtimeInventoryState
+	hotbar    *runtimeHotbarState
+	container *runtimeContainerState
+}
+
+func (s runtimeItemStack) isEmpty() bool {
+	return s.ItemID == "" || s.Quantity <= 0
+}
+
+func cloneItemInstance(instance *runtimeItemInstance) *runtimeItemInstance {
+	if instance == nil {
+		return nil
+	}
+	cloned := *instance
+	return &cloned
+}
+
+func cloneItemStack(stack runtimeItemStack) runtimeItemStack {
+	if stack.isEmpty() {
+		return runtimeItemStack{}
+	}
+	return runtimeItemStack{
+		ItemID:   stack.ItemID,
+		Quantity: stack.Quantity,
+		Instance: cloneItemInstance(stack.Instance),
+	}
+}
+
+func cloneItemStacks(stacks []runtimeItemStack) []runtimeItemStack {
+	cloned := make([]runtimeItemStack, len(stacks))
+	for index, stack := range stacks {
+		cloned[index] = cloneItemStack(stack)
+	}
+	return cloned
+}
+
+func itemInstancesMergeable(left *runtimeItemInstance, right *runtimeItemInstance) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if left.InstanceID != "" || right.InstanceID != "" {
+		return false
+	}
+	return *left == *right
+}
+
+func itemStacksMergeable(left runtimeItemStack, right runtimeItemStack) bool {
+	return left.ItemID == right.ItemID && itemInstancesMergeable(left.Instance, right.Instance)
+}
+
+func (c *runtimeContent) isKnownItem(itemID string) bool {
+	_, ok := c.itemMaxStacks[itemID]
+	return ok
+}
+
+func (c *runtimeContent) maxStackForItem(itemID string) int {
+	if maxStack, ok := c.itemMaxStacks[itemID]; ok && maxStack > 0 {
+		return maxStack
+	}
+	return defaultItemMaxStack
+}
+
+func countItems(slots []runtimeItemStack, itemID string) int {
+	total := 0
+	for _, stack := range slots {
+		if stack.ItemID == itemID && stack.Quantity > 0 {
+			total += stack.Quantity
+		}
+	}
+	return total
+}
+
+// addItemStack merges template into slots, filling matching stacks first and
+// then empty slots. It returns how many units fit.
+func (c *runtimeContent) addItemStack(slots []runtimeItemStack, template runtimeItemStack) int {
+	if template.isEmpty() {
+		return 0
+	}
+	maxStack := c.maxStackForItem(template.ItemID)
+	remaining := template.Quantity
+	for index := range slots {
+		if remaining <= 0 {
+			break
+		}
+		if slots[index].isEmpty() || !itemStacksMergeable(slots[index], template) {
+			continue
+		}
+		room := maxStack - slots[index].Quantity
+		if room <= 0 {
+			continue
+		}
+		moved := min(room, remaining)
+		slots[index].Quantity += moved
+		remaining -= moved
+	}
+	for index := range slots {
+		if remaining <= 0 {
+			break
+		}
+		if !slots[index].isEmpty() {
+			continue
+		}
+		moved := min(maxStack, remaining)
+		slots[index] = runtimeItemStack{
+			ItemID:   template.ItemID,
+			Quantity: moved,
+			Instance: cloneItemInstance(template.Instance),
+		}
+		remaining -= moved
+	}
+	return template.Quantity - remaining
+}
+
+func (c *runtimeContent) addItems(slots []runtimeItemStack, itemID string, quantity int) int {
+	return c.addItemStack(slots, runtimeItemStack{ItemID: itemID, Quantity: quantity})
+}
+
+// canAddItems reports whether quantity units of itemID fit without mutating slots.
+func (c *runtimeContent) canAddItems(slots []runtimeItemStack, itemID string, quantity int) bool {
+	return c.addItems(cloneItemStacks(slots), itemID, quantity) == quantity
+}
+
+// removeItems takes quantity units of itemID out of slots, preferring plain
+// stacks and later slots. Nothing changes when there are not enough units.
+func removeItems(slots []runtimeItemStack, itemID string, quantity int) bool {
+	_, ok := takeItems(slots, itemID, quantity)
+	return ok
+}
+
+// takeItems is removeItems returning the removed units as stacks, so item
+// instances survive being moved elsewhere.
+func takeItems(slots []runtimeItemStack, itemID string, quantity int) ([]runtimeItemStack, bool) {
+	if quantity <= 0 {
+		return nil, true
+	}
+	if countItems(slots, itemID) < quantity {
+		return nil, false
+	}
+	taken := make([]runtimeItemStack, 0, 1)
+	remaining := quantity
+	for pass := 0; pass < 2 && remaining > 0; pass++ {
+		for index := len(slots) - 1; index >= 0 && remaining > 0; index-- {
+			stack := slots[index]
+			if stack.ItemID != itemID || stack.Quantity <= 0 {
+				continue
+			}
+			if pass == 0 && stack.Instance != nil {
+				continue
+			}
+			moved := min(stack.Quantity, remaining)
+			part := cloneItemStack(stack)
+			part.Quantity = moved
+			taken = append(taken, part)
+			slots[index].Quantity -= moved
+			remaining -= moved
+			if slots[index].Quantity <= 0 {
+				slots[index] = runtimeItemStack{}
+			}
+		}
+	}
+	return taken, true
+}
+
+// projectResources builds the legacy resource map from slots so clients that
+// predate slot inventories keep working.
+func (c *runtimeContent) projectResources(slots []runtimeItemStack) map[string]int {
+	resources := c.defaultResourceMap()
+	for _, stack := range slots {
+		if stack.isEmpty() {
+			continue
+		}
+		if _, ok := resources[stack.ItemID]; ok {
+			resources[stack.ItemID] += stack.Quantity
+		}
+	}
+	return resources
+}
+
+// normalizeItemSlots resizes slots to capacity, drops unknown items and clamps
+// quantities to the item's max stack.
+func (c *runtimeContent) normalizeItemSlots(slots []runtimeItemStack, capacity int) []runtimeItemStack {
+	normalized := make([]runtimeItemStack, capacity)
+	overflow := make([]runtimeItemStack, 0)
+	for index, stack := range slots {
+		if stack.isEmpty() || !c.isKnownItem(stack.ItemID) {
+			continue
+		}
+		stack = cloneItemStack(stack)
+		if maxStack := c.maxStackForItem(stack.ItemID); stack.Quantity > maxStack {
+			overflow = append(overflow, runtimeItemStack{
+				ItemID:   stack.ItemID,
+				Quantity: stack.Quantity - maxStack,
+				Instance: cloneItemInstance(stack.Instance),
+			})
+			stack.Quantity = maxStack
+		}
+		if index < capacity {
+			normalized[index] = stack
+			continue
+		}
+		overflow = append(overflow, stack)
+	}
+	for _, stack := range overflow {
+		c.addItemStack(normalized, stack)
+	}
+	return normalized
+}
+
+// slotsFromResources converts a legacy resource map into slots, in content order.
+func (c *runtimeContent) slotsFromResources(resources map[string]int, capacity int) []runtimeItemStack {
+	slots := make([]runtimeItemStack, capacity)
+	for _, resourceID := range c.resourceIDs {
+		if amount := resources[resourceID]; amount > 0 {
+			c.addItems(slots, resourceID, amount)
+		}
+	}
+	return slots
+}
+
+func (h *worldHub) nextItemInstanceIDLocked() string {
+	h.itemSeq++
+	return "item-" + strconv.FormatInt(h.itemSeq, 10)
+}
+
+// grantItemsLocked adds quantity units of itemID, minting an instance for
+// every unit of non-stackable items. It returns how many units fit.
+func (h *worldHub) grantItemsLocked(slots []runtimeItemStack, itemID string, quantity int, origin string) int {
+	if h.content.maxStackForItem(itemID) > 1 {
+		return h.content.addItems(slots, itemID, quantity)
+	}
+	added := 0
+	for ; added < quantity; added++ {
+		stack := runtimeItemStack{
+			ItemID:   itemID,
+			Quantity: 1,
+			Instance: &runtimeItemInstance{
+				InstanceID: h.nextItemInstanceIDLocked(),
+				Origin:     origin,
+			},
+		}
+		if h.content.addItemStack(slots, stack) != 1 {
+			break
+		}
+	}
+	return added
+}
+
+func containerCapacity(containerID string) int {
+	if isChestContainerID(containerID) {
+		return chestContainerCapacity
+	}
+	if _, isPrivate := privateContainerOwner(containerID); isPrivate {
+		return privateContainerCapacity
+	}
+	return sharedContainerCapacity
+}
+
+func (h *worldHub) syncInventoryLocked(state *runtimeInventoryState) {
+	state.Capacity = playerInventoryCapacity
+	if len(state.Slots) != state.Capacity {
+		state.Slots = h.content.normalizeItemSlots(state.Slots, state.Capacity)
+	}
+	state.Resources = h.content.projectResources(state.Slots)
+	state.Tick = h.tick
+	h.inventoryStates[state.PlayerID] = cloneInventoryState(*state)
+}
+
+func (h *worldHub) syncContainerLocked(state *runtimeContainerState) {
+	state.Capacity = containerCapacity(state.ContainerID)
+	if len(state.Slots) != state.Capacity {
+		state.Slots = h.content.normalizeItemSlots(state.Slots, state.Capacity)
+	}
+	state.Resources = h.content.projectResources(state.Slots)
+	state.Chest = nil
+	if chest, ok := h.chests[state.ContainerID]; ok {
+		chestCopy := cloneChest(chest)
+		state.Chest = &chestCopy
+	}
+	state.Tick = h.tick
+	h.containerStates[state.ContainerID] = cloneContainerState(*state)
+}
+
+// hotbarItemID returns the item a hotbar position holds: consumables and
+// equipment hold themselves, ability slots without durability hold nothing.
+func (h *worldHub) hotbarItemID(slotID string) string {
+	if config, ok := h.content.combatSlots[slotID]; ok && (config.kind == "item" || config.maxDurability > 0) {
+		return slotID
+	}
+	return ""
+}
+
+// normalizeHotbarItemsLocked keeps Items aligned with SlotIDs (rebuilding them
+// from legacy StackCounts when needed, with equipment given its item) and
+// re-projects StackCounts.
+func (h *worldHub) normalizeHotbarItemsLocked(state runtimeHotbarState) runtimeHotbarState {
+	if len(state.Items) != len(state.SlotIDs) {
+		items := make([]runtimeItemStack, len(state.SlotIDs))
+		for index, slotID := range state.SlotIDs {
+			itemID := h.hotbarItemID(slotID)
+			if itemID != "" && h.content.combatSlots[slotID].maxDurability > 0 {
+				items[index] = runtimeItemStack{ItemID: itemID, Quantity: 1}
+				continue
+			}
+			if itemID == "" || index >= len(state.StackCounts) || state.StackCounts[index] <= 0 {
+				continue
+			}
+			items[index] = runtimeItemStack{
+				ItemID:   itemID,
+				Quantity: min(state.StackCounts[index], h.content.maxStackForItem(itemID)),
+			}
+		}
+		state.Items = items
+	}
+	state.StackCounts = make([]int, len(state.SlotIDs))
+	for index, slotID := range state.SlotIDs {
+		stack := state.Items[index]
+		if stack.isEmpty() || stack.ItemID != h.hotbarItemID(slotID) {
+			state.Items[index] = runtimeItemStack{}
+			continue
+		}
+		state.StackCounts[index] = stack.Quantity
+	}
+	h.normalizeHotbarDurability(&state)
+	h.normalizeHotbarUnlocks(&state)
+	return state
+}
+
+func (h *worldHub) storeHotbarLocked(state *runtimeHotbarState) {
+	*state = h.normalizeHotbarItemsLocked(*state)
+	state.Tick = h.tick
+	h.hotbarStates[state.PlayerID] = cloneHotbarState(*state)
+}
+
+// applyInventorySlotOp moves or renames stacks between the player's inventory,
+// hotbar and accessible containers.
+func (h *worldHub) applyInventorySlotOp(payload inventorySlotOpPayload) (runtimeInventorySlotResult, inventorySlotOpUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := runtimeInventorySlotResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		Operation: payload.Operation,
+		Tick:      h.tick,
+	}
+	if h.replaySeenActionLocked(payload.PlayerID, "inventory_slot_op", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, inventorySlotOpUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "inventory_slot_op", payload.ActionID, result.Accepted, result)
+	}()
+	reject := func(reason string) (runtimeInventorySlotResult, inventorySlotOpUpdates) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordInventorySlotEventLocked(result, payload)
+		return result, inventorySlotOpUpdates{}
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.Quantity < 0 {
+		return reject("invalid_payload")
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		return reject("player_not_found")
+	}
+
+	areas := newSlotAreaSet(h, payload.PlayerID)
+	switch payload.Operation {
+	case "move":
+		if reason := h.moveItemStackLocked(areas, payload.From, payload.To, payload.Quantity); reason != "" {
+			return reject(reason)
+		}
+	case "rename":
+		if reason := areas.renameStack(payload.From, payload.CustomName); reason != "" {
+			return reject(reason)
+		}
+	default:
+		return reject("invalid_operation")
+	}
+
+	updates := areas.commit()
+	result.Accepted = true
+	h.recordInventorySlotEventLocked(result, payload)
+	return result, updates
+}
+
+// slotAreaSet stages edits to the inventory, hotbar and at most one container
+// so a slot operation either applies completely or not at all.
+type slotAreaSet struct {
+	hub         *worldHub
+	playerID    string
+	inventory   *runtimeInventoryState
+	hotbar      *runtimeHotbarState
+	container   *runtimeContainerState
+	containerID string
+}
+
+func newSlotAreaSet(h *worldHub, playerID string) *slotAreaSet {
+	return &slotAreaSet{hub: h, playerID: playerID}
+}
+
+func (a *slotAreaSet) slots(ref inventorySlotRef) ([]runtimeItemStack, string) {
+	h := a.hub
+	switch ref.Area {
+	case "inventory":
+		if a.inventory == nil {
+			state := cloneInventoryState(h.ensureInventoryStateLocked(a.playerID))
+			a.inventory = &state
+		}
+		return a.inventory.Slots, ""
+	case "hotbar":
+		if a.hotbar == nil {
+			state := cloneHotbarState(h.ensureHotbarStateLocked(a.playerID))
+			a.hotbar = &state
+		}
+		return a.hotbar.Items, ""
+	case "container":
+		if ref.ContainerID == "" {
+			return nil, "invalid_payload"
+		}
+		if a.container != nil && a.containerID != ref.ContainerID {
+			return nil, "invalid_payload"
+		}
+		if reason := h.containerAccessReasonLocked(a.playerID, ref.ContainerID); reason != "" {
+			return nil, reason
+		}
+		if a.container == nil {
+			state := cloneContainerState(h.ensureContainerStateLocked(ref.ContainerID))
+			a.container = &state
+			a.containerID = ref.ContainerID
+		}
+		return a.container.Slots, ""
+	default:
+		return nil, "invalid_area"
+	}
+}
+
+func (a *slotAreaSet) hotbarSlotID(ref inventorySlotRef) string {
+	if ref.Area != "hotbar" || a.hotbar == nil || ref.Index < 0 || ref.Index >= len(a.hotbar.SlotIDs) {
+		return ""
+	}
+	return a.hotbar.SlotIDs[ref.Index]
+}
+
+// accepts reports whether stack may sit in ref. Hotbar positions only hold
+// the item bound to their combat slot.
+func (a *slotAreaSet) accepts(ref inventorySlotRef, stack runtimeItemStack) bool {
+	if stack.isEmpty() || ref.Area != "hotbar" {
+		return true
+	}
+	return a.hub.hotbarItemID(a.hotbarSlotID(ref)) == stack.ItemID
+}
+
+func (a *slotAreaSet) renameStack(ref inventorySlotRef, customName string) string {
+	slots, reason := a.slots(ref)
+	if reason != "" {
+		return reason
+	}
+	if ref.Index < 0 || ref.Index >= len(slots) {
+		return "invalid_slot"
+	}
+	if slots[ref.Index].isEmpty() {
+		return "slot_empty"
+	}
+	customName = strings.TrimSpace(customName)
+	if len(customName) > maxItemCustomNameLength {
+		return "invalid_name"
+	}
+	instance := cloneItemInstance(slots[ref.Index].Instance)
+	if instance == nil {
+		instance = &runtimeItemInstance{}
+	}
+	instance.CustomName = customName
+	if *instance == (runtimeItemInstance{}) {
+		instance = nil
+	}
+	slots[ref.Index].Instance = instance
+	return ""
+}
+
+func (h *worldHub) moveItemStackLocked(areas *slotAreaSet, from inventorySlotRef, to inventorySlotRef, quantity int) string {
+	source, reason := areas.slots(from)
+	if reason != "" {
+		return reason
+	}
+	target, reason := areas.slots(to)
+	if reason != "" {
+		return reason
+	}
+	if from.Index < 0 || from.Index >= len(source) || to.Index < 0 || to.Index >= len(target) {
+		return "invalid_slot"
+	}
+	if from.Area == to.Area && from.ContainerID == to.ContainerID && from.Index == to.Index {
+		return "invalid_slot"
+	}
+	moving := source[from.Index]
+	if moving.isEmpty() {
+		return "slot_empty"
+	}
+	if quantity == 0 {
+		quantity = moving.Quantity
+	}
+	if quantity > moving.Quantity {
+		return "insufficient_items"
+	}
+	if !areas.accepts(to, moving) {
+		return "slot_mismatch"
+	}
+
+	existing := target[to.Index]
+	switch {
+	case existing.isEmpty():
+		target[to.Index] = runtimeItemStack{
+			ItemID:   moving.ItemID,
+			Quantity: quantity,
+			Instance: cloneItemInstance(moving.Instance),
+		}
+	case itemStacksMergeable(existing, moving):
+		room := h.content.maxStackForItem(moving.ItemID) - existing.Quantity
+		if room <= 0 {
+			return "stack_full"
+		}
+		quantity = min(quantity, room)
+		target[to.Index].Quantity += quantity
+	case quantity == moving.Quantity:
+		if !areas.accepts(from, existing) {
+			return "slot_mismatch"
+		}
+		target[to.Index] = moving
+		source[from.Index] = existing
+		return ""
+	default:
+		return "slot_occupied"
+	}
+
+	source[from.Index].Quantity -= quantity
+	if source[from.Index].Quantity <= 0 {
+		source[from.Index] = runtimeItemStack{}
+	}
+	return ""
+}
+
+func (a *slotAreaSet) commit() inventorySlotOpUpdates {
+	h := a.hub
+	updates := inventorySlotOpUpdates{}
+	if a.inventory != nil {
+		h.syncInventoryLocked(a.inventory)
+		inventoryCopy := cloneInventoryState(*a.inventory)
+		updates.inventory = &inventoryCopy
+	}
+	if a.hotbar != nil {
+		h.storeHotbarLocked(a.hotbar)
+		hotbarCopy := cloneHotbarState(*a.hotbar)
+		updates.hotbar = &hotbarCopy
+	}
+	if a.container != nil {
+		h.syncContainerLocked(a.container)
+		containerCopy := cloneContainerState(*a.container)
+		updates.container = &containerCopy
+	}
+	return updates
+}
+
+func (h *worldHub) containerAccessReasonLocked(playerID string, containerID string) string {
+	if isChestContainerID(containerID) {
+		return h.chestAccessReasonLocked(playerID, containerID)
+	}
+	if !canAccessContainer(playerID, containerID) {
+		return "container_forbidden"
+	}
+	if !h.canUseContainerLocked(playerID, containerID) {
+		return "claim_protected"
+	}
+	return ""
+}
+
+func (h *worldHub) recordInventorySlotEventLocked(result runtimeInventorySlotResult, payload inventorySlotOpPayload) {
+	eventType := "inventory_slot_rejected"
+	if result.Accepted {
+		eventType = "inventory_slot_applied"
+	}
+	eventPayload := map[string]any{
+		"actionId":  result.ActionID,
+		"operation": result.Operation,
+		"fromArea":  payload.From.Area,
+		"fromIndex": payload.From.Index,
+	}
+	if payload.Operation == "move" {
+		eventPayload["toArea"] = payload.To.Area
+		eventPayload["toIndex"] = payload.To.Index
+		eventPayload["quantity"] = payload.Quantity
+	}
+	if payload.From.ContainerID != "" {
+		eventPayload["fromContainerId"] = payload.From.ContainerID
+	}
+	if payload.To.ContainerID != "" {
+		eventPayload["toContainerId"] = payload.To.ContainerID
+	}
+	if result.Reason != "" {
+		eventPayload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, eventPayload)
+}
+
+// addToHotbarSlotLocked adds quantity units of the hotbar position's bound
+// item, failing without changes when the stack would exceed its max stack.
+func (h *worldHub) addToHotbarSlotLocked(state *runtimeHotbarState, index int, quantity int) bool {
+	if index < 0 || index >= len(state.SlotIDs) || index >= len(state.Items) {
+		return false
+	}
+	itemID := h.hotbarItemID(state.SlotIDs[index])
+	if itemID == "" {
+		return false
+	}
+	stack := state.Items[index]
+	if stack.isEmpty() {
+		stack = runtimeItemStack{ItemID: itemID}
+	}
+	if stack.Quantity+quantity > h.content.maxStackForItem(itemID) {
+		return false
+	}
+	stack.Quantity += quantity
+	state.Items[index] = stack
+	return true
+}
+
+func sortedResourceKeys(resources map[string]int) []string {
+	keys := make([]string, 0, len(resources))
+	for key := range resources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxDepartedPlayers bounds how many departed players keep their parked
+// state. The longest-departed are forgotten first.
+const maxDepartedPlayers = 1024
+
+// departedPlayer is the state parked when a player leaves or lingers past
+// the timeout, restored when the same player joins again. ResumeToken is the
+// token of the player's last session; the join must present it.
+type departedPlayer struct {
+	PlayerID    string                `json:"playerId"`
+	X           float64               `json:"x"`
+	Z           float64               `json:"z"`
+	Hotbar      runtimeHotbarState    `json:"hotbar"`
+	Inventory   runtimeInventoryState `json:"inventory"`
+	Health      runtimeHealthState    `json:"health"`
+	LeftTick    int64                 `json:"leftTick"`
+	ResumeToken string                `json:"resumeToken,omitempty"`
+}
+
+// parkPlayerLocked moves a leaving player's hotbar, inventory and health out
+// of the live maps so a later join can restore them.
+func (h *worldHub) parkPlayerLocked(playerID string) {
+	player, ok := h.players[playerID]
+	if !ok {
+		return
+	}
+	departed := departedPlayer{
+		PlayerID: playerID,
+		X:        player.X,
+		Z:        player.Z,
+		LeftTick: h.tick,
+	}
+	if hotbar, ok := h.hotbarStates[playerID]; ok {
+		departed.Hotbar = cloneHotbarState(hotbar)
+	}
+	if inventory, ok := h.inventoryStates[playerID]; ok {
+		departed.Inventory = cloneInventoryState(inventory)
+	}
+	if health, ok := h.healthStates[playerID]; ok {
+		departed.Health = health
+	}
+	if session, ok := h.sessions[playerID]; ok {
+		departed.ResumeToken = session.Token
+	}
+	h.departedPlayers[playerID] = departed
+	h.pruneDepartedPlayersLocked()
+}
+
+func (h *worldHub) pruneDepartedPlayersLocked() {
+	if len(h.departedPlayers) <= maxDepartedPlayers {
+		return
+	}
+	departed := make([]departedPlayer, 0, len(h.departedPlayers))
+	for _, entry := range h.departedPlayers {
+		departed = append(departed, entry)
+	}
+	sort.Slice(departed, func(left int, right int) bool {
+		if departed[left].LeftTick != departed[right].LeftTick {
+			return departed[left].LeftTick < departed[right].LeftTick
+		}
+		return departed[left].PlayerID < departed[right].PlayerID
+	})
+	for _, entry := range departed[:len(departed)-maxDepartedPlayers] {
+		delete(h.departedPlayers, entry.PlayerID)
+		if _, live := h.players[entry.PlayerID]; !live {
+			delete(h.seenActions, entry.PlayerID)
+		}
+	}
+}
+
+// joinIdentityProblemLocked decides whether client may join as join.PlayerID.
+// A new player id is free to take. A player in the world or parked belongs to
+// whoever holds its resume token, so the join must present it unless the
+// client already controls the player. Parked state saved before tokens were
+// recorded has nothing to check against and goes to the first join.
+func (h *worldHub) joinIdentityProblemLocked(client *clientConn, join joinRuntimeRequest) string {
+	if _, owned := client.playerIDs[join.PlayerID]; owned {
+		return ""
+	}
+	expected := ""
+	if session, ok := h.sessions[join.PlayerID]; ok {
+		expected = session.Token
+	} else if departed, ok := h.departedPlayers[join.PlayerID]; ok {
+		expected = departed.ResumeToken
+	}
+	if expected != "" && join.ResumeToken != expected {
+		return "invalid_resume_token"
+	}
+	return ""
+}
+
+// restoreDepartedPlayerLocked puts a returning player back where they left
+// with the state they left with. It reports whether there was anything to
+// restore.
+func (h *worldHub) restoreDepartedPlayerLocked(playerID string) bool {
+	departed, ok := h.departedPlayers[playerID]
+	if !ok {
+		return false
+	}
+	delete(h.departedPlayers, playerID)
+	h.players[playerID] = &playerState{
+		PlayerID: playerID,
+		X:        departed.X,
+		Z:        departed.Z,
+	}
+	if departed.Hotbar.PlayerID == playerID {
+		h.hotbarStates[playerID] = cloneHotbarState(departed.Hotbar)
+	}
+	if departed.Inventory.PlayerID == playerID {
+		h.inventoryStates[playerID] = cloneInventoryState(departed.Inventory)
+	}
+	if departed.Health.PlayerID == playerID && departed.Health.Current > 0 {
+		h.healthStates[playerID] = departed.Health
+	}
+	h.recordWorldEventLocked("player_restored", playerID, map[string]any{
+		"leftTick": departed.LeftTick,
+	})
+	return true
+}
+
+// lingerUncontrolledPlayersLocked starts the linger timeout for players no
+// connection controls, such as those restored by importState, so they do not
+// stay in the world forever. A player keeps the resume token it was saved
+// with, so its client can still resume or rejoin.
+func (h *worldHub) lingerUncontrolledPlayersLocked(tokens map[string]string) {
+	for playerID := range h.players {
+		if _, ok := h.sessions[playerID]; ok || h.playerControlledLocked(playerID) {
+			continue
+		}
+		token := tokens[playerID]
+		if token == "" {
+			token = newResumeToken()
+		}
+		h.sessions[playerID] = &playerSession{
+			PlayerID:     playerID,
+			Token:        token,
+			Detached:     true,
+			DetachedTick: h.tick,
+		}
+	}
+}
+
+func (h *worldHub) playerAwayLocked(playerID string) bool {
+	session, ok := h.sessions[playerID]
+	return ok && session.Detached
+}
+
+func (h *worldHub) exportDepartedPlayersLocked() []departedPlayer {
+	playerIDs := make([]string, 0, len(h.departedPlayers))
+	for playerID := range h.departedPlayers {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	exported := make([]departedPlayer, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		entry := h.departedPlayers[playerID]
+		entry.Hotbar = cloneHotbarState(entry.Hotbar)
+		entry.Inventory = cloneInventoryState(entry.Inventory)
+		exported = append(exported, entry)
+	}
+	return exported
+}
+
+// importDepartedPlayersLocked normalises parked inventories and hotbars the
+// same way importState does for live players.
+func (h *worldHub) importDepartedPlayersLocked(entries []departedPlayer) map[string]departedPlayer {
+	imported := make(map[string]departedPlayer, len(entries))
+	for _, entry := range entries {
+		playerID := strings.TrimSpace(entry.PlayerID)
+		if playerID == "" {
+			continue
+		}
+		entry.PlayerID = playerID
+		entry.X = sanitizeNumber(entry.X)
+		entry.Z = sanitizeNumber(entry.Z)
+		if len(entry.Hotbar.SlotIDs) > 0 {
+			entry.Hotbar.PlayerID = playerID
+			entry.Hotbar = h.normalizeHotbarItemsLocked(cloneHotbarState(entry.Hotbar))
+		} else {
+			entry.Hotbar = runtimeHotbarState{}
+		}
+		slots := h.content.slotsFromResources(entry.Inventory.Resources, playerInventoryCapacity)
+		if len(entry.Inventory.Slots) > 0 {
+			slots = h.content.normalizeItemSlots(entry.Inventory.Slots, playerInventoryCapacity)
+		}
+		entry.Inventory = runtimeInventoryState{
+			PlayerID:  playerID,
+			Slots:     slots,
+			Capacity:  playerInventoryCapacity,
+			Resources: h.content.projectResources(slots),
+			Tick:      entry.Inventory.Tick,
+		}
+		if entry.Health.Max <= 0 || entry.Health.Current <= 0 || entry.Health.Current > entry.Health.Max {
+			entry.Health = runtimeHealthState{}
+		} else {
+			entry.Health.PlayerID = playerID
+		}
+		imported[playerID] = entry
+	}
+	return imported
+}
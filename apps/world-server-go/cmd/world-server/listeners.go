@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// originAllowlist decides which browser origins may open /ws. Patterns are
+// "*" (any origin), an exact origin such as "https://play.example.com", or an
+// origin whose host starts with "*." to match any subdomain, such as
+// "https://*.example.com". Requests without an Origin header come from
+// non-browser clients (bots, tools) and are always allowed.
+type originAllowlist struct {
+	patterns []string
+}
+
+func parseOriginAllowlist(raw string) originAllowlist {
+	patterns := make([]string, 0)
+	for _, pattern := range strings.Split(raw, ",") {
+		pattern = strings.ToLower(strings.TrimRight(strings.TrimSpace(pattern), "/"))
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return originAllowlist{patterns: patterns}
+}
+
+func (a originAllowlist) allows(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(strings.ToLower(origin))
+	if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+		return false
+	}
+	for _, pattern := range a.patterns {
+		if pattern == "*" {
+			return true
+		}
+		scheme, host, ok := strings.Cut(pattern, "://")
+		if !ok || scheme != parsed.Scheme {
+			continue
+		}
+		if host == parsed.Host {
+			return true
+		}
+		if suffix, wildcard := strings.CutPrefix(host, "*."); wildcard && strings.HasSuffix(parsed.Host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newUpgrader(allowlist originAllowlist) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(request *http.Request) bool {
+			return allowlist.allows(request.Header.Get("Origin"))
+		},
+	}
+}
+
+// certReloadInterval throttles how often the certificate files are checked
+// for changes.
+const certReloadInterval = time.Second
+
+// certReloader serves a TLS certificate from disk and reloads it when the
+// certificate or key file changes, so renewals need no restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	checked     time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("stat tls certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("stat tls key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load tls key pair: %w", err)
+	}
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. A pair that fails to
+// load (for example mid-rotation, with only one file replaced) keeps the
+// previous certificate in service.
+func (r *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.checked) >= certReloadInterval {
+		r.checked = now
+		certInfo, certErr := os.Stat(r.certFile)
+		keyInfo, keyErr := os.Stat(r.keyFile)
+		if certErr == nil && keyErr == nil &&
+			(!certInfo.ModTime().Equal(r.certModTime) || !keyInfo.ModTime().Equal(r.keyModTime)) {
+			_ = r.reload()
+		}
+	}
+	return r.cert, nil
+}
+
+// buildPublicMux serves what game clients need plus the health probes. When
+// adminOnPublic is set (no separate admin listener), the admin endpoints are
+// mounted too.
+func buildPublicMux(hub *worldHub, adminOnPublic bool) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	mountHealthRoutes(mux, hub)
+	if adminOnPublic {
+		mountAdminRoutes(mux, hub)
+	}
+	return mux
+}
+
+func buildAdminMux(hub *worldHub) *http.ServeMux {
+	mux := http.NewServeMux()
+	mountHealthRoutes(mux, hub)
+	mountAdminRoutes(mux, hub)
+	mountDiagnosticsRoutes(mux, hub)
+	return mux
+}
+
+func mountAdminRoutes(mux *http.ServeMux, hub *worldHub) {
+	auth := hub.adminAuth
+	mux.HandleFunc("/openclaw/directives", auth.require(scopeDirectivesWrite, buildDirectiveHandler(hub)))
+	mux.HandleFunc("/openclaw/events", auth.require(scopeEventsRead, buildEventFeedHandler(hub)))
+	mux.HandleFunc("/debug/state", auth.require(scopeAdminState, buildDebugStateHandler(hub)))
+	mux.HandleFunc("/debug/load-state", auth.require(scopeAdminState, buildDebugLoadStateHandler(hub)))
+	mux.HandleFunc("/debug/reload-content", auth.require(scopeAdminState, buildContentReloadHandler(hub)))
+	mux.HandleFunc("/debug/metrics", auth.require(scopeAdminState, buildMetricsHandler(hub)))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+// newLogger builds the server logger. format is "text" or "json"; level is
+// any slog level name ("debug", "info", "warn", "error").
+func newLogger(writer io.Writer, level string, format string) (*slog.Logger, error) {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+	options := &slog.HandlerOptions{Level: parsed}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(writer, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(writer, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+}
+
+// fatal logs at error level and exits, for startup failures.
+func fatal(logger *slog.Logger, message string, args ...any) {
+	logger.Error(message, args...)
+	os.Exit(1)
+}
+
+func (h *worldHub) newConnectionID() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connSeq++
+	return "conn-" + strconv.FormatInt(h.connSeq, 10)
+}
+
+// messageLogIDs are the fields most client payloads carry that tie a log
+// line to a player and a specific action.
+type messageLogIDs struct {
+	PlayerID string `json:"playerId"`
+	ActionID string `json:"actionId"`
+}
+
+// messageLog writes lines about one client message carrying the connection,
+// player, action and tick, so a reported action can be traced through every
+// line it produced. The fields are worked out only for lines the logger will
+// write: most messages log nothing, and they should not pay for a second
+// payload decode or the hub lock.
+type messageLog struct {
+	hub      *worldHub
+	client   *clientConn
+	envelope clientEnvelope
+}
+
+func (h *worldHub) messageLogger(client *clientConn, envelope clientEnvelope) messageLog {
+	return messageLog{hub: h, client: client, envelope: envelope}
+}
+
+func (l messageLog) Debug(message string, args ...any) {
+	l.log(slog.LevelDebug, message, args)
+}
+
+func (l messageLog) Info(message string, args ...any) {
+	l.log(slog.LevelInfo, message, args)
+}
+
+func (l messageLog) Warn(message string, args ...any) {
+	l.log(slog.LevelWarn, message, args)
+}
+
+func (l messageLog) log(level slog.Level, message string, args []any) {
+	ctx := context.Background()
+	if !l.hub.logger.Enabled(ctx, level) {
+		return
+	}
+	var ids messageLogIDs
+	_ = json.Unmarshal(l.envelope.Payload, &ids)
+	fields := []any{
+		"connId", l.client.id,
+		"messageType", l.envelope.Type,
+		"requestId", l.envelope.RequestID,
+		"playerId", ids.PlayerID,
+		"actionId", ids.ActionID,
+		"tick", l.hub.publishedTick.Load(),
+	}
+	l.hub.logger.Log(ctx, level, message, append(fields, args...)...)
+}
+
+// logActionOutcome records rejected actions at debug level with the reason
+// the client was given.
+func logActionOutcome(logger messageLog, accepted bool, reason string) {
+	if accepted {
+		return
+	}
+	logger.Debug("action rejected", "reason", reason)
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math"
 	"net/http"
+	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -24,10 +31,11 @@ type runtimeInputState struct {
 }
 
 type joinRuntimeRequest struct {
-	WorldSeed string  `json:"worldSeed"`
-	PlayerID  string  `json:"playerId"`
-	StartX    float64 `json:"startX"`
-	StartZ    float64 `json:"startZ"`
+	WorldSeed   string  `json:"worldSeed"`
+	PlayerID    string  `json:"playerId"`
+	StartX      float64 `json:"startX"`
+	StartZ      float64 `json:"startZ"`
+	ResumeToken string  `json:"resumeToken,omitempty"`
 }
 
 type runtimePlayerSnapshot struct {
@@ -35,12 +43,19 @@ type runtimePlayerSnapshot struct {
 	X        float64 `json:"x"`
 	Z        float64 `json:"z"`
 	Speed    float64 `json:"speed"`
+	Away     bool    `json:"away,omitempty"`
 }
 
+// worldRuntimeSnapshot is the per-tick view of the world. In snapshots sent
+// to clients, GroundItems lists only ground items that came into view or
+// changed since the connection's previous snapshot and GroundItemsRemoved the
+// ids of those that left it.
 type worldRuntimeSnapshot struct {
-	WorldSeed string                           `json:"worldSeed"`
-	Tick      int64                            `json:"tick"`
-	Players   map[string]runtimePlayerSnapshot `json:"players"`
+	WorldSeed          string                           `json:"worldSeed"`
+	Tick               int64                            `json:"tick"`
+	Players            map[string]runtimePlayerSnapshot `json:"players"`
+	GroundItems        []runtimeGroundItem              `json:"groundItems,omitempty"`
+	GroundItemsRemoved []string                         `json:"groundItemsRemoved,omitempty"`
 }
 
 type runtimeBlockDelta struct {
@@ -53,20 +68,35 @@ type runtimeBlockDelta struct {
 	BlockType string `json:"blockType,omitempty"`
 }
 
+// serverEnvelope wraps every message the server sends. RequestID echoes the
+// requestId of the client message a reply answers and is empty otherwise.
 type serverEnvelope struct {
-	Type    string `json:"type"`
-	Payload any    `json:"payload"`
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+	Payload   any    `json:"payload"`
 }
 
+// clientEnvelope wraps every client message. RequestID is an optional
+// client-chosen correlation id echoed on the direct reply and on errors.
 type clientEnvelope struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 type leavePayload struct {
 	PlayerID string `json:"playerId"`
 }
 
+// runtimeRequestAck answers leave and input, which have no result of their
+// own to report.
+type runtimeRequestAck struct {
+	PlayerID string `json:"playerId"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+	Tick     int64  `json:"tick"`
+}
+
 type inputPayload struct {
 	PlayerID string            `json:"playerId"`
 	Input    runtimeInputState `json:"input"`
@@ -74,6 +104,7 @@ type inputPayload struct {
 
 type blockActionPayload struct {
 	PlayerID  string `json:"playerId"`
+	ActionID  string `json:"actionId,omitempty"`
 	Action    string `json:"action"`
 	ChunkX    int    `json:"chunkX"`
 	ChunkZ    int    `json:"chunkZ"`
@@ -83,6 +114,23 @@ type blockActionPayload struct {
 	BlockType string `json:"blockType,omitempty"`
 }
 
+type runtimeBlockActionResult struct {
+	ActionID    string `json:"actionId,omitempty"`
+	PlayerID    string `json:"playerId"`
+	Action      string `json:"action"`
+	ChunkX      int    `json:"chunkX"`
+	ChunkZ      int    `json:"chunkZ"`
+	X           int    `json:"x"`
+	Y           int    `json:"y"`
+	Z           int    `json:"z"`
+	BlockType   string `json:"blockType,omitempty"`
+	ContainerID string `json:"containerId,omitempty"`
+	Accepted    bool   `json:"accepted"`
+	Reason      string `json:"reason,omitempty"`
+	Tick        int64  `json:"tick"`
+	Replayed    bool   `json:"replayed,omitempty"`
+}
+
 type hotbarSelectPayload struct {
 	PlayerID  string `json:"playerId"`
 	SlotIndex int    `json:"slotIndex"`
@@ -137,6 +185,7 @@ type runtimeCombatResult struct {
 	TargetWorldZ        *float64 `json:"targetWorldZ,omitempty"`
 	CooldownRemainingMs int      `json:"cooldownRemainingMs,omitempty"`
 	Tick                int64    `json:"tick"`
+	Replayed            bool     `json:"replayed,omitempty"`
 }
 
 type runtimeInteractResult struct {
@@ -150,19 +199,26 @@ type runtimeInteractResult struct {
 	TargetWorldZ *float64 `json:"targetWorldZ,omitempty"`
 	Message      string   `json:"message,omitempty"`
 	Tick         int64    `json:"tick"`
+	Replayed     bool     `json:"replayed,omitempty"`
 }
 type runtimeHotbarState struct {
-	PlayerID      string   `json:"playerId"`
-	SlotIDs       []string `json:"slotIds"`
-	StackCounts   []int    `json:"stackCounts"`
-	SelectedIndex int      `json:"selectedIndex"`
-	Tick          int64    `json:"tick"`
+	PlayerID      string             `json:"playerId"`
+	SlotIDs       []string           `json:"slotIds"`
+	StackCounts   []int              `json:"stackCounts"`
+	Items         []runtimeItemStack `json:"items"`
+	Unlocked      []string           `json:"unlocked,omitempty"`
+	SelectedIndex int                `json:"selectedIndex"`
+	Tick          int64              `json:"tick"`
 }
 
+// runtimeInventoryState holds the player's item slots. Resources is a
+// projection of Slots kept for clients that predate slot inventories.
 type runtimeInventoryState struct {
-	PlayerID  string         `json:"playerId"`
-	Resources map[string]int `json:"resources"`
-	Tick      int64          `json:"tick"`
+	PlayerID  string             `json:"playerId"`
+	Slots     []runtimeItemStack `json:"slots"`
+	Capacity  int                `json:"capacity"`
+	Resources map[string]int     `json:"resources"`
+	Tick      int64              `json:"tick"`
 }
 
 type runtimeHealthState struct {
@@ -188,25 +244,33 @@ type runtimeCraftResult struct {
 	Count    int    `json:"count"`
 	Accepted bool   `json:"accepted"`
 	Reason   string `json:"reason,omitempty"`
+	JobID    string `json:"jobId,omitempty"`
+	Queued   bool   `json:"queued,omitempty"`
 	Tick     int64  `json:"tick"`
+	Replayed bool   `json:"replayed,omitempty"`
 }
 
 type runtimeContainerState struct {
-	ContainerID string         `json:"containerId"`
-	Resources   map[string]int `json:"resources"`
-	Tick        int64          `json:"tick"`
+	ContainerID string             `json:"containerId"`
+	Slots       []runtimeItemStack `json:"slots"`
+	Capacity    int                `json:"capacity"`
+	Resources   map[string]int     `json:"resources"`
+	Chest       *runtimeChest      `json:"chest,omitempty"`
+	Tick        int64              `json:"tick"`
 }
 
 type runtimeContainerActionResult struct {
-	ActionID    string `json:"actionId"`
-	PlayerID    string `json:"playerId"`
-	ContainerID string `json:"containerId"`
-	Operation   string `json:"operation"`
-	ResourceID  string `json:"resourceId"`
-	Amount      int    `json:"amount"`
-	Accepted    bool   `json:"accepted"`
-	Reason      string `json:"reason,omitempty"`
-	Tick        int64  `json:"tick"`
+	ActionID    string                       `json:"actionId"`
+	PlayerID    string                       `json:"playerId"`
+	ContainerID string                       `json:"containerId"`
+	Operation   string                       `json:"operation"`
+	ResourceID  string                       `json:"resourceId"`
+	Amount      int                          `json:"amount"`
+	Accepted    bool                         `json:"accepted"`
+	Reason      string                       `json:"reason,omitempty"`
+	Tick        int64                        `json:"tick"`
+	Lines       []runtimeContainerLineResult `json:"lines,omitempty"`
+	Replayed    bool                         `json:"replayed,omitempty"`
 }
 
 type runtimeWorldFlagState struct {
@@ -228,15 +292,26 @@ type runtimeDirectiveState struct {
 }
 
 type worldDebugState struct {
-	Snapshot        worldRuntimeSnapshot    `json:"snapshot"`
-	BlockDeltas     []runtimeBlockDelta     `json:"blockDeltas"`
-	HotbarStates    []runtimeHotbarState    `json:"hotbarStates"`
-	InventoryStates []runtimeInventoryState `json:"inventoryStates"`
-	HealthStates    []runtimeHealthState    `json:"healthStates"`
+	Snapshot        worldRuntimeSnapshot       `json:"snapshot"`
+	BlockDeltas     []runtimeBlockDelta        `json:"blockDeltas"`
+	HotbarStates    []runtimeHotbarState       `json:"hotbarStates"`
+	InventoryStates []runtimeInventoryState    `json:"inventoryStates"`
+	HealthStates    []runtimeHealthState       `json:"healthStates"`
 	EntityHealth    []runtimeEntityHealthState `json:"entityHealth"`
-	ContainerStates []runtimeContainerState `json:"containerStates"`
-	WorldFlags      runtimeWorldFlagState   `json:"worldFlags"`
-	DirectiveState  runtimeDirectiveState   `json:"directiveState"`
+	ContainerStates []runtimeContainerState    `json:"containerStates"`
+	Claims          []runtimeLandClaim         `json:"claims"`
+	CraftQueues     []runtimeCraftQueueState   `json:"craftQueues"`
+	ItemSeq         int64                      `json:"itemSeq"`
+	GroundItems     []runtimeGroundItem        `json:"groundItems"`
+	GroundItemSeq   int64                      `json:"groundItemSeq"`
+	Trades          []runtimeTradeSession      `json:"trades"`
+	TradeSeq        int64                      `json:"tradeSeq"`
+	Chests          []runtimeChest             `json:"chests"`
+	SeenActions     []playerSeenActions        `json:"seenActions"`
+	DepartedPlayers []departedPlayer           `json:"departedPlayers"`
+	ResumeTokens    []playerResumeToken        `json:"resumeTokens"`
+	WorldFlags      runtimeWorldFlagState      `json:"worldFlags"`
+	DirectiveState  runtimeDirectiveState      `json:"directiveState"`
 }
 
 type debugLoadStateAck struct {
@@ -306,6 +381,9 @@ const (
 	blockDeltaChunkRadius     = 2
 	chunkGridCells            = 16
 	worldChunkSize            = 64.0
+	voxelBlockSize            = worldChunkSize / chunkGridCells
+	maxBlockY                 = 64
+	defaultBlockReach         = 12.0
 	defaultSpawnHintTTLTicks  = 600
 	maxSpawnHintTTLTicks      = 4000
 	terrainMaxHeight          = 8
@@ -329,22 +407,7 @@ type combatSlotConfig struct {
 	requiresTarget bool
 	damage         int
 	heal           int
-}
-
-var combatSlotConfigs = map[string]combatSlotConfig{
-	"slot-1-rust-blade": {kind: "melee", cooldownTicks: 12, maxRange: 3.4, requiresTarget: true, damage: 2},
-	"slot-2-ember-bolt": {kind: "spell", cooldownTicks: 20, maxRange: 11.5, requiresTarget: true, damage: 3},
-	"slot-3-frost-bind": {kind: "spell", cooldownTicks: 29, maxRange: 8.5, requiresTarget: true, damage: 2},
-	"slot-4-bandage":    {kind: "item", cooldownTicks: 42, maxRange: 0, requiresTarget: false, heal: 2},
-	"slot-5-bomb":       {kind: "item", cooldownTicks: 33, maxRange: 9.5, requiresTarget: true, damage: 4},
-}
-
-var defaultHotbarSlotIDs = []string{
-	"slot-1-rust-blade",
-	"slot-2-ember-bolt",
-	"slot-3-frost-bind",
-	"slot-4-bandage",
-	"slot-5-bomb",
+	maxDurability  int
 }
 
 type craftIngredient struct {
@@ -352,72 +415,30 @@ type craftIngredient struct {
 	amount     int
 }
 
+// craftOutput produces amount units into a hotbar slot or the inventory, or
+// restores amount durability to repairSlotID.
 type craftOutput struct {
 	targetSlotID string
 	resourceID   string
+	repairSlotID string
 	amount       int
 }
 
-type craftRecipeConfig struct {
-	id          string
-	ingredients []craftIngredient
-	output      craftOutput
-}
-
-var craftRecipeConfigs = map[string]craftRecipeConfig{
-	"craft-bandage": {
-		id: "craft-bandage",
-		ingredients: []craftIngredient{
-			{resourceID: "fiber", amount: 2},
-			{resourceID: "salvage", amount: 1},
-		},
-		output: craftOutput{
-			targetSlotID: "slot-4-bandage",
-			amount:       1,
-		},
-	},
-	"craft-bomb": {
-		id: "craft-bomb",
-		ingredients: []craftIngredient{
-			{resourceID: "coal", amount: 2},
-			{resourceID: "fiber", amount: 1},
-		},
-		output: craftOutput{
-			targetSlotID: "slot-5-bomb",
-			amount:       1,
-		},
-	},
-	"craft-charcoal": {
-		id: "craft-charcoal",
-		ingredients: []craftIngredient{
-			{resourceID: "wood", amount: 2},
-		},
-		output: craftOutput{
-			resourceID: "coal",
-			amount:     1,
-		},
-	},
-	"craft-iron-ingot": {
-		id: "craft-iron-ingot",
-		ingredients: []craftIngredient{
-			{resourceID: "iron_ore", amount: 2},
-			{resourceID: "coal", amount: 1},
-		},
-		output: craftOutput{
-			resourceID: "iron_ingot",
-			amount:     1,
-		},
-	},
+// itemID returns the item the output produces; hotbar-bound outputs produce
+// the item of their target combat slot.
+func (o craftOutput) itemID() string {
+	if o.targetSlotID != "" {
+		return o.targetSlotID
+	}
+	return o.resourceID
 }
 
-var runtimeResourceIDs = []string{
-	"salvage",
-	"wood",
-	"stone",
-	"fiber",
-	"coal",
-	"iron_ore",
-	"iron_ingot",
+type craftRecipeConfig struct {
+	id            string
+	ingredients   []craftIngredient
+	output        craftOutput
+	durationTicks int64
+	station       string
 }
 
 const worldSharedContainerID = "world:camp-shared"
@@ -430,9 +451,18 @@ type playerState struct {
 }
 
 type clientConn struct {
+	id        string
 	conn      *websocket.Conn
 	writeMu   sync.Mutex
 	playerIDs map[string]struct{}
+	// firstSeq is the first broadcast sequence number the connection
+	// receives live; 0 when it was never added to the hub.
+	firstSeq int64
+	// groundItems maps each ground item the connection was last sent to its
+	// quantity then, so snapshots carry only changes.
+	groundItems  map[string]int
+	writeTimeout time.Duration
+	writeState   connWriteState
 }
 
 type worldHub struct {
@@ -441,27 +471,69 @@ type worldHub struct {
 	worldSeed string
 	tick      int64
 
-	players            map[string]*playerState
-	placed             map[string]string
-	removed            map[string]bool
-	combatCooldownTick map[string]map[string]int64
-	hotbarStates       map[string]runtimeHotbarState
-	inventoryStates    map[string]runtimeInventoryState
-	healthStates       map[string]runtimeHealthState
-	entityHealth       map[string]runtimeEntityHealthState
-	containerStates    map[string]runtimeContainerState
-	eventSeq           int64
-	eventLog           []worldEvent
-	worldFlags         map[string]string
-	storyBeats         []string
-	spawnHints         map[string]spawnHintEntry
-	directiveQueue     []openclawDirective
-	directiveSeen      map[string]struct{}
-	clients            map[*clientConn]struct{}
-
-	tickRateHz    float64
-	walkSpeed     float64
-	runMultiplier float64
+	players              map[string]*playerState
+	placed               map[string]string
+	removed              map[string]bool
+	stations             map[stationCell]map[string]struct{}
+	combatCooldownTick   map[string]map[string]int64
+	hotbarStates         map[string]runtimeHotbarState
+	inventoryStates      map[string]runtimeInventoryState
+	healthStates         map[string]runtimeHealthState
+	entityHealth         map[string]runtimeEntityHealthState
+	containerStates      map[string]runtimeContainerState
+	claims               map[string]runtimeLandClaim
+	claimsChanged        bool
+	craftQueues          map[string][]runtimeCraftJob
+	craftProgressOutbox  []runtimeCraftProgress
+	itemSeq              int64
+	groundItems          map[string]runtimeGroundItem
+	groundItemSeq        int64
+	groundPickupOutbox   []string
+	trades               map[string]runtimeTradeSession
+	tradeSeq             int64
+	tradeOutbox          []tradeUpdates
+	chests               map[string]runtimeChest
+	openContainers       map[string]string
+	containerCloseOutbox []runtimeContainerClosed
+	chestRemovalOutbox   []chestRemoval
+	seenActions          map[string][]seenAction
+	sessions             map[string]*playerSession
+	departedPlayers      map[string]departedPlayer
+	eventSeq             int64
+	eventLog             []worldEvent
+	broadcastSeq         int64
+	worldFlags           map[string]string
+	storyBeats           []string
+	spawnHints           map[string]spawnHintEntry
+	directiveQueue       []openclawDirective
+	directiveSeen        map[string]struct{}
+	clients              map[*clientConn]struct{}
+
+	tickRateHz     float64
+	walkSpeed      float64
+	runMultiplier  float64
+	blockReach     float64
+	lingerTicks    int64
+	connLimits     connectionLimits
+	allowedOrigins originAllowlist
+	adminAuth      *adminAuth
+	connMetrics    connectionMetrics
+	connSeq        int64
+	pendingTrace   tickTrace
+	tickTraces     tickTraceRing
+
+	blockProfileRate int
+	logger           *slog.Logger
+	content          *runtimeContent
+	contentDir       string
+	shuttingDown     atomic.Bool
+	publishedTick    atomic.Int64
+	dispatchMu       sync.RWMutex
+	stateLoaded      bool
+	startedAt        time.Time
+	tickHealth       tickHealth
+
+	readinessLimits readinessLimits
 
 	directiveBudgetTick  int64
 	directiveBudgetCount int
@@ -475,12 +547,22 @@ func newWorldHub() *worldHub {
 		players:            make(map[string]*playerState),
 		placed:             make(map[string]string),
 		removed:            make(map[string]bool),
+		stations:           make(map[stationCell]map[string]struct{}),
 		combatCooldownTick: make(map[string]map[string]int64),
 		hotbarStates:       make(map[string]runtimeHotbarState),
 		inventoryStates:    make(map[string]runtimeInventoryState),
 		healthStates:       make(map[string]runtimeHealthState),
 		entityHealth:       make(map[string]runtimeEntityHealthState),
 		containerStates:    make(map[string]runtimeContainerState),
+		claims:             make(map[string]runtimeLandClaim),
+		craftQueues:        make(map[string][]runtimeCraftJob),
+		groundItems:        make(map[string]runtimeGroundItem),
+		trades:             make(map[string]runtimeTradeSession),
+		chests:             make(map[string]runtimeChest),
+		openContainers:     make(map[string]string),
+		seenActions:        make(map[string][]seenAction),
+		sessions:           make(map[string]*playerSession),
+		departedPlayers:    make(map[string]departedPlayer),
 		eventLog:           make([]worldEvent, 0, maxOpenClawEvents),
 		worldFlags:         make(map[string]string),
 		storyBeats:         make([]string, 0, 32),
@@ -492,35 +574,60 @@ func newWorldHub() *worldHub {
 		tickRateHz:         20,
 		walkSpeed:          6,
 		runMultiplier:      1.35,
+		blockReach:         defaultBlockReach,
+		lingerTicks:        resumeGraceTicks,
+		connLimits:         defaultConnectionLimits(),
+		allowedOrigins:     parseOriginAllowlist("*"),
+		startedAt:          time.Now(),
+		logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+		readinessLimits:    defaultReadinessLimits(),
+		adminAuth:          newAdminAuth(nil, newAuditLog(io.Discard)),
+		connMetrics: connectionMetrics{
+			Closes:      make(map[string]int64),
+			RateLimited: make(map[string]int64),
+		},
+		content: builtinContent,
 	}
 }
 
 func (h *worldHub) addClient(client *clientConn) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	client.firstSeq = h.broadcastSeq + 1
 	h.clients[client] = struct{}{}
 }
 
 func (h *worldHub) removeClient(client *clientConn) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if _, ok := h.clients[client]; !ok {
+		return
+	}
 	delete(h.clients, client)
 	for playerID := range client.playerIDs {
 		if player, ok := h.players[playerID]; ok {
 			player.Input = runtimeInputState{}
 		}
 	}
+	h.detachSessionsLocked(client)
 }
 
-func (h *worldHub) handleJoin(client *clientConn, join joinRuntimeRequest) {
+// handleJoin adds the player to the world, or takes control of it when it is
+// already there or parked. Only a client presenting the player's current
+// resume token may take over an existing player; it returns the rejection
+// reason otherwise.
+func (h *worldHub) handleJoin(client *clientConn, join joinRuntimeRequest) string {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	if reason := h.joinIdentityProblemLocked(client, join); reason != "" {
+		return reason
+	}
 	if join.WorldSeed != "" {
 		h.worldSeed = join.WorldSeed
 	}
 	if existing, ok := h.players[join.PlayerID]; ok {
 		existing.Input = runtimeInputState{}
-	} else {
+	} else if !h.restoreDepartedPlayerLocked(join.PlayerID) {
 		h.players[join.PlayerID] = &playerState{
 			PlayerID: join.PlayerID,
 			X:        join.StartX,
@@ -528,6 +635,7 @@ func (h *worldHub) handleJoin(client *clientConn, join joinRuntimeRequest) {
 		}
 	}
 	client.playerIDs[join.PlayerID] = struct{}{}
+	h.openSessionLocked(join.PlayerID)
 	h.ensureHotbarStateLocked(join.PlayerID)
 	h.ensureInventoryStateLocked(join.PlayerID)
 	h.ensureHealthStateLocked(join.PlayerID)
@@ -537,25 +645,41 @@ func (h *worldHub) handleJoin(client *clientConn, join joinRuntimeRequest) {
 		"x": join.StartX,
 		"z": join.StartZ,
 	})
+	return ""
 }
 
-func (h *worldHub) handleLeave(playerID string) {
+// handleLeave removes a player the client controls and gives up the client's
+// control of it.
+func (h *worldHub) handleLeave(client *clientConn, playerID string) runtimeRequestAck {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	delete(client.playerIDs, playerID)
+	h.handleLeaveLocked(playerID)
+	return runtimeRequestAck{PlayerID: playerID, Accepted: true, Tick: h.tick}
+}
+
+func (h *worldHub) handleLeaveLocked(playerID string) {
+	h.cancelPlayerTradeLocked(playerID, "player_left")
+	h.parkPlayerLocked(playerID)
+	delete(h.sessions, playerID)
+	delete(h.openContainers, playerID)
 	delete(h.players, playerID)
 	delete(h.combatCooldownTick, playerID)
 	delete(h.hotbarStates, playerID)
 	delete(h.inventoryStates, playerID)
 	delete(h.healthStates, playerID)
+	h.pruneSeenActionsLocked()
 	h.recordWorldEventLocked("player_left", playerID, nil)
 }
 
-func (h *worldHub) handleInput(payload inputPayload) {
+func (h *worldHub) handleInput(payload inputPayload) runtimeRequestAck {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	ack := runtimeRequestAck{PlayerID: payload.PlayerID, Tick: h.tick}
 	player, ok := h.players[payload.PlayerID]
 	if !ok {
-		return
+		ack.Reason = "player_not_found"
+		return ack
 	}
 	player.Input = runtimeInputState{
 		MoveX:   sanitizeNumber(payload.Input.MoveX),
@@ -563,24 +687,112 @@ func (h *worldHub) handleInput(payload inputPayload) {
 		Running: payload.Input.Running,
 		Jump:    payload.Input.Jump,
 	}
+	ack.Accepted = true
+	return ack
 }
 
-func (h *worldHub) applyBlockAction(payload blockActionPayload) (runtimeBlockDelta, bool) {
+func (h *worldHub) applyBlockAction(payload blockActionPayload) (runtimeBlockActionResult, *runtimeBlockDelta) {
+	result := runtimeBlockActionResult{
+		ActionID:  payload.ActionID,
+		PlayerID:  payload.PlayerID,
+		Action:    payload.Action,
+		ChunkX:    payload.ChunkX,
+		ChunkZ:    payload.ChunkZ,
+		X:         payload.X,
+		Y:         payload.Y,
+		Z:         payload.Z,
+		BlockType: payload.BlockType,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "block_action", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "block_action", payload.ActionID, result.Accepted, result)
+	}()
+
+	if payload.PlayerID == "" {
+		result.Accepted = false
+		result.Reason = "invalid_payload"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
 	if payload.Action != "break" && payload.Action != "place" {
-		return runtimeBlockDelta{}, false
+		result.Accepted = false
+		result.Reason = "invalid_action"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
 	}
-	if payload.Y < 0 || payload.Y > 64 {
-		return runtimeBlockDelta{}, false
+	if !isValidBlockCoordinate(payload.X, payload.Y, payload.Z) {
+		result.Accepted = false
+		result.Reason = "invalid_coordinates"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
 	}
-	if payload.X < 0 || payload.X > 64 || payload.Z < 0 || payload.Z > 64 {
-		return runtimeBlockDelta{}, false
+	player, ok := h.players[payload.PlayerID]
+	if !ok {
+		result.Accepted = false
+		result.Reason = "player_not_found"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
+
+	blockX, blockZ := blockWorldPosition(payload.ChunkX, payload.ChunkZ, payload.X, payload.Z)
+	if h.blockReach > 0 && math.Hypot(blockX-player.X, blockZ-player.Z) > h.blockReach {
+		result.Accepted = false
+		result.Reason = "out_of_reach"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
+	if !h.blockLineOfSightClearLocked(player, payload) {
+		result.Accepted = false
+		result.Reason = "line_of_sight_blocked"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
+	cellX, cellZ := globalBlockCell(payload.ChunkX, payload.ChunkZ, payload.X, payload.Z)
+	if !h.canEditCellLocked(payload.PlayerID, cellX, cellZ) {
+		result.Accepted = false
+		result.Reason = "claim_protected"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
 	key := blockKey(payload.ChunkX, payload.ChunkZ, payload.X, payload.Y, payload.Z)
+	containerID := chestContainerID(payload.ChunkX, payload.ChunkZ, payload.X, payload.Y, payload.Z)
+	chest, hasChest := h.chests[containerID]
+	if hasChest && payload.Action == "place" {
+		result.Accepted = false
+		result.Reason = "container_exists"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
+	if hasChest && !h.chestAllowsPlayerLocked(chest, payload.PlayerID) {
+		result.Accepted = false
+		result.Reason = "container_forbidden"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
+	if payload.Action == "place" && payload.BlockType == chestBlockType && h.ownedChestCountLocked(payload.PlayerID) >= maxChestsPerPlayer {
+		result.Accepted = false
+		result.Reason = "chest_limit"
+		h.recordBlockActionRejectedLocked(result)
+		return result, nil
+	}
 
+	previousBlockType, hadBlock := h.placed[key]
 	if payload.Action == "break" {
+		if hasChest {
+			h.destroyChestLocked(containerID, payload.PlayerID)
+			result.ContainerID = containerID
+		}
+		if hadBlock && h.isStationLocked(previousBlockType) {
+			h.removeStationLocked(key, previousBlockType, payload.PlayerID)
+		}
 		delete(h.placed, key)
 		h.removed[key] = true
 		h.recordWorldEventLocked("block_broken", payload.PlayerID, map[string]any{
@@ -590,20 +802,41 @@ func (h *worldHub) applyBlockAction(payload blockActionPayload) (runtimeBlockDel
 			"y":      payload.Y,
 			"z":      payload.Z,
 		})
-		return runtimeBlockDelta{
+		result.Accepted = true
+		result.BlockType = ""
+		return result, &runtimeBlockDelta{
 			Action: "break",
 			ChunkX: payload.ChunkX,
 			ChunkZ: payload.ChunkZ,
 			X:      payload.X,
 			Y:      payload.Y,
 			Z:      payload.Z,
-		}, true
+		}
 	}
 
 	blockType := payload.BlockType
 	if blockType == "" {
 		blockType = "dirt"
 	}
+	if h.placesFromItemLocked(blockType) {
+		inventoryState := h.ensureInventoryStateLocked(payload.PlayerID)
+		if _, ok := takeItems(inventoryState.Slots, blockType, 1); !ok {
+			result.Accepted = false
+			result.Reason = "station_item_required"
+			if blockType == chestBlockType {
+				result.Reason = "chest_item_required"
+			}
+			h.recordBlockActionRejectedLocked(result)
+			return result, nil
+		}
+		h.syncInventoryLocked(&inventoryState)
+	}
+	if hadBlock && h.isStationLocked(previousBlockType) {
+		h.removeStationLocked(key, previousBlockType, payload.PlayerID)
+	}
+	if h.isStationLocked(blockType) {
+		h.indexStationLocked(key, blockType)
+	}
 	h.placed[key] = blockType
 	delete(h.removed, key)
 	h.recordWorldEventLocked("block_placed", payload.PlayerID, map[string]any{
@@ -614,7 +847,12 @@ func (h *worldHub) applyBlockAction(payload blockActionPayload) (runtimeBlockDel
 		"z":         payload.Z,
 		"blockType": blockType,
 	})
-	return runtimeBlockDelta{
+	if blockType == chestBlockType {
+		result.ContainerID = h.placeChestLocked(payload.PlayerID, payload).ContainerID
+	}
+	result.Accepted = true
+	result.BlockType = blockType
+	return result, &runtimeBlockDelta{
 		Action:    "place",
 		ChunkX:    payload.ChunkX,
 		ChunkZ:    payload.ChunkZ,
@@ -622,7 +860,74 @@ func (h *worldHub) applyBlockAction(payload blockActionPayload) (runtimeBlockDel
 		Y:         payload.Y,
 		Z:         payload.Z,
 		BlockType: blockType,
-	}, true
+	}
+}
+
+// blockLineOfSightClearLocked walks the horizontal segment between the player
+// and the target block and reports whether a placed block sits in between at
+// the target layer (or the layer above it). Terrain is generated client-side,
+// so only server-tracked placements can obstruct.
+func (h *worldHub) blockLineOfSightClearLocked(player *playerState, payload blockActionPayload) bool {
+	targetX, targetZ := blockWorldPosition(payload.ChunkX, payload.ChunkZ, payload.X, payload.Z)
+	distance := math.Hypot(targetX-player.X, targetZ-player.Z)
+	steps := int(math.Ceil(distance / (voxelBlockSize * 0.5)))
+	for step := 1; step < steps; step++ {
+		t := float64(step) / float64(steps)
+		chunkX, chunkZ, cellX, cellZ := blockCellAtWorld(lerp(player.X, targetX, t), lerp(player.Z, targetZ, t))
+		if chunkX == payload.ChunkX && chunkZ == payload.ChunkZ && cellX == payload.X && cellZ == payload.Z {
+			continue
+		}
+		for y := payload.Y; y <= payload.Y+1 && y <= maxBlockY; y++ {
+			if _, occupied := h.placed[blockKey(chunkX, chunkZ, cellX, y, cellZ)]; occupied {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (h *worldHub) recordBlockActionRejectedLocked(result runtimeBlockActionResult) {
+	payload := map[string]any{
+		"action": result.Action,
+		"chunkX": result.ChunkX,
+		"chunkZ": result.ChunkZ,
+		"x":      result.X,
+		"y":      result.Y,
+		"z":      result.Z,
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked("block_action_rejected", result.PlayerID, payload)
+}
+
+func isValidBlockCoordinate(x int, y int, z int) bool {
+	if x < 0 || x >= chunkGridCells || z < 0 || z >= chunkGridCells {
+		return false
+	}
+	return y >= 0 && y <= maxBlockY
+}
+
+func blockWorldPosition(chunkX int, chunkZ int, x int, z int) (float64, float64) {
+	halfChunk := worldChunkSize * 0.5
+	worldX := (float64(chunkX) * worldChunkSize) - halfChunk + ((float64(x) + 0.5) * voxelBlockSize)
+	worldZ := (float64(chunkZ) * worldChunkSize) - halfChunk + ((float64(z) + 0.5) * voxelBlockSize)
+	return worldX, worldZ
+}
+
+func blockCellAtWorld(worldX float64, worldZ float64) (int, int, int, int) {
+	halfChunk := worldChunkSize * 0.5
+	chunkX := int(math.Floor((worldX + halfChunk) / worldChunkSize))
+	chunkZ := int(math.Floor((worldZ + halfChunk) / worldChunkSize))
+	cellX := int(math.Floor((worldX - (float64(chunkX) * worldChunkSize) + halfChunk) / voxelBlockSize))
+	cellZ := int(math.Floor((worldZ - (float64(chunkZ) * worldChunkSize) + halfChunk) / voxelBlockSize))
+	if cellX >= chunkGridCells {
+		cellX = chunkGridCells - 1
+	}
+	if cellZ >= chunkGridCells {
+		cellZ = chunkGridCells - 1
+	}
+	return chunkX, chunkZ, cellX, cellZ
 }
 
 func (h *worldHub) applyCombatAction(payload combatActionPayload) (runtimeCombatResult, []runtimeHealthState, []runtimeInventoryState, []worldEvent) {
@@ -643,6 +948,13 @@ func (h *worldHub) applyCombatAction(payload combatActionPayload) (runtimeCombat
 	inventoryUpdates := make([]runtimeInventoryState, 0, 1)
 	worldEvents := make([]worldEvent, 0, 1)
 	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "combat_action", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil, nil, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "combat_action", payload.ActionID, result.Accepted, result)
+	}()
 
 	if payload.PlayerID == "" || payload.ActionID == "" || payload.SlotID == "" || payload.Kind == "" {
 		result.Accepted = false
@@ -659,7 +971,7 @@ func (h *worldHub) applyCombatAction(payload combatActionPayload) (runtimeCombat
 		return result, healthUpdates, inventoryUpdates, worldEvents
 	}
 
-	slotConfig, ok := combatSlotConfigs[payload.SlotID]
+	slotConfig, ok := h.content.combatSlots[payload.SlotID]
 	if !ok {
 		result.Accepted = false
 		result.Reason = "invalid_slot"
@@ -680,6 +992,18 @@ func (h *worldHub) applyCombatAction(payload combatActionPayload) (runtimeCombat
 		h.recordCombatEventLocked(result)
 		return result, healthUpdates, inventoryUpdates, worldEvents
 	}
+	if slotConfig.maxDurability > 0 && hotbarState.Items[slotIndex].isEmpty() {
+		result.Accepted = false
+		result.Reason = "insufficient_item"
+		h.recordCombatEventLocked(result)
+		return result, healthUpdates, inventoryUpdates, worldEvents
+	}
+	if slotConfig.maxDurability > 0 && slotDurability(hotbarState, payload.SlotID) <= 0 {
+		result.Accepted = false
+		result.Reason = "slot_broken"
+		h.recordCombatEventLocked(result)
+		return result, healthUpdates, inventoryUpdates, worldEvents
+	}
 	if slotConfig.requiresTarget {
 		resolvedX, resolvedZ, resolvedByServer := h.resolveTargetCoordinatesLocked(payload.PlayerID, result.TargetID)
 		switch {
@@ -749,9 +1073,12 @@ func (h *worldHub) applyCombatAction(payload combatActionPayload) (runtimeCombat
 			h.recordCombatEventLocked(result)
 			return result, healthUpdates, inventoryUpdates, worldEvents
 		}
-		hotbarState.StackCounts[slotIndex] = remaining - 1
-		hotbarState.Tick = h.tick
-		h.hotbarStates[payload.PlayerID] = cloneHotbarState(hotbarState)
+		hotbarState.Items[slotIndex].Quantity = remaining - 1
+		h.storeHotbarLocked(&hotbarState)
+	}
+	if slotConfig.maxDurability > 0 {
+		h.wearHotbarSlotLocked(&hotbarState, payload.SlotID)
+		h.storeHotbarLocked(&hotbarState)
 	}
 
 	playerCooldowns[payload.SlotID] = h.tick + slotConfig.cooldownTicks
@@ -803,6 +1130,16 @@ func (h *worldHub) applyCombatEffectsLocked(result runtimeCombatResult, slotConf
 					"source":  result.PlayerID,
 					"slotId":  result.SlotID,
 				})
+				if state.Current == 0 {
+					inventoryState, groundItemIDs := h.dropPlayerInventoryOnDeathLocked(result.TargetID)
+					inventoryUpdates = append(inventoryUpdates, inventoryState)
+					event := h.recordWorldEventLocked("player_defeated", result.TargetID, map[string]any{
+						"source":        result.PlayerID,
+						"slotId":        result.SlotID,
+						"groundItemIds": groundItemIDs,
+					})
+					worldEvents = append(worldEvents, event)
+				}
 			}
 		} else {
 			entityState, ok, defeatedNow := h.applyEntityDamageLocked(result.TargetID, slotConfig.damage)
@@ -852,6 +1189,13 @@ func (h *worldHub) applyInteractAction(payload interactActionPayload) runtimeInt
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "interact_action", payload.ActionID, &result) {
+		result.Replayed = true
+		return result
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "interact_action", payload.ActionID, result.Accepted, result)
+	}()
 
 	if payload.PlayerID == "" || payload.ActionID == "" {
 		result.Accepted = false
@@ -866,6 +1210,11 @@ func (h *worldHub) applyInteractAction(payload interactActionPayload) runtimeInt
 		return result
 	}
 
+	if isGroundItemID(result.TargetID) {
+		result = h.interactGroundItemLocked(result, player)
+		return result
+	}
+
 	if result.TargetID != "" {
 		resolvedX, resolvedZ, resolvedByServer := h.resolveTargetCoordinatesLocked(payload.PlayerID, result.TargetID)
 		if resolvedByServer {
@@ -927,16 +1276,24 @@ func (h *worldHub) resolveTargetCoordinatesLocked(actorPlayerID string, targetID
 	return x, z, true
 }
 
-func (h *worldHub) applyHotbarSelection(payload hotbarSelectPayload) (runtimeHotbarState, bool) {
+func (h *worldHub) applyHotbarSelection(payload hotbarSelectPayload) (runtimeHotbarResult, *runtimeHotbarState) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	result := runtimeHotbarResult{
+		PlayerID:  payload.PlayerID,
+		Operation: "select",
+		SlotIndex: payload.SlotIndex,
+		Tick:      h.tick,
+	}
 	if _, ok := h.players[payload.PlayerID]; !ok {
-		return runtimeHotbarState{}, false
+		result.Reason = "player_not_found"
+		return result, nil
 	}
 	state := h.ensureHotbarStateLocked(payload.PlayerID)
 	if payload.SlotIndex < 0 || payload.SlotIndex >= len(state.SlotIDs) {
-		return runtimeHotbarState{}, false
+		result.Reason = "invalid_slot"
+		return result, nil
 	}
 	state.SelectedIndex = payload.SlotIndex
 	state.Tick = h.tick
@@ -945,7 +1302,10 @@ func (h *worldHub) applyHotbarSelection(payload hotbarSelectPayload) (runtimeHot
 		"slotIndex": payload.SlotIndex,
 		"slotId":    state.SlotIDs[payload.SlotIndex],
 	})
-	return cloneHotbarState(state), true
+	result.SlotID = state.SlotIDs[payload.SlotIndex]
+	result.Accepted = true
+	hotbarCopy := cloneHotbarState(state)
+	return result, &hotbarCopy
 }
 
 func (h *worldHub) hotbarStateForPlayer(playerID string) (runtimeHotbarState, bool) {
@@ -964,26 +1324,25 @@ func (h *worldHub) ensureHotbarStateLocked(playerID string) runtimeHotbarState {
 	if !ok {
 		state = runtimeHotbarState{
 			PlayerID:      playerID,
-			SlotIDs:       append([]string{}, defaultHotbarSlotIDs...),
-			StackCounts:   buildDefaultHotbarStackCounts(defaultHotbarSlotIDs),
+			SlotIDs:       append([]string{}, h.content.defaultHotbarSlotIDs...),
+			StackCounts:   h.content.defaultHotbarStackCounts(h.content.defaultHotbarSlotIDs),
 			SelectedIndex: 0,
-			Tick:          h.tick,
 		}
-		h.hotbarStates[playerID] = cloneHotbarState(state)
+		h.storeHotbarLocked(&state)
 		return state
 	}
 
 	if len(state.SlotIDs) == 0 {
-		state.SlotIDs = append([]string{}, defaultHotbarSlotIDs...)
+		state.SlotIDs = append([]string{}, h.content.defaultHotbarSlotIDs...)
+		state.Items = nil
 	}
-	if len(state.StackCounts) != len(state.SlotIDs) {
-		state.StackCounts = buildDefaultHotbarStackCounts(state.SlotIDs)
+	if len(state.Items) != len(state.SlotIDs) && len(state.StackCounts) != len(state.SlotIDs) {
+		state.StackCounts = h.content.defaultHotbarStackCounts(state.SlotIDs)
 	}
 	if state.SelectedIndex < 0 || state.SelectedIndex >= len(state.SlotIDs) {
 		state.SelectedIndex = 0
 	}
-	state.Tick = h.tick
-	h.hotbarStates[playerID] = cloneHotbarState(state)
+	h.storeHotbarLocked(&state)
 	return state
 }
 
@@ -992,6 +1351,8 @@ func cloneHotbarState(state runtimeHotbarState) runtimeHotbarState {
 		PlayerID:      state.PlayerID,
 		SlotIDs:       append([]string{}, state.SlotIDs...),
 		StackCounts:   append([]int{}, state.StackCounts...),
+		Items:         cloneItemStacks(state.Items),
+		Unlocked:      append([]string(nil), state.Unlocked...),
 		SelectedIndex: state.SelectedIndex,
 		Tick:          state.Tick,
 	}
@@ -1006,25 +1367,6 @@ func hotbarSlotIndex(state runtimeHotbarState, slotID string) int {
 	return -1
 }
 
-func buildDefaultHotbarStackCounts(slotIDs []string) []int {
-	counts := make([]int, len(slotIDs))
-	for index, slotID := range slotIDs {
-		counts[index] = defaultStackCountForSlot(slotID)
-	}
-	return counts
-}
-
-func defaultStackCountForSlot(slotID string) int {
-	switch slotID {
-	case "slot-4-bandage":
-		return 3
-	case "slot-5-bomb":
-		return 2
-	default:
-		return 0
-	}
-}
-
 func (h *worldHub) inventoryStateForPlayer(playerID string) (runtimeInventoryState, bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -1051,16 +1393,11 @@ func (h *worldHub) ensureInventoryStateLocked(playerID string) runtimeInventoryS
 	state, ok := h.inventoryStates[playerID]
 	if !ok {
 		state = runtimeInventoryState{
-			PlayerID:  playerID,
-			Resources: buildDefaultResourceMap(),
-			Tick:      h.tick,
+			PlayerID: playerID,
+			Slots:    make([]runtimeItemStack, playerInventoryCapacity),
 		}
-		h.inventoryStates[playerID] = cloneInventoryState(state)
-		return state
 	}
-	state.Resources = normalizeResourceMap(state.Resources)
-	state.Tick = h.tick
-	h.inventoryStates[playerID] = cloneInventoryState(state)
+	h.syncInventoryLocked(&state)
 	return state
 }
 
@@ -1189,12 +1526,12 @@ func (h *worldHub) awardInventoryResource(playerID string, resource string, amou
 		return runtimeInventoryState{}, false
 	}
 	state := h.ensureInventoryStateLocked(playerID)
-	state.Resources[resource] = state.Resources[resource] + amount
-	state.Tick = h.tick
-	h.inventoryStates[playerID] = cloneInventoryState(state)
+	added := h.grantItemsLocked(state.Slots, resource, amount, "award")
+	h.syncInventoryLocked(&state)
+	h.dropInventoryOverflowLocked(playerID, resource, amount-added)
 	h.recordWorldEventLocked("inventory_updated", playerID, map[string]any{
 		"resource": resource,
-		"amount":   amount,
+		"amount":   added,
 		"total":    state.Resources[resource],
 	})
 	return cloneInventoryState(state), true
@@ -1213,19 +1550,19 @@ func (h *worldHub) awardInventoryResources(playerID string, grants map[string]in
 		if resource == "" || amount <= 0 {
 			continue
 		}
-		state.Resources[resource] = state.Resources[resource] + amount
+		added := h.grantItemsLocked(state.Slots, resource, amount, "award")
+		h.dropInventoryOverflowLocked(playerID, resource, amount-added)
 		changed = true
 		h.recordWorldEventLocked("inventory_updated", playerID, map[string]any{
 			"resource": resource,
-			"amount":   amount,
-			"total":    state.Resources[resource],
+			"amount":   added,
+			"total":    countItems(state.Slots, resource),
 		})
 	}
 	if !changed {
 		return runtimeInventoryState{}, false
 	}
-	state.Tick = h.tick
-	h.inventoryStates[playerID] = cloneInventoryState(state)
+	h.syncInventoryLocked(&state)
 	return cloneInventoryState(state), true
 }
 
@@ -1239,14 +1576,14 @@ func (h *worldHub) awardInventoryResourcesLocked(playerID string, grants map[str
 		if resource == "" || amount <= 0 {
 			continue
 		}
-		state.Resources[resource] = state.Resources[resource] + amount
+		added := h.grantItemsLocked(state.Slots, resource, amount, "loot")
+		h.dropInventoryOverflowLocked(playerID, resource, amount-added)
 		changed = true
 	}
 	if !changed {
 		return runtimeInventoryState{}, false
 	}
-	state.Tick = h.tick
-	h.inventoryStates[playerID] = cloneInventoryState(state)
+	h.syncInventoryLocked(&state)
 	return cloneInventoryState(state), true
 }
 
@@ -1263,6 +1600,13 @@ func (h *worldHub) applyCraftRequest(
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "craft_request", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "craft_request", payload.ActionID, result.Accepted, result)
+	}()
 
 	if payload.PlayerID == "" || payload.ActionID == "" || payload.RecipeID == "" || payload.Count <= 0 {
 		result.Accepted = false
@@ -1277,18 +1621,50 @@ func (h *worldHub) applyCraftRequest(
 		return result, nil, nil
 	}
 
-	recipe, ok := craftRecipeConfigs[payload.RecipeID]
+	recipe, ok := h.content.recipes[payload.RecipeID]
 	if !ok {
 		result.Accepted = false
 		result.Reason = "invalid_recipe"
 		h.recordCraftEventLocked(result)
 		return result, nil, nil
 	}
+	if recipe.station != "" && !h.stationNearPlayerLocked(payload.PlayerID, recipe.station) {
+		result.Accepted = false
+		result.Reason = "station_required"
+		h.recordCraftEventLocked(result)
+		return result, nil, nil
+	}
+	if recipe.durationTicks > 0 {
+		queue := h.craftQueues[payload.PlayerID]
+		if len(queue) >= maxCraftQueueLength {
+			result.Accepted = false
+			result.Reason = "craft_queue_full"
+			h.recordCraftEventLocked(result)
+			return result, nil, nil
+		}
+		for _, job := range queue {
+			if job.JobID == payload.ActionID {
+				result.Accepted = false
+				result.Reason = "craft_job_exists"
+				h.recordCraftEventLocked(result)
+				return result, nil, nil
+			}
+		}
+	}
+
+	if recipe.output.repairSlotID != "" {
+		if reason := repairCountProblem(h.ensureHotbarStateLocked(payload.PlayerID), recipe, payload.Count); reason != "" {
+			result.Accepted = false
+			result.Reason = reason
+			h.recordCraftEventLocked(result)
+			return result, nil, nil
+		}
+	}
 
 	inventoryState := h.ensureInventoryStateLocked(payload.PlayerID)
 	for _, ingredient := range recipe.ingredients {
 		requiredTotal := ingredient.amount * payload.Count
-		if inventoryState.Resources[ingredient.resourceID] < requiredTotal {
+		if countItems(inventoryState.Slots, ingredient.resourceID) < requiredTotal {
 			result.Accepted = false
 			result.Reason = "insufficient_resources"
 			h.recordCraftEventLocked(result)
@@ -1296,40 +1672,76 @@ func (h *worldHub) applyCraftRequest(
 		}
 	}
 
+	// Outputs bound to an unequipped hotbar slot land in the inventory.
 	var hotbarState runtimeHotbarState
-	var outputSlotIndex int
+	outputSlotIndex := -1
 	if recipe.output.targetSlotID != "" {
 		hotbarState = h.ensureHotbarStateLocked(payload.PlayerID)
 		outputSlotIndex = hotbarSlotIndex(hotbarState, recipe.output.targetSlotID)
-		if outputSlotIndex < 0 {
+	}
+
+	outputAmount := recipe.output.amount * payload.Count
+	remainingSlots := cloneItemStacks(inventoryState.Slots)
+	for _, ingredient := range recipe.ingredients {
+		removeItems(remainingSlots, ingredient.resourceID, ingredient.amount*payload.Count)
+	}
+	if recipe.durationTicks <= 0 {
+		outputFits := true
+		if outputSlotIndex >= 0 {
+			candidate := cloneHotbarState(hotbarState)
+			outputFits = h.addToHotbarSlotLocked(&candidate, outputSlotIndex, outputAmount)
+		} else if outputItemID := recipe.output.itemID(); outputItemID != "" {
+			outputFits = h.content.canAddItems(remainingSlots, outputItemID, outputAmount)
+		}
+		if !outputFits {
 			result.Accepted = false
-			result.Reason = "craft_target_slot_missing"
+			result.Reason = "inventory_full"
 			h.recordCraftEventLocked(result)
 			return result, nil, nil
 		}
 	}
 
-	for _, ingredient := range recipe.ingredients {
-		requiredTotal := ingredient.amount * payload.Count
-		inventoryState.Resources[ingredient.resourceID] = inventoryState.Resources[ingredient.resourceID] - requiredTotal
-	}
-
-	inventoryState.Tick = h.tick
-	h.inventoryStates[payload.PlayerID] = cloneInventoryState(inventoryState)
+	inventoryState.Slots = remainingSlots
+	h.syncInventoryLocked(&inventoryState)
 
 	inventoryCopy := cloneInventoryState(inventoryState)
+	if recipe.durationTicks > 0 {
+		escrow := make(map[string]int, len(recipe.ingredients))
+		for _, ingredient := range recipe.ingredients {
+			escrow[ingredient.resourceID] = escrow[ingredient.resourceID] + (ingredient.amount * payload.Count)
+		}
+		h.enqueueCraftJobLocked(payload.PlayerID, runtimeCraftJob{
+			JobID:         payload.ActionID,
+			RecipeID:      payload.RecipeID,
+			Count:         payload.Count,
+			Station:       recipe.station,
+			DurationTicks: recipe.durationTicks * int64(payload.Count),
+			Ingredients:   escrow,
+			QueuedTick:    h.tick,
+		})
+		result.Accepted = true
+		result.Queued = true
+		result.JobID = payload.ActionID
+		h.recordCraftEventLocked(result)
+		return result, &inventoryCopy, nil
+	}
+
 	var hotbarCopy *runtimeHotbarState
 
-	if recipe.output.targetSlotID != "" {
-		hotbarState.StackCounts[outputSlotIndex] = hotbarState.StackCounts[outputSlotIndex] + (recipe.output.amount * payload.Count)
-		hotbarState.Tick = h.tick
-		h.hotbarStates[payload.PlayerID] = cloneHotbarState(hotbarState)
+	if recipe.output.repairSlotID != "" {
+		hotbarState = h.ensureHotbarStateLocked(payload.PlayerID)
+		h.repairHotbarSlotLocked(&hotbarState, recipe.output.repairSlotID, outputAmount)
+		h.storeHotbarLocked(&hotbarState)
+		hotbarSnapshot := cloneHotbarState(hotbarState)
+		hotbarCopy = &hotbarSnapshot
+	} else if outputSlotIndex >= 0 {
+		h.addToHotbarSlotLocked(&hotbarState, outputSlotIndex, outputAmount)
+		h.storeHotbarLocked(&hotbarState)
 		hotbarSnapshot := cloneHotbarState(hotbarState)
 		hotbarCopy = &hotbarSnapshot
-	} else if recipe.output.resourceID != "" {
-		inventoryState.Resources[recipe.output.resourceID] = inventoryState.Resources[recipe.output.resourceID] + (recipe.output.amount * payload.Count)
-		inventoryState.Tick = h.tick
-		h.inventoryStates[payload.PlayerID] = cloneInventoryState(inventoryState)
+	} else if outputItemID := recipe.output.itemID(); outputItemID != "" {
+		h.grantItemsLocked(inventoryState.Slots, outputItemID, outputAmount, "craft:"+recipe.id)
+		h.syncInventoryLocked(&inventoryState)
 		inventoryCopy = cloneInventoryState(inventoryState)
 	}
 
@@ -1345,6 +1757,8 @@ func cloneInventoryState(state runtimeInventoryState) runtimeInventoryState {
 	}
 	return runtimeInventoryState{
 		PlayerID:  state.PlayerID,
+		Slots:     cloneItemStacks(state.Slots),
+		Capacity:  state.Capacity,
 		Resources: resources,
 		Tick:      state.Tick,
 	}
@@ -1359,24 +1773,6 @@ func cloneHealthState(state runtimeHealthState) runtimeHealthState {
 	}
 }
 
-func buildDefaultResourceMap() map[string]int {
-	resources := make(map[string]int, len(runtimeResourceIDs))
-	for _, resourceID := range runtimeResourceIDs {
-		resources[resourceID] = 0
-	}
-	return resources
-}
-
-func normalizeResourceMap(resources map[string]int) map[string]int {
-	normalized := buildDefaultResourceMap()
-	for resourceID, value := range resources {
-		if _, exists := normalized[resourceID]; exists {
-			normalized[resourceID] = value
-		}
-	}
-	return normalized
-}
-
 func cloneResourceMap(resources map[string]int) map[string]int {
 	cloned := make(map[string]int, len(resources))
 	for key, value := range resources {
@@ -1401,15 +1797,10 @@ func (h *worldHub) ensureContainerStateLocked(containerID string) runtimeContain
 	if !ok {
 		state = runtimeContainerState{
 			ContainerID: containerID,
-			Resources:   buildDefaultResourceMap(),
-			Tick:        h.tick,
+			Slots:       make([]runtimeItemStack, containerCapacity(containerID)),
 		}
-		h.containerStates[containerID] = cloneContainerState(state)
-		return state
 	}
-	state.Resources = normalizeResourceMap(state.Resources)
-	state.Tick = h.tick
-	h.containerStates[containerID] = cloneContainerState(state)
+	h.syncContainerLocked(&state)
 	return state
 }
 
@@ -1418,11 +1809,27 @@ func cloneContainerState(state runtimeContainerState) runtimeContainerState {
 	for key, value := range state.Resources {
 		resources[key] = value
 	}
-	return runtimeContainerState{
+	cloned := runtimeContainerState{
 		ContainerID: state.ContainerID,
+		Slots:       cloneItemStacks(state.Slots),
+		Capacity:    state.Capacity,
 		Resources:   resources,
 		Tick:        state.Tick,
 	}
+	if state.Chest != nil {
+		chest := cloneChest(*state.Chest)
+		cloned.Chest = &chest
+	}
+	return cloned
+}
+
+// containerAnchor returns the world position a container lives at, if any.
+// Private stashes are not placed in the world.
+func containerAnchor(containerID string) (float64, float64, bool) {
+	if containerID == worldSharedContainerID {
+		return campAnchorX, campAnchorZ, true
+	}
+	return 0, 0, false
 }
 
 func playerPrivateContainerID(playerID string) string {
@@ -1457,6 +1864,13 @@ func (h *worldHub) applyContainerAction(
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	result.Tick = h.tick
+	if h.replaySeenActionLocked(payload.PlayerID, "container_action", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, nil, nil
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "container_action", payload.ActionID, result.Accepted, result)
+	}()
 
 	if payload.PlayerID == "" || payload.ActionID == "" || payload.ContainerID == "" || payload.ResourceID == "" || payload.Amount <= 0 {
 		result.Accepted = false
@@ -1470,9 +1884,9 @@ func (h *worldHub) applyContainerAction(
 		h.recordContainerEventLocked(result)
 		return result, nil, nil
 	}
-	if !canAccessContainer(payload.PlayerID, payload.ContainerID) {
+	if reason := h.containerAccessReasonLocked(payload.PlayerID, payload.ContainerID); reason != "" {
 		result.Accepted = false
-		result.Reason = "container_forbidden"
+		result.Reason = reason
 		h.recordContainerEventLocked(result)
 		return result, nil, nil
 	}
@@ -1486,33 +1900,36 @@ func (h *worldHub) applyContainerAction(
 	inventoryState := h.ensureInventoryStateLocked(payload.PlayerID)
 	containerState := h.ensureContainerStateLocked(payload.ContainerID)
 
-	playerAmount := inventoryState.Resources[payload.ResourceID]
-	containerAmount := containerState.Resources[payload.ResourceID]
-
 	if payload.Operation == "deposit" {
-		if playerAmount < payload.Amount {
+		if !removeItems(inventoryState.Slots, payload.ResourceID, payload.Amount) {
 			result.Accepted = false
 			result.Reason = "insufficient_resources"
 			h.recordContainerEventLocked(result)
 			return result, nil, nil
 		}
-		inventoryState.Resources[payload.ResourceID] = playerAmount - payload.Amount
-		containerState.Resources[payload.ResourceID] = containerAmount + payload.Amount
+		if h.content.addItems(containerState.Slots, payload.ResourceID, payload.Amount) != payload.Amount {
+			result.Accepted = false
+			result.Reason = "container_full"
+			h.recordContainerEventLocked(result)
+			return result, nil, nil
+		}
 	} else {
-		if containerAmount < payload.Amount {
+		if !removeItems(containerState.Slots, payload.ResourceID, payload.Amount) {
 			result.Accepted = false
 			result.Reason = "container_insufficient_resources"
 			h.recordContainerEventLocked(result)
 			return result, nil, nil
 		}
-		inventoryState.Resources[payload.ResourceID] = playerAmount + payload.Amount
-		containerState.Resources[payload.ResourceID] = containerAmount - payload.Amount
+		if h.content.addItems(inventoryState.Slots, payload.ResourceID, payload.Amount) != payload.Amount {
+			result.Accepted = false
+			result.Reason = "inventory_full"
+			h.recordContainerEventLocked(result)
+			return result, nil, nil
+		}
 	}
 
-	inventoryState.Tick = h.tick
-	containerState.Tick = h.tick
-	h.inventoryStates[payload.PlayerID] = cloneInventoryState(inventoryState)
-	h.containerStates[payload.ContainerID] = cloneContainerState(containerState)
+	h.syncInventoryLocked(&inventoryState)
+	h.syncContainerLocked(&containerState)
 	result.Accepted = true
 	h.recordContainerEventLocked(result)
 
@@ -1556,6 +1973,10 @@ func (h *worldHub) recordCraftEventLocked(result runtimeCraftResult) {
 		"recipeId": result.RecipeID,
 		"count":    result.Count,
 	}
+	if result.Queued {
+		eventType = "craft_queued"
+		payload["jobId"] = result.JobID
+	}
 	if result.Reason != "" {
 		payload["reason"] = result.Reason
 	}
@@ -1834,6 +2255,10 @@ func (h *worldHub) applyDirectiveBudgetLocked() bool {
 				}
 				h.spawnHints[hintID] = nextEntry
 			}
+		case "land_claim":
+			if h.applyClaimDirectiveLocked(directive) {
+				h.claimsChanged = true
+			}
 		}
 
 		h.recordWorldEventLocked("directive_applied", "openclaw", map[string]any{
@@ -1866,13 +2291,20 @@ func (h *worldHub) snapshotLocked() worldRuntimeSnapshot {
 	}
 
 	return worldRuntimeSnapshot{
-		WorldSeed: h.worldSeed,
-		Tick:      h.tick,
-		Players:   players,
+		WorldSeed:   h.worldSeed,
+		Tick:        h.tick,
+		Players:     players,
+		GroundItems: h.groundItemsNearLocked(nil, 0),
 	}
 }
 
 func (h *worldHub) snapshotForClientLocked(client *clientConn, radius float64) worldRuntimeSnapshot {
+	snapshot := h.fullSnapshotForClientLocked(client, radius)
+	snapshot.GroundItems, snapshot.GroundItemsRemoved = groundItemDelta(client, snapshot.GroundItems)
+	return snapshot
+}
+
+func (h *worldHub) fullSnapshotForClientLocked(client *clientConn, radius float64) worldRuntimeSnapshot {
 	players := make(map[string]runtimePlayerSnapshot)
 	if len(h.players) == 0 {
 		return worldRuntimeSnapshot{
@@ -1909,9 +2341,10 @@ func (h *worldHub) snapshotForClientLocked(client *clientConn, radius float64) w
 	}
 
 	return worldRuntimeSnapshot{
-		WorldSeed: h.worldSeed,
-		Tick:      h.tick,
-		Players:   players,
+		WorldSeed:   h.worldSeed,
+		Tick:        h.tick,
+		Players:     players,
+		GroundItems: h.groundItemsNearLocked(anchors, radius),
 	}
 }
 
@@ -1930,14 +2363,18 @@ func (h *worldHub) runtimePlayerSnapshotFromStateLocked(state *playerState) runt
 		X:        state.X,
 		Z:        state.Z,
 		Speed:    speed,
+		Away:     h.playerAwayLocked(state.PlayerID),
 	}
 }
 
 func (h *worldHub) advanceOneTick() bool {
+	timer := startPhaseTimer()
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.pendingTrace.LockWaitMs = timer.lap()
 
 	h.tick++
+	h.publishedTick.Store(h.tick)
 	deltaSeconds := 1.0 / h.tickRateHz
 	for _, state := range h.players {
 		moveX, moveZ := normalize(state.Input.MoveX, state.Input.MoveZ)
@@ -1948,10 +2385,20 @@ func (h *worldHub) advanceOneTick() bool {
 		state.X += moveX * speed * deltaSeconds
 		state.Z += moveZ * speed * deltaSeconds
 	}
+	h.pendingTrace.MovementMs = timer.lap()
+	h.advanceGroundItemsLocked()
+	h.advanceCraftQueuesLocked()
+	h.revalidateContainerSubscriptionsLocked()
+	h.expireDetachedSessionsLocked()
+	h.expirePendingTradesLocked()
+	h.pendingTrace.SimulationMs = timer.lap()
 	stateChanged := h.pruneExpiredSpawnHintsLocked()
 	if h.applyDirectiveBudgetLocked() {
 		stateChanged = true
 	}
+	h.pendingTrace.DirectivesMs = timer.lap()
+	h.pendingTrace.Tick = h.tick
+	h.pendingTrace.Players = len(h.players)
 	return stateChanged
 }
 
@@ -2069,12 +2516,7 @@ func (h *worldHub) exportState() worldDebugState {
 	sort.Strings(inventoryPlayerIDs)
 	inventoryStates := make([]runtimeInventoryState, 0, len(inventoryPlayerIDs))
 	for _, playerID := range inventoryPlayerIDs {
-		state := h.inventoryStates[playerID]
-		inventoryStates = append(inventoryStates, runtimeInventoryState{
-			PlayerID:  state.PlayerID,
-			Resources: cloneResourceMap(state.Resources),
-			Tick:      state.Tick,
-		})
+		inventoryStates = append(inventoryStates, cloneInventoryState(h.inventoryStates[playerID]))
 	}
 
 	healthPlayerIDs := make([]string, 0, len(h.healthStates))
@@ -2126,6 +2568,9 @@ func (h *worldHub) exportState() worldDebugState {
 		})
 	}
 
+	claims := h.claimStateLocked().Claims
+	craftQueues := h.craftQueueStatesLocked()
+
 	flags := make(map[string]string, len(h.worldFlags))
 	for key, value := range h.worldFlags {
 		flags[key] = value
@@ -2158,6 +2603,17 @@ func (h *worldHub) exportState() worldDebugState {
 		HealthStates:    healthStates,
 		EntityHealth:    entityHealth,
 		ContainerStates: containerStates,
+		Claims:          claims,
+		CraftQueues:     craftQueues,
+		ItemSeq:         h.itemSeq,
+		GroundItems:     h.exportGroundItemsLocked(),
+		GroundItemSeq:   h.groundItemSeq,
+		Trades:          h.exportTradesLocked(),
+		TradeSeq:        h.tradeSeq,
+		Chests:          h.exportChestsLocked(),
+		SeenActions:     h.exportSeenActionsLocked(),
+		DepartedPlayers: h.exportDepartedPlayersLocked(),
+		ResumeTokens:    h.exportResumeTokensLocked(),
 		WorldFlags: runtimeWorldFlagState{
 			Flags: flags,
 			Tick:  h.tick,
@@ -2181,6 +2637,9 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	// Hotbars below may mint equipment instances, which must not collide with
+	// the imported ones.
+	h.itemSeq = max(state.ItemSeq, 0)
 
 	nextPlayers := make(map[string]*playerState, len(state.Snapshot.Players))
 	for playerID, snapshot := range state.Snapshot.Players {
@@ -2202,7 +2661,7 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 		if delta.Action != "place" && delta.Action != "break" {
 			continue
 		}
-		if delta.X < 0 || delta.X > 64 || delta.Z < 0 || delta.Z > 64 || delta.Y < 0 || delta.Y > 64 {
+		if !isValidBlockCoordinate(delta.X, delta.Y, delta.Z) {
 			continue
 		}
 		key := blockKey(delta.ChunkX, delta.ChunkZ, delta.X, delta.Y, delta.Z)
@@ -2227,11 +2686,11 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 		}
 		slotIDs := append([]string{}, hotbarState.SlotIDs...)
 		if len(slotIDs) == 0 {
-			slotIDs = append([]string{}, defaultHotbarSlotIDs...)
+			slotIDs = append([]string{}, h.content.defaultHotbarSlotIDs...)
 		}
 		stackCounts := append([]int{}, hotbarState.StackCounts...)
-		if len(stackCounts) != len(slotIDs) {
-			stackCounts = buildDefaultHotbarStackCounts(slotIDs)
+		if len(stackCounts) != len(slotIDs) && len(hotbarState.Items) != len(slotIDs) {
+			stackCounts = h.content.defaultHotbarStackCounts(slotIDs)
 		}
 		selectedIndex := hotbarState.SelectedIndex
 		if selectedIndex < 0 || selectedIndex >= len(slotIDs) {
@@ -2241,13 +2700,18 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 		if tick < 0 {
 			tick = state.Snapshot.Tick
 		}
-		nextHotbar[playerID] = runtimeHotbarState{
+		items := cloneItemStacks(hotbarState.Items)
+		if len(hotbarState.SlotIDs) == 0 {
+			items = nil
+		}
+		nextHotbar[playerID] = h.normalizeHotbarItemsLocked(runtimeHotbarState{
 			PlayerID:      playerID,
 			SlotIDs:       slotIDs,
 			StackCounts:   stackCounts,
+			Items:         items,
 			SelectedIndex: selectedIndex,
 			Tick:          tick,
-		}
+		})
 	}
 
 	nextInventory := make(map[string]runtimeInventoryState, len(state.InventoryStates))
@@ -2260,9 +2724,15 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 		if tick < 0 {
 			tick = state.Snapshot.Tick
 		}
+		slots := h.content.slotsFromResources(inventoryState.Resources, playerInventoryCapacity)
+		if len(inventoryState.Slots) > 0 {
+			slots = h.content.normalizeItemSlots(inventoryState.Slots, playerInventoryCapacity)
+		}
 		nextInventory[playerID] = runtimeInventoryState{
 			PlayerID:  playerID,
-			Resources: normalizeResourceMap(inventoryState.Resources),
+			Slots:     slots,
+			Capacity:  playerInventoryCapacity,
+			Resources: h.content.projectResources(slots),
 			Tick:      tick,
 		}
 	}
@@ -2349,13 +2819,23 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 		if tick < 0 {
 			tick = state.Snapshot.Tick
 		}
+		capacity := containerCapacity(containerID)
+		slots := h.content.slotsFromResources(containerState.Resources, capacity)
+		if len(containerState.Slots) > 0 {
+			slots = h.content.normalizeItemSlots(containerState.Slots, capacity)
+		}
 		nextContainers[containerID] = runtimeContainerState{
 			ContainerID: containerID,
-			Resources:   normalizeResourceMap(containerState.Resources),
+			Slots:       slots,
+			Capacity:    capacity,
+			Resources:   h.content.projectResources(slots),
 			Tick:        tick,
 		}
 	}
 
+	nextClaims := importLandClaims(state.Claims, state.Snapshot.Tick)
+	nextCraftQueues := importCraftQueues(state.CraftQueues, h.content)
+
 	nextWorldFlags := make(map[string]string, len(state.WorldFlags.Flags))
 	for key, value := range state.WorldFlags.Flags {
 		cleanKey := strings.TrimSpace(key)
@@ -2400,8 +2880,10 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 
 	h.worldSeed = worldSeed
 	h.tick = state.Snapshot.Tick
+	h.publishedTick.Store(h.tick)
 	h.players = nextPlayers
 	h.placed = nextPlaced
+	h.reindexStationsLocked()
 	h.removed = nextRemoved
 	h.combatCooldownTick = make(map[string]map[string]int64)
 	h.hotbarStates = nextHotbar
@@ -2409,6 +2891,28 @@ func (h *worldHub) importState(state worldDebugState) (debugLoadStateAck, error)
 	h.healthStates = nextHealth
 	h.entityHealth = nextEntityHealth
 	h.containerStates = nextContainers
+	h.claims = nextClaims
+	h.craftQueues = nextCraftQueues
+	h.craftProgressOutbox = nil
+	h.groundItems = importGroundItems(state.GroundItems, h.content)
+	h.groundItemSeq = max(state.GroundItemSeq, 0)
+	h.groundPickupOutbox = nil
+	h.trades = importTrades(state.Trades)
+	h.tradeSeq = max(state.TradeSeq, 0)
+	h.tradeOutbox = nil
+	h.chests = importChests(state.Chests)
+	h.openContainers = make(map[string]string)
+	h.containerCloseOutbox = nil
+	h.chestRemovalOutbox = nil
+	h.seenActions = importSeenActions(state.SeenActions)
+	for playerID := range h.sessions {
+		if _, ok := h.players[playerID]; !ok {
+			delete(h.sessions, playerID)
+		}
+	}
+	h.lingerUncontrolledPlayersLocked(importResumeTokens(state.ResumeTokens))
+	h.departedPlayers = h.importDepartedPlayersLocked(state.DepartedPlayers)
+	h.pruneSeenActionsLocked()
 	h.worldFlags = nextWorldFlags
 	h.storyBeats = nextStoryBeats
 	h.spawnHints = nextSpawnHints
@@ -2944,8 +3448,19 @@ func resolveNpcWanderOffset(targetID string, tick int64, tickRateHz float64) (fl
 	return math.Cos(angle+phaseA) * radius, math.Sin(angle*sway+phaseB) * radius * 0.7
 }
 
+// broadcast sends an envelope to every client, encoded once. Each broadcast
+// takes the next sequence number in the same critical section that picks the
+// recipients and buffers it for detached sessions, so a resume can tell which
+// buffered broadcasts its connection already received live.
 func (h *worldHub) broadcast(envelope serverEnvelope) {
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		h.logger.Error("broadcast encode failed", "messageType", envelope.Type, "err", err)
+		return
+	}
 	h.mu.Lock()
+	h.broadcastSeq++
+	h.bufferMissedBroadcastLocked(envelope.Type, h.broadcastSeq, encoded)
 	clients := make([]*clientConn, 0, len(h.clients))
 	for client := range h.clients {
 		clients = append(clients, client)
@@ -2953,20 +3468,20 @@ func (h *worldHub) broadcast(envelope serverEnvelope) {
 	h.mu.Unlock()
 
 	for _, client := range clients {
-		if err := client.writeJSON(envelope); err != nil {
-			log.Printf("world-server: write error: %v", err)
-			_ = client.conn.Close()
-			h.removeClient(client)
+		if err := client.writeEncoded(encoded); err != nil {
+			h.dropClientAfterWriteError(client, envelope.Type, err)
 		}
 	}
 }
 
 func (h *worldHub) broadcastSnapshots(radius float64) {
+	timer := startPhaseTimer()
 	h.mu.Lock()
 	snapshots := make(map[*clientConn]worldRuntimeSnapshot, len(h.clients))
 	for client := range h.clients {
 		snapshots[client] = h.snapshotForClientLocked(client, radius)
 	}
+	h.pendingTrace.SnapshotBuildMs = timer.lap()
 	h.mu.Unlock()
 
 	for client, snapshot := range snapshots {
@@ -2977,13 +3492,22 @@ func (h *worldHub) broadcastSnapshots(radius float64) {
 	}
 }
 
-func (h *worldHub) broadcastCombatResult(result runtimeCombatResult) {
+// broadcastCombatResult replicates a combat result to the actor and nearby
+// players. requestID belongs to the sender's message, so only the sender's
+// copy echoes it.
+func (h *worldHub) broadcastCombatResult(sender *clientConn, result runtimeCombatResult, requestID string) {
 	recipients := h.selectCombatRecipients(result.PlayerID, combatReplicationRadius)
 	envelope := serverEnvelope{
 		Type:    "combat_result",
 		Payload: result,
 	}
 	for _, client := range recipients {
+		if client == sender {
+			reply := envelope
+			reply.RequestID = requestID
+			h.sendToClient(client, reply)
+			continue
+		}
 		h.sendToClient(client, envelope)
 	}
 }
@@ -3076,44 +3600,102 @@ func (h *worldHub) selectPlayerOwnedRecipients(playerID string) []*clientConn {
 }
 
 func (h *worldHub) sendToPlayerOwnedRecipients(playerID string, envelope serverEnvelope) {
+	h.bufferMissedEnvelope(playerID, envelope)
 	recipients := h.selectPlayerOwnedRecipients(playerID)
 	for _, client := range recipients {
 		h.sendToClient(client, envelope)
 	}
 }
 
-func (h *worldHub) sendToClient(client *clientConn, envelope serverEnvelope) {
-	if err := client.writeJSON(envelope); err != nil {
-		log.Printf("world-server: client write error: %v", err)
-		_ = client.conn.Close()
-		h.removeClient(client)
+// sendToPlayersOwnedRecipients sends one envelope to the clients of several
+// players, once per client.
+func (h *worldHub) sendToPlayersOwnedRecipients(playerIDs []string, envelope serverEnvelope) {
+	sent := make(map[*clientConn]struct{}, len(playerIDs))
+	for _, playerID := range playerIDs {
+		h.bufferMissedEnvelope(playerID, envelope)
+		for _, client := range h.selectPlayerOwnedRecipients(playerID) {
+			if _, ok := sent[client]; ok {
+				continue
+			}
+			sent[client] = struct{}{}
+			h.sendToClient(client, envelope)
+		}
+	}
+}
+
+// publishContainerState sends private stashes to their owner. Every other
+// container goes to players who have it open, plus actorID (the player whose
+// action changed it) when set.
+func (h *worldHub) publishContainerState(state runtimeContainerState, actorID string) {
+	envelope := serverEnvelope{
+		Type:    "container_state",
+		Payload: state,
+	}
+	if ownerPlayerID, isPrivate := privateContainerOwner(state.ContainerID); isPrivate {
+		h.sendToPlayerOwnedRecipients(ownerPlayerID, envelope)
+		return
+	}
+	for _, client := range h.selectContainerSubscribers(state.ContainerID, actorID) {
+		h.sendToClient(client, envelope)
+	}
+}
+
+func (h *worldHub) sendToClient(client *clientConn, envelope serverEnvelope) {
+	if err := client.writeJSON(envelope); err != nil {
+		h.dropClientAfterWriteError(client, envelope.Type, err)
 	}
 }
 
 func (c *clientConn) writeJSON(value any) error {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
+	c.armWriteDeadline()
 	return c.conn.WriteJSON(value)
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(_ *http.Request) bool { return true },
+func (c *clientConn) writeEncoded(encoded []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.armWriteDeadline()
+	return c.conn.WriteMessage(websocket.TextMessage, encoded)
 }
 
 func buildWSHandler(hub *worldHub) http.HandlerFunc {
+	upgrader := newUpgrader(hub.allowedOrigins)
 	return func(writer http.ResponseWriter, request *http.Request) {
+		if hub.isShuttingDown() {
+			http.Error(writer, "server shutting down", http.StatusServiceUnavailable)
+			return
+		}
 		conn, err := upgrader.Upgrade(writer, request, nil)
 		if err != nil {
-			log.Printf("world-server: ws upgrade failed: %v", err)
+			hub.logger.Warn("websocket upgrade failed", "remote", request.RemoteAddr, "err", err)
 			return
 		}
 
+		limits := hub.connLimits
+		limiter := newMessageLimiter(limits)
+		conn.SetReadLimit(limits.MaxMessageBytes)
+		_ = conn.SetReadDeadline(time.Now().Add(limits.IdleTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(limits.IdleTimeout))
+		})
+		stopHeartbeat := startHeartbeat(conn, limits.HeartbeatInterval)
+
 		client := &clientConn{
-			conn:      conn,
-			playerIDs: make(map[string]struct{}),
+			id:           hub.newConnectionID(),
+			conn:         conn,
+			playerIDs:    make(map[string]struct{}),
+			writeTimeout: limits.WriteTimeout,
 		}
 		hub.addClient(client)
+		hub.recordConnectionOpened()
+		hub.logger.Info("connection opened", "connId", client.id, "remote", request.RemoteAddr)
+		closeReason, forcedClose := "connection_lost", false
 		defer func() {
+			stopHeartbeat()
+			hub.logger.Info("connection closed", "connId", client.id, "reason", closeReason, "forced", forcedClose)
+			hub.recordConnectionClosed(client, closeReason, forcedClose)
 			hub.removeClient(client)
 			_ = conn.Close()
 		}()
@@ -3130,225 +3712,805 @@ func buildWSHandler(hub *worldHub) http.HandlerFunc {
 			})
 		}
 
+		dispatching := false
+		defer func() {
+			if dispatching {
+				hub.endDispatch()
+			}
+		}()
 		for {
+			if dispatching {
+				hub.endDispatch()
+				dispatching = false
+			}
 			_, payload, err := conn.ReadMessage()
 			if err != nil {
+				closeReason, forcedClose = readCloseReason(err)
+				if client.writeState.timedOut.Load() {
+					closeReason, forcedClose = "write_timeout", true
+				}
+				if hub.isShuttingDown() {
+					closeReason, forcedClose = "server_shutdown", false
+				}
+				if closeReason == "idle_timeout" {
+					client.closeWithReason(idleTimeoutCloseCode, closeReason)
+				}
 				return
 			}
+			_ = conn.SetReadDeadline(time.Now().Add(limits.IdleTimeout))
 
 			var envelope clientEnvelope
-			if err := json.Unmarshal(payload, &envelope); err != nil {
+			malformed := json.Unmarshal(payload, &envelope) != nil
+			if !limiter.allow(envelope.Type, time.Now()) {
+				hub.messageLogger(client, envelope).Warn("rate limited, closing connection")
+				bucketName := limiter.bucketName(envelope.Type)
+				hub.recordRateLimited(bucketName)
+				client.closeWithReason(rateLimitedCloseCode, "rate_limited: "+bucketName)
+				closeReason, forcedClose = "rate_limited", true
+				return
+			}
+			if malformed {
+				hub.sendClientError(client, envelope, "malformed_envelope")
+				continue
+			}
+			msgLog := hub.messageLogger(client, envelope)
+			msgLog.Debug("message received")
+			if !hub.beginDispatch(envelope.Type) {
+				hub.sendClientError(client, envelope, "server_shutting_down")
 				continue
 			}
+			dispatching = true
 
 			switch envelope.Type {
+			case "hello":
+				var hello helloPayload
+				if json.Unmarshal(envelope.Payload, &hello) == nil {
+					welcome, refusal := hub.negotiateHello(hello)
+					if refusal != "" {
+						msgLog.Info("handshake refused", "reason", refusal, "clientProtocol", hello.ProtocolVersion)
+						client.closeWithReason(protocolMismatchCloseCode, refusal)
+						closeReason, forcedClose = "protocol_mismatch", true
+						return
+					}
+					hub.sendToClient(client, serverEnvelope{
+						Type:      "welcome",
+						RequestID: envelope.RequestID,
+						Payload:   welcome,
+					})
+				} else {
+					hub.sendClientError(client, envelope, "invalid_payload")
+				}
 			case "join":
 				var join joinRuntimeRequest
 				if json.Unmarshal(envelope.Payload, &join) == nil {
-					hub.handleJoin(client, join)
-					hub.sendToClient(client, serverEnvelope{
-						Type:    "snapshot",
-						Payload: hub.snapshotForClient(client, snapshotReplicationRadius),
-					})
-					if hotbarState, ok := hub.hotbarStateForPlayer(join.PlayerID); ok {
-						hub.sendToClient(client, serverEnvelope{
-							Type:    "hotbar_state",
-							Payload: hotbarState,
-						})
-					}
-					if inventoryState, ok := hub.inventoryStateForPlayer(join.PlayerID); ok {
-						hub.sendToClient(client, serverEnvelope{
-							Type:    "inventory_state",
-							Payload: inventoryState,
-						})
+					if reason := hub.handleJoin(client, join); reason != "" {
+						hub.sendClientError(client, envelope, reason)
+						continue
 					}
-					if healthState, ok := hub.healthStateForPlayer(join.PlayerID); ok {
+					msgLog.Info("player joined")
+					if grant, ok := hub.sessionGrant(join.PlayerID); ok {
 						hub.sendToClient(client, serverEnvelope{
-							Type:    "health_state",
-							Payload: healthState,
+							Type:    "session",
+							Payload: grant,
 						})
 					}
-					if containerState, ok := hub.containerState(worldSharedContainerID); ok {
+					hub.sendPlayerState(client, join.PlayerID, envelope.RequestID)
+				} else {
+					hub.sendClientError(client, envelope, "invalid_payload")
+				}
+			case "resume":
+				var resume resumePayload
+				if json.Unmarshal(envelope.Payload, &resume) == nil {
+					result := hub.resumeSession(client, resume, envelope.RequestID)
+					logActionOutcome(msgLog, result.Accepted, result.Reason)
+					if !result.Accepted {
 						hub.sendToClient(client, serverEnvelope{
-							Type:    "container_state",
-							Payload: containerState,
+							Type:      "resume_result",
+							RequestID: envelope.RequestID,
+							Payload:   result,
 						})
-					}
-					if containerState, ok := hub.containerState(playerPrivateContainerID(join.PlayerID)); ok {
+					} else if result.Resynced {
+						hub.sendPlayerState(client, resume.PlayerID, "")
+					} else if result.Accepted {
 						hub.sendToClient(client, serverEnvelope{
-							Type:    "container_state",
-							Payload: containerState,
+							Type:    "snapshot",
+							Payload: hub.snapshotForClient(client, snapshotReplicationRadius),
 						})
 					}
-					hub.sendToClient(client, serverEnvelope{
-						Type:    "world_flag_state",
-						Payload: hub.worldFlagState(),
-					})
-					hub.sendToClient(client, serverEnvelope{
-						Type:    "world_directive_state",
-						Payload: hub.worldDirectiveState(),
-					})
+				} else {
+					hub.sendClientError(client, envelope, "invalid_payload")
 				}
 			case "leave":
 				var leave leavePayload
-				if json.Unmarshal(envelope.Payload, &leave) == nil {
-					hub.handleLeave(leave.PlayerID)
+				if _, ok := hub.decodePlayerRequest(client, envelope, &leave); !ok {
+					continue
 				}
+				ack := hub.handleLeave(client, leave.PlayerID)
+				msgLog.Info("player left")
+				// The connection no longer controls the player, so the
+				// result goes to it directly.
+				hub.sendToClient(client, serverEnvelope{
+					Type:      "leave_result",
+					RequestID: envelope.RequestID,
+					Payload:   ack,
+				})
+				hub.flushTradeUpdates()
 			case "input":
 				var input inputPayload
-				if json.Unmarshal(envelope.Payload, &input) == nil {
-					hub.handleInput(input)
+				req, ok := hub.decodePlayerRequest(client, envelope, &input)
+				if !ok {
+					continue
+				}
+				// Input streams every frame, so an accepted one is only
+				// acknowledged when the client asks for correlation.
+				if ack := hub.handleInput(input); !ack.Accepted || envelope.RequestID != "" {
+					req.reply("input_result", ack)
 				}
 			case "block_action":
 				var action blockActionPayload
-				if json.Unmarshal(envelope.Payload, &action) == nil {
-					if delta, ok := hub.applyBlockAction(action); ok {
-						hub.broadcastBlockDelta(delta)
-						if action.Action == "break" {
-							if inventoryState, changed := hub.awardInventoryResources(action.PlayerID, breakResourceGrants(action)); changed {
-								hub.sendToPlayerOwnedRecipients(inventoryState.PlayerID, serverEnvelope{
-									Type:    "inventory_state",
-									Payload: inventoryState,
-								})
-							}
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, delta := hub.applyBlockAction(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("block_action_result", result)
+				if delta != nil {
+					hub.broadcastBlockDelta(*delta)
+					if result.ContainerID != "" && action.Action == "place" {
+						if containerState, ok := hub.containerState(result.ContainerID); ok {
+							hub.publishContainerState(containerState, action.PlayerID)
 						}
 					}
-				}
-			case "combat_action":
-				var action combatActionPayload
-				if json.Unmarshal(envelope.Payload, &action) == nil {
-					result, healthUpdates, inventoryUpdates, worldEvents := hub.applyCombatAction(action)
-					hub.broadcastCombatResult(result)
-					for _, state := range healthUpdates {
-						hub.sendToPlayerOwnedRecipients(state.PlayerID, serverEnvelope{
-							Type:    "health_state",
-							Payload: state,
-						})
+					if action.Action == "place" && hub.placesFromItem(result.BlockType) {
+						if inventoryState, ok := hub.inventoryStateForPlayer(action.PlayerID); ok {
+							hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+								Type:    "inventory_state",
+								Payload: inventoryState,
+							})
+						}
 					}
-					for _, state := range inventoryUpdates {
-						hub.sendToPlayerOwnedRecipients(state.PlayerID, serverEnvelope{
-							Type:    "inventory_state",
-							Payload: state,
-						})
+					if result.ContainerID != "" && action.Action == "break" {
+						hub.flushChestRemovals()
+						hub.flushContainerCloses()
 					}
-					if len(worldEvents) > 0 {
-						recipients := hub.selectCombatRecipients(result.PlayerID, combatReplicationRadius)
-						for _, event := range worldEvents {
-							for _, client := range recipients {
-								hub.sendToClient(client, serverEnvelope{
-									Type:    "world_event",
-									Payload: event,
-								})
-							}
+					if action.Action == "break" {
+						if inventoryState, changed := hub.awardInventoryResources(action.PlayerID, breakResourceGrants(action)); changed {
+							hub.sendToPlayerOwnedRecipients(inventoryState.PlayerID, serverEnvelope{
+								Type:    "inventory_state",
+								Payload: inventoryState,
+							})
 						}
 					}
-					if result.Accepted && action.Kind == "item" {
-						if state, ok := hub.hotbarStateForPlayer(action.PlayerID); ok {
-							hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
-								Type:    "hotbar_state",
-								Payload: state,
+				}
+			case "combat_action":
+				var action combatActionPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, healthUpdates, inventoryUpdates, worldEvents := hub.applyCombatAction(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				if result.Replayed {
+					req.reply("combat_result", result)
+					continue
+				}
+				hub.broadcastCombatResult(client, result, envelope.RequestID)
+				for _, state := range healthUpdates {
+					hub.sendToPlayerOwnedRecipients(state.PlayerID, serverEnvelope{
+						Type:    "health_state",
+						Payload: state,
+					})
+				}
+				for _, state := range inventoryUpdates {
+					hub.sendToPlayerOwnedRecipients(state.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: state,
+					})
+				}
+				if len(worldEvents) > 0 {
+					recipients := hub.selectCombatRecipients(result.PlayerID, combatReplicationRadius)
+					for _, event := range worldEvents {
+						for _, client := range recipients {
+							hub.sendToClient(client, serverEnvelope{
+								Type:    "world_event",
+								Payload: event,
 							})
 						}
 					}
 				}
+				if result.Accepted && (action.Kind == "item" || hub.combatSlotWears(action.SlotID)) {
+					if state, ok := hub.hotbarStateForPlayer(action.PlayerID); ok {
+						hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+							Type:    "hotbar_state",
+							Payload: state,
+						})
+					}
+				}
 			case "interact_action":
 				var action interactActionPayload
-				if json.Unmarshal(envelope.Payload, &action) == nil {
-					result := hub.applyInteractAction(action)
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result := hub.applyInteractAction(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("interact_result", result)
+				if result.Accepted && isGroundItemID(result.TargetID) {
+					if inventoryState, ok := hub.inventoryStateForPlayer(action.PlayerID); ok {
+						hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+							Type:    "inventory_state",
+							Payload: inventoryState,
+						})
+					}
+				}
+			case "drop_item":
+				var action dropItemPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyDropItem(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("drop_item_result", result)
+				if updates.inventory != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: *updates.inventory,
+					})
+				}
+				if updates.hotbar != nil {
 					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
-						Type:    "interact_result",
-						Payload: result,
+						Type:    "hotbar_state",
+						Payload: *updates.hotbar,
 					})
 				}
 			case "hotbar_select":
 				var action hotbarSelectPayload
-				if json.Unmarshal(envelope.Payload, &action) == nil {
-					if _, owned := client.playerIDs[action.PlayerID]; !owned {
-						continue
-					}
-					if state, ok := hub.applyHotbarSelection(action); ok {
-						hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
-							Type:    "hotbar_state",
-							Payload: state,
-						})
-					}
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, hotbarState := hub.applyHotbarSelection(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("hotbar_result", result)
+				if hotbarState != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "hotbar_state",
+						Payload: *hotbarState,
+					})
+				}
+			case "hotbar_assign":
+				var action hotbarAssignPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyHotbarAssign(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("hotbar_result", result)
+				if updates.hotbar != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "hotbar_state",
+						Payload: *updates.hotbar,
+					})
+				}
+				if updates.inventory != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: *updates.inventory,
+					})
+				}
+			case "hotbar_swap":
+				var action hotbarSwapPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, hotbarState := hub.applyHotbarSwap(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("hotbar_result", result)
+				if hotbarState != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "hotbar_state",
+						Payload: *hotbarState,
+					})
 				}
 			case "craft_request":
 				var craft craftRequestPayload
-				if json.Unmarshal(envelope.Payload, &craft) == nil {
-					if _, owned := client.playerIDs[craft.PlayerID]; !owned {
-						continue
-					}
-					result, inventoryState, hotbarState := hub.applyCraftRequest(craft)
+				req, ok := hub.decodePlayerRequest(client, envelope, &craft)
+				if !ok {
+					continue
+				}
+				result, inventoryState, hotbarState := hub.applyCraftRequest(craft)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("craft_result", result)
+				if inventoryState != nil {
+					hub.sendToPlayerOwnedRecipients(craft.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: *inventoryState,
+					})
+				}
+				if hotbarState != nil {
 					hub.sendToPlayerOwnedRecipients(craft.PlayerID, serverEnvelope{
-						Type:    "craft_result",
-						Payload: result,
+						Type:    "hotbar_state",
+						Payload: *hotbarState,
 					})
-					if inventoryState != nil {
-						hub.sendToPlayerOwnedRecipients(craft.PlayerID, serverEnvelope{
+				}
+				hub.flushCraftProgress()
+			case "craft_cancel":
+				var cancel craftCancelPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &cancel)
+				if !ok {
+					continue
+				}
+				progress := hub.applyCraftCancel(cancel)
+				logActionOutcome(msgLog, progress.Reason == "", progress.Reason)
+				req.reply("craft_progress", progress)
+				if progress.State == "cancelled" {
+					if inventoryState, ok := hub.inventoryStateForPlayer(cancel.PlayerID); ok {
+						hub.sendToPlayerOwnedRecipients(cancel.PlayerID, serverEnvelope{
 							Type:    "inventory_state",
-							Payload: *inventoryState,
-						})
-					}
-					if hotbarState != nil {
-						hub.sendToPlayerOwnedRecipients(craft.PlayerID, serverEnvelope{
-							Type:    "hotbar_state",
-							Payload: *hotbarState,
+							Payload: inventoryState,
 						})
 					}
 				}
 			case "container_action":
 				var action containerActionPayload
-				if json.Unmarshal(envelope.Payload, &action) == nil {
-					if _, owned := client.playerIDs[action.PlayerID]; !owned {
-						continue
-					}
-					result, inventoryState, containerState := hub.applyContainerAction(action)
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, inventoryState, containerState := hub.applyContainerAction(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("container_result", result)
+				if inventoryState != nil {
 					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
-						Type:    "container_result",
-						Payload: result,
+						Type:    "inventory_state",
+						Payload: *inventoryState,
 					})
-					if inventoryState != nil {
-						hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
-							Type:    "inventory_state",
-							Payload: *inventoryState,
-						})
-					}
-					if containerState != nil {
-						if ownerPlayerID, isPrivate := privateContainerOwner(containerState.ContainerID); isPrivate {
-							hub.sendToPlayerOwnedRecipients(ownerPlayerID, serverEnvelope{
-								Type:    "container_state",
-								Payload: *containerState,
-							})
-						} else {
-							hub.broadcast(serverEnvelope{
-								Type:    "container_state",
-								Payload: *containerState,
-							})
-						}
-					}
 				}
+				if containerState != nil {
+					hub.publishContainerState(*containerState, action.PlayerID)
+				}
+			case "container_transaction":
+				var action containerTransactionPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, inventoryState, containerState := hub.applyContainerTransaction(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("container_result", result)
+				if inventoryState != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: *inventoryState,
+					})
+				}
+				if containerState != nil {
+					hub.publishContainerState(*containerState, action.PlayerID)
+				}
+			case "container_open":
+				var action containerSubscriptionPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, containerState := hub.applyContainerOpen(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("container_subscription_result", result)
+				if containerState != nil {
+					hub.sendToPlayerOwnedRecipients(action.PlayerID, serverEnvelope{
+						Type:    "container_state",
+						Payload: *containerState,
+					})
+				}
+			case "container_close":
+				var action containerSubscriptionPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result := hub.applyContainerClose(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("container_subscription_result", result)
+			case "container_acl":
+				var action containerACLPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, containerState := hub.applyContainerACL(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("container_acl_result", result)
+				if containerState != nil {
+					hub.publishContainerState(*containerState, action.PlayerID)
+				}
+				hub.flushContainerCloses()
+			case "inventory_slot_op":
+				var slotOp inventorySlotOpPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &slotOp)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyInventorySlotOp(slotOp)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("inventory_slot_result", result)
+				if updates.inventory != nil {
+					hub.sendToPlayerOwnedRecipients(slotOp.PlayerID, serverEnvelope{
+						Type:    "inventory_state",
+						Payload: *updates.inventory,
+					})
+				}
+				if updates.hotbar != nil {
+					hub.sendToPlayerOwnedRecipients(slotOp.PlayerID, serverEnvelope{
+						Type:    "hotbar_state",
+						Payload: *updates.hotbar,
+					})
+				}
+				if updates.container != nil {
+					hub.publishContainerState(*updates.container, slotOp.PlayerID)
+				}
+			case "trade_request":
+				var action tradeRequestPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyTradeRequest(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("trade_result", result)
+				hub.sendTradeUpdates(updates)
+			case "trade_offer_update":
+				var action tradeOfferUpdatePayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyTradeOfferUpdate(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("trade_result", result)
+				hub.sendTradeUpdates(updates)
+			case "trade_confirm":
+				var action tradeConfirmPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyTradeConfirm(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("trade_result", result)
+				hub.sendTradeUpdates(updates)
+			case "trade_cancel":
+				var action tradeCancelPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, updates := hub.applyTradeCancel(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				req.reply("trade_result", result)
+				hub.sendTradeUpdates(updates)
+			case "claim_create":
+				var action claimCreatePayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, changed := hub.applyClaimCreate(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				hub.sendClaimResult(req, result, changed)
+			case "claim_members":
+				var action claimMembersPayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, changed := hub.applyClaimMembers(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				hub.sendClaimResult(req, result, changed)
+			case "claim_remove":
+				var action claimRemovePayload
+				req, ok := hub.decodePlayerRequest(client, envelope, &action)
+				if !ok {
+					continue
+				}
+				result, changed := hub.applyClaimRemove(action)
+				logActionOutcome(msgLog, result.Accepted, result.Reason)
+				hub.sendClaimResult(req, result, changed)
+			case "claim_list":
+				var action claimListPayload
+				if json.Unmarshal(envelope.Payload, &action) == nil {
+					hub.sendToClient(client, serverEnvelope{
+						Type:      "claim_state",
+						RequestID: envelope.RequestID,
+						Payload:   hub.claimState(),
+					})
+				} else {
+					hub.sendClientError(client, envelope, "invalid_payload")
+				}
+			default:
+				hub.sendClientError(client, envelope, "unknown_message_type")
 			}
 		}
 	}
 }
 
+// sendPlayerState sends everything a client needs to render a player it just
+// took control of. requestID is echoed on the snapshot.
+func (h *worldHub) sendPlayerState(client *clientConn, playerID string, requestID string) {
+	h.sendToClient(client, serverEnvelope{
+		Type:    "content_catalog",
+		Payload: h.contentCatalog(),
+	})
+	h.sendToClient(client, serverEnvelope{
+		Type:      "snapshot",
+		RequestID: requestID,
+		Payload:   h.snapshotForClient(client, snapshotReplicationRadius),
+	})
+	if hotbarState, ok := h.hotbarStateForPlayer(playerID); ok {
+		h.sendToClient(client, serverEnvelope{
+			Type:    "hotbar_state",
+			Payload: hotbarState,
+		})
+	}
+	if inventoryState, ok := h.inventoryStateForPlayer(playerID); ok {
+		h.sendToClient(client, serverEnvelope{
+			Type:    "inventory_state",
+			Payload: inventoryState,
+		})
+	}
+	if healthState, ok := h.healthStateForPlayer(playerID); ok {
+		h.sendToClient(client, serverEnvelope{
+			Type:    "health_state",
+			Payload: healthState,
+		})
+	}
+	if containerState, ok := h.containerState(playerPrivateContainerID(playerID)); ok {
+		h.sendToClient(client, serverEnvelope{
+			Type:    "container_state",
+			Payload: containerState,
+		})
+	}
+	h.sendToClient(client, serverEnvelope{
+		Type:    "world_flag_state",
+		Payload: h.worldFlagState(),
+	})
+	h.sendToClient(client, serverEnvelope{
+		Type:    "world_directive_state",
+		Payload: h.worldDirectiveState(),
+	})
+	h.sendToClient(client, serverEnvelope{
+		Type:    "claim_state",
+		Payload: h.claimState(),
+	})
+	h.sendToClient(client, serverEnvelope{
+		Type:    "craft_queue_state",
+		Payload: h.craftQueueState(playerID),
+	})
+}
+
+// runtimeClientError rejects a client message the server could not route to
+// a handler. Code is machine-readable; MessageType and RequestID echo the
+// offending envelope when it could be decoded.
+type runtimeClientError struct {
+	Code        string `json:"code"`
+	MessageType string `json:"messageType,omitempty"`
+	RequestID   string `json:"requestId,omitempty"`
+}
+
+// sendClientError replies to the sending connection only, since the message
+// may not name a player the connection controls.
+func (h *worldHub) sendClientError(client *clientConn, envelope clientEnvelope, code string) {
+	h.messageLogger(client, envelope).Debug("message rejected", "reason", code)
+	h.sendToClient(client, serverEnvelope{
+		Type:      "error",
+		RequestID: envelope.RequestID,
+		Payload: runtimeClientError{
+			Code:        code,
+			MessageType: envelope.Type,
+			RequestID:   envelope.RequestID,
+		},
+	})
+}
+
+// playerRequest is a decoded client message naming a player the sending
+// connection controls.
+type playerRequest struct {
+	hub      *worldHub
+	envelope clientEnvelope
+	playerID string
+}
+
+// decodePlayerRequest decodes envelope's payload into target and checks that
+// the connection controls the player it names. On failure the sender gets an
+// error envelope and ok is false.
+func (h *worldHub) decodePlayerRequest(client *clientConn, envelope clientEnvelope, target any) (playerRequest, bool) {
+	var owner struct {
+		PlayerID string `json:"playerId"`
+	}
+	if json.Unmarshal(envelope.Payload, target) != nil || json.Unmarshal(envelope.Payload, &owner) != nil {
+		h.sendClientError(client, envelope, "invalid_payload")
+		return playerRequest{}, false
+	}
+	if _, owned := client.playerIDs[owner.PlayerID]; !owned {
+		h.sendClientError(client, envelope, "player_not_owned")
+		return playerRequest{}, false
+	}
+	return playerRequest{hub: h, envelope: envelope, playerID: owner.PlayerID}, true
+}
+
+// reply sends the direct answer to the request to every connection that
+// controls the player, echoing the request's requestId.
+func (r playerRequest) reply(messageType string, payload any) {
+	r.hub.sendToPlayerOwnedRecipients(r.playerID, serverEnvelope{
+		Type:      messageType,
+		RequestID: r.envelope.RequestID,
+		Payload:   payload,
+	})
+}
+
+func (h *worldHub) sendClaimResult(req playerRequest, result runtimeClaimResult, changed bool) {
+	req.reply("claim_result", result)
+	if changed {
+		h.broadcast(serverEnvelope{
+			Type:    "claim_state",
+			Payload: h.claimState(),
+		})
+	}
+}
+
 func main() {
 	addr := flag.String("addr", ":8787", "listen address")
+	blockReach := flag.Float64("block-reach", defaultBlockReach, "max horizontal distance (world units) for block break/place")
+	contentDir := flag.String("content-dir", "", "directory with items.json, combat_slots.json and recipes.json (defaults to the builtin pack)")
+	lingerTimeout := flag.Duration("linger-timeout", 30*time.Second, "how long a disconnected player stays in the world (marked away) before being removed")
+	connDefaults := defaultConnectionLimits()
+	heartbeatInterval := flag.Duration("heartbeat-interval", connDefaults.HeartbeatInterval, "how often the server pings each websocket client")
+	idleTimeout := flag.Duration("idle-timeout", connDefaults.IdleTimeout, "close a websocket connection after this long without a message or pong")
+	writeTimeout := flag.Duration("write-timeout", connDefaults.WriteTimeout, "close a websocket connection whose write takes longer than this (a peer that stopped reading)")
+	maxMessageBytes := flag.Int64("max-message-bytes", connDefaults.MaxMessageBytes, "largest websocket message a client may send")
+	ratePerSecond := flag.Float64("message-rate", connDefaults.DefaultRate.Rate, "base messages per second per connection; each message type has its own bucket scaled from it (input 3x, claims and trades 0.25x); 0 disables")
+	allowedOrigins := flag.String("allowed-origins", "*", "comma-separated browser origins allowed to open /ws; supports * and https://*.example.com")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for the public listener; reloaded when it changes")
+	tlsKey := flag.String("tls-key", "", "TLS private key file for the public listener")
+	apiKeysFile := flag.String("api-keys", "", "JSON file of scoped API keys for /debug and /openclaw endpoints, reloaded when it changes; without it those endpoints refuse every request")
+	insecureAdmin := flag.Bool("insecure-admin", false, "serve /debug and /openclaw endpoints without authentication when -api-keys is not set; local development only")
+	auditLogPath := flag.String("audit-log", "", "file to append the admin audit log to (defaults to stderr)")
+	stateFile := flag.String("state-file", "", "file the world state is restored from at startup and saved to on shutdown")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long a graceful shutdown may take before remaining connections are dropped")
+	reconnectAfter := flag.Duration("shutdown-reconnect-after", 5*time.Second, "reconnect delay hinted to clients in the server_shutdown notice")
+	readyDefaults := defaultReadinessLimits()
+	readyMaxTickAge := flag.Duration("ready-max-tick-age", readyDefaults.MaxTickAge, "/readyz fails when the last tick finished longer ago than this")
+	readyMaxOverruns := flag.Int("ready-max-overruns", readyDefaults.MaxConsecutiveOverruns, "/readyz fails after this many consecutive ticks run over the tick interval")
+	adminAddr := flag.String("admin-addr", "", "separate listen address for /debug and /openclaw endpoints, e.g. 127.0.0.1:8788 (defaults to serving them on -addr); pprof and /debug/tick-trace are only served here")
+	mutexProfileFraction := flag.Int("mutex-profile-fraction", 0, "sample 1/n mutex contention events for /debug/pprof/mutex (0 disables; adjustable at runtime via /debug/profiling)")
+	blockProfileRate := flag.Int("block-profile-rate", 0, "sample one blocking event per n nanoseconds for /debug/pprof/block (0 disables; adjustable at runtime via /debug/profiling)")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error (debug logs every client message and rejected action)")
+	logFormat := flag.String("log-format", "text", "log output format: text or json")
 	flag.Parse()
 
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "world-server: %v\n", err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
+	content, err := loadRuntimeContent(*contentDir)
+	if err != nil {
+		fatal(logger, "content load failed", "err", err)
+	}
+
 	hub := newWorldHub()
-	go runTickLoop(hub)
+	hub.logger = logger
+	hub.blockReach = *blockReach
+	hub.content = content
+	hub.contentDir = *contentDir
+	hub.lingerTicks = max(int64(lingerTimeout.Seconds()*hub.tickRateHz), 1)
+	hub.connLimits.HeartbeatInterval = *heartbeatInterval
+	hub.connLimits.IdleTimeout = *idleTimeout
+	hub.connLimits.WriteTimeout = *writeTimeout
+	hub.connLimits.MaxMessageBytes = *maxMessageBytes
+	hub.connLimits.DefaultRate = messageRate{Rate: *ratePerSecond, Burst: *ratePerSecond * 2}
+	hub.connLimits.TypeRates = messageTypeRates(*ratePerSecond)
+	hub.allowedOrigins = parseOriginAllowlist(*allowedOrigins)
+	hub.applyProfilingSettings(profilingSettings{
+		MutexProfileFraction: mutexProfileFraction,
+		BlockProfileRate:     blockProfileRate,
+	})
+	hub.readinessLimits = readinessLimits{MaxTickAge: *readyMaxTickAge, MaxConsecutiveOverruns: *readyMaxOverruns}
+	auditWriter := io.Writer(os.Stderr)
+	if *auditLogPath != "" {
+		auditFile, err := os.OpenFile(*auditLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			fatal(logger, "open audit log failed", "path", *auditLogPath, "err", err)
+		}
+		defer auditFile.Close()
+		auditWriter = auditFile
+	}
+	if *apiKeysFile != "" {
+		hub.adminAuth, err = newAdminAuthFromFile(*apiKeysFile, newAuditLog(auditWriter), logger)
+		if err != nil {
+			fatal(logger, "load api keys failed", "path", *apiKeysFile, "err", err)
+		}
+		logger.Info("api keys loaded", "count", len(hub.adminAuth.currentKeys()))
+	} else {
+		hub.adminAuth = newAdminAuth(nil, newAuditLog(auditWriter))
+		hub.adminAuth.allowWithoutKeys = *insecureAdmin
+		if *insecureAdmin {
+			logger.Warn("-insecure-admin set; /debug and /openclaw endpoints are unauthenticated")
+		} else {
+			logger.Warn("no -api-keys configured; /debug and /openclaw endpoints refuse every request")
+		}
+	}
+	logger.Info("content pack loaded", "version", content.catalog.Version, "hash", content.catalog.Hash)
+	if *stateFile != "" {
+		loaded, err := hub.loadStateFile(*stateFile)
+		if err != nil {
+			fatal(logger, "restore world state failed", "path", *stateFile, "err", err)
+		}
+		if loaded {
+			logger.Info("world state restored", "path", *stateFile)
+		}
+	}
+	hub.markStateLoaded()
+	loop := startTickLoop(hub)
+	go watchContentReloadSignal(hub)
+
+	serveErrors := make(chan error, 2)
+	servers := make([]*http.Server, 0, 2)
+	if *adminAddr != "" {
+		adminServer := &http.Server{Addr: *adminAddr, Handler: buildAdminMux(hub)}
+		servers = append(servers, adminServer)
+		go func() {
+			logger.Info("admin listening", "addr", *adminAddr)
+			serveErrors <- adminServer.ListenAndServe()
+		}()
+	}
 
-	http.HandleFunc("/ws", buildWSHandler(hub))
-	http.HandleFunc("/openclaw/directives", buildDirectiveHandler(hub))
-	http.HandleFunc("/openclaw/events", buildEventFeedHandler(hub))
-	http.HandleFunc("/debug/state", buildDebugStateHandler(hub))
-	http.HandleFunc("/debug/load-state", buildDebugLoadStateHandler(hub))
+	publicServer := &http.Server{Addr: *addr, Handler: buildPublicMux(hub, *adminAddr == "")}
+	servers = append(servers, publicServer)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		fatal(logger, "-tls-cert and -tls-key must be set together")
+	}
+	if *tlsCert != "" {
+		reloader, err := newCertReloader(*tlsCert, *tlsKey)
+		if err != nil {
+			fatal(logger, "load tls certificate failed", "err", err)
+		}
+		publicServer.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+		go func() {
+			logger.Info("listening", "addr", *addr, "tls", true)
+			serveErrors <- publicServer.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			logger.Info("listening", "addr", *addr, "tls", false)
+			serveErrors <- publicServer.ListenAndServe()
+		}()
+	}
 
-	log.Printf("world-server: listening on %s", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
-		log.Fatalf("world-server: listen failed: %v", err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-serveErrors:
+		fatal(logger, "listen failed", "err", err)
+	case received := <-signals:
+		logger.Info("shutting down", "signal", received.String(), "deadline", shutdownTimeout.String())
+		started := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		err := hub.shutdown(ctx, servers, loop, shutdownOptions{
+			Reason:         "server_restarting",
+			ReconnectAfter: *reconnectAfter,
+			StateFile:      *stateFile,
+		})
+		logShutdown(logger, err, started)
 	}
 }
 
@@ -3486,17 +4648,7 @@ func buildDebugLoadStateHandler(hub *worldHub) http.HandlerFunc {
 			})
 		}
 		for _, containerState := range state.ContainerStates {
-			if ownerPlayerID, isPrivate := privateContainerOwner(containerState.ContainerID); isPrivate {
-				hub.sendToPlayerOwnedRecipients(ownerPlayerID, serverEnvelope{
-					Type:    "container_state",
-					Payload: containerState,
-				})
-				continue
-			}
-			hub.broadcast(serverEnvelope{
-				Type:    "container_state",
-				Payload: containerState,
-			})
+			hub.publishContainerState(containerState, "")
 		}
 		hub.broadcast(serverEnvelope{
 			Type:    "world_flag_state",
@@ -3506,6 +4658,10 @@ func buildDebugLoadStateHandler(hub *worldHub) http.HandlerFunc {
 			Type:    "world_directive_state",
 			Payload: state.DirectiveState,
 		})
+		hub.broadcast(serverEnvelope{
+			Type:    "claim_state",
+			Payload: hub.claimState(),
+		})
 
 		writer.Header().Set("Content-Type", "application/json")
 		writer.WriteHeader(http.StatusAccepted)
@@ -3513,13 +4669,53 @@ func buildDebugLoadStateHandler(hub *worldHub) http.HandlerFunc {
 	}
 }
 
-func runTickLoop(hub *worldHub) {
-	ticker := time.NewTicker(50 * time.Millisecond)
+func buildContentReloadHandler(hub *worldHub) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		ack := hub.reloadContent()
+		statusCode := http.StatusAccepted
+		if !ack.Accepted {
+			statusCode = http.StatusUnprocessableEntity
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(statusCode)
+		_ = json.NewEncoder(writer).Encode(ack)
+	}
+}
+
+func watchContentReloadSignal(hub *worldHub) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		ack := hub.reloadContent()
+		if !ack.Accepted {
+			hub.logger.Warn("content reload rejected", "problems", ack.Problems)
+			continue
+		}
+		hub.logger.Info("content pack reloaded", "version", ack.Version, "hash", ack.Hash, "migratedHotbars", len(ack.MigratedPlayers))
+	}
+}
+
+func runTickLoop(hub *worldHub, stop <-chan struct{}) {
+	ticker := time.NewTicker(tickInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		started := time.Now()
 		directiveStateChanged := hub.advanceOneTick()
+		timer := startPhaseTimer()
 		hub.broadcastSnapshots(snapshotReplicationRadius)
+		snapshotsMs := timer.lap()
+		hub.flushOutboxes()
 		if directiveStateChanged {
 			hub.broadcast(serverEnvelope{
 				Type:    "world_flag_state",
@@ -3530,6 +4726,9 @@ func runTickLoop(hub *worldHub) {
 				Payload: hub.worldDirectiveState(),
 			})
 		}
+		flushMs := timer.lap()
+		hub.recordTickTrace(started, snapshotsMs, flushMs)
+		hub.recordTickTiming(started, time.Now())
 	}
 }
 
@@ -3592,7 +4791,7 @@ func makeFloat64Ptr(value float64) *float64 {
 
 func isAllowedDirectiveType(directiveType string) bool {
 	switch directiveType {
-	case "set_world_flag", "emit_story_beat", "spawn_hint":
+	case "set_world_flag", "emit_story_beat", "spawn_hint", "land_claim":
 		return true
 	default:
 		return false
@@ -2,12 +2,28 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestListBlockDeltasSortsDeterministically(t *testing.T) {
@@ -493,8 +509,8 @@ func TestHotbarSelectionAndSnapshotState(t *testing.T) {
 	if !ok {
 		t.Fatalf("expected hotbar state after join")
 	}
-	if len(state.SlotIDs) != len(defaultHotbarSlotIDs) {
-		t.Fatalf("unexpected slot count: got=%d want=%d", len(state.SlotIDs), len(defaultHotbarSlotIDs))
+	if len(state.SlotIDs) != len(hub.content.defaultHotbarSlotIDs) {
+		t.Fatalf("unexpected slot count: got=%d want=%d", len(state.SlotIDs), len(hub.content.defaultHotbarSlotIDs))
 	}
 	if state.SelectedIndex != 0 {
 		t.Fatalf("expected initial selected index 0, got %d", state.SelectedIndex)
@@ -506,28 +522,28 @@ func TestHotbarSelectionAndSnapshotState(t *testing.T) {
 		t.Fatalf("unexpected initial item stacks: %#v", state.StackCounts)
 	}
 
-	selectedState, selected := hub.applyHotbarSelection(hotbarSelectPayload{
+	selected, selectedState := hub.applyHotbarSelection(hotbarSelectPayload{
 		PlayerID:  "p-hotbar",
 		SlotIndex: 2,
 	})
-	if !selected {
-		t.Fatalf("expected hotbar selection accepted")
+	if !selected.Accepted || selectedState == nil {
+		t.Fatalf("expected hotbar selection accepted, got %#v", selected)
 	}
 	if selectedState.SelectedIndex != 2 {
 		t.Fatalf("expected selected index 2, got %d", selectedState.SelectedIndex)
 	}
 
-	if _, ok := hub.applyHotbarSelection(hotbarSelectPayload{
+	if result, state := hub.applyHotbarSelection(hotbarSelectPayload{
 		PlayerID:  "p-hotbar",
 		SlotIndex: 999,
-	}); ok {
-		t.Fatalf("expected invalid index selection rejected")
+	}); result.Accepted || result.Reason != "invalid_slot" || state != nil {
+		t.Fatalf("expected invalid index selection rejected, got %#v", result)
 	}
-	if _, ok := hub.applyHotbarSelection(hotbarSelectPayload{
+	if result, _ := hub.applyHotbarSelection(hotbarSelectPayload{
 		PlayerID:  "missing",
 		SlotIndex: 1,
-	}); ok {
-		t.Fatalf("expected missing player selection rejected")
+	}); result.Accepted || result.Reason != "player_not_found" {
+		t.Fatalf("expected missing player selection rejected, got %#v", result)
 	}
 }
 
@@ -1081,15 +1097,15 @@ func TestDebugStateHandlerExportsWorldState(t *testing.T) {
 		StartX:    5,
 		StartZ:    -3,
 	})
-	if _, ok := hub.applyBlockAction(blockActionPayload{
+	if result, _ := hub.applyBlockAction(blockActionPayload{
 		PlayerID: "player-debug",
 		Action:   "place",
 		ChunkX:   0,
 		ChunkZ:   0,
-		X:        1,
+		X:        9,
 		Y:        2,
-		Z:        3,
-	}); !ok {
+		Z:        7,
+	}); !result.Accepted {
 		t.Fatalf("expected block placement accepted")
 	}
 	hub.ingestDirective(openclawDirectiveRequest{
@@ -1140,11 +1156,11 @@ func TestImportStateRoundTrip(t *testing.T) {
 	source.applyBlockAction(blockActionPayload{
 		PlayerID:  "player-roundtrip",
 		Action:    "place",
-		ChunkX:    1,
-		ChunkZ:    -1,
-		X:         2,
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         10,
 		Y:         3,
-		Z:         4,
+		Z:         7,
 		BlockType: "stone",
 	})
 	source.awardInventoryResources("player-roundtrip", map[string]int{
@@ -1201,9 +1217,9 @@ func TestDebugLoadStateHandlerImportsWorldState(t *testing.T) {
 		Action:   "break",
 		ChunkX:   0,
 		ChunkZ:   0,
-		X:        1,
+		X:        8,
 		Y:        1,
-		Z:        1,
+		Z:        8,
 	})
 	source.advanceOneTick()
 	exported := source.exportState()
@@ -1251,43 +1267,3007 @@ func TestDebugLoadStateHandlerImportsWorldState(t *testing.T) {
 	}
 }
 
-func floatPtr(value float64) *float64 {
-	return &value
+func TestApplyBlockActionEnforcesReachAndCoordinates(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-block-reach",
+		PlayerID:  "builder",
+		StartX:    0,
+		StartZ:    0,
+	})
+
+	nearby, delta := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "place",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	})
+	if !nearby.Accepted || delta == nil {
+		t.Fatalf("expected nearby placement accepted, got %#v", nearby)
+	}
+	if nearby.BlockType != "dirt" || delta.BlockType != "dirt" {
+		t.Fatalf("expected default dirt block type, got result=%q delta=%q", nearby.BlockType, delta.BlockType)
+	}
+
+	farAway, delta := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        1,
+		Y:        1,
+		Z:        1,
+	})
+	if farAway.Accepted || farAway.Reason != "out_of_reach" || delta != nil {
+		t.Fatalf("expected out_of_reach rejection, got %#v", farAway)
+	}
+
+	outsideGrid, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "place",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        chunkGridCells,
+		Y:        1,
+		Z:        8,
+	})
+	if outsideGrid.Accepted || outsideGrid.Reason != "invalid_coordinates" {
+		t.Fatalf("expected invalid_coordinates rejection, got %#v", outsideGrid)
+	}
+
+	unknownPlayer, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "ghost",
+		Action:   "place",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	})
+	if unknownPlayer.Accepted || unknownPlayer.Reason != "player_not_found" {
+		t.Fatalf("expected player_not_found rejection, got %#v", unknownPlayer)
+	}
+
+	hub.blockReach = worldChunkSize
+	if result, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        1,
+		Y:        1,
+		Z:        1,
+	}); !result.Accepted {
+		t.Fatalf("expected configured reach to accept distant block, got %#v", result)
+	}
 }
 
-func nearlyEqual(left float64, right float64) bool {
-	diff := left - right
-	if diff < 0 {
-		diff = -diff
+func TestApplyBlockActionRejectsObstructedLineOfSight(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-block-los",
+		PlayerID:  "builder",
+		StartX:    2,
+		StartZ:    2,
+	})
+
+	if result, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "place",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        9,
+		Y:        1,
+		Z:        8,
+	}); !result.Accepted {
+		t.Fatalf("expected wall placement accepted, got %#v", result)
+	}
+
+	blocked, delta := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        10,
+		Y:        1,
+		Z:        8,
+	})
+	if blocked.Accepted || blocked.Reason != "line_of_sight_blocked" || delta != nil {
+		t.Fatalf("expected line_of_sight_blocked rejection, got %#v", blocked)
+	}
+
+	clear, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        10,
+		Y:        4,
+		Z:        8,
+	})
+	if !clear.Accepted {
+		t.Fatalf("expected break above the wall accepted, got %#v", clear)
 	}
-	return diff < 0.000001
 }
 
-func findFirstResolvableTargetToken(worldSeed string, tick int64, tickRateHz float64) (string, float64, float64, bool) {
-	for chunkX := -2; chunkX <= 2; chunkX++ {
-		for chunkZ := -2; chunkZ <= 2; chunkZ++ {
-			entities := generateChunkEntitiesForTargetResolution(chunkX, chunkZ, worldSeed)
-			for index, entity := range entities {
-				if entity.entityType != "npc" && entity.entityType != "wild-mon" {
-					continue
-				}
-				token := intToString(chunkX) + ":" + intToString(chunkZ) + ":" + entity.entityType + ":" + intToString(index)
-				worldX, worldZ, resolved := resolveNonPlayerTargetCoordinates(token, worldSeed, tick, tickRateHz)
-				if !resolved {
-					continue
-				}
-				return token, worldX, worldZ, true
-			}
+func TestLandClaimsProtectBlocksAndContainersForNonMembers(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-claims",
+		PlayerID:  "owner",
+		StartX:    0,
+		StartZ:    0,
+	})
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-claims",
+		PlayerID:  "visitor",
+		StartX:    2,
+		StartZ:    2,
+	})
+	hub.awardInventoryResources("visitor", map[string]int{"salvage": 2})
+
+	created, changed := hub.applyClaimCreate(claimCreatePayload{
+		PlayerID:  "owner",
+		ActionID:  "claim-1",
+		Alignment: "chunk",
+		MinX:      0,
+		MinZ:      0,
+		MaxX:      0,
+		MaxZ:      0,
+	})
+	if !created.Accepted || !changed {
+		t.Fatalf("expected claim accepted, got %#v", created)
+	}
+	if created.ClaimID != "claim:owner:claim-1" {
+		t.Fatalf("expected generated claim id, got %q", created.ClaimID)
+	}
+
+	overlap, _ := hub.applyClaimCreate(claimCreatePayload{
+		PlayerID:  "visitor",
+		ActionID:  "claim-2",
+		Alignment: "block",
+		MinX:      6,
+		MinZ:      6,
+		MaxX:      10,
+		MaxZ:      10,
+	})
+	if overlap.Accepted || overlap.Reason != "claim_overlap" {
+		t.Fatalf("expected claim_overlap, got %#v", overlap)
+	}
+
+	blocked, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "visitor",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	})
+	if blocked.Accepted || blocked.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected block rejection, got %#v", blocked)
+	}
+	container, _, _ := hub.applyContainerAction(containerActionPayload{
+		PlayerID:    "visitor",
+		ActionID:    "deposit-1",
+		ContainerID: worldSharedContainerID,
+		Operation:   "deposit",
+		ResourceID:  "salvage",
+		Amount:      1,
+	})
+	if container.Accepted || container.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected for the camp container inside a claim, got %#v", container)
+	}
+
+	forbidden, _ := hub.applyClaimMembers(claimMembersPayload{
+		PlayerID:  "visitor",
+		ActionID:  "member-0",
+		ClaimID:   created.ClaimID,
+		Operation: "add",
+		MemberID:  "visitor",
+	})
+	if forbidden.Accepted || forbidden.Reason != "claim_forbidden" {
+		t.Fatalf("expected claim_forbidden for non-owner, got %#v", forbidden)
+	}
+	added, _ := hub.applyClaimMembers(claimMembersPayload{
+		PlayerID:  "owner",
+		ActionID:  "member-1",
+		ClaimID:   created.ClaimID,
+		Operation: "add",
+		MemberID:  "visitor",
+	})
+	if !added.Accepted {
+		t.Fatalf("expected member add accepted, got %#v", added)
+	}
+	if result, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "visitor",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	}); !result.Accepted {
+		t.Fatalf("expected member block action accepted, got %#v", result)
+	}
+	if result, _, _ := hub.applyContainerAction(containerActionPayload{
+		PlayerID:    "visitor",
+		ActionID:    "deposit-2",
+		ContainerID: worldSharedContainerID,
+		Operation:   "deposit",
+		ResourceID:  "salvage",
+		Amount:      1,
+	}); !result.Accepted {
+		t.Fatalf("expected member camp deposit accepted, got %#v", result)
+	}
+
+	removed, _ := hub.applyClaimRemove(claimRemovePayload{
+		PlayerID: "owner",
+		ActionID: "remove-1",
+		ClaimID:  created.ClaimID,
+	})
+	if !removed.Accepted || len(hub.claimState().Claims) != 0 {
+		t.Fatalf("expected claim removed, got %#v claims=%#v", removed, hub.claimState().Claims)
+	}
+
+	events := hub.listWorldEventsSince(0)
+	foundCreated := false
+	foundRemoved := false
+	for _, event := range events.Events {
+		foundCreated = foundCreated || event.Type == "claim_created"
+		foundRemoved = foundRemoved || event.Type == "claim_removed"
+	}
+	if !foundCreated || !foundRemoved {
+		t.Fatalf("expected claim_created and claim_removed events, got %#v", events.Events)
+	}
+}
+
+func TestLandClaimsProtectPublicChestsForNonMembers(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-claim-chest", PlayerID: "owner", StartX: blockX, StartZ: blockZ + 1})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-claim-chest", PlayerID: "visitor", StartX: blockX + 1, StartZ: blockZ})
+	hub.awardInventoryResources("owner", map[string]int{"wood": 2, "chest": 1})
+
+	placed, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8, BlockType: chestBlockType})
+	if !placed.Accepted {
+		t.Fatalf("expected chest placement, got %#v", placed)
+	}
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "owner", ActionID: "acl-1", ContainerID: placed.ContainerID, Access: "public"}); !result.Accepted {
+		t.Fatalf("expected public ACL, got %#v", result)
+	}
+	deposit := func(playerID string, actionID string) runtimeContainerActionResult {
+		result, _, _ := hub.applyContainerAction(containerActionPayload{
+			PlayerID:    playerID,
+			ActionID:    actionID,
+			ContainerID: placed.ContainerID,
+			Operation:   "deposit",
+			ResourceID:  "wood",
+			Amount:      1,
+		})
+		return result
+	}
+	if result := deposit("owner", "deposit-1"); !result.Accepted {
+		t.Fatalf("expected owner deposit, got %#v", result)
+	}
+	hub.awardInventoryResources("visitor", map[string]int{"wood": 1})
+
+	created, _ := hub.applyClaimCreate(claimCreatePayload{PlayerID: "owner", ActionID: "claim-1", Alignment: "block", MinX: 4, MinZ: 4, MaxX: 12, MaxZ: 12})
+	if !created.Accepted {
+		t.Fatalf("expected claim accepted, got %#v", created)
+	}
+	if result := deposit("visitor", "deposit-2"); result.Accepted || result.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected for a public chest inside a claim, got %#v", result)
+	}
+	if result, _ := hub.applyContainerOpen(containerSubscriptionPayload{PlayerID: "visitor", ActionID: "open-1", ContainerID: placed.ContainerID}); result.Accepted || result.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected when opening a public chest inside a claim, got %#v", result)
+	}
+
+	if result, _ := hub.applyClaimMembers(claimMembersPayload{PlayerID: "owner", ActionID: "member-1", ClaimID: created.ClaimID, Operation: "add", MemberID: "visitor"}); !result.Accepted {
+		t.Fatalf("expected member add, got %#v", result)
+	}
+	if result := deposit("visitor", "deposit-3"); !result.Accepted {
+		t.Fatalf("expected member deposit, got %#v", result)
+	}
+}
+
+func TestLandClaimsRejectBoundsOutsideTheWorld(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-claim-bounds", PlayerID: "greedy", StartX: 0, StartZ: 0})
+
+	for _, alignment := range []string{"block", "chunk"} {
+		result, changed := hub.applyClaimCreate(claimCreatePayload{
+			PlayerID:  "greedy",
+			ActionID:  "claim-" + alignment,
+			Alignment: alignment,
+			MinX:      math.MinInt64,
+			MinZ:      math.MinInt64,
+			MaxX:      math.MaxInt64,
+			MaxZ:      math.MaxInt64,
+		})
+		if result.Accepted || changed || result.Reason != "claim_out_of_bounds" {
+			t.Fatalf("expected claim_out_of_bounds for %s alignment, got %#v", alignment, result)
 		}
 	}
-	return "", 0, 0, false
+	wide, _ := hub.applyClaimCreate(claimCreatePayload{
+		PlayerID:  "greedy",
+		ActionID:  "claim-wide",
+		Alignment: "block",
+		MinX:      -maxClaimCell,
+		MinZ:      -maxClaimCell,
+		MaxX:      maxClaimCell,
+		MaxZ:      maxClaimCell,
+	})
+	if wide.Accepted || wide.Reason != "claim_too_large" {
+		t.Fatalf("expected claim_too_large for a world-wide claim, got %#v", wide)
+	}
+	hub.mu.Lock()
+	canEdit := hub.canEditCellLocked("stranger", 100000, -5000)
+	hub.mu.Unlock()
+	if !canEdit || len(hub.claimState().Claims) != 0 {
+		t.Fatalf("expected no claim to lock the world, got %#v", hub.claimState().Claims)
+	}
+
+	state := hub.exportState()
+	state.Claims = []runtimeLandClaim{
+		{ClaimID: "everything", OwnerID: "greedy", MinX: math.MinInt64, MinZ: math.MinInt64, MaxX: math.MaxInt64, MaxZ: math.MaxInt64},
+		{ClaimID: "wrapped", OwnerID: "greedy", MinX: math.MaxInt64 - 1, MinZ: 0, MaxX: math.MaxInt64, MaxZ: 1},
+		{ClaimID: "home", OwnerID: "greedy", MinX: 0, MinZ: 0, MaxX: 3, MaxZ: 3},
+	}
+	target := newWorldHub()
+	if _, err := target.importState(state); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	imported := target.claimState().Claims
+	if len(imported) != 1 || imported[0].ClaimID != "home" {
+		t.Fatalf("expected only the in-world claim imported, got %#v", imported)
+	}
 }
 
-func containsClient(clients []*clientConn, target *clientConn) bool {
-	for _, client := range clients {
-		if client == target {
-			return true
+func TestLandClaimDirectiveAndPersistence(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-claim-directive",
+		PlayerID:  "builder",
+		StartX:    0,
+		StartZ:    0,
+	})
+
+	ack := hub.ingestDirective(openclawDirectiveRequest{
+		DirectiveID: "claim-camp",
+		Type:        "land_claim",
+		Payload: map[string]any{
+			"action":    "create",
+			"claimId":   "camp",
+			"ownerId":   "openclaw",
+			"alignment": "block",
+			"minX":      float64(4),
+			"minZ":      float64(4),
+			"maxX":      float64(12),
+			"maxZ":      float64(12),
+			"members":   []any{"guide"},
+		},
+	})
+	if !ack.Accepted {
+		t.Fatalf("expected land_claim directive accepted, got %#v", ack)
+	}
+	if changed := hub.advanceOneTick(); changed || !hub.claimsChanged {
+		t.Fatalf("expected land_claim to mark claims changed without touching directive state")
+	}
+
+	blocked, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID: "builder",
+		Action:   "place",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	})
+	if blocked.Accepted || blocked.Reason != "claim_protected" {
+		t.Fatalf("expected directive claim to protect camp, got %#v", blocked)
+	}
+
+	exported := hub.exportState()
+	if len(exported.Claims) != 1 || exported.Claims[0].ClaimID != "camp" {
+		t.Fatalf("expected exported camp claim, got %#v", exported.Claims)
+	}
+
+	target := newWorldHub()
+	if _, err := target.importState(exported); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	imported := target.claimState()
+	if !reflect.DeepEqual(exported.Claims, imported.Claims) {
+		t.Fatalf("expected imported claims to match\nexpected=%#v\nactual=%#v", exported.Claims, imported.Claims)
+	}
+}
+
+func TestBuiltinContentMatchesRuntimeDefaults(t *testing.T) {
+	content, err := loadRuntimeContent("")
+	if err != nil {
+		t.Fatalf("load builtin content failed: %v", err)
+	}
+	expectedHotbar := []string{
+		"slot-1-rust-blade",
+		"slot-2-ember-bolt",
+		"slot-3-frost-bind",
+		"slot-4-bandage",
+		"slot-5-bomb",
+	}
+	if !reflect.DeepEqual(content.defaultHotbarSlotIDs, expectedHotbar) {
+		t.Fatalf("unexpected default hotbar %#v", content.defaultHotbarSlotIDs)
+	}
+	if !reflect.DeepEqual(content.defaultHotbarStackCounts(expectedHotbar), []int{0, 0, 0, 3, 2}) {
+		t.Fatalf("unexpected default stacks %#v", content.defaultHotbarStackCounts(expectedHotbar))
+	}
+	bomb := content.combatSlots["slot-5-bomb"]
+	if bomb.kind != "item" || bomb.cooldownTicks != 33 || bomb.damage != 4 || !bomb.requiresTarget {
+		t.Fatalf("unexpected bomb config %#v", bomb)
+	}
+	ingot, ok := content.recipes["craft-iron-ingot"]
+	if !ok || ingot.output.resourceID != "iron_ingot" || len(ingot.ingredients) != 2 {
+		t.Fatalf("unexpected iron ingot recipe %#v", ingot)
+	}
+	if len(content.resourceIDs) != 9 {
+		t.Fatalf("unexpected resource ids %#v", content.resourceIDs)
+	}
+	if content.catalog.Hash == "" || len(content.catalog.Recipes) != 7 {
+		t.Fatalf("expected hashed catalog with recipes, got %#v", content.catalog)
+	}
+}
+
+func TestContentPackValidationReportsProblems(t *testing.T) {
+	pack, err := readContentPack(os.DirFS("content/default"))
+	if err != nil {
+		t.Fatalf("read builtin pack failed: %v", err)
+	}
+	pack.Recipes = append(pack.Recipes,
+		contentRecipe{
+			ID:          "craft-mystery",
+			Ingredients: []contentIngredient{{ResourceID: "unobtainium", Amount: 1}},
+			Output:      contentRecipeOutput{ResourceID: "coal", Amount: 1},
+		},
+		contentRecipe{
+			ID:          "craft-negative",
+			Ingredients: []contentIngredient{{ResourceID: "wood", Amount: -2}},
+			Output:      contentRecipeOutput{ResourceID: "coal", Amount: 1},
+		},
+		contentRecipe{
+			ID:          "craft-ghost-slot",
+			Ingredients: []contentIngredient{{ResourceID: "wood", Amount: 1}},
+			Output:      contentRecipeOutput{TargetSlotID: "slot-9-missing", Amount: 1},
+		},
+		contentRecipe{
+			ID:          "repair-ember-bolt",
+			Ingredients: []contentIngredient{{ResourceID: "iron_ingot", Amount: 1}},
+			Output:      contentRecipeOutput{RepairSlotID: "slot-2-ember-bolt", Amount: 5},
+		},
+		contentRecipe{
+			ID:          "craft-at-anvil",
+			Station:     "anvil",
+			Ingredients: []contentIngredient{{ResourceID: "iron_ingot", Amount: 1}},
+			Output:      contentRecipeOutput{ResourceID: "coal", Amount: 1},
+		},
+	)
+
+	_, err = compileContentPack(pack)
+	var validationErr *contentValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected content validation error, got %v", err)
+	}
+	expected := []string{
+		`recipe "craft-mystery": unknown ingredient resource "unobtainium"`,
+		`recipe "craft-negative": ingredient "wood" amount must be positive`,
+		`recipe "craft-ghost-slot": output targets missing combat slot "slot-9-missing"`,
+		`recipe "repair-ember-bolt": repair targets combat slot "slot-2-ember-bolt" without durability`,
+		`recipe "craft-at-anvil": station "anvil" is not a defined resource`,
+	}
+	if !reflect.DeepEqual(validationErr.Problems, expected) {
+		t.Fatalf("unexpected validation problems %#v", validationErr.Problems)
+	}
+}
+
+func TestContentDirOverridesRecipesAndCombatSlots(t *testing.T) {
+	dir := t.TempDir()
+	writeTestContentPack(t, dir, func(name string, raw string) string {
+		switch name {
+		case contentCombatSlotsFile:
+			return strings.Replace(raw, `"damage": 4`, `"damage": 7`, 1)
+		case contentRecipesFile:
+			return strings.Replace(raw, `{ "resourceId": "wood", "amount": 2 }`, `{ "resourceId": "wood", "amount": 5 }`, 1)
 		}
+		return raw
+	})
+
+	content, err := loadRuntimeContent(dir)
+	if err != nil {
+		t.Fatalf("load content dir failed: %v", err)
+	}
+	if content.combatSlots["slot-5-bomb"].damage != 7 {
+		t.Fatalf("expected overridden bomb damage, got %#v", content.combatSlots["slot-5-bomb"])
+	}
+	if content.catalog.Hash == builtinContent.catalog.Hash {
+		t.Fatalf("expected catalog hash to change with content")
+	}
+
+	hub := newWorldHub()
+	hub.content = content
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-content",
+		PlayerID:  "crafter",
+	})
+	hub.awardInventoryResources("crafter", map[string]int{"wood": 4})
+	result, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "crafter",
+		ActionID: "craft-1",
+		RecipeID: "craft-charcoal",
+		Count:    1,
+	})
+	if result.Accepted || result.Reason != "insufficient_resources" {
+		t.Fatalf("expected overridden charcoal cost to reject craft, got %#v", result)
+	}
+
+	if err := os.Remove(filepath.Join(dir, contentRecipesFile)); err != nil {
+		t.Fatalf("remove recipes failed: %v", err)
+	}
+	if _, err := loadRuntimeContent(dir); err == nil {
+		t.Fatalf("expected missing recipes file to fail")
+	}
+}
+
+func TestReloadContentMigratesHotbarsAndKeepsOldContentOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	writeTestContentPack(t, dir, nil)
+
+	hub := newWorldHub()
+	hub.contentDir = dir
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-reload",
+		PlayerID:  "reloader",
+	})
+	hub.mu.Lock()
+	hotbar := hub.hotbarStates["reloader"]
+	hotbar.SelectedIndex = 4
+	hub.hotbarStates["reloader"] = cloneHotbarState(hotbar)
+	hub.mu.Unlock()
+
+	writeTestContentPack(t, dir, withoutThirdCombatSlot(t))
+
+	ack := hub.reloadContent()
+	if !ack.Accepted {
+		t.Fatalf("expected reload accepted, got %#v", ack)
+	}
+	if !reflect.DeepEqual(ack.MigratedPlayers, []string{"reloader"}) {
+		t.Fatalf("expected reloader hotbar migrated, got %#v", ack.MigratedPlayers)
+	}
+	migrated, _ := hub.hotbarStateForPlayer("reloader")
+	expectedSlots := []string{"slot-1-rust-blade", "slot-2-ember-bolt", "slot-4-bandage", "slot-5-bomb"}
+	if !reflect.DeepEqual(migrated.SlotIDs, expectedSlots) {
+		t.Fatalf("unexpected migrated slots %#v", migrated.SlotIDs)
+	}
+	if !reflect.DeepEqual(migrated.StackCounts, []int{1, 0, 3, 2}) {
+		t.Fatalf("expected stack counts preserved, got %#v", migrated.StackCounts)
+	}
+	if migrated.SelectedIndex != 3 {
+		t.Fatalf("expected selection to follow slot-5-bomb, got %d", migrated.SelectedIndex)
+	}
+	activeHash := hub.contentCatalog().Hash
+	if activeHash != ack.Hash || activeHash == builtinContent.catalog.Hash {
+		t.Fatalf("expected reloaded catalog hash to be active, got %q ack=%q", activeHash, ack.Hash)
+	}
+
+	writeTestContentPack(t, dir, func(name string, raw string) string {
+		if name != contentRecipesFile {
+			return raw
+		}
+		return strings.Replace(raw, `"resourceId": "fiber", "amount": 2`, `"resourceId": "silk", "amount": 2`, 1)
+	})
+	rejected := hub.reloadContent()
+	if rejected.Accepted || rejected.Reason != "content_invalid" {
+		t.Fatalf("expected invalid content rejected, got %#v", rejected)
+	}
+	if !reflect.DeepEqual(rejected.Problems, []string{`recipe "craft-bandage": unknown ingredient resource "silk"`}) {
+		t.Fatalf("unexpected reload problems %#v", rejected.Problems)
+	}
+	if hub.contentCatalog().Hash != activeHash {
+		t.Fatalf("expected failed reload to keep previous content")
+	}
+
+	recorder := httptest.NewRecorder()
+	buildContentReloadHandler(hub)(recorder, httptest.NewRequest(http.MethodPost, "/debug/reload-content", nil))
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 from reload handler, got %d", recorder.Code)
+	}
+	var handlerAck contentReloadAck
+	if err := json.Unmarshal(recorder.Body.Bytes(), &handlerAck); err != nil {
+		t.Fatalf("decode reload ack failed: %v", err)
+	}
+	if handlerAck.Accepted || len(handlerAck.Problems) != 1 {
+		t.Fatalf("unexpected handler ack %#v", handlerAck)
+	}
+}
+
+func TestReloadContentMigratesHotbarsOfLingeringPlayers(t *testing.T) {
+	dir := t.TempDir()
+	writeTestContentPack(t, dir, nil)
+
+	hub := newWorldHub()
+	hub.contentDir = dir
+	hub.lingerTicks = 2
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.addClient(client)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-reload-linger", PlayerID: "lingerer"})
+	hub.applyHotbarSelection(hotbarSelectPayload{PlayerID: "lingerer", SlotIndex: 4})
+	grant, _ := hub.sessionGrant("lingerer")
+	hub.removeClient(client)
+	for tick := 0; tick < 2; tick++ {
+		hub.advanceOneTick()
+	}
+	if _, parked := hub.departedPlayers["lingerer"]; !parked {
+		t.Fatalf("expected lingering player parked before the reload")
+	}
+
+	writeTestContentPack(t, dir, withoutThirdCombatSlot(t))
+	ack := hub.reloadContent()
+	if !ack.Accepted || !reflect.DeepEqual(ack.MigratedPlayers, []string{"lingerer"}) {
+		t.Fatalf("expected parked hotbar migrated, got %#v", ack)
+	}
+
+	rejoinClient := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.addClient(rejoinClient)
+	if reason := hub.handleJoin(rejoinClient, joinRuntimeRequest{WorldSeed: "seed-reload-linger", PlayerID: "lingerer", ResumeToken: grant.ResumeToken}); reason != "" {
+		t.Fatalf("expected rejoin accepted, got %q", reason)
+	}
+	restored, _ := hub.hotbarStateForPlayer("lingerer")
+	expectedSlots := []string{"slot-1-rust-blade", "slot-2-ember-bolt", "slot-4-bandage", "slot-5-bomb"}
+	if !reflect.DeepEqual(restored.SlotIDs, expectedSlots) {
+		t.Fatalf("expected restored hotbar to hold only current slots, got %#v", restored.SlotIDs)
+	}
+	if restored.SelectedIndex != 3 {
+		t.Fatalf("expected selection to follow slot-5-bomb, got %d", restored.SelectedIndex)
+	}
+}
+
+// withoutThirdCombatSlot edits a content pack to drop slot-3 from the combat
+// slots and the default hotbar.
+func withoutThirdCombatSlot(t *testing.T) func(name string, raw string) string {
+	return func(name string, raw string) string {
+		if name != contentCombatSlotsFile {
+			return raw
+		}
+		var slots contentCombatSlotsFileData
+		if err := json.Unmarshal([]byte(raw), &slots); err != nil {
+			t.Fatalf("decode combat slots failed: %v", err)
+		}
+		slots.Slots = append(slots.Slots[:2], slots.Slots[3:]...)
+		slots.DefaultHotbar = append(slots.DefaultHotbar[:2], slots.DefaultHotbar[3:]...)
+		encoded, err := json.Marshal(slots)
+		if err != nil {
+			t.Fatalf("encode combat slots failed: %v", err)
+		}
+		return string(encoded)
+	}
+}
+
+func TestReloadContentRefusesToRemoveHeldItems(t *testing.T) {
+	dir := t.TempDir()
+	withGlass := func(name string, raw string) string {
+		if name != contentItemsFile {
+			return raw
+		}
+		return strings.Replace(raw, `{ "id": "salvage", "label": "Salvage" },`, `{ "id": "salvage", "label": "Salvage" }, { "id": "glass", "label": "Glass" },`, 1)
+	}
+	writeTestContentPack(t, dir, withGlass)
+
+	hub := newWorldHub()
+	hub.contentDir = dir
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-held", PlayerID: "glazier"})
+	if ack := hub.reloadContent(); !ack.Accepted {
+		t.Fatalf("expected pack with glass accepted, got %#v", ack)
+	}
+	hub.awardInventoryResources("glazier", map[string]int{"glass": 3})
+	withGlassHash := hub.contentCatalog().Hash
+
+	writeTestContentPack(t, dir, nil)
+	rejected := hub.reloadContent()
+	if rejected.Accepted || rejected.Reason != "content_removes_held_items" {
+		t.Fatalf("expected reload removing held glass rejected, got %#v", rejected)
+	}
+	if !reflect.DeepEqual(rejected.Problems, []string{`inventory of player "glazier": holds 3 of removed item "glass"`}) {
+		t.Fatalf("unexpected held item problems %#v", rejected.Problems)
+	}
+	if hub.contentCatalog().Hash != withGlassHash {
+		t.Fatalf("expected refused reload to keep previous content")
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("glazier"); inventory.Resources["glass"] != 3 {
+		t.Fatalf("expected held glass kept, got %#v", inventory.Resources)
+	}
+
+	hub.mu.Lock()
+	state := hub.inventoryStates["glazier"]
+	takeItems(state.Slots, "glass", 3)
+	hub.syncInventoryLocked(&state)
+	hub.mu.Unlock()
+	if ack := hub.reloadContent(); !ack.Accepted {
+		t.Fatalf("expected reload accepted once nothing holds glass, got %#v", ack)
+	}
+}
+
+func TestTimedCraftQueueRequiresStationAndCompletesOverTicks(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-smelt",
+		PlayerID:  "smelter",
+	})
+	hub.awardInventoryResources("smelter", map[string]int{"iron_ore": 4, "coal": 2, "furnace": 1})
+
+	noStation, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "smelter",
+		ActionID: "smelt-0",
+		RecipeID: "craft-iron-ingot",
+		Count:    1,
+	})
+	if noStation.Accepted || noStation.Reason != "station_required" {
+		t.Fatalf("expected station_required without furnace, got %#v", noStation)
+	}
+
+	if placed, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID:  "smelter",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         8,
+		Y:         1,
+		Z:         8,
+		BlockType: "furnace",
+	}); !placed.Accepted {
+		t.Fatalf("expected furnace placement accepted, got %#v", placed)
+	}
+
+	for _, actionID := range []string{"smelt-1", "smelt-2"} {
+		queued, inventory, hotbar := hub.applyCraftRequest(craftRequestPayload{
+			PlayerID: "smelter",
+			ActionID: actionID,
+			RecipeID: "craft-iron-ingot",
+			Count:    1,
+		})
+		if !queued.Accepted || !queued.Queued || queued.JobID != actionID {
+			t.Fatalf("expected queued craft for %s, got %#v", actionID, queued)
+		}
+		if inventory == nil || hotbar != nil {
+			t.Fatalf("expected escrowed inventory update only for %s", actionID)
+		}
+	}
+	inventory, _ := hub.inventoryStateForPlayer("smelter")
+	if inventory.Resources["iron_ore"] != 0 || inventory.Resources["coal"] != 0 {
+		t.Fatalf("expected ingredients escrowed, got %#v", inventory.Resources)
+	}
+
+	cancelled := hub.applyCraftCancel(craftCancelPayload{
+		PlayerID: "smelter",
+		ActionID: "cancel-1",
+		JobID:    "smelt-2",
+	})
+	if cancelled.State != "cancelled" || cancelled.QueuePosition != 1 {
+		t.Fatalf("expected smelt-2 cancelled, got %#v", cancelled)
+	}
+	inventory, _ = hub.inventoryStateForPlayer("smelter")
+	if inventory.Resources["iron_ore"] != 2 || inventory.Resources["coal"] != 1 {
+		t.Fatalf("expected cancelled ingredients refunded, got %#v", inventory.Resources)
+	}
+	missing := hub.applyCraftCancel(craftCancelPayload{PlayerID: "smelter", ActionID: "cancel-2", JobID: "smelt-2"})
+	if missing.State != "cancel_rejected" || missing.Reason != "craft_job_not_found" {
+		t.Fatalf("expected craft_job_not_found, got %#v", missing)
+	}
+
+	for tick := 0; tick < 30; tick++ {
+		hub.advanceOneTick()
+	}
+	hub.mu.Lock()
+	hub.players["smelter"].X = 40
+	hub.mu.Unlock()
+	for tick := 0; tick < 40; tick++ {
+		hub.advanceOneTick()
+	}
+	stalled := hub.craftQueueState("smelter")
+	if len(stalled.Jobs) != 1 || stalled.Jobs[0].ProgressTicks != 30 || !stalled.Jobs[0].Stalled {
+		t.Fatalf("expected job stalled away from furnace, got %#v", stalled.Jobs)
+	}
+	hub.mu.Lock()
+	hub.players["smelter"].X = 0
+	hub.mu.Unlock()
+	for tick := 0; tick < 29; tick++ {
+		hub.advanceOneTick()
+	}
+	inventory, _ = hub.inventoryStateForPlayer("smelter")
+	if inventory.Resources["iron_ingot"] != 0 {
+		t.Fatalf("expected ingot not ready before duration, got %#v", inventory.Resources)
+	}
+	hub.advanceOneTick()
+	inventory, _ = hub.inventoryStateForPlayer("smelter")
+	if inventory.Resources["iron_ingot"] != 1 {
+		t.Fatalf("expected ingot after duration, got %#v", inventory.Resources)
+	}
+	if queue := hub.craftQueueState("smelter"); len(queue.Jobs) != 0 {
+		t.Fatalf("expected empty queue after completion, got %#v", queue.Jobs)
+	}
+
+	states := make([]string, 0)
+	for _, progress := range hub.drainCraftProgress() {
+		if progress.JobID == "smelt-1" {
+			states = append(states, progress.State)
+		}
+	}
+	if len(states) < 3 || states[0] != "queued" || states[len(states)-1] != "completed" {
+		t.Fatalf("unexpected progress states %#v", states)
+	}
+	foundStalled := false
+	for _, state := range states {
+		foundStalled = foundStalled || state == "stalled"
+	}
+	if !foundStalled {
+		t.Fatalf("expected stalled progress while away from furnace, got %#v", states)
+	}
+}
+
+func TestStationsArePlacedFromItemsAndIndexedByPosition(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-station", PlayerID: "builder", StartX: blockX + 1, StartZ: blockZ})
+	furnace := blockActionPayload{PlayerID: "builder", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 1, Z: 8, BlockType: "furnace"}
+
+	if result, delta := hub.applyBlockAction(furnace); result.Accepted || result.Reason != "station_item_required" || delta != nil {
+		t.Fatalf("expected furnace without the item rejected, got %#v", result)
+	}
+	hub.awardInventoryResources("builder", map[string]int{"furnace": 1})
+	if result, _ := hub.applyBlockAction(furnace); !result.Accepted {
+		t.Fatalf("expected furnace placed from the item, got %#v", result)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("builder"); inventory.Resources["furnace"] != 0 {
+		t.Fatalf("expected furnace item spent, got %#v", inventory.Resources)
+	}
+
+	hub.mu.Lock()
+	near := hub.stationNearPlayerLocked("builder", "furnace")
+	hub.players["builder"].X = blockX + craftStationRange + 1
+	far := hub.stationNearPlayerLocked("builder", "furnace")
+	hub.players["builder"].X = blockX - craftStationRange + 0.5
+	acrossCell := hub.stationNearPlayerLocked("builder", "furnace")
+	hub.players["builder"].X = blockX + 1
+	hub.mu.Unlock()
+	if !near || far || !acrossCell {
+		t.Fatalf("expected station found within range only, got near=%v far=%v acrossCell=%v", near, far, acrossCell)
+	}
+
+	target := newWorldHub()
+	if _, err := target.importState(hub.exportState()); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	target.mu.Lock()
+	imported := target.stationNearPlayerLocked("builder", "furnace")
+	target.mu.Unlock()
+	if !imported {
+		t.Fatalf("expected imported furnace indexed")
+	}
+
+	breakFurnace := furnace
+	breakFurnace.Action = "break"
+	if result, _ := hub.applyBlockAction(breakFurnace); !result.Accepted {
+		t.Fatalf("expected furnace broken, got %#v", result)
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.stationNearPlayerLocked("builder", "furnace") {
+		t.Fatalf("expected broken furnace removed from the index")
+	}
+	dropped := 0
+	for _, item := range hub.groundItems {
+		if item.Stack.ItemID == "furnace" && item.Source == "station" {
+			dropped++
+		}
+	}
+	if dropped != 1 {
+		t.Fatalf("expected broken furnace dropped as an item, got %#v", hub.groundItems)
+	}
+}
+
+func TestCraftQueuePersistsThroughExportImport(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-smelt-persist",
+		PlayerID:  "smelter",
+	})
+	hub.awardInventoryResources("smelter", map[string]int{"iron_ore": 2, "coal": 1, "furnace": 1})
+	hub.applyBlockAction(blockActionPayload{
+		PlayerID:  "smelter",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         8,
+		Y:         1,
+		Z:         8,
+		BlockType: "furnace",
+	})
+	if queued, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "smelter",
+		ActionID: "smelt-persist",
+		RecipeID: "craft-iron-ingot",
+		Count:    1,
+	}); !queued.Queued {
+		t.Fatalf("expected queued craft, got %#v", queued)
+	}
+	for tick := 0; tick < 10; tick++ {
+		hub.advanceOneTick()
+	}
+
+	exported := hub.exportState()
+	if len(exported.CraftQueues) != 1 || exported.CraftQueues[0].Jobs[0].ProgressTicks != 10 {
+		t.Fatalf("expected exported craft queue, got %#v", exported.CraftQueues)
+	}
+
+	target := newWorldHub()
+	if _, err := target.importState(exported); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	restored := target.craftQueueState("smelter")
+	if !reflect.DeepEqual(restored.Jobs, exported.CraftQueues[0].Jobs) {
+		t.Fatalf("expected restored queue\nexpected=%#v\nactual=%#v", exported.CraftQueues[0].Jobs, restored.Jobs)
+	}
+	for tick := 0; tick < 50; tick++ {
+		target.advanceOneTick()
+	}
+	inventory, _ := target.inventoryStateForPlayer("smelter")
+	if inventory.Resources["iron_ingot"] != 1 {
+		t.Fatalf("expected restored job to complete, got %#v", inventory.Resources)
+	}
+}
+
+func TestSlotInventoryEnforcesStackLimitsAndCapacity(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-slots",
+		PlayerID:  "hoarder",
+	})
+
+	state, ok := hub.awardInventoryResources("hoarder", map[string]int{"salvage": 130})
+	if !ok {
+		t.Fatalf("expected award accepted")
+	}
+	if state.Capacity != playerInventoryCapacity || len(state.Slots) != playerInventoryCapacity {
+		t.Fatalf("unexpected inventory capacity %d slots=%d", state.Capacity, len(state.Slots))
+	}
+	if state.Slots[0].Quantity != defaultItemMaxStack || state.Slots[1].Quantity != defaultItemMaxStack || state.Slots[2].Quantity != 2 {
+		t.Fatalf("expected salvage split into max stacks, got %#v", state.Slots[:3])
+	}
+	if state.Resources["salvage"] != 130 {
+		t.Fatalf("expected resource projection to total stacks, got %#v", state.Resources)
+	}
+
+	state, _ = hub.awardInventoryResources("hoarder", map[string]int{"stone": playerInventoryCapacity * defaultItemMaxStack})
+	if state.Resources["stone"] != (playerInventoryCapacity-3)*defaultItemMaxStack {
+		t.Fatalf("expected stone to fill remaining slots, got %#v", state.Resources)
+	}
+	overflowed := false
+	for _, event := range hub.listWorldEventsSince(0).Events {
+		if event.Type == "inventory_overflow" && event.Payload["itemId"] == "stone" {
+			overflowed = true
+		}
+	}
+	if !overflowed {
+		t.Fatalf("expected inventory_overflow event for stone")
+	}
+
+	full, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "hoarder",
+		ActionID: "craft-full",
+		RecipeID: "craft-charcoal",
+		Count:    1,
+	})
+	if full.Accepted || full.Reason != "insufficient_resources" {
+		t.Fatalf("expected charcoal craft without wood rejected, got %#v", full)
+	}
+}
+
+func TestInventorySlotOpsMoveBetweenInventoryHotbarAndContainers(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{
+		WorldSeed: "seed-slot-ops",
+		PlayerID:  "mover",
+	})
+	hub.awardInventoryResources("mover", map[string]int{"wood": 10})
+
+	result, updates := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:  "mover",
+		ActionID:  "move-1",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "hotbar", Index: 3},
+		To:        inventorySlotRef{Area: "inventory", Index: 5},
+		Quantity:  2,
+	})
+	if !result.Accepted || updates.inventory == nil || updates.hotbar == nil {
+		t.Fatalf("expected hotbar to inventory move accepted, got %#v", result)
+	}
+	if updates.hotbar.StackCounts[3] != 1 || updates.inventory.Slots[5].ItemID != "slot-4-bandage" || updates.inventory.Slots[5].Quantity != 2 {
+		t.Fatalf("unexpected move outcome hotbar=%#v inventory=%#v", updates.hotbar.StackCounts, updates.inventory.Slots[5])
+	}
+
+	mismatch, _ := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:  "mover",
+		ActionID:  "move-2",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "inventory", Index: 5},
+		To:        inventorySlotRef{Area: "hotbar", Index: 4},
+	})
+	if mismatch.Accepted || mismatch.Reason != "slot_mismatch" {
+		t.Fatalf("expected slot_mismatch moving bandage onto bomb slot, got %#v", mismatch)
+	}
+
+	renamed, updates := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:   "mover",
+		ActionID:   "rename-1",
+		Operation:  "rename",
+		From:       inventorySlotRef{Area: "inventory", Index: 5},
+		CustomName: "Lucky Wrap",
+	})
+	if !renamed.Accepted || updates.inventory.Slots[5].Instance == nil || updates.inventory.Slots[5].Instance.CustomName != "Lucky Wrap" {
+		t.Fatalf("expected renamed bandage stack, got %#v %#v", renamed, updates.inventory)
+	}
+	refill, updates := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:  "mover",
+		ActionID:  "move-3",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "hotbar", Index: 3},
+		To:        inventorySlotRef{Area: "inventory", Index: 5},
+	})
+	if !refill.Accepted {
+		t.Fatalf("expected swap of differing bandages accepted, got %#v", refill)
+	}
+	if updates.hotbar.Items[3].Instance == nil || updates.hotbar.StackCounts[3] != 2 || updates.inventory.Slots[5].Instance != nil {
+		t.Fatalf("expected named bandages swapped into hotbar, got hotbar=%#v inventory=%#v", updates.hotbar.Items[3], updates.inventory.Slots[5])
+	}
+
+	deposit, updates := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:  "mover",
+		ActionID:  "move-4",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "inventory", Index: 0},
+		To:        inventorySlotRef{Area: "container", ContainerID: playerPrivateContainerID("mover"), Index: 2},
+		Quantity:  4,
+	})
+	if !deposit.Accepted || updates.container == nil {
+		t.Fatalf("expected move into private stash accepted, got %#v", deposit)
+	}
+	if updates.container.Resources["wood"] != 4 || updates.inventory.Resources["wood"] != 6 {
+		t.Fatalf("unexpected projections container=%#v inventory=%#v", updates.container.Resources, updates.inventory.Resources)
+	}
+
+	forbidden, _ := hub.applyInventorySlotOp(inventorySlotOpPayload{
+		PlayerID:  "mover",
+		ActionID:  "move-5",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "inventory", Index: 0},
+		To:        inventorySlotRef{Area: "container", ContainerID: playerPrivateContainerID("someone-else"), Index: 0},
+	})
+	if forbidden.Accepted || forbidden.Reason != "container_forbidden" {
+		t.Fatalf("expected container_forbidden, got %#v", forbidden)
+	}
+}
+
+func TestImportStateMigratesLegacyResourceMapsToSlots(t *testing.T) {
+	hub := newWorldHub()
+	_, err := hub.importState(worldDebugState{
+		Snapshot: worldRuntimeSnapshot{
+			WorldSeed: "seed-legacy",
+			Tick:      5,
+			Players: map[string]runtimePlayerSnapshot{
+				"legacy": {X: 0, Z: 0},
+			},
+		},
+		HotbarStates: []runtimeHotbarState{{
+			PlayerID:    "legacy",
+			SlotIDs:     []string{"slot-1-rust-blade", "slot-4-bandage"},
+			StackCounts: []int{0, 4},
+		}},
+		InventoryStates: []runtimeInventoryState{{
+			PlayerID:  "legacy",
+			Resources: map[string]int{"wood": 70, "coal": 3},
+		}},
+		ContainerStates: []runtimeContainerState{{
+			ContainerID: worldSharedContainerID,
+			Resources:   map[string]int{"salvage": 2},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("import legacy state failed: %v", err)
+	}
+
+	inventory, _ := hub.inventoryStateForPlayer("legacy")
+	if inventory.Resources["wood"] != 70 || inventory.Resources["coal"] != 3 {
+		t.Fatalf("expected legacy resources preserved, got %#v", inventory.Resources)
+	}
+	if inventory.Slots[0].ItemID != "wood" || inventory.Slots[0].Quantity != defaultItemMaxStack || inventory.Slots[1].Quantity != 6 {
+		t.Fatalf("expected wood split across slots, got %#v", inventory.Slots[:3])
+	}
+	hotbar, _ := hub.hotbarStateForPlayer("legacy")
+	if hotbar.Items[1].ItemID != "slot-4-bandage" || hotbar.Items[1].Quantity != 4 {
+		t.Fatalf("expected hotbar items built from stack counts, got %#v", hotbar.Items)
+	}
+	blade := hotbar.Items[0]
+	if blade.ItemID != "slot-1-rust-blade" || blade.Quantity != 1 || blade.Instance == nil || blade.Instance.Durability != blade.Instance.MaxDurability {
+		t.Fatalf("expected legacy blade equipped as an unworn item, got %#v", blade)
+	}
+	container, _ := hub.containerState(worldSharedContainerID)
+	if container.Capacity != sharedContainerCapacity || container.Resources["salvage"] != 2 {
+		t.Fatalf("expected container slots from legacy resources, got %#v", container)
+	}
+}
+
+func TestHotbarAssignAndSwapCustomiseSlots(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-hotbar", PlayerID: "hb1"})
+
+	unequip, updates := hub.applyHotbarAssign(hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-1", SlotIndex: 3})
+	if !unequip.Accepted || unequip.Operation != "unequip" {
+		t.Fatalf("expected bandage unequip accepted, got %#v", unequip)
+	}
+	if updates.hotbar.SlotIDs[3] != "" || updates.hotbar.StackCounts[3] != 0 {
+		t.Fatalf("expected hotbar position 3 cleared, got %#v", updates.hotbar)
+	}
+	if countItems(updates.inventory.Slots, "slot-4-bandage") != 3 {
+		t.Fatalf("expected bandages returned to inventory, got %#v", updates.inventory.Resources)
+	}
+	notEquipped, _, _, _ := hub.applyCombatAction(combatActionPayload{
+		PlayerID: "hb1",
+		ActionID: "hb-combat-1",
+		SlotID:   "slot-4-bandage",
+		Kind:     "item",
+	})
+	if notEquipped.Accepted || notEquipped.Reason != "slot_not_equipped" {
+		t.Fatalf("expected slot_not_equipped after unequip, got %#v", notEquipped)
+	}
+
+	equip, updates := hub.applyHotbarAssign(hotbarAssignPayload{
+		PlayerID:  "hb1",
+		ActionID:  "hb-2",
+		SlotIndex: 6,
+		SlotID:    "slot-4-bandage",
+	})
+	if !equip.Accepted {
+		t.Fatalf("expected bandage equip accepted, got %#v", equip)
+	}
+	if len(updates.hotbar.SlotIDs) != 7 || updates.hotbar.SlotIDs[6] != "slot-4-bandage" || updates.hotbar.StackCounts[6] != 3 {
+		t.Fatalf("expected bandage stack at padded position 6, got %#v", updates.hotbar)
+	}
+	if countItems(updates.inventory.Slots, "slot-4-bandage") != 0 {
+		t.Fatalf("expected bandages moved out of inventory, got %#v", updates.inventory.Resources)
+	}
+	used, _, _, _ := hub.applyCombatAction(combatActionPayload{
+		PlayerID: "hb1",
+		ActionID: "hb-combat-2",
+		SlotID:   "slot-4-bandage",
+		Kind:     "item",
+	})
+	if !used.Accepted {
+		t.Fatalf("expected bandage usable from reordered position, got %#v", used)
+	}
+	if state, _ := hub.hotbarStateForPlayer("hb1"); state.StackCounts[6] != 2 {
+		t.Fatalf("expected bandage consumed from position 6, got %#v", state.StackCounts)
+	}
+
+	for _, tc := range []struct {
+		payload hotbarAssignPayload
+		reason  string
+	}{
+		{hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-3", SlotIndex: 0, SlotID: "slot-5-bomb"}, "slot_already_equipped"},
+		{hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-4", SlotIndex: 3, SlotID: "slot-9-unknown"}, "unknown_slot"},
+		{hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-5", SlotIndex: maxHotbarSlots, SlotID: "slot-1-rust-blade"}, "invalid_slot"},
+	} {
+		rejected, rejectedUpdates := hub.applyHotbarAssign(tc.payload)
+		if rejected.Accepted || rejected.Reason != tc.reason || rejectedUpdates.hotbar != nil {
+			t.Fatalf("expected %s, got %#v", tc.reason, rejected)
+		}
+	}
+
+	swap, swapped := hub.applyHotbarSwap(hotbarSwapPayload{PlayerID: "hb1", ActionID: "hb-6", FromIndex: 0, ToIndex: 4})
+	if !swap.Accepted || swapped == nil {
+		t.Fatalf("expected swap accepted, got %#v", swap)
+	}
+	if swapped.SlotIDs[0] != "slot-5-bomb" || swapped.SlotIDs[4] != "slot-1-rust-blade" || swapped.StackCounts[0] != 2 {
+		t.Fatalf("expected bomb and blade swapped with stacks, got %#v", swapped)
+	}
+	if swapped.SelectedIndex != 4 {
+		t.Fatalf("expected selection to follow the blade, got %d", swapped.SelectedIndex)
+	}
+
+	if _, updates = hub.applyHotbarAssign(hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-7", SlotIndex: 0}); countItems(updates.inventory.Slots, "slot-5-bomb") != 2 {
+		t.Fatalf("expected bombs returned to inventory, got %#v", updates.inventory)
+	}
+	hub.awardInventoryResources("hb1", map[string]int{"coal": 2, "fiber": 1})
+	craft, inventoryState, hotbarState := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "hb1",
+		ActionID: "hb-craft",
+		RecipeID: "craft-bomb",
+		Count:    1,
+	})
+	if !craft.Accepted || hotbarState != nil {
+		t.Fatalf("expected unequipped bomb craft accepted into inventory, got %#v %#v", craft, hotbarState)
+	}
+	if countItems(inventoryState.Slots, "slot-5-bomb") != 3 {
+		t.Fatalf("expected crafted bomb in inventory, got %#v", inventoryState.Resources)
+	}
+
+	hub.mu.Lock()
+	inventory := hub.ensureInventoryStateLocked("hb1")
+	removeItems(inventory.Slots, "slot-5-bomb", 3)
+	hub.syncInventoryLocked(&inventory)
+	hub.mu.Unlock()
+	notOwned, _ := hub.applyHotbarAssign(hotbarAssignPayload{PlayerID: "hb1", ActionID: "hb-8", SlotIndex: 0, SlotID: "slot-5-bomb"})
+	if notOwned.Accepted || notOwned.Reason != "item_not_owned" {
+		t.Fatalf("expected item_not_owned, got %#v", notOwned)
+	}
+}
+
+func TestSlotDurabilityWearsBreaksAndRepairs(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-durability", PlayerID: "dur1"})
+
+	state, _ := hub.hotbarStateForPlayer("dur1")
+	blade := state.Items[0]
+	if blade.ItemID != "slot-1-rust-blade" || blade.Quantity != 1 || blade.Instance == nil || blade.Instance.InstanceID == "" || blade.Instance.Durability != 40 || blade.Instance.MaxDurability != 40 {
+		t.Fatalf("expected a fresh rust blade instance equipped, got %#v", blade)
+	}
+	bladeInstanceID := blade.Instance.InstanceID
+	notNeeded, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "dur1",
+		ActionID: "repair-early",
+		RecipeID: "repair-rust-blade",
+		Count:    1,
+	})
+	if notNeeded.Accepted || notNeeded.Reason != "repair_not_needed" {
+		t.Fatalf("expected repair_not_needed, got %#v", notNeeded)
+	}
+
+	hub.mu.Lock()
+	worn := cloneHotbarState(hub.hotbarStates["dur1"])
+	worn.Items[0].Instance.Durability = 1
+	hub.hotbarStates["dur1"] = worn
+	hub.mu.Unlock()
+
+	swing := func(actionID string) runtimeCombatResult {
+		result, _, _, _ := hub.applyCombatAction(combatActionPayload{
+			PlayerID:     "dur1",
+			ActionID:     actionID,
+			SlotID:       "slot-1-rust-blade",
+			Kind:         "melee",
+			TargetWorldX: floatPtr(1),
+			TargetWorldZ: floatPtr(0),
+		})
+		return result
+	}
+	if result := swing("swing-1"); !result.Accepted {
+		t.Fatalf("expected last swing accepted, got %#v", result)
+	}
+	state, _ = hub.hotbarStateForPlayer("dur1")
+	if slotDurability(state, "slot-1-rust-blade") != 0 {
+		t.Fatalf("expected blade worn to zero, got %#v", state.Items[0])
+	}
+	broken := false
+	for _, event := range hub.eventLog {
+		if event.Type == "slot_broken" && event.Payload["slotId"] == "slot-1-rust-blade" && event.Payload["instanceId"] == bladeInstanceID {
+			broken = true
+		}
+	}
+	if !broken {
+		t.Fatalf("expected slot_broken world event")
+	}
+	if result := swing("swing-2"); result.Accepted || result.Reason != "slot_broken" {
+		t.Fatalf("expected slot_broken rejection, got %#v", result)
+	}
+
+	hub.awardInventoryResources("dur1", map[string]int{"iron_ingot": 3})
+	if tooMany, _, _ := hub.applyCraftRequest(craftRequestPayload{PlayerID: "dur1", ActionID: "repair-3", RecipeID: "repair-rust-blade", Count: 3}); tooMany.Accepted || tooMany.Reason != "repair_count_exceeds_need" {
+		t.Fatalf("expected a third repair past full durability refused, got %#v", tooMany)
+	}
+	repair, inventoryState, hotbarState := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "dur1",
+		ActionID: "repair-1",
+		RecipeID: "repair-rust-blade",
+		Count:    1,
+	})
+	if !repair.Accepted || hotbarState == nil || slotDurability(*hotbarState, "slot-1-rust-blade") != 20 {
+		t.Fatalf("expected repair to restore 20 durability, got %#v %#v", repair, hotbarState)
+	}
+	if inventoryState.Resources["iron_ingot"] != 2 {
+		t.Fatalf("expected one iron ingot consumed, got %#v", inventoryState.Resources)
+	}
+
+	_, updates := hub.applyHotbarAssign(hotbarAssignPayload{PlayerID: "dur1", ActionID: "unequip-blade", SlotIndex: 0})
+	var stowed *runtimeItemInstance
+	for _, stack := range updates.inventory.Slots {
+		if stack.ItemID == "slot-1-rust-blade" {
+			stowed = stack.Instance
+		}
+	}
+	if stowed == nil || stowed.InstanceID != bladeInstanceID || stowed.Durability != 20 {
+		t.Fatalf("expected the worn blade moved to the inventory, got %#v", updates.inventory.Slots)
+	}
+	if result, _, _ := hub.applyCraftRequest(craftRequestPayload{PlayerID: "dur1", ActionID: "repair-2", RecipeID: "repair-rust-blade", Count: 1}); result.Reason != "repair_target_missing" {
+		t.Fatalf("expected repair_target_missing while unequipped, got %#v", result)
+	}
+
+	target := newWorldHub()
+	if _, err := target.importState(hub.exportState()); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	if result, updates := target.applyHotbarAssign(hotbarAssignPayload{PlayerID: "dur1", ActionID: "equip-blade", SlotIndex: 0, SlotID: "slot-1-rust-blade"}); !result.Accepted || updates.hotbar.Items[0].Instance.InstanceID != bladeInstanceID || slotDurability(*updates.hotbar, "slot-1-rust-blade") != 20 {
+		t.Fatalf("expected the persisted blade re-equipped with its wear, got %#v %#v", result, updates.hotbar)
+	}
+	if again, _ := target.applyHotbarAssign(hotbarAssignPayload{PlayerID: "dur1", ActionID: "equip-blade-2", SlotIndex: 1, SlotID: "slot-1-rust-blade"}); again.Accepted {
+		t.Fatalf("expected no second blade to equip, got %#v", again)
+	}
+}
+
+func TestGroundItemsDropPickupReserveDespawnAndPersist(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-ground", PlayerID: "dropper"})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-ground", PlayerID: "mate", StartX: 40})
+	hub.awardInventoryResources("dropper", map[string]int{"fiber": 5})
+
+	drop, updates := hub.applyDropItem(dropItemPayload{
+		PlayerID: "dropper",
+		ActionID: "drop-1",
+		From:     inventorySlotRef{Area: "inventory", Index: 0},
+		Quantity: 3,
+	})
+	if !drop.Accepted || drop.GroundItemID == "" {
+		t.Fatalf("expected drop accepted, got %#v", drop)
+	}
+	if updates.inventory.Resources["fiber"] != 2 {
+		t.Fatalf("expected fiber removed from inventory, got %#v", updates.inventory.Resources)
+	}
+	hub.advanceOneTick()
+	if _, ok := hub.groundItems[drop.GroundItemID]; !ok {
+		t.Fatalf("expected dropper standing on own drop not to pick it up")
+	}
+
+	hub.mu.Lock()
+	far := hub.snapshotForClientLocked(&clientConn{playerIDs: map[string]struct{}{"mate": {}}}, 10)
+	hub.players["mate"].X = 0.5
+	near := hub.snapshotForClientLocked(&clientConn{playerIDs: map[string]struct{}{"mate": {}}}, 10)
+	hub.mu.Unlock()
+	if len(far.GroundItems) != 0 || len(near.GroundItems) != 1 || near.GroundItems[0].Stack.Quantity != 3 {
+		t.Fatalf("expected ground items replicated only within radius, got far=%#v near=%#v", far.GroundItems, near.GroundItems)
+	}
+
+	hub.advanceOneTick()
+	if _, ok := hub.groundItems[drop.GroundItemID]; ok {
+		t.Fatalf("expected teammate to pick up drop by walking over it")
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("mate"); inventory.Resources["fiber"] != 3 {
+		t.Fatalf("expected teammate to receive fiber, got %#v", inventory.Resources)
+	}
+	if pickups := hub.drainGroundPickups(); !reflect.DeepEqual(pickups, []string{"mate"}) {
+		t.Fatalf("expected pickup outbox for mate, got %#v", pickups)
+	}
+
+	hub.mu.Lock()
+	reserved := hub.spawnGroundItemLocked(runtimeItemStack{ItemID: "coal", Quantity: 2}, 2.5, 0, "death", "dropper", "dropper")
+	hub.mu.Unlock()
+	interact := func(actionID string) runtimeInteractResult {
+		return hub.applyInteractAction(interactActionPayload{PlayerID: "mate", ActionID: actionID, TargetID: reserved.GroundItemID})
+	}
+	if result := interact("grab-1"); result.Accepted || result.Reason != "ground_item_reserved" {
+		t.Fatalf("expected ground_item_reserved, got %#v", result)
+	}
+	hub.mu.Lock()
+	hub.tick = reserved.OwnerUntilTick
+	hub.mu.Unlock()
+	if result := interact("grab-2"); !result.Accepted {
+		t.Fatalf("expected pickup after ownership window, got %#v", result)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("mate"); inventory.Resources["coal"] != 2 {
+		t.Fatalf("expected coal picked up via interact, got %#v", inventory.Resources)
+	}
+
+	hub.mu.Lock()
+	expiring := hub.spawnGroundItemLocked(runtimeItemStack{ItemID: "stone", Quantity: 1}, -20, 0, "drop", "dropper", "")
+	lingering := hub.spawnGroundItemLocked(runtimeItemStack{ItemID: "wood", Quantity: 4}, -20, 0, "drop", "dropper", "")
+	expiringItem := hub.groundItems[expiring.GroundItemID]
+	expiringItem.DespawnTick = hub.tick + 1
+	hub.groundItems[expiring.GroundItemID] = expiringItem
+	hub.mu.Unlock()
+	hub.advanceOneTick()
+	if _, ok := hub.groundItems[expiring.GroundItemID]; ok {
+		t.Fatalf("expected ground item to despawn")
+	}
+
+	target := newWorldHub()
+	if _, err := target.importState(hub.exportState()); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	restored, ok := target.groundItems[lingering.GroundItemID]
+	if !ok || restored.Stack.Quantity != 4 || target.groundItemSeq != hub.groundItemSeq {
+		t.Fatalf("expected ground items persisted, got %#v seq=%d", target.groundItems, target.groundItemSeq)
+	}
+}
+
+func TestSnapshotsCarryGroundItemChangesInSpawnOrder(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-ground-delta", PlayerID: "watcher", StartX: 20})
+
+	hub.mu.Lock()
+	for index := 0; index < 11; index++ {
+		hub.spawnGroundItemLocked(runtimeItemStack{ItemID: "fiber", Quantity: 2}, 21, 0, "drop", "", "")
+	}
+	first := hub.snapshotForClientLocked(client, 10)
+	if len(first.GroundItems) != 11 || first.GroundItems[1].GroundItemID != "ground-2" || first.GroundItems[10].GroundItemID != "ground-11" {
+		t.Fatalf("expected every ground item in spawn order, got %#v", first.GroundItems)
+	}
+	if second := hub.snapshotForClientLocked(client, 10); len(second.GroundItems) != 0 || len(second.GroundItemsRemoved) != 0 {
+		t.Fatalf("expected unchanged ground items left out, got %#v removed=%#v", second.GroundItems, second.GroundItemsRemoved)
+	}
+
+	item := hub.groundItems["ground-3"]
+	item.Stack.Quantity = 1
+	hub.groundItems["ground-3"] = item
+	hub.removeGroundItemLocked("ground-10", "despawned")
+	hub.removeGroundItemLocked("ground-2", "despawned")
+	changed := hub.snapshotForClientLocked(client, 10)
+	if len(changed.GroundItems) != 1 || changed.GroundItems[0].GroundItemID != "ground-3" || changed.GroundItems[0].Stack.Quantity != 1 {
+		t.Fatalf("expected only the changed ground item, got %#v", changed.GroundItems)
+	}
+	if !reflect.DeepEqual(changed.GroundItemsRemoved, []string{"ground-2", "ground-10"}) {
+		t.Fatalf("expected removed ground items listed in spawn order, got %#v", changed.GroundItemsRemoved)
+	}
+
+	hub.players["watcher"].X = 60
+	moved := hub.snapshotForClientLocked(client, 10)
+	hub.mu.Unlock()
+	if len(moved.GroundItemsRemoved) != 9 {
+		t.Fatalf("expected ground items out of range removed, got %#v", moved.GroundItemsRemoved)
+	}
+	if exported := hub.exportState(); len(exported.Snapshot.GroundItems) != 0 || len(exported.GroundItems) != 9 {
+		t.Fatalf("expected ground items exported once, got snapshot=%d top-level=%d", len(exported.Snapshot.GroundItems), len(exported.GroundItems))
+	}
+}
+
+func TestOverflowAndPlayerDefeatDropGroundItems(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-ground-death", PlayerID: "attacker"})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-ground-death", PlayerID: "victim", StartX: 2})
+
+	hub.awardInventoryResources("victim", map[string]int{"stone": playerInventoryCapacity*defaultItemMaxStack + 5})
+	hub.mu.Lock()
+	overflow := make([]runtimeGroundItem, 0)
+	for _, item := range hub.groundItems {
+		overflow = append(overflow, item)
+	}
+	hub.mu.Unlock()
+	if len(overflow) != 1 || overflow[0].Source != "overflow" || overflow[0].Stack.Quantity != 5 || overflow[0].OwnerID != "victim" {
+		t.Fatalf("expected overflow pile reserved for victim, got %#v", overflow)
+	}
+
+	hub.mu.Lock()
+	health := hub.ensureHealthStateLocked("victim")
+	health.Current = 1
+	hub.healthStates["victim"] = health
+	hub.mu.Unlock()
+	result, _, inventoryUpdates, worldEvents := hub.applyCombatAction(combatActionPayload{
+		PlayerID: "attacker",
+		ActionID: "finish",
+		SlotID:   "slot-1-rust-blade",
+		Kind:     "melee",
+		TargetID: "victim",
+	})
+	if !result.Accepted {
+		t.Fatalf("expected finishing blow accepted, got %#v", result)
+	}
+	if len(worldEvents) != 1 || worldEvents[0].Type != "player_defeated" {
+		t.Fatalf("expected player_defeated event, got %#v", worldEvents)
+	}
+	if len(inventoryUpdates) != 1 || inventoryUpdates[0].PlayerID != "victim" || inventoryUpdates[0].Resources["stone"] != 0 {
+		t.Fatalf("expected victim inventory emptied, got %#v", inventoryUpdates)
+	}
+	hub.mu.Lock()
+	groundCount := len(hub.groundItems)
+	hub.mu.Unlock()
+	if groundCount != playerInventoryCapacity+1 {
+		t.Fatalf("expected one death pile per slot plus overflow, got %d", groundCount)
+	}
+
+	hub.advanceOneTick()
+	if inventory, _ := hub.inventoryStateForPlayer("victim"); inventory.Resources["stone"] != 5 {
+		t.Fatalf("expected victim to walk-over only the overflow pile, got %#v", inventory.Resources)
+	}
+}
+
+func TestHotbarAssignRequiresUnlockedNonItemSlots(t *testing.T) {
+	dir := t.TempDir()
+	writeTestContentPack(t, dir, func(name string, raw string) string {
+		if name != contentCombatSlotsFile {
+			return raw
+		}
+		return strings.Replace(raw, `"slots": [`, `"slots": [
+    { "id": "slot-6-storm-call", "label": "Storm Call", "kind": "spell", "cooldownTicks": 40, "maxRange": 9, "requiresTarget": true, "damage": 5 },`, 1)
+	})
+	content, err := loadRuntimeContent(dir)
+	if err != nil {
+		t.Fatalf("load content dir failed: %v", err)
+	}
+	hub := newWorldHub()
+	hub.content = content
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-unlock", PlayerID: "caster"})
+
+	assign := func(actionID string, slotIndex int, slotID string) runtimeHotbarResult {
+		result, _ := hub.applyHotbarAssign(hotbarAssignPayload{PlayerID: "caster", ActionID: actionID, SlotIndex: slotIndex, SlotID: slotID})
+		return result
+	}
+	if result := assign("u-1", 6, "slot-6-storm-call"); result.Accepted || result.Reason != "slot_not_owned" {
+		t.Fatalf("expected a spell the player never unlocked refused, got %#v", result)
+	}
+	if result := assign("u-2", 0, ""); !result.Accepted {
+		t.Fatalf("expected blade unequip accepted, got %#v", result)
+	}
+	if result := assign("u-3", 7, "slot-1-rust-blade"); !result.Accepted {
+		t.Fatalf("expected default blade re-equipped, got %#v", result)
+	}
+
+	hub.mu.Lock()
+	hotbar := hub.hotbarStates["caster"]
+	hotbar.Unlocked = append(hotbar.Unlocked, "slot-6-storm-call")
+	hub.hotbarStates["caster"] = hotbar
+	hub.mu.Unlock()
+	if result := assign("u-4", 6, "slot-6-storm-call"); !result.Accepted {
+		t.Fatalf("expected unlocked spell equipped, got %#v", result)
+	}
+	if result := assign("u-5", 6, ""); !result.Accepted {
+		t.Fatalf("expected unlocked spell unequipped, got %#v", result)
+	}
+	if state, _ := hub.hotbarStateForPlayer("caster"); !containsString(state.Unlocked, "slot-6-storm-call") {
+		t.Fatalf("expected unequipping to keep the spell unlocked, got %#v", state.Unlocked)
+	}
+}
+
+func TestTradeEscrowsOffersAndSettlesAfterBothConfirm(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-trade", PlayerID: "ann"})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-trade", PlayerID: "bo", StartX: 2})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-trade", PlayerID: "far", StartX: 50})
+	hub.awardInventoryResources("ann", map[string]int{"fiber": 5})
+	hub.awardInventoryResources("bo", map[string]int{"coal": 4})
+
+	if result, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "ann", ActionID: "t-0", PartnerID: "far"}); result.Reason != "partner_out_of_range" {
+		t.Fatalf("expected partner_out_of_range, got %#v", result)
+	}
+	invite, updates := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "ann", ActionID: "t-1", PartnerID: "bo"})
+	if !invite.Accepted || updates.session.State != "pending" {
+		t.Fatalf("expected pending trade, got %#v %#v", invite, updates.session)
+	}
+	tradeID := invite.TradeID
+	if result, _ := hub.applyTradeOfferUpdate(tradeOfferUpdatePayload{PlayerID: "bo", ActionID: "t-2", TradeID: tradeID, Items: map[string]int{"coal": 1}}); result.Reason != "trade_not_open" {
+		t.Fatalf("expected trade_not_open before acceptance, got %#v", result)
+	}
+	if result, updates := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "bo", ActionID: "t-3", PartnerID: "ann"}); !result.Accepted || result.TradeID != tradeID || updates.session.State != "open" {
+		t.Fatalf("expected partner request to open trade, got %#v", result)
+	}
+
+	offer := func(playerID string, actionID string, items map[string]int) (runtimeTradeResult, tradeUpdates) {
+		return hub.applyTradeOfferUpdate(tradeOfferUpdatePayload{PlayerID: playerID, ActionID: actionID, TradeID: tradeID, Items: items})
+	}
+	confirm := func(playerID string, actionID string, revision int) (runtimeTradeResult, tradeUpdates) {
+		return hub.applyTradeConfirm(tradeConfirmPayload{PlayerID: playerID, ActionID: actionID, TradeID: tradeID, Revision: revision})
+	}
+	if result, updates := offer("ann", "t-4", map[string]int{"fiber": 3}); !result.Accepted || updates.inventories[0].Resources["fiber"] != 2 {
+		t.Fatalf("expected fiber escrowed, got %#v %#v", result, updates.inventories)
+	}
+	if result, _ := offer("ann", "t-5", map[string]int{"fiber": 10}); result.Reason != "insufficient_items" {
+		t.Fatalf("expected insufficient_items, got %#v", result)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("ann"); inventory.Resources["fiber"] != 2 {
+		t.Fatalf("expected rejected offer to leave escrow intact, got %#v", inventory.Resources)
+	}
+	if result, updates := offer("bo", "t-6", map[string]int{"coal": 4}); !result.Accepted || updates.session.Revision != 2 {
+		t.Fatalf("expected coal offer at revision 2, got %#v", result)
+	}
+	if result, _ := confirm("ann", "t-7", 1); result.Reason != "stale_revision" {
+		t.Fatalf("expected stale_revision, got %#v", result)
+	}
+	if result, updates := confirm("ann", "t-8", 2); !result.Accepted || updates.session.State != "open" || !updates.session.offerFor("ann").Confirmed {
+		t.Fatalf("expected first confirmation recorded, got %#v", result)
+	}
+	if _, updates := offer("bo", "t-9", map[string]int{"coal": 3}); updates.session.offerFor("ann").Confirmed || updates.session.Revision != 3 {
+		t.Fatalf("expected offer change to clear confirmations, got %#v", updates.session)
+	}
+	if result, _ := confirm("ann", "t-10", 3); !result.Accepted {
+		t.Fatalf("expected confirm at revision 3, got %#v", result)
+	}
+	settle, updates := confirm("bo", "t-11", 3)
+	if !settle.Accepted || updates.session.State != "settled" || len(updates.inventories) != 2 {
+		t.Fatalf("expected trade settled, got %#v %#v", settle, updates.session)
+	}
+	annInventory, _ := hub.inventoryStateForPlayer("ann")
+	boInventory, _ := hub.inventoryStateForPlayer("bo")
+	if annInventory.Resources["fiber"] != 2 || annInventory.Resources["coal"] != 3 {
+		t.Fatalf("unexpected initiator inventory after settlement %#v", annInventory.Resources)
+	}
+	if boInventory.Resources["fiber"] != 3 || boInventory.Resources["coal"] != 1 {
+		t.Fatalf("unexpected partner inventory after settlement %#v", boInventory.Resources)
+	}
+	if len(hub.trades) != 0 {
+		t.Fatalf("expected settled trade removed, got %#v", hub.trades)
+	}
+	settled := false
+	for _, event := range hub.eventLog {
+		if event.Type == "trade_settled" && event.Payload["tradeId"] == tradeID {
+			settled = true
+		}
+	}
+	if !settled {
+		t.Fatalf("expected trade_settled audit event")
+	}
+
+	second, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "ann", ActionID: "t-12", PartnerID: "bo"})
+	hub.applyTradeRequest(tradeRequestPayload{PlayerID: "bo", ActionID: "t-13", PartnerID: "ann"})
+	hub.applyTradeOfferUpdate(tradeOfferUpdatePayload{PlayerID: "ann", ActionID: "t-14", TradeID: second.TradeID, Items: map[string]int{"coal": 3}})
+	if result, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "far", ActionID: "t-15", PartnerID: "ann"}); result.Reason != "partner_out_of_range" && result.Reason != "trade_busy" {
+		t.Fatalf("expected busy partner rejected, got %#v", result)
+	}
+	hub.handleLeave(client, "bo")
+	if inventory, _ := hub.inventoryStateForPlayer("ann"); inventory.Resources["coal"] != 3 {
+		t.Fatalf("expected escrow refunded when partner left, got %#v", inventory.Resources)
+	}
+	drained := hub.drainTradeUpdates()
+	if len(drained) != 1 || drained[0].session.State != "cancelled" || drained[0].session.Reason != "player_left" {
+		t.Fatalf("expected queued cancellation update, got %#v", drained)
+	}
+}
+
+func TestPendingTradeInvitesExpireAfterTheirTTL(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	for index, playerID := range []string{"ann", "bo", "cy", "di"} {
+		hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-trade-ttl", PlayerID: playerID, StartX: float64(index)})
+	}
+	invite, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "ann", ActionID: "ttl-1", PartnerID: "bo"})
+	opened, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "cy", ActionID: "ttl-2", PartnerID: "di"})
+	hub.applyTradeRequest(tradeRequestPayload{PlayerID: "di", ActionID: "ttl-3", PartnerID: "cy"})
+	if !invite.Accepted || !opened.Accepted {
+		t.Fatalf("expected both trades started, got %#v %#v", invite, opened)
+	}
+
+	for tick := 0; tick < tradeInviteTTLTicks-1; tick++ {
+		hub.advanceOneTick()
+	}
+	if session, ok := hub.trades[invite.TradeID]; !ok || session.State != "pending" {
+		t.Fatalf("expected invite still pending before its ttl, got %#v", session)
+	}
+	hub.advanceOneTick()
+	if _, ok := hub.trades[invite.TradeID]; ok {
+		t.Fatalf("expected unanswered invite removed at its ttl")
+	}
+	if session, ok := hub.trades[opened.TradeID]; !ok || session.State != "open" {
+		t.Fatalf("expected open trade kept, got %#v", session)
+	}
+	drained := hub.drainTradeUpdates()
+	if len(drained) != 1 || drained[0].session.TradeID != invite.TradeID ||
+		drained[0].session.State != "cancelled" || drained[0].session.Reason != "invite_expired" {
+		t.Fatalf("expected one invite_expired update queued, got %#v", drained)
+	}
+	if result, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "bo", ActionID: "ttl-4", PartnerID: "ann"}); !result.Accepted || result.TradeID == invite.TradeID {
+		t.Fatalf("expected a late answer to start a fresh invite, got %#v", result)
+	}
+}
+
+func TestTradeCancelReturnsEscrowOfAbsentParticipants(t *testing.T) {
+	hub := newWorldHub()
+	hub.departedPlayers["gone"] = departedPlayer{PlayerID: "gone", X: 3, Z: 4}
+	session := runtimeTradeSession{
+		TradeID:     "trade-absent",
+		InitiatorID: "gone",
+		PartnerID:   "lost",
+		State:       "open",
+		Offers: []runtimeTradeOffer{
+			{PlayerID: "gone", Items: []runtimeItemStack{{ItemID: "fiber", Quantity: 2}}},
+			{PlayerID: "lost", Items: []runtimeItemStack{{ItemID: "coal", Quantity: 1}}},
+		},
+	}
+	hub.trades[session.TradeID] = session
+
+	hub.mu.Lock()
+	hub.cancelTradeLocked(session, "gone", "cancelled")
+	hub.mu.Unlock()
+
+	parked := hub.departedPlayers["gone"]
+	if parked.Inventory.PlayerID != "gone" || parked.Inventory.Resources["fiber"] != 2 {
+		t.Fatalf("expected departed player's escrow back in their parked inventory, got %#v", parked.Inventory)
+	}
+	if len(hub.groundItems) != 1 {
+		t.Fatalf("expected escrow of an unknown player dropped on the ground, got %#v", hub.groundItems)
+	}
+	for _, item := range hub.groundItems {
+		if item.Stack.ItemID != "coal" || item.Stack.Quantity != 1 || item.OwnerID != "lost" || item.Source != "trade_escrow" {
+			t.Fatalf("unexpected escrow ground item %#v", item)
+		}
+	}
+}
+
+func TestChestContainersEnforceACLProximityAndDropOnBreak(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-chest", PlayerID: "oak", StartX: blockX, StartZ: blockZ + 1})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-chest", PlayerID: "ivy", StartX: blockX + 1, StartZ: blockZ})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-chest", PlayerID: "far", StartX: blockX + 20, StartZ: blockZ})
+	hub.awardInventoryResources("oak", map[string]int{"wood": 3})
+
+	chestBlock := blockActionPayload{PlayerID: "oak", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8, BlockType: chestBlockType}
+	if result, delta := hub.applyBlockAction(chestBlock); result.Reason != "chest_item_required" || delta != nil {
+		t.Fatalf("expected chest without the item rejected, got %#v", result)
+	}
+	hub.awardInventoryResources("oak", map[string]int{"chest": 1})
+	placed, _ := hub.applyBlockAction(chestBlock)
+	containerID := chestContainerID(0, 0, 8, 2, 8)
+	if !placed.Accepted || placed.ContainerID != containerID {
+		t.Fatalf("expected chest placement to create %q, got %#v", containerID, placed)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("oak"); inventory.Resources["chest"] != 0 {
+		t.Fatalf("expected chest item spent, got %#v", inventory.Resources)
+	}
+	if again, _ := hub.applyBlockAction(chestBlock); again.Reason != "container_exists" {
+		t.Fatalf("expected container_exists, got %#v", again)
+	}
+
+	containerAction := func(playerID string, actionID string, operation string) runtimeContainerActionResult {
+		result, _, _ := hub.applyContainerAction(containerActionPayload{
+			PlayerID:    playerID,
+			ActionID:    actionID,
+			ContainerID: containerID,
+			Operation:   operation,
+			ResourceID:  "wood",
+			Amount:      1,
+		})
+		return result
+	}
+	for _, actionID := range []string{"chest-1", "chest-2", "chest-3"} {
+		if result := containerAction("oak", actionID, "deposit"); !result.Accepted {
+			t.Fatalf("expected owner deposit, got %#v", result)
+		}
+	}
+	state, _ := hub.containerState(containerID)
+	if state.Capacity != chestContainerCapacity || state.Chest == nil || state.Chest.OwnerID != "oak" || state.Chest.Access != "private" {
+		t.Fatalf("unexpected chest container state %#v", state)
+	}
+	if result := containerAction("ivy", "chest-4", "withdraw"); result.Reason != "container_forbidden" {
+		t.Fatalf("expected private chest forbidden, got %#v", result)
+	}
+
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "oak", ActionID: "acl-1", ContainerID: containerID, Access: "party"}); !result.Accepted {
+		t.Fatalf("expected party acl accepted, got %#v", result)
+	}
+	if result := containerAction("ivy", "chest-5", "withdraw"); result.Reason != "container_forbidden" {
+		t.Fatalf("expected party chest forbidden without shared claim, got %#v", result)
+	}
+	hub.applyClaimCreate(claimCreatePayload{PlayerID: "oak", ActionID: "claim-chest", Alignment: "chunk", Members: []string{"ivy"}})
+	if result := containerAction("ivy", "chest-6", "withdraw"); !result.Accepted {
+		t.Fatalf("expected claim member withdraw, got %#v", result)
+	}
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "ivy", ActionID: "acl-2", ContainerID: containerID, Access: "public"}); result.Reason != "container_forbidden" {
+		t.Fatalf("expected non-owner acl change rejected, got %#v", result)
+	}
+
+	crowd := make([]string, 0, maxChestAllowedPlayers+1)
+	for index := 0; index <= maxChestAllowedPlayers; index++ {
+		crowd = append(crowd, fmt.Sprintf("guest-%02d", index))
+	}
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "oak", ActionID: "acl-crowd", ContainerID: containerID, Access: "players", Players: append(crowd, "oak", "guest-00")}); result.Accepted || result.Reason != "too_many_players" {
+		t.Fatalf("expected too_many_players, got %#v", result)
+	}
+	if chest := hub.chests[containerID]; chest.Access != "party" {
+		t.Fatalf("expected rejected acl to leave the chest unchanged, got %#v", chest)
+	}
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "oak", ActionID: "acl-crowd-fits", ContainerID: containerID, Access: "players", Players: append(crowd[:maxChestAllowedPlayers], "oak")}); !result.Accepted || len(hub.chests[containerID].Allowed) != maxChestAllowedPlayers {
+		t.Fatalf("expected a full allow list accepted, got %#v", result)
+	}
+	if result, _ := hub.applyContainerACL(containerACLPayload{PlayerID: "oak", ActionID: "acl-3", ContainerID: containerID, Access: "players", Players: []string{"far", "oak"}}); !result.Accepted {
+		t.Fatalf("expected players acl accepted, got %#v", result)
+	}
+	if chest := hub.chests[containerID]; !reflect.DeepEqual(chest.Allowed, []string{"far"}) || !hub.chestAllowsPlayerLocked(chest, "far") || hub.chestAllowsPlayerLocked(chest, "ivy") {
+		t.Fatalf("expected chest admitting only far besides the owner, got %#v", chest)
+	}
+	if result := containerAction("far", "chest-7", "withdraw"); result.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected for an allowed non-member, got %#v", result)
+	}
+	hub.applyClaimMembers(claimMembersPayload{PlayerID: "oak", ActionID: "member-far", ClaimID: "claim:oak:claim-chest", Operation: "add", MemberID: "far"})
+	if result := containerAction("far", "chest-7b", "withdraw"); result.Reason != "container_out_of_range" {
+		t.Fatalf("expected container_out_of_range, got %#v", result)
+	}
+	hub.players["far"].X = blockX - 1
+	if result := containerAction("far", "chest-8", "withdraw"); !result.Accepted {
+		t.Fatalf("expected allowed player withdraw in range, got %#v", result)
+	}
+
+	exported := hub.exportState()
+	target := newWorldHub()
+	if _, err := target.importState(exported); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	if restored, ok := target.chests[containerID]; !ok || restored.Access != "players" || restored.OwnerID != "oak" {
+		t.Fatalf("expected chest acl restored, got %#v", target.chests)
+	}
+
+	if result, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "ivy", Action: "break", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8}); result.Reason != "container_forbidden" {
+		t.Fatalf("expected forbidden chest break, got %#v", result)
+	}
+	broken, delta := hub.applyBlockAction(blockActionPayload{PlayerID: "oak", Action: "break", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8})
+	if !broken.Accepted || delta == nil || broken.ContainerID != containerID {
+		t.Fatalf("expected owner to break chest, got %#v", broken)
+	}
+	if _, ok := hub.chests[containerID]; ok {
+		t.Fatalf("expected chest removed after break")
+	}
+	dropped, refunded := 0, 0
+	for _, item := range hub.groundItems {
+		if item.Source == "container" && item.Stack.ItemID == "wood" {
+			dropped += item.Stack.Quantity
+		}
+		if item.Source == "chest" && item.Stack.ItemID == chestBlockType {
+			refunded += item.Stack.Quantity
+		}
+	}
+	if dropped != 1 {
+		t.Fatalf("expected remaining wood dropped from chest, got %d", dropped)
+	}
+	if refunded != 1 {
+		t.Fatalf("expected the chest item dropped at the broken chest, got %d", refunded)
+	}
+	if result := containerAction("oak", "chest-9", "deposit"); result.Reason != "unknown_container" {
+		t.Fatalf("expected unknown_container after break, got %#v", result)
+	}
+}
+
+func TestChestRemovalNotifiesOnlyItsViewers(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	for _, playerID := range []string{"owner", "friend", "stranger", "visitor"} {
+		hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-removal", PlayerID: playerID, StartX: blockX + 1, StartZ: blockZ})
+	}
+	hub.awardInventoryResources("owner", map[string]int{"chest": 2})
+
+	shared, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8, BlockType: chestBlockType})
+	hub.applyContainerACL(containerACLPayload{PlayerID: "owner", ActionID: "acl-1", ContainerID: shared.ContainerID, Access: "players", Players: []string{"friend"}})
+	hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "break", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8})
+	removals := hub.drainChestRemovals()
+	if len(removals) != 1 || removals[0].Removed.ContainerID != shared.ContainerID || !reflect.DeepEqual(removals[0].PlayerIDs, []string{"friend", "owner"}) {
+		t.Fatalf("expected removal sent to owner and allowed player only, got %#v", removals)
+	}
+
+	public, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "place", ChunkX: 0, ChunkZ: 0, X: 9, Y: 2, Z: 8, BlockType: chestBlockType})
+	hub.applyContainerACL(containerACLPayload{PlayerID: "owner", ActionID: "acl-2", ContainerID: public.ContainerID, Access: "public"})
+	if result, _ := hub.applyContainerOpen(containerSubscriptionPayload{PlayerID: "visitor", ActionID: "open-1", ContainerID: public.ContainerID}); !result.Accepted {
+		t.Fatalf("expected public chest open, got %#v", result)
+	}
+	hub.applyBlockAction(blockActionPayload{PlayerID: "friend", Action: "break", ChunkX: 0, ChunkZ: 0, X: 9, Y: 2, Z: 8})
+	removals = hub.drainChestRemovals()
+	if len(removals) != 1 || !reflect.DeepEqual(removals[0].PlayerIDs, []string{"friend", "owner", "visitor"}) {
+		t.Fatalf("expected public chest removal sent to breaker, owner and subscriber, got %#v", removals)
+	}
+}
+
+func TestContainerSubscriptionsOpenCloseAndRevalidate(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-sub", PlayerID: "owner", StartX: blockX, StartZ: blockZ + 1})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-sub", PlayerID: "guest", StartX: blockX + 1, StartZ: blockZ})
+
+	open := func(playerID string, actionID string, containerID string) runtimeContainerSubscriptionResult {
+		result, _ := hub.applyContainerOpen(containerSubscriptionPayload{PlayerID: playerID, ActionID: actionID, ContainerID: containerID})
+		return result
+	}
+	if result := open("guest", "open-1", playerPrivateContainerID("owner")); result.Reason != "container_forbidden" {
+		t.Fatalf("expected foreign stash forbidden, got %#v", result)
+	}
+	if result := hub.applyContainerClose(containerSubscriptionPayload{PlayerID: "guest", ActionID: "close-1", ContainerID: worldSharedContainerID}); result.Reason != "container_not_open" {
+		t.Fatalf("expected container_not_open, got %#v", result)
+	}
+
+	hub.awardInventoryResources("owner", map[string]int{"chest": 1})
+	placed, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8, BlockType: chestBlockType})
+	hub.applyContainerACL(containerACLPayload{PlayerID: "owner", ActionID: "acl-1", ContainerID: placed.ContainerID, Access: "public"})
+	if result := open("guest", "open-2", placed.ContainerID); !result.Accepted {
+		t.Fatalf("expected public chest open, got %#v", result)
+	}
+	if result := open("owner", "open-3", placed.ContainerID); !result.Accepted {
+		t.Fatalf("expected owner chest open, got %#v", result)
+	}
+
+	hub.applyContainerACL(containerACLPayload{PlayerID: "owner", ActionID: "acl-2", ContainerID: placed.ContainerID, Access: "private"})
+	closes := hub.drainContainerCloses()
+	if len(closes) != 1 || closes[0].PlayerID != "guest" || closes[0].Reason != "access_revoked" {
+		t.Fatalf("expected guest subscription revoked, got %#v", closes)
+	}
+	if hub.openContainers["owner"] != placed.ContainerID {
+		t.Fatalf("expected owner subscription kept, got %#v", hub.openContainers)
+	}
+
+	hub.applyBlockAction(blockActionPayload{PlayerID: "owner", Action: "break", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8})
+	closes = hub.drainContainerCloses()
+	if len(closes) != 1 || closes[0].PlayerID != "owner" || closes[0].Reason != "container_removed" {
+		t.Fatalf("expected owner subscription closed on break, got %#v", closes)
+	}
+
+	hub.players["guest"].X = containerSubscriptionRange + 1
+	if result := open("guest", "open-4", worldSharedContainerID); result.Reason != "container_out_of_range" {
+		t.Fatalf("expected shared container out of range, got %#v", result)
+	}
+	hub.players["guest"].X, hub.players["guest"].Z = 1, 0
+	if result := open("guest", "open-5", worldSharedContainerID); !result.Accepted {
+		t.Fatalf("expected shared container open near camp, got %#v", result)
+	}
+	hub.handleLeave(client, "guest")
+	if _, ok := hub.openContainers["guest"]; ok {
+		t.Fatalf("expected subscription dropped on leave")
+	}
+}
+
+func TestContainerTransactionAppliesAllOrNothingAndReplays(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-container-tx", PlayerID: "mover"})
+	hub.awardInventoryResources("mover", map[string]int{"wood": 5, "stone": 2})
+
+	transact := func(actionID string, lines ...containerTransactionLine) (runtimeContainerActionResult, *runtimeInventoryState, *runtimeContainerState) {
+		return hub.applyContainerTransaction(containerTransactionPayload{
+			PlayerID:    "mover",
+			ActionID:    actionID,
+			ContainerID: worldSharedContainerID,
+			Lines:       lines,
+		})
+	}
+
+	rejected, inventory, container := transact("tx-1",
+		containerTransactionLine{Operation: "deposit", ResourceID: "wood", Amount: 3},
+		containerTransactionLine{Operation: "deposit", ResourceID: "stone", Amount: 5},
+		containerTransactionLine{Operation: "withdraw", ResourceID: "fiber", Amount: 1},
+	)
+	if rejected.Accepted || rejected.Reason != "line_rejected" || inventory != nil || container != nil {
+		t.Fatalf("expected line_rejected without state changes, got %#v", rejected)
+	}
+	reasons := []string{rejected.Lines[0].Reason, rejected.Lines[1].Reason, rejected.Lines[2].Reason}
+	if !reflect.DeepEqual(reasons, []string{"", "insufficient_resources", "container_insufficient_resources"}) || !rejected.Lines[0].Accepted {
+		t.Fatalf("unexpected per-line outcomes %#v", rejected.Lines)
+	}
+	if state, _ := hub.inventoryStateForPlayer("mover"); state.Resources["wood"] != 5 {
+		t.Fatalf("expected rejected transaction to leave inventory untouched, got %#v", state.Resources)
+	}
+	if state, _ := hub.containerState(worldSharedContainerID); state.Resources["wood"] != 0 {
+		t.Fatalf("expected rejected transaction to leave container untouched, got %#v", state.Resources)
+	}
+
+	applied, inventory, container := transact("tx-2",
+		containerTransactionLine{Operation: "deposit", ResourceID: "wood", Amount: 3},
+		containerTransactionLine{Operation: "deposit", ResourceID: "stone", Amount: 2},
+		containerTransactionLine{Operation: "withdraw", ResourceID: "wood", Amount: 1},
+	)
+	if !applied.Accepted || len(applied.Lines) != 3 || inventory == nil || container == nil {
+		t.Fatalf("expected transaction applied, got %#v", applied)
+	}
+	if inventory.Resources["wood"] != 3 || inventory.Resources["stone"] != 0 || container.Resources["wood"] != 2 || container.Resources["stone"] != 2 {
+		t.Fatalf("unexpected balances inventory=%#v container=%#v", inventory.Resources, container.Resources)
+	}
+
+	replayed, inventory, _ := transact("tx-2",
+		containerTransactionLine{Operation: "deposit", ResourceID: "wood", Amount: 3},
+	)
+	if !replayed.Accepted || !replayed.Replayed || len(replayed.Lines) != 3 || inventory.Resources["wood"] != 3 {
+		t.Fatalf("expected idempotent replay, got %#v %#v", replayed, inventory.Resources)
+	}
+
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-container-tx", PlayerID: "hoarder"})
+	hub.awardInventoryResources("hoarder", map[string]int{"wood": 3})
+	stash := playerPrivateContainerID("hoarder")
+	if result, _, _ := hub.applyContainerAction(containerActionPayload{PlayerID: "hoarder", ActionID: "stash-1", ContainerID: stash, Operation: "deposit", ResourceID: "wood", Amount: 3}); !result.Accepted {
+		t.Fatalf("expected hoarder deposit, got %#v", result)
+	}
+	snooped, _, container := hub.applyContainerTransaction(containerTransactionPayload{
+		PlayerID:    "mover",
+		ActionID:    "tx-2",
+		ContainerID: stash,
+		Lines:       []containerTransactionLine{{Operation: "deposit", ResourceID: "wood", Amount: 1}},
+	})
+	if !snooped.Replayed || snooped.ContainerID != worldSharedContainerID || container == nil || container.ContainerID != worldSharedContainerID {
+		t.Fatalf("expected replay to return only the original container, got %#v %#v", snooped, container)
+	}
+
+	tooMany := make([]containerTransactionLine, maxContainerTransactionLines+1)
+	if result, _, _ := transact("tx-3", tooMany...); result.Reason != "too_many_lines" {
+		t.Fatalf("expected too_many_lines, got %#v", result)
+	}
+
+	appliedEvents := 0
+	for _, event := range hub.eventLog {
+		if event.Type == "container_transaction_applied" {
+			appliedEvents++
+		}
+	}
+	if appliedEvents != 1 {
+		t.Fatalf("expected exactly one applied audit event, got %d", appliedEvents)
+	}
+}
+
+func TestSeenActionCacheReplaysAppliedActionsAcrossReconnectAndExport(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-seen", PlayerID: "retrier", StartX: 5, StartZ: -3})
+	hub.awardInventoryResources("retrier", map[string]int{"wood": 4})
+
+	deposit := containerActionPayload{
+		PlayerID:    "retrier",
+		ActionID:    "seen-deposit-1",
+		ContainerID: playerPrivateContainerID("retrier"),
+		Operation:   "deposit",
+		ResourceID:  "wood",
+		Amount:      3,
+	}
+	if first, _, _ := hub.applyContainerAction(deposit); !first.Accepted || first.Replayed {
+		t.Fatalf("expected first deposit applied, got %#v", first)
+	}
+	replayed, inventory, container := hub.applyContainerAction(deposit)
+	if !replayed.Accepted || !replayed.Replayed || inventory != nil || container != nil {
+		t.Fatalf("expected replayed deposit without state changes, got %#v", replayed)
+	}
+	if state, _ := hub.inventoryStateForPlayer("retrier"); state.Resources["wood"] != 1 {
+		t.Fatalf("expected wood deducted once, got %#v", state.Resources)
+	}
+
+	place := blockActionPayload{PlayerID: "retrier", ActionID: "seen-place-1", Action: "place", ChunkX: 0, ChunkZ: 0, X: 9, Y: 2, Z: 7}
+	if result, delta := hub.applyBlockAction(place); !result.Accepted || delta == nil {
+		t.Fatalf("expected block placed, got %#v", result)
+	}
+	if result, delta := hub.applyBlockAction(place); !result.Replayed || delta != nil || result.ActionID != "seen-place-1" {
+		t.Fatalf("expected replayed block action without delta, got %#v", result)
+	}
+
+	rejected := deposit
+	rejected.ActionID = "seen-deposit-2"
+	if result, _, _ := hub.applyContainerAction(rejected); result.Accepted {
+		t.Fatalf("expected deposit rejected for lack of wood, got %#v", result)
+	}
+	hub.awardInventoryResources("retrier", map[string]int{"wood": 2})
+	if result, _, _ := hub.applyContainerAction(rejected); !result.Accepted || result.Replayed {
+		t.Fatalf("expected rejected action to apply on retry, got %#v", result)
+	}
+
+	grant, _ := hub.sessionGrant("retrier")
+	hub.handleLeave(client, "retrier")
+	if reason := hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-seen", PlayerID: "retrier", StartX: 5, StartZ: -3, ResumeToken: grant.ResumeToken}); reason != "" {
+		t.Fatalf("expected rejoin with the resume token accepted, got %q", reason)
+	}
+	if result, _, _ := hub.applyContainerAction(deposit); !result.Replayed {
+		t.Fatalf("expected replay to survive reconnect, got %#v", result)
+	}
+
+	exported := hub.exportState()
+	if len(exported.SeenActions) != 1 || len(exported.SeenActions[0].Actions) != 3 {
+		t.Fatalf("expected three seen actions exported, got %#v", exported.SeenActions)
+	}
+	target := newWorldHub()
+	if _, err := target.importState(exported); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	target.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-seen", PlayerID: "retrier", StartX: 5, StartZ: -3})
+	if result, _ := target.applyBlockAction(place); !result.Replayed {
+		t.Fatalf("expected replay after import, got %#v", result)
+	}
+
+	for index := 0; index < maxSeenActionsPerPlayer+1; index++ {
+		hub.rememberSeenActionLocked("bounded", "interact_action", fmt.Sprintf("seen-%d", index), true, runtimeInteractResult{Accepted: true})
+	}
+	var replay runtimeInteractResult
+	if len(hub.seenActions["bounded"]) != maxSeenActionsPerPlayer || hub.replaySeenActionLocked("bounded", "interact_action", "seen-0", &replay) {
+		t.Fatalf("expected oldest seen action evicted, got %d entries", len(hub.seenActions["bounded"]))
+	}
+	if hub.replaySeenActionLocked("bounded", "combat_action", "seen-1", &replay) {
+		t.Fatalf("expected seen actions keyed by message kind")
+	}
+}
+
+func TestSeenActionCacheCoversTradesClaimsACLAndCraftCancel(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-dedup", PlayerID: "ann", StartX: blockX + 1, StartZ: blockZ})
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-dedup", PlayerID: "bo", StartX: blockX + 2, StartZ: blockZ})
+	hub.awardInventoryResources("ann", map[string]int{"fiber": 5})
+
+	request := tradeRequestPayload{PlayerID: "ann", ActionID: "dedup-trade-1", PartnerID: "bo"}
+	invite, _ := hub.applyTradeRequest(request)
+	if result, updates := hub.applyTradeRequest(request); !result.Replayed || result.TradeID != invite.TradeID || updates.session != nil {
+		t.Fatalf("expected replayed trade request without updates, got %#v", result)
+	}
+	hub.applyTradeRequest(tradeRequestPayload{PlayerID: "bo", ActionID: "dedup-trade-2", PartnerID: "ann"})
+	offer := tradeOfferUpdatePayload{PlayerID: "ann", ActionID: "dedup-trade-3", TradeID: invite.TradeID, Items: map[string]int{"fiber": 2}}
+	if result, _ := hub.applyTradeOfferUpdate(offer); !result.Accepted {
+		t.Fatalf("expected offer accepted, got %#v", result)
+	}
+	if result, updates := hub.applyTradeOfferUpdate(offer); !result.Replayed || len(updates.inventories) != 0 {
+		t.Fatalf("expected replayed offer without updates, got %#v", result)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("ann"); inventory.Resources["fiber"] != 3 {
+		t.Fatalf("expected fiber escrowed once, got %#v", inventory.Resources)
+	}
+	confirm := tradeConfirmPayload{PlayerID: "ann", ActionID: "dedup-trade-4", TradeID: invite.TradeID, Revision: 1}
+	hub.applyTradeConfirm(confirm)
+	if result, _ := hub.applyTradeConfirm(confirm); !result.Accepted || !result.Replayed {
+		t.Fatalf("expected replayed confirm, got %#v", result)
+	}
+
+	claim := claimCreatePayload{PlayerID: "ann", ActionID: "dedup-claim-1", Alignment: "chunk"}
+	if result, changed := hub.applyClaimCreate(claim); !result.Accepted || !changed {
+		t.Fatalf("expected claim created, got %#v", result)
+	}
+	if result, changed := hub.applyClaimCreate(claim); !result.Accepted || !result.Replayed || changed {
+		t.Fatalf("expected replayed claim without a change, got %#v", result)
+	}
+	remove := claimRemovePayload{PlayerID: "ann", ActionID: "dedup-claim-2", ClaimID: "claim:ann:dedup-claim-1"}
+	hub.applyClaimRemove(remove)
+	if result, changed := hub.applyClaimRemove(remove); !result.Accepted || !result.Replayed || changed {
+		t.Fatalf("expected replayed claim removal, got %#v", result)
+	}
+
+	hub.awardInventoryResources("ann", map[string]int{"chest": 1})
+	chest, _ := hub.applyBlockAction(blockActionPayload{PlayerID: "ann", Action: "place", ChunkX: 0, ChunkZ: 0, X: 8, Y: 2, Z: 8, BlockType: chestBlockType})
+	acl := containerACLPayload{PlayerID: "ann", ActionID: "dedup-acl-1", ContainerID: chest.ContainerID, Access: "public"}
+	if result, state := hub.applyContainerACL(acl); !result.Accepted || state == nil {
+		t.Fatalf("expected acl accepted, got %#v", result)
+	}
+	if result, state := hub.applyContainerACL(acl); !result.Replayed || state != nil {
+		t.Fatalf("expected replayed acl without container state, got %#v", result)
+	}
+
+	hub.mu.Lock()
+	hub.craftQueues["bo"] = []runtimeCraftJob{{JobID: "job-1", RecipeID: "craft-iron-ingot", Count: 1, DurationTicks: 40, Ingredients: map[string]int{"coal": 1}}}
+	hub.mu.Unlock()
+	cancel := craftCancelPayload{PlayerID: "bo", ActionID: "dedup-cancel-1", JobID: "job-1"}
+	if progress := hub.applyCraftCancel(cancel); progress.State != "cancelled" {
+		t.Fatalf("expected job cancelled, got %#v", progress)
+	}
+	if progress := hub.applyCraftCancel(cancel); progress.State != "cancelled" || !progress.Replayed {
+		t.Fatalf("expected replayed cancel, got %#v", progress)
+	}
+	if inventory, _ := hub.inventoryStateForPlayer("bo"); inventory.Resources["coal"] != 1 {
+		t.Fatalf("expected ingredients refunded once, got %#v", inventory.Resources)
+	}
+}
+
+func TestSeenActionsAreForgottenWithThePlayer(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-forget", PlayerID: "parked"})
+	hub.applyBlockAction(blockActionPayload{PlayerID: "parked", ActionID: "forget-1", Action: "place", ChunkX: 0, ChunkZ: 0, X: 9, Y: 2, Z: 7})
+
+	hub.mu.Lock()
+	hub.rememberSeenActionLocked("ghost", "block_action", "forget-2", true, runtimeBlockActionResult{Accepted: true})
+	hub.mu.Unlock()
+	hub.handleLeave(client, "parked")
+	hub.mu.Lock()
+	_, parkedKept := hub.seenActions["parked"]
+	_, ghostKept := hub.seenActions["ghost"]
+	hub.mu.Unlock()
+	if !parkedKept || ghostKept {
+		t.Fatalf("expected only the parked player's actions kept, got parked=%v ghost=%v", parkedKept, ghostKept)
+	}
+
+	hub.mu.Lock()
+	for index := 0; index < maxDepartedPlayers; index++ {
+		hub.departedPlayers[fmt.Sprintf("later-%d", index)] = departedPlayer{PlayerID: fmt.Sprintf("later-%d", index), LeftTick: hub.tick + 1}
+	}
+	hub.pruneDepartedPlayersLocked()
+	_, parkedKept = hub.seenActions["parked"]
+	hub.mu.Unlock()
+	if parkedKept {
+		t.Fatalf("expected actions forgotten once the parked state is dropped")
+	}
+}
+
+func TestNegotiateHelloChecksMajorVersionAndEncoding(t *testing.T) {
+	hub := newWorldHub()
+
+	welcome, refusal := hub.negotiateHello(helloPayload{ProtocolVersion: "1.7", Encodings: []string{"msgpack", "json"}})
+	if refusal != "" {
+		t.Fatalf("expected same-major client accepted, got refusal %q", refusal)
+	}
+	if welcome.ProtocolVersion != protocolVersion || welcome.Encoding != "json" || welcome.ServerBuild == "" {
+		t.Fatalf("unexpected welcome %#v", welcome)
+	}
+	if !containsString(welcome.Features, "request_ids") {
+		t.Fatalf("expected welcome to advertise request_ids, got %#v", welcome.Features)
+	}
+
+	if _, refusal := hub.negotiateHello(helloPayload{ProtocolVersion: "1"}); refusal != "" {
+		t.Fatalf("expected bare major version accepted, got %q", refusal)
+	}
+	if _, refusal := hub.negotiateHello(helloPayload{ProtocolVersion: "2.0"}); !strings.HasPrefix(refusal, "unsupported_protocol_version") {
+		t.Fatalf("expected newer major refused, got %q", refusal)
+	}
+	if _, refusal := hub.negotiateHello(helloPayload{ProtocolVersion: ""}); refusal != "invalid_protocol_version" {
+		t.Fatalf("expected missing version refused, got %q", refusal)
+	}
+	if _, refusal := hub.negotiateHello(helloPayload{ProtocolVersion: "1.0", Encodings: []string{"msgpack"}}); !strings.HasPrefix(refusal, "unsupported_encoding") {
+		t.Fatalf("expected unsupported encoding refused, got %q", refusal)
+	}
+}
+
+func TestDetachedSessionKeepsPlayerUntilGraceWindowElapses(t *testing.T) {
+	hub := newWorldHub()
+	annClient := &clientConn{playerIDs: map[string]struct{}{}}
+	boClient := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.addClient(annClient)
+	hub.addClient(boClient)
+	hub.handleJoin(annClient, joinRuntimeRequest{PlayerID: "ann"})
+	hub.handleJoin(boClient, joinRuntimeRequest{PlayerID: "bo"})
+	grant, ok := hub.sessionGrant("ann")
+	if !ok || len(grant.ResumeToken) != 32 || grant.GraceTicks != resumeGraceTicks {
+		t.Fatalf("expected resume token issued on join, got %#v", grant)
+	}
+	request, _ := hub.applyTradeRequest(tradeRequestPayload{PlayerID: "ann", ActionID: "grace-1", PartnerID: "bo"})
+	if !request.Accepted {
+		t.Fatalf("expected trade request accepted, got %#v", request)
+	}
+
+	hub.removeClient(annClient)
+	hub.removeClient(annClient)
+	if session := hub.sessions["ann"]; session == nil || !session.Detached || session.DetachedTick != 0 {
+		t.Fatalf("expected ann detached once at tick 0, got %#v", session)
+	}
+	hub.bufferMissedEnvelope("ann", serverEnvelope{Type: "inventory_state", Payload: runtimeInventoryState{PlayerID: "ann"}})
+	hub.bufferMissedEnvelope("bo", serverEnvelope{Type: "inventory_state", Payload: runtimeInventoryState{PlayerID: "bo"}})
+	if missed := len(hub.sessions["ann"].Missed); missed != 1 {
+		t.Fatalf("expected only ann's envelope buffered, got %d", missed)
+	}
+	if hub.sessions["bo"].Detached {
+		t.Fatalf("expected connected player not detached")
+	}
+
+	for tick := 1; tick < resumeGraceTicks; tick++ {
+		hub.advanceOneTick()
+	}
+	if _, ok := hub.players["ann"]; !ok {
+		t.Fatalf("expected ann kept during grace window")
+	}
+	if _, ok := hub.tradeForPlayerLocked("ann"); !ok {
+		t.Fatalf("expected pending trade kept during grace window")
+	}
+
+	hub.advanceOneTick()
+	if _, ok := hub.players["ann"]; ok {
+		t.Fatalf("expected ann removed after grace window")
+	}
+	if _, ok := hub.sessions["ann"]; ok {
+		t.Fatalf("expected ann's session dropped after grace window")
+	}
+	if _, ok := hub.tradeForPlayerLocked("bo"); ok {
+		t.Fatalf("expected pending trade cancelled after grace window")
+	}
+	left := false
+	for _, event := range hub.eventLog {
+		if event.Type == "player_left" && event.PlayerID == "ann" {
+			left = true
+		}
+	}
+	if !left {
+		t.Fatalf("expected player_left event after grace window")
+	}
+
+	lateClient := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.addClient(lateClient)
+	if result := hub.resumeSession(lateClient, resumePayload{PlayerID: "ann", ResumeToken: grant.ResumeToken}, ""); result.Accepted || result.Reason != "session_not_found" {
+		t.Fatalf("expected resume after expiry refused, got %#v", result)
+	}
+}
+
+func TestLeftPlayersKeepStateAcrossRejoinAndLingerWhenDisconnected(t *testing.T) {
+	hub := newWorldHub()
+	client := &clientConn{playerIDs: map[string]struct{}{}}
+	hub.addClient(client)
+	hub.handleJoin(client, joinRuntimeRequest{WorldSeed: "seed-lifecycle", PlayerID: "wanderer", StartX: 2, StartZ: 3})
+	hub.awardInventoryResources("wanderer", map[string]int{"wood": 4})
+	hub.applyHotbarSelection(hotbarSelectPayload{PlayerID: "wanderer", SlotIndex: 2})
+	hub.mu.Lock()
+	health := hub.healthStates["wanderer"]
+	health.Current = health.Max - 3
+	hub.healthStates["wanderer"] = health
+	hub.players["wanderer"].X = 7
+	hub.mu.Unlock()
+	grant, _ := hub.sessionGrant("wanderer")
+
+	hub.handleLeave(client, "wanderer")
+	if _, ok := hub.inventoryStateForPlayer("wanderer"); ok {
+		t.Fatalf("expected live inventory removed on leave")
+	}
+	exported := hub.exportState()
+	if len(exported.DepartedPlayers) != 1 || exported.DepartedPlayers[0].Inventory.Resources["wood"] != 4 {
+		t.Fatalf("expected departed player exported with inventory, got %#v", exported.DepartedPlayers)
+	}
+
+	restored := newWorldHub()
+	if _, err := restored.importState(exported); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	rejoinClient := &clientConn{playerIDs: map[string]struct{}{}}
+	restored.addClient(rejoinClient)
+	for _, token := range []string{"", "guessed"} {
+		if reason := restored.handleJoin(rejoinClient, joinRuntimeRequest{WorldSeed: "seed-lifecycle", PlayerID: "wanderer", ResumeToken: token}); reason != "invalid_resume_token" {
+			t.Fatalf("expected join without the resume token rejected, got %q", reason)
+		}
+	}
+	if _, ok := restored.departedPlayers["wanderer"]; !ok || len(rejoinClient.playerIDs) != 0 {
+		t.Fatalf("expected parked state kept for the rightful player")
+	}
+	restored.handleJoin(rejoinClient, joinRuntimeRequest{WorldSeed: "seed-lifecycle", PlayerID: "wanderer", ResumeToken: grant.ResumeToken})
+	if inventory, _ := restored.inventoryStateForPlayer("wanderer"); inventory.Resources["wood"] != 4 {
+		t.Fatalf("expected inventory restored on rejoin, got %#v", inventory.Resources)
+	}
+	if hotbar, _ := restored.hotbarStateForPlayer("wanderer"); hotbar.SelectedIndex != 2 {
+		t.Fatalf("expected hotbar selection restored on rejoin, got %d", hotbar.SelectedIndex)
+	}
+	if restoredHealth, _ := restored.healthStateForPlayer("wanderer"); restoredHealth.Current != health.Current {
+		t.Fatalf("expected health %d restored on rejoin, got %#v", health.Current, restoredHealth)
+	}
+	if player := restored.players["wanderer"]; player.X != 7 || player.Z != 3 {
+		t.Fatalf("expected player restored where they left, got %#v", player)
+	}
+	if len(restored.departedPlayers) != 0 {
+		t.Fatalf("expected departed entry consumed by rejoin")
+	}
+
+	restored.lingerTicks = 5
+	restored.removeClient(rejoinClient)
+	if snapshot := restored.snapshot(); !snapshot.Players["wanderer"].Away {
+		t.Fatalf("expected disconnected player marked away, got %#v", snapshot.Players["wanderer"])
+	}
+	for tick := 0; tick < 5; tick++ {
+		restored.advanceOneTick()
+	}
+	if _, ok := restored.players["wanderer"]; ok {
+		t.Fatalf("expected lingering player removed after the linger timeout")
+	}
+	if departed, ok := restored.departedPlayers["wanderer"]; !ok || departed.Inventory.Resources["wood"] != 4 {
+		t.Fatalf("expected lingering player's state parked, got %#v", departed)
+	}
+
+	withStatue := hub.exportState()
+	withStatue.Snapshot.Players["statue"] = runtimePlayerSnapshot{PlayerID: "statue", X: 1}
+	imported := newWorldHub()
+	imported.lingerTicks = 2
+	if _, err := imported.importState(withStatue); err != nil {
+		t.Fatalf("import state failed: %v", err)
+	}
+	if snapshot := imported.snapshot(); !snapshot.Players["statue"].Away {
+		t.Fatalf("expected imported uncontrolled player marked away")
+	}
+	imported.advanceOneTick()
+	imported.advanceOneTick()
+	if _, ok := imported.players["statue"]; ok {
+		t.Fatalf("expected uncontrolled player removed after the linger timeout")
+	}
+}
+
+func TestMessageLimiterKeepsBucketsPerTypeAndRefills(t *testing.T) {
+	limits := defaultConnectionLimits()
+	limits.DefaultRate = messageRate{Rate: 2, Burst: 2}
+	limits.TypeRates = map[string]messageRate{
+		"input":         {Rate: 10, Burst: 4},
+		"block_action":  {Rate: 2, Burst: 2},
+		"craft_request": {Rate: 2, Burst: 2},
+	}
+	limiter := newMessageLimiter(limits)
+	start := time.Unix(1000, 0)
+
+	for index := 0; index < 2; index++ {
+		if !limiter.allow("block_action", start) {
+			t.Fatalf("expected burst message %d allowed", index)
+		}
+	}
+	if limiter.allow("block_action", start) {
+		t.Fatalf("expected block_action bucket exhausted")
+	}
+	if !limiter.allow("craft_request", start) {
+		t.Fatalf("expected a flood of block_action not to throttle craft_request")
+	}
+	for index := 0; index < 4; index++ {
+		if !limiter.allow("input", start) {
+			t.Fatalf("expected input burst message %d allowed", index)
+		}
+	}
+	if limiter.allow("input", start) {
+		t.Fatalf("expected input bucket exhausted")
+	}
+	if !limiter.allow("invented_a", start) || !limiter.allow("invented_b", start) || limiter.allow("invented_c", start) {
+		t.Fatalf("expected unknown types to share the default bucket")
+	}
+	if !limiter.allow("block_action", start.Add(500*time.Millisecond)) {
+		t.Fatalf("expected block_action bucket refilled after half a second")
+	}
+	if limiter.allow("block_action", start.Add(500*time.Millisecond)) {
+		t.Fatalf("expected refill capped by elapsed time")
+	}
+	if len(limiter.buckets) != 4 || limiter.bucketName("invented_a") != "*" || limiter.bucketName("craft_request") != "craft_request" {
+		t.Fatalf("expected four buckets named by type or \"*\", got %d", len(limiter.buckets))
+	}
+}
+
+func TestDefaultMessageRatesGiveEveryKnownTypeABucket(t *testing.T) {
+	rates := messageTypeRates(20)
+	for messageType := range messageTypeRateScales {
+		if rate := rates[messageType]; rate.Rate <= 0 || rate.Burst < rate.Rate {
+			t.Fatalf("expected a positive rate for %q, got %#v", messageType, rate)
+		}
+	}
+}
+
+func TestOriginAllowlistMatchesExactAndWildcardOrigins(t *testing.T) {
+	allowlist := parseOriginAllowlist(" https://play.example.com/, https://*.example.org ,http://localhost:3100")
+	cases := map[string]bool{
+		"":                            true,
+		"https://play.example.com":    true,
+		"HTTPS://Play.Example.com":    true,
+		"http://play.example.com":     false,
+		"https://evil.example.com":    false,
+		"https://a.example.org":       true,
+		"https://a.b.example.org":     true,
+		"https://example.org":         false,
+		"https://evilexample.org":     false,
+		"http://localhost:3100":       true,
+		"http://localhost:3101":       false,
+		"null":                        false,
+		"https://play.example.com.io": false,
+	}
+	for origin, expected := range cases {
+		if allowed := allowlist.allows(origin); allowed != expected {
+			t.Fatalf("origin %q: expected allowed=%v, got %v", origin, expected, allowed)
+		}
+	}
+	if !parseOriginAllowlist("*").allows("https://anything.test") {
+		t.Fatalf("expected * to allow every origin")
+	}
+	if parseOriginAllowlist("").allows("https://anything.test") {
+		t.Fatalf("expected an empty allowlist to allow only non-browser clients")
+	}
+}
+
+func TestCertReloaderPicksUpRotatedCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeTestCertificate(t, certFile, keyFile, "first.test")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load certificate failed: %v", err)
+	}
+	if name := servedCertificateName(t, reloader); name != "first.test" {
+		t.Fatalf("expected first certificate served, got %q", name)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "second.test")
+	rotated := time.Now().Add(time.Minute)
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, rotated, rotated); err != nil {
+			t.Fatalf("touch %s failed: %v", file, err)
+		}
+	}
+	reloader.checked = time.Time{}
+	if name := servedCertificateName(t, reloader); name != "second.test" {
+		t.Fatalf("expected rotated certificate served, got %q", name)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("corrupt key failed: %v", err)
+	}
+	broken := rotated.Add(time.Minute)
+	_ = os.Chtimes(keyFile, broken, broken)
+	reloader.checked = time.Time{}
+	if name := servedCertificateName(t, reloader); name != "second.test" {
+		t.Fatalf("expected last good certificate kept when reload fails, got %q", name)
+	}
+}
+
+func TestAdminRoutesMoveToTheAdminListenerWhenSeparated(t *testing.T) {
+	hub := newWorldHub()
+	recorder := httptest.NewRecorder()
+	buildPublicMux(hub, true).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), "api_keys_not_configured") {
+		t.Fatalf("expected admin routes refused without api keys, got %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	hub.adminAuth.allowWithoutKeys = true
+	for _, testCase := range []struct {
+		name    string
+		handler http.Handler
+		status  int
+	}{
+		{name: "public with admin", handler: buildPublicMux(hub, true), status: http.StatusOK},
+		{name: "public only", handler: buildPublicMux(hub, false), status: http.StatusNotFound},
+		{name: "admin", handler: buildAdminMux(hub), status: http.StatusOK},
+	} {
+		recorder := httptest.NewRecorder()
+		testCase.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+		if recorder.Code != testCase.status {
+			t.Fatalf("%s: expected /debug/metrics status %d, got %d", testCase.name, testCase.status, recorder.Code)
+		}
+	}
+}
+
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key failed: %v", err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write certificate failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key failed: %v", err)
+	}
+}
+
+func servedCertificateName(t *testing.T, reloader *certReloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("get certificate failed: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse served certificate failed: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestAdminEndpointsRequireScopedKeysAndAreAudited(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(keysFile, []byte(`{"keys":[
+		{"id":"director","secret":"director-secret-0001","scopes":["events:read","directives:write"]},
+		{"id":"ops","secret":"ops-secret-000000001","scopes":["admin:state"]}
+	]}`), 0o600); err != nil {
+		t.Fatalf("write keys failed: %v", err)
+	}
+	keys, err := loadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatalf("load keys failed: %v", err)
+	}
+
+	var audit bytes.Buffer
+	hub := newWorldHub()
+	hub.adminAuth = newAdminAuth(keys, newAuditLog(&audit))
+	now := time.Unix(1_800_000_000, 0)
+	hub.adminAuth.now = func() time.Time { return now }
+	mux := buildAdminMux(hub)
+
+	serve := func(method string, target string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, bytes.NewReader(body))
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder
+	}
+	signed := func(keyID string, secret string, method string, target string, body []byte, timestamp time.Time) http.Header {
+		sum := sha256.Sum256(body)
+		return http.Header{
+			"X-Key-Id":    {keyID},
+			"X-Timestamp": {fmt.Sprint(timestamp.Unix())},
+			"X-Signature": {signAdminRequest(secret, method, target, timestamp.Unix(), hex.EncodeToString(sum[:]))},
+		}
+	}
+
+	if recorder := serve(http.MethodGet, "/debug/state", nil, nil); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected missing credentials rejected with 401, got %d", recorder.Code)
+	}
+	directorKey := http.Header{"Authorization": {"ApiKey director:director-secret-0001"}}
+	if recorder := serve(http.MethodGet, "/debug/state", nil, directorKey); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected key without admin scope rejected with 403, got %d", recorder.Code)
+	}
+	if recorder := serve(http.MethodGet, "/openclaw/events", nil, directorKey); recorder.Code != http.StatusOK {
+		t.Fatalf("expected events readable with events scope, got %d", recorder.Code)
+	}
+	wrongSecret := http.Header{"Authorization": {"ApiKey director:guess"}}
+	if recorder := serve(http.MethodGet, "/openclaw/events", nil, wrongSecret); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected wrong secret rejected with 401, got %d", recorder.Code)
+	}
+
+	state, _ := json.Marshal(hub.exportState())
+	loadHeader := signed("ops", "ops-secret-000000001", http.MethodPost, "/debug/load-state", state, now)
+	if recorder := serve(http.MethodPost, "/debug/load-state", state, loadHeader); recorder.Code >= 300 {
+		t.Fatalf("expected signed state load accepted, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	tampered := append([]byte(nil), state...)
+	tampered[len(tampered)-2] = ' '
+	if recorder := serve(http.MethodPost, "/debug/load-state", tampered, loadHeader); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected tampered body rejected with 401, got %d", recorder.Code)
+	}
+	stale := signed("ops", "ops-secret-000000001", http.MethodGet, "/debug/state", nil, now.Add(-maxSignatureSkew-time.Second))
+	if recorder := serve(http.MethodGet, "/debug/state", nil, stale); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected stale signature rejected with 401, got %d", recorder.Code)
+	}
+
+	lines := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("expected every admin call audited, got %d lines:\n%s", len(lines), audit.String())
+	}
+	var load auditEntry
+	if err := json.Unmarshal([]byte(lines[4]), &load); err != nil {
+		t.Fatalf("decode audit entry failed: %v", err)
+	}
+	stateSum := sha256.Sum256(state)
+	if load.KeyID != "ops" || load.Path != "/debug/load-state" || load.Scope != scopeAdminState || load.PayloadHash != hex.EncodeToString(stateSum[:]) || load.Denied != "" {
+		t.Fatalf("unexpected audit entry for state load %#v", load)
+	}
+	var forbidden auditEntry
+	_ = json.Unmarshal([]byte(lines[1]), &forbidden)
+	if forbidden.KeyID != "director" || forbidden.Status != http.StatusForbidden || forbidden.Denied != "missing_scope" {
+		t.Fatalf("unexpected audit entry for forbidden call %#v", forbidden)
+	}
+
+	if err := os.WriteFile(keysFile, []byte(`{"keys":[{"id":"x","secret":"long-enough-secret","scopes":["world:delete"]}]}`), 0o600); err != nil {
+		t.Fatalf("write keys failed: %v", err)
+	}
+	if _, err := loadAPIKeys(keysFile); err == nil {
+		t.Fatalf("expected unknown scope rejected")
+	}
+}
+
+func TestAdminAuthReloadsRotatedKeyFile(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys := func(secret string, modTime time.Time) {
+		t.Helper()
+		body := fmt.Sprintf(`{"keys":[{"id":"ops","secret":%q,"scopes":["admin:state"]}]}`, secret)
+		if err := os.WriteFile(keysFile, []byte(body), 0o600); err != nil {
+			t.Fatalf("write keys failed: %v", err)
+		}
+		if err := os.Chtimes(keysFile, modTime, modTime); err != nil {
+			t.Fatalf("touch keys failed: %v", err)
+		}
+	}
+	modTime := time.Unix(1_700_000_000, 0)
+	writeKeys("ops-secret-original01", modTime)
+
+	hub := newWorldHub()
+	auth, err := newAdminAuthFromFile(keysFile, newAuditLog(io.Discard), hub.logger)
+	if err != nil {
+		t.Fatalf("load keys failed: %v", err)
+	}
+	now := time.Unix(1_800_000_000, 0)
+	auth.now = func() time.Time { return now }
+	hub.adminAuth = auth
+	mux := buildAdminMux(hub)
+	status := func(secret string) int {
+		request := httptest.NewRequest(http.MethodGet, "/debug/metrics", nil)
+		request.Header.Set("Authorization", "ApiKey ops:"+secret)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := status("ops-secret-original01"); code != http.StatusOK {
+		t.Fatalf("expected original key accepted, got %d", code)
+	}
+	writeKeys("ops-secret-rotated001", modTime.Add(time.Minute))
+	now = now.Add(apiKeyReloadInterval)
+	if code := status("ops-secret-original01"); code != http.StatusUnauthorized {
+		t.Fatalf("expected rotated-out key rejected, got %d", code)
+	}
+	if code := status("ops-secret-rotated001"); code != http.StatusOK {
+		t.Fatalf("expected rotated key accepted, got %d", code)
+	}
+
+	if err := os.WriteFile(keysFile, []byte(`{"keys":[`), 0o600); err != nil {
+		t.Fatalf("write keys failed: %v", err)
+	}
+	_ = os.Chtimes(keysFile, modTime.Add(2*time.Minute), modTime.Add(2*time.Minute))
+	now = now.Add(apiKeyReloadInterval)
+	if code := status("ops-secret-rotated001"); code != http.StatusOK {
+		t.Fatalf("expected broken key file to keep the previous keys, got %d", code)
+	}
+}
+
+func TestReadinessTracksTickLivenessOverrunsStateAndShutdown(t *testing.T) {
+	hub := newWorldHub()
+	hub.readinessLimits = readinessLimits{MaxTickAge: time.Second, MaxConsecutiveOverruns: 3}
+	now := time.Unix(1_800_000_000, 0)
+
+	status := hub.readiness(now)
+	if status.Ready || !reflect.DeepEqual(status.Problems, []string{"state_not_loaded", "tick_not_started"}) {
+		t.Fatalf("expected fresh hub not ready, got %#v", status)
+	}
+
+	hub.markStateLoaded()
+	hub.recordTickTiming(now.Add(-10*time.Millisecond), now)
+	if status := hub.readiness(now.Add(200 * time.Millisecond)); !status.Ready || status.LastTickAgeMs != 200 {
+		t.Fatalf("expected ready after a timely tick, got %#v", status)
+	}
+	if status := hub.readiness(now.Add(2 * time.Second)); status.Ready || !reflect.DeepEqual(status.Problems, []string{"tick_stalled"}) {
+		t.Fatalf("expected stalled tick loop to fail readiness, got %#v", status)
+	}
+
+	for overrun := 0; overrun < 3; overrun++ {
+		hub.recordTickTiming(now.Add(-2*tickInterval), now)
+	}
+	if status := hub.readiness(now); status.Ready || !reflect.DeepEqual(status.Problems, []string{"tick_overrun"}) || status.Overruns != 3 {
+		t.Fatalf("expected overruns to fail readiness, got %#v", status)
+	}
+	hub.recordTickTiming(now.Add(-tickInterval/2), now)
+	if status := hub.readiness(now); !status.Ready || status.ConsecutiveOverruns != 0 || status.Overruns != 3 {
+		t.Fatalf("expected a timely tick to clear the overrun streak, got %#v", status)
+	}
+
+	hub.beginShutdown()
+	if status := hub.readiness(now); status.Ready || !reflect.DeepEqual(status.Problems, []string{"shutting_down"}) {
+		t.Fatalf("expected shutdown to fail readiness, got %#v", status)
+	}
+}
+
+func TestHealthEndpointsServeProbesAndBuildInfo(t *testing.T) {
+	hub := newWorldHub()
+	hub.worldSeed = "seed-health"
+	mux := buildPublicMux(hub, false)
+
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected /healthz 200, got %d", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected /readyz 503 before the first tick, got %d", recorder.Code)
+	}
+	hub.markStateLoaded()
+	hub.recordTickTiming(time.Now(), time.Now())
+	recorder = httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var status runtimeReadiness
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode readiness failed: %v", err)
+	}
+	if recorder.Code != http.StatusOK || !status.Ready {
+		t.Fatalf("expected /readyz 200 after a tick, got %d %#v", recorder.Code, status)
+	}
+
+	recorder = httptest.NewRecorder()
+	buildAdminMux(hub).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info runtimeVersionInfo
+	if err := json.Unmarshal(recorder.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decode version failed: %v", err)
+	}
+	if recorder.Code != http.StatusOK ||
+		info.ServerBuild != serverBuild ||
+		info.ProtocolVersion != protocolVersion ||
+		info.ContentHash != builtinContent.catalog.Hash ||
+		info.WorldSeed != "seed-health" ||
+		info.UptimeSeconds < 0 {
+		t.Fatalf("unexpected /version %d %#v", recorder.Code, info)
+	}
+}
+
+func TestTickTraceRingKeepsNewestTicksInOrder(t *testing.T) {
+	var ring tickTraceRing
+	for tick := int64(1); tick <= maxTickTraces+5; tick++ {
+		ring.push(tickTrace{Tick: tick})
+	}
+	if all := ring.last(0); len(all) != maxTickTraces || all[0].Tick != 6 || all[len(all)-1].Tick != maxTickTraces+5 {
+		t.Fatalf("expected the newest %d ticks oldest first, got %d from %d", maxTickTraces, len(all), all[0].Tick)
+	}
+	recent := ring.last(3)
+	if len(recent) != 3 || recent[0].Tick != maxTickTraces+3 || recent[2].Tick != maxTickTraces+5 {
+		t.Fatalf("unexpected last(3) %#v", recent)
+	}
+}
+
+func TestDiagnosticsServeOnlyOnAdminListenerAndTracePhases(t *testing.T) {
+	hub := newWorldHub()
+	hub.players["tracer"] = &playerState{PlayerID: "tracer"}
+	hub.adminAuth.allowWithoutKeys = true
+	public := buildPublicMux(hub, true)
+	admin := buildAdminMux(hub)
+
+	for _, path := range []string{"/debug/pprof/", "/debug/profiling", "/debug/tick-trace"} {
+		recorder := httptest.NewRecorder()
+		public.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusNotFound {
+			t.Fatalf("expected %s to be absent from the public listener, got %d", path, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/pprof/goroutine?debug=1", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "goroutine") {
+		t.Fatalf("expected goroutine profile on the admin listener, got %d", recorder.Code)
+	}
+
+	loop := startTickLoop(hub)
+	time.Sleep(200 * time.Millisecond)
+	loop.stop()
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/tick-trace?limit=2", nil))
+	var trace struct {
+		Ticks []tickTrace `json:"ticks"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &trace); err != nil {
+		t.Fatalf("decode tick trace failed: %v", err)
+	}
+	if recorder.Code != http.StatusOK || len(trace.Ticks) != 2 {
+		t.Fatalf("expected two traced ticks, got %d %#v", recorder.Code, trace.Ticks)
+	}
+	latest := trace.Ticks[1]
+	if latest.Tick != hub.exportState().Snapshot.Tick || trace.Ticks[0].Tick != latest.Tick-1 {
+		t.Fatalf("expected the two most recent ticks in order, got %#v", trace.Ticks)
+	}
+	phases := latest.LockWaitMs + latest.MovementMs + latest.SimulationMs + latest.DirectivesMs +
+		latest.SnapshotBuildMs + latest.FanoutMs + latest.FlushMs
+	if latest.Players != 1 || latest.StartedAt == "" || latest.TotalMs < 0 || phases > latest.TotalMs+0.01 {
+		t.Fatalf("unexpected tick trace %#v", latest)
+	}
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/tick-trace?limit=0", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected invalid limit to be rejected, got %d", recorder.Code)
+	}
+
+	t.Cleanup(func() {
+		off := 0
+		hub.applyProfilingSettings(profilingSettings{MutexProfileFraction: &off, BlockProfileRate: &off})
+	})
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/profiling", strings.NewReader(`{"mutexProfileFraction":5}`)))
+	var settings profilingSettings
+	if err := json.Unmarshal(recorder.Body.Bytes(), &settings); err != nil {
+		t.Fatalf("decode profiling settings failed: %v", err)
+	}
+	if recorder.Code != http.StatusOK || *settings.MutexProfileFraction != 5 || *settings.BlockProfileRate != 0 {
+		t.Fatalf("unexpected profiling settings %d %s", recorder.Code, recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/profiling", strings.NewReader(`{"blockProfileRate":1000}`)))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &settings); err != nil {
+		t.Fatalf("decode profiling settings failed: %v", err)
+	}
+	if *settings.MutexProfileFraction != 5 || *settings.BlockProfileRate != 1000 {
+		t.Fatalf("expected both rates to stick, got %s", recorder.Body.String())
+	}
+}
+
+func writeTestContentPack(t *testing.T, dir string, mutate func(name string, raw string) string) {
+	t.Helper()
+	for _, name := range []string{contentItemsFile, contentCombatSlotsFile, contentRecipesFile} {
+		raw, err := os.ReadFile(filepath.Join("content", "default", name))
+		if err != nil {
+			t.Fatalf("read %s failed: %v", name, err)
+		}
+		content := string(raw)
+		if mutate != nil {
+			content = mutate(name, content)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s failed: %v", name, err)
+		}
+	}
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+func nearlyEqual(left float64, right float64) bool {
+	diff := left - right
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff < 0.000001
+}
+
+func findFirstResolvableTargetToken(worldSeed string, tick int64, tickRateHz float64) (string, float64, float64, bool) {
+	for chunkX := -2; chunkX <= 2; chunkX++ {
+		for chunkZ := -2; chunkZ <= 2; chunkZ++ {
+			entities := generateChunkEntitiesForTargetResolution(chunkX, chunkZ, worldSeed)
+			for index, entity := range entities {
+				if entity.entityType != "npc" && entity.entityType != "wild-mon" {
+					continue
+				}
+				token := intToString(chunkX) + ":" + intToString(chunkZ) + ":" + entity.entityType + ":" + intToString(index)
+				worldX, worldZ, resolved := resolveNonPlayerTargetCoordinates(token, worldSeed, tick, tickRateHz)
+				if !resolved {
+					continue
+				}
+				return token, worldX, worldZ, true
+			}
+		}
+	}
+	return "", 0, 0, false
+}
+
+func containsClient(clients []*clientConn, target *clientConn) bool {
+	for _, client := range clients {
+		if client == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMessageLoggerBuildsFieldsOnlyForWrittenLines(t *testing.T) {
+	hub := newWorldHub()
+	var output bytes.Buffer
+	logger, err := newLogger(&output, "info", "json")
+	if err != nil {
+		t.Fatalf("new logger failed: %v", err)
+	}
+	hub.logger = logger
+	hub.advanceOneTick()
+	client := &clientConn{id: "conn-7", playerIDs: map[string]struct{}{}}
+	msgLog := hub.messageLogger(client, clientEnvelope{Type: "craft_request", RequestID: "req-1", Payload: json.RawMessage(`{"playerId":"p1","actionId":"a1"}`)})
+
+	// The hub lock is held by the tick loop for whole ticks; logging must not
+	// wait for it.
+	hub.mu.Lock()
+	msgLog.Debug("action rejected", "reason", "hidden")
+	msgLog.Info("player joined")
+	hub.mu.Unlock()
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the info line written, got %q", output.String())
+	}
+	var line map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("decode log line failed: %v", err)
+	}
+	if line["connId"] != "conn-7" || line["playerId"] != "p1" || line["actionId"] != "a1" || line["requestId"] != "req-1" || line["tick"] != float64(1) {
+		t.Fatalf("expected message fields on the written line, got %#v", line)
 	}
-	return false
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// maxSeenActionsPerPlayer bounds how many applied actions are remembered per
+// player. A client retrying further back than this re-applies.
+const maxSeenActionsPerPlayer = 64
+
+// seenAction is the stored result of an applied mutating action. Kind is the
+// client message type so the same ActionID used for different messages does
+// not collide.
+type seenAction struct {
+	Kind     string          `json:"kind"`
+	ActionID string          `json:"actionId"`
+	Result   json.RawMessage `json:"result"`
+}
+
+type playerSeenActions struct {
+	PlayerID string       `json:"playerId"`
+	Actions  []seenAction `json:"actions"`
+}
+
+// replaySeenActionLocked decodes the stored result of an already-applied
+// action into result and reports whether there was one. Actions without an
+// ActionID are never deduplicated.
+func (h *worldHub) replaySeenActionLocked(playerID string, kind string, actionID string, result any) bool {
+	if playerID == "" || actionID == "" {
+		return false
+	}
+	for _, action := range h.seenActions[playerID] {
+		if action.Kind == kind && action.ActionID == actionID {
+			return json.Unmarshal(action.Result, result) == nil
+		}
+	}
+	return false
+}
+
+// rememberSeenActionLocked stores the result of an accepted action. Rejected
+// actions changed nothing and may succeed when retried, so they are skipped.
+// The cache is keyed by player rather than connection so it survives
+// reconnects.
+func (h *worldHub) rememberSeenActionLocked(playerID string, kind string, actionID string, accepted bool, result any) {
+	if playerID == "" || actionID == "" || !accepted {
+		return
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	actions := append(h.seenActions[playerID], seenAction{Kind: kind, ActionID: actionID, Result: encoded})
+	if len(actions) > maxSeenActionsPerPlayer {
+		actions = append([]seenAction(nil), actions[len(actions)-maxSeenActionsPerPlayer:]...)
+	}
+	h.seenActions[playerID] = actions
+}
+
+// pruneSeenActionsLocked forgets the actions of players that are neither in
+// the world nor parked. A parked player keeps them so a client retrying after
+// rejoining still gets the stored result.
+func (h *worldHub) pruneSeenActionsLocked() {
+	for playerID := range h.seenActions {
+		if _, live := h.players[playerID]; live {
+			continue
+		}
+		if _, parked := h.departedPlayers[playerID]; parked {
+			continue
+		}
+		delete(h.seenActions, playerID)
+	}
+}
+
+func (h *worldHub) exportSeenActionsLocked() []playerSeenActions {
+	playerIDs := make([]string, 0, len(h.seenActions))
+	for playerID := range h.seenActions {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	exported := make([]playerSeenActions, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		exported = append(exported, playerSeenActions{
+			PlayerID: playerID,
+			Actions:  append([]seenAction(nil), h.seenActions[playerID]...),
+		})
+	}
+	return exported
+}
+
+func importSeenActions(entries []playerSeenActions) map[string][]seenAction {
+	imported := make(map[string][]seenAction, len(entries))
+	for _, entry := range entries {
+		playerID := strings.TrimSpace(entry.PlayerID)
+		if playerID == "" {
+			continue
+		}
+		actions := make([]seenAction, 0, len(entry.Actions))
+		for _, action := range entry.Actions {
+			if action.Kind == "" || action.ActionID == "" || !json.Valid(action.Result) {
+				continue
+			}
+			actions = append(actions, action)
+		}
+		if len(actions) > maxSeenActionsPerPlayer {
+			actions = actions[len(actions)-maxSeenActionsPerPlayer:]
+		}
+		if len(actions) > 0 {
+			imported[playerID] = actions
+		}
+	}
+	return imported
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/gorilla/websocket"
+)
+
+// resumeGraceTicks is the default for how long a disconnected player lingers
+// in the world waiting for its client to resume (30s at the default 20Hz tick
+// rate). The hub's lingerTicks overrides it.
+const resumeGraceTicks = 600
+
+// maxResumeBufferedEnvelopes bounds the envelopes buffered for a detached
+// session. A session that overflows resumes with a full state resync instead.
+const maxResumeBufferedEnvelopes = 256
+
+// playerSession tracks the resume token issued to a joined player and, while
+// no connection controls the player, the envelopes it missed.
+type playerSession struct {
+	PlayerID     string
+	Token        string
+	Detached     bool
+	DetachedTick int64
+	Missed       []missedEnvelope
+	Overflowed   bool
+}
+
+// missedEnvelope is an encoded envelope buffered for a detached session.
+// BroadcastSeq is the broadcast's sequence number, or 0 for an envelope
+// addressed to the player.
+type missedEnvelope struct {
+	BroadcastSeq int64
+	Encoded      json.RawMessage
+}
+
+// runtimeSessionGrant hands a client the token it presents to resume.
+type runtimeSessionGrant struct {
+	PlayerID    string `json:"playerId"`
+	ResumeToken string `json:"resumeToken"`
+	GraceTicks  int64  `json:"graceTicks"`
+	Tick        int64  `json:"tick"`
+}
+
+type resumePayload struct {
+	PlayerID    string `json:"playerId"`
+	ResumeToken string `json:"resumeToken"`
+}
+
+type runtimeResumeResult struct {
+	PlayerID string `json:"playerId"`
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+	Replayed int    `json:"replayed"`
+	Resynced bool   `json:"resynced,omitempty"`
+	Tick     int64  `json:"tick"`
+}
+
+// playerResumeToken persists the resume token of a player in the world, so
+// its client can still resume or rejoin after the state is reloaded.
+type playerResumeToken struct {
+	PlayerID    string `json:"playerId"`
+	ResumeToken string `json:"resumeToken"`
+}
+
+func newResumeToken() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buffer)
+}
+
+// openSessionLocked issues a fresh resume token for a join. Joining again
+// rotates the token, so a token stops working once another join claims the
+// player.
+func (h *worldHub) openSessionLocked(playerID string) {
+	h.sessions[playerID] = &playerSession{
+		PlayerID: playerID,
+		Token:    newResumeToken(),
+	}
+}
+
+func (h *worldHub) sessionGrant(playerID string) (runtimeSessionGrant, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	session, ok := h.sessions[playerID]
+	if !ok {
+		return runtimeSessionGrant{}, false
+	}
+	return runtimeSessionGrant{
+		PlayerID:    playerID,
+		ResumeToken: session.Token,
+		GraceTicks:  h.lingerTicks,
+		Tick:        h.tick,
+	}, true
+}
+
+// detachSessionsLocked starts the grace window for every player the closing
+// client controlled that no other connection still controls.
+func (h *worldHub) detachSessionsLocked(client *clientConn) {
+	for playerID := range client.playerIDs {
+		session, ok := h.sessions[playerID]
+		if !ok || session.Detached || h.playerControlledLocked(playerID) {
+			continue
+		}
+		session.Detached = true
+		session.DetachedTick = h.tick
+		session.Missed = nil
+		session.Overflowed = false
+		h.recordWorldEventLocked("player_detached", playerID, map[string]any{
+			"graceTicks": h.lingerTicks,
+		})
+	}
+}
+
+func (h *worldHub) playerControlledLocked(playerID string) bool {
+	for client := range h.clients {
+		if _, owned := client.playerIDs[playerID]; owned {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferMissedEnvelope records an envelope addressed to playerID while its
+// session is detached.
+func (h *worldHub) bufferMissedEnvelope(playerID string, envelope serverEnvelope) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	session, ok := h.sessions[playerID]
+	if !ok || !session.Detached {
+		return
+	}
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		return
+	}
+	bufferSessionEnvelope(session, missedEnvelope{Encoded: encoded})
+}
+
+// bufferMissedBroadcastLocked records an encoded broadcast for every detached
+// session. Snapshots are skipped: the resume reply carries a fresh one.
+func (h *worldHub) bufferMissedBroadcastLocked(envelopeType string, seq int64, encoded []byte) {
+	if envelopeType == "snapshot" {
+		return
+	}
+	for _, session := range h.sessions {
+		if session.Detached {
+			bufferSessionEnvelope(session, missedEnvelope{BroadcastSeq: seq, Encoded: encoded})
+		}
+	}
+}
+
+func bufferSessionEnvelope(session *playerSession, missed missedEnvelope) {
+	if session.Overflowed {
+		return
+	}
+	if len(session.Missed) >= maxResumeBufferedEnvelopes {
+		session.Missed = nil
+		session.Overflowed = true
+		return
+	}
+	session.Missed = append(session.Missed, missed)
+}
+
+// expireDetachedSessionsLocked removes players whose grace window elapsed
+// without a resume. Their state is parked for a later join.
+func (h *worldHub) expireDetachedSessionsLocked() {
+	playerIDs := make([]string, 0)
+	for playerID, session := range h.sessions {
+		if session.Detached && h.tick-session.DetachedTick >= h.lingerTicks {
+			playerIDs = append(playerIDs, playerID)
+		}
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		h.handleLeaveLocked(playerID)
+	}
+}
+
+// resumeSession reattaches a detached player to client. On success it writes
+// the resume_result and the envelopes the player missed, in order, before any
+// newer envelope can reach the connection; rejections are left to the caller.
+// Broadcasts the connection already received live, between connecting and
+// resuming, are not replayed.
+func (h *worldHub) resumeSession(client *clientConn, payload resumePayload, requestID string) runtimeResumeResult {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+
+	h.mu.Lock()
+	result := runtimeResumeResult{PlayerID: payload.PlayerID, Tick: h.tick}
+	session, ok := h.sessions[payload.PlayerID]
+	switch {
+	case payload.PlayerID == "" || payload.ResumeToken == "":
+		result.Reason = "invalid_payload"
+	case !ok:
+		result.Reason = "session_not_found"
+	case session.Token != payload.ResumeToken:
+		result.Reason = "invalid_resume_token"
+	}
+	if result.Reason != "" {
+		h.mu.Unlock()
+		return result
+	}
+
+	missed := make([]json.RawMessage, 0, len(session.Missed))
+	for _, entry := range session.Missed {
+		if entry.BroadcastSeq > 0 && client.firstSeq > 0 && entry.BroadcastSeq >= client.firstSeq {
+			continue
+		}
+		missed = append(missed, entry.Encoded)
+	}
+	result.Accepted = true
+	result.Resynced = session.Overflowed
+	if !result.Resynced {
+		result.Replayed = len(missed)
+	}
+	session.Detached = false
+	session.Missed = nil
+	session.Overflowed = false
+	client.playerIDs[payload.PlayerID] = struct{}{}
+	h.recordWorldEventLocked("player_resumed", payload.PlayerID, map[string]any{
+		"replayed": result.Replayed,
+		"resynced": result.Resynced,
+	})
+	h.mu.Unlock()
+
+	client.armWriteDeadline()
+	if err := client.conn.WriteJSON(serverEnvelope{Type: "resume_result", RequestID: requestID, Payload: result}); err != nil {
+		h.dropClientAfterWriteError(client, "resume_result", err)
+		return result
+	}
+	if result.Resynced {
+		return result
+	}
+	for _, encoded := range missed {
+		client.armWriteDeadline()
+		if err := client.conn.WriteMessage(websocket.TextMessage, encoded); err != nil {
+			h.dropClientAfterWriteError(client, "resume_replay", err)
+			break
+		}
+	}
+	return result
+}
+
+func (h *worldHub) exportResumeTokensLocked() []playerResumeToken {
+	playerIDs := make([]string, 0, len(h.sessions))
+	for playerID := range h.sessions {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	exported := make([]playerResumeToken, 0, len(playerIDs))
+	for _, playerID := range playerIDs {
+		exported = append(exported, playerResumeToken{
+			PlayerID:    playerID,
+			ResumeToken: h.sessions[playerID].Token,
+		})
+	}
+	return exported
+}
+
+func importResumeTokens(entries []playerResumeToken) map[string]string {
+	tokens := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.PlayerID != "" && entry.ResumeToken != "" {
+			tokens[entry.PlayerID] = entry.ResumeToken
+		}
+	}
+	return tokens
+}
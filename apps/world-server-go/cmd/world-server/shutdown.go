@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// runtimeServerShutdown tells clients the server is going away on purpose.
+// ReconnectAfterMs hints how long to wait before reconnecting, so a restart
+// is not met by every client retrying at once; resume tokens stay valid if
+// the state file carries the world across the restart.
+type runtimeServerShutdown struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMs int64  `json:"reconnectAfterMs"`
+	Tick             int64  `json:"tick"`
+}
+
+// shutdownOptions configures a graceful shutdown.
+type shutdownOptions struct {
+	Reason         string
+	ReconnectAfter time.Duration
+	StateFile      string
+}
+
+// tickLoop runs runTickLoop until stopped. stop waits for the tick in progress
+// to finish, so the world is never left half-advanced.
+type tickLoop struct {
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+func startTickLoop(hub *worldHub) *tickLoop {
+	loop := &tickLoop{
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(loop.done)
+		runTickLoop(hub, loop.stopCh)
+	}()
+	return loop
+}
+
+func (l *tickLoop) stop() {
+	close(l.stopCh)
+	<-l.done
+}
+
+func (h *worldHub) beginShutdown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *worldHub) isShuttingDown() bool {
+	return h.shuttingDown.Load()
+}
+
+// readOnlyMessageTypes are still handled while shutting down. Anything else
+// could change the world after the final save and is refused.
+var readOnlyMessageTypes = map[string]bool{
+	"hello":      true,
+	"claim_list": true,
+}
+
+// beginDispatch admits one client message for handling and reports whether
+// it may proceed; endDispatch must follow when it does. Once shutdown has
+// started only read-only messages are admitted, and waitForDispatches lets
+// the ones already admitted finish before the world is saved.
+func (h *worldHub) beginDispatch(messageType string) bool {
+	h.dispatchMu.RLock()
+	if h.shuttingDown.Load() && !readOnlyMessageTypes[messageType] {
+		h.dispatchMu.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (h *worldHub) endDispatch() {
+	h.dispatchMu.RUnlock()
+}
+
+func (h *worldHub) waitForDispatches() {
+	h.dispatchMu.Lock()
+	defer h.dispatchMu.Unlock()
+}
+
+func (h *worldHub) clientCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// flushOutboxes sends everything the last tick queued for clients.
+func (h *worldHub) flushOutboxes() {
+	h.flushCraftProgress()
+	h.flushGroundPickups()
+	h.flushTradeUpdates()
+	h.flushChestRemovals()
+	h.flushContainerCloses()
+	h.flushClaimState()
+}
+
+// notifyShutdown sends server_shutdown to every client followed by a close
+// frame. Writes are synchronous under each client's write lock, so the
+// notice is on the wire before the close.
+func (h *worldHub) notifyShutdown(reason string, reconnectAfter time.Duration) {
+	h.mu.Lock()
+	notice := runtimeServerShutdown{
+		Reason:           reason,
+		ReconnectAfterMs: reconnectAfter.Milliseconds(),
+		Tick:             h.tick,
+	}
+	clients := make([]*clientConn, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		h.sendToClient(client, serverEnvelope{
+			Type:    "server_shutdown",
+			Payload: notice,
+		})
+		client.closeWithReason(websocket.CloseServiceRestart, "server_shutdown")
+	}
+}
+
+// closeRemainingClients drops connections whose clients never answered the
+// close frame.
+func (h *worldHub) closeRemainingClients() {
+	h.mu.Lock()
+	clients := make([]*clientConn, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+	for _, client := range clients {
+		_ = client.conn.Close()
+	}
+}
+
+// runBeforeDeadline runs step in the background and waits for it or for ctx,
+// whichever comes first. A step still running at the deadline is left behind;
+// closing the connections unblocks the write it is stuck in.
+func runBeforeDeadline(ctx context.Context, step func()) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		step()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops the server in order: refuse new connections and mutating
+// messages, stop the tick loop at a tick boundary, let messages already being
+// handled finish, flush queued envelopes, tell clients, save the world, then
+// wait for clients to hang up. Every wait gives up when ctx expires: the
+// remaining connections are closed and the world is saved as it stands.
+func (h *worldHub) shutdown(ctx context.Context, servers []*http.Server, loop *tickLoop, options shutdownOptions) error {
+	h.beginShutdown()
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown %s: %w", server.Addr, err))
+		}
+	}
+	steps := []struct {
+		name string
+		run  func()
+	}{
+		{"stop tick loop", func() {
+			if loop != nil {
+				loop.stop()
+			}
+		}},
+		{"drain dispatches", h.waitForDispatches},
+		{"flush outboxes", h.flushOutboxes},
+		{"notify clients", func() { h.notifyShutdown(options.Reason, options.ReconnectAfter) }},
+	}
+	for _, step := range steps {
+		if err := runBeforeDeadline(ctx, step.run); err != nil {
+			h.closeRemainingClients()
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+			break
+		}
+	}
+	if options.StateFile != "" {
+		if err := h.saveStateFile(options.StateFile); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for h.clientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			h.closeRemainingClients()
+			errs = append(errs, fmt.Errorf("clients still connected at deadline: %w", ctx.Err()))
+			return errors.Join(errs...)
+		case <-ticker.C:
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// saveStateFile writes the exported world state next to path and renames it
+// into place, so a crash mid-write never leaves a truncated state file.
+func (h *worldHub) saveStateFile(path string) error {
+	encoded, err := json.MarshalIndent(h.exportState(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode world state: %w", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("save world state: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(encoded); err != nil {
+		_ = temp.Close()
+		return fmt.Errorf("save world state: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("save world state: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("save world state: %w", err)
+	}
+	return nil
+}
+
+// loadStateFile restores a state file written by saveStateFile. A missing
+// file is not an error: it is the first start.
+func (h *worldHub) loadStateFile(path string) (bool, error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read world state: %w", err)
+	}
+	var state worldDebugState
+	if err := json.Unmarshal(raw, &state); err != nil {
+		return false, fmt.Errorf("parse world state: %w", err)
+	}
+	if _, err := h.importState(state); err != nil {
+		return false, fmt.Errorf("import world state: %w", err)
+	}
+	return true, nil
+}
+
+// logShutdown reports the outcome of a shutdown.
+func logShutdown(logger *slog.Logger, err error, started time.Time) {
+	elapsed := time.Since(started).Round(time.Millisecond).String()
+	if err != nil {
+		logger.Error("shutdown finished with errors", "elapsed", elapsed, "err", err)
+		return
+	}
+	logger.Info("shutdown complete", "elapsed", elapsed)
+}
@@ -0,0 +1,105 @@
+package main
+
+import "math"
+
+// Crafting stations are placed blocks whose type a recipe names as its
+// station. Each one is placed from an item of the same id and gives that item
+// back when broken. The hub indexes them by station and grid cell, so a
+// crafting job only looks at the stations around its player.
+
+// stationCell is a craftStationRange-sized square of the world holding
+// stations of one type.
+type stationCell struct {
+	Station string
+	CellX   int
+	CellZ   int
+}
+
+func stationCellAt(station string, x float64, z float64) stationCell {
+	return stationCell{
+		Station: station,
+		CellX:   int(math.Floor(x / craftStationRange)),
+		CellZ:   int(math.Floor(z / craftStationRange)),
+	}
+}
+
+func blockKeyWorldPosition(key string) (float64, float64) {
+	chunkX, chunkZ, x, _, z := parseBlockKey(key)
+	return blockWorldPosition(chunkX, chunkZ, x, z)
+}
+
+func (h *worldHub) isStationLocked(blockType string) bool {
+	_, ok := h.content.stations[blockType]
+	return ok
+}
+
+func (h *worldHub) placesFromItem(blockType string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.placesFromItemLocked(blockType)
+}
+
+// placesFromItemLocked reports whether placing the block spends an item of
+// the same id: stations and chests do, terrain blocks do not.
+func (h *worldHub) placesFromItemLocked(blockType string) bool {
+	return blockType == chestBlockType || h.isStationLocked(blockType)
+}
+
+func (h *worldHub) indexStationLocked(key string, station string) {
+	x, z := blockKeyWorldPosition(key)
+	cell := stationCellAt(station, x, z)
+	if h.stations[cell] == nil {
+		h.stations[cell] = make(map[string]struct{})
+	}
+	h.stations[cell][key] = struct{}{}
+}
+
+func (h *worldHub) unindexStationLocked(key string, station string) {
+	x, z := blockKeyWorldPosition(key)
+	cell := stationCellAt(station, x, z)
+	delete(h.stations[cell], key)
+	if len(h.stations[cell]) == 0 {
+		delete(h.stations, cell)
+	}
+}
+
+// removeStationLocked drops the item of a station that was broken or built
+// over at its block, for anyone to pick up.
+func (h *worldHub) removeStationLocked(key string, station string, playerID string) {
+	h.unindexStationLocked(key, station)
+	x, z := blockKeyWorldPosition(key)
+	h.spawnGroundItemLocked(runtimeItemStack{ItemID: station, Quantity: 1}, x, z, "station", playerID, "")
+}
+
+// reindexStationsLocked rebuilds the index from the placed blocks, after a
+// state import or when reloaded content changes which blocks are stations.
+func (h *worldHub) reindexStationsLocked() {
+	h.stations = make(map[stationCell]map[string]struct{})
+	for key, blockType := range h.placed {
+		if h.isStationLocked(blockType) {
+			h.indexStationLocked(key, blockType)
+		}
+	}
+}
+
+// stationNearPlayerLocked reports whether a placed block of the station type
+// sits within craftStationRange of the player.
+func (h *worldHub) stationNearPlayerLocked(playerID string, station string) bool {
+	player, ok := h.players[playerID]
+	if !ok {
+		return false
+	}
+	center := stationCellAt(station, player.X, player.Z)
+	for dx := -1; dx <= 1; dx++ {
+		for dz := -1; dz <= 1; dz++ {
+			cell := stationCell{Station: station, CellX: center.CellX + dx, CellZ: center.CellZ + dz}
+			for key := range h.stations[cell] {
+				x, z := blockKeyWorldPosition(key)
+				if math.Hypot(x-player.X, z-player.Z) <= craftStationRange {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// containerSubscriptionRange is how far a player may stand from a placed
+// container while keeping it open. It is wider than the range needed to open
+// a chest so subscriptions do not flap at the edge.
+const containerSubscriptionRange = 8.0
+
+type containerSubscriptionPayload struct {
+	PlayerID    string `json:"playerId"`
+	ActionID    string `json:"actionId"`
+	ContainerID string `json:"containerId"`
+}
+
+type runtimeContainerSubscriptionResult struct {
+	ActionID    string `json:"actionId"`
+	PlayerID    string `json:"playerId"`
+	ContainerID string `json:"containerId"`
+	Operation   string `json:"operation"`
+	Accepted    bool   `json:"accepted"`
+	Reason      string `json:"reason,omitempty"`
+	Tick        int64  `json:"tick"`
+}
+
+// runtimeContainerClosed tells a client the server closed its open container.
+type runtimeContainerClosed struct {
+	PlayerID    string `json:"playerId"`
+	ContainerID string `json:"containerId"`
+	Reason      string `json:"reason"`
+	Tick        int64  `json:"tick"`
+}
+
+// containerPositionLocked returns where a container sits in the world. Chests
+// sit at their block; the shared camp container at the camp anchor.
+func (h *worldHub) containerPositionLocked(containerID string) (float64, float64, bool) {
+	if chest, ok := h.chests[containerID]; ok {
+		blockX, blockZ := blockWorldPosition(chest.ChunkX, chest.ChunkZ, chest.X, chest.Z)
+		return blockX, blockZ, true
+	}
+	return containerAnchor(containerID)
+}
+
+func (h *worldHub) containerInSubscriptionRangeLocked(player *playerState, containerID string) bool {
+	containerX, containerZ, placed := h.containerPositionLocked(containerID)
+	if !placed {
+		return true
+	}
+	return math.Hypot(containerX-player.X, containerZ-player.Z) <= containerSubscriptionRange
+}
+
+// applyContainerOpen subscribes a player to a container's updates and returns
+// its current state. A player has at most one container open; opening another
+// replaces the previous subscription.
+func (h *worldHub) applyContainerOpen(payload containerSubscriptionPayload) (runtimeContainerSubscriptionResult, *runtimeContainerState) {
+	result := runtimeContainerSubscriptionResult{
+		ActionID:    payload.ActionID,
+		PlayerID:    payload.PlayerID,
+		ContainerID: payload.ContainerID,
+		Operation:   "open",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+
+	reject := func(reason string) (runtimeContainerSubscriptionResult, *runtimeContainerState) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordContainerSubscriptionEventLocked(result)
+		return result, nil
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ContainerID == "" {
+		return reject("invalid_payload")
+	}
+	player, ok := h.players[payload.PlayerID]
+	if !ok {
+		return reject("player_not_found")
+	}
+	if reason := h.containerAccessReasonLocked(payload.PlayerID, payload.ContainerID); reason != "" {
+		return reject(reason)
+	}
+	if !h.containerInSubscriptionRangeLocked(player, payload.ContainerID) {
+		return reject("container_out_of_range")
+	}
+
+	h.openContainers[payload.PlayerID] = payload.ContainerID
+	result.Accepted = true
+	h.recordContainerSubscriptionEventLocked(result)
+	state := cloneContainerState(h.ensureContainerStateLocked(payload.ContainerID))
+	return result, &state
+}
+
+func (h *worldHub) applyContainerClose(payload containerSubscriptionPayload) runtimeContainerSubscriptionResult {
+	result := runtimeContainerSubscriptionResult{
+		ActionID:    payload.ActionID,
+		PlayerID:    payload.PlayerID,
+		ContainerID: payload.ContainerID,
+		Operation:   "close",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ContainerID == "" {
+		result.Reason = "invalid_payload"
+	} else if h.openContainers[payload.PlayerID] != payload.ContainerID {
+		result.Reason = "container_not_open"
+	} else {
+		delete(h.openContainers, payload.PlayerID)
+		result.Accepted = true
+	}
+	h.recordContainerSubscriptionEventLocked(result)
+	return result
+}
+
+func (h *worldHub) recordContainerSubscriptionEventLocked(result runtimeContainerSubscriptionResult) {
+	eventType := "container_subscription_rejected"
+	if result.Accepted && result.Operation == "open" {
+		eventType = "container_opened"
+	} else if result.Accepted {
+		eventType = "container_closed"
+	}
+	payload := map[string]any{
+		"actionId":    result.ActionID,
+		"containerId": result.ContainerID,
+		"operation":   result.Operation,
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, payload)
+}
+
+// revalidateContainerSubscriptionsLocked closes subscriptions whose player
+// walked out of range, lost access, or whose container was destroyed, and
+// queues a container_closed notice for each.
+func (h *worldHub) revalidateContainerSubscriptionsLocked() {
+	playerIDs := make([]string, 0, len(h.openContainers))
+	for playerID := range h.openContainers {
+		playerIDs = append(playerIDs, playerID)
+	}
+	sort.Strings(playerIDs)
+	for _, playerID := range playerIDs {
+		containerID := h.openContainers[playerID]
+		player, ok := h.players[playerID]
+		if !ok {
+			delete(h.openContainers, playerID)
+			continue
+		}
+		reason := ""
+		switch access := h.containerAccessReasonLocked(playerID, containerID); access {
+		case "", "container_out_of_range":
+			if !h.containerInSubscriptionRangeLocked(player, containerID) {
+				reason = "out_of_range"
+			}
+		case "unknown_container":
+			reason = "container_removed"
+		default:
+			reason = "access_revoked"
+		}
+		if reason == "" {
+			continue
+		}
+		delete(h.openContainers, playerID)
+		h.recordWorldEventLocked("container_closed", playerID, map[string]any{
+			"containerId": containerID,
+			"reason":      reason,
+		})
+		h.containerCloseOutbox = append(h.containerCloseOutbox, runtimeContainerClosed{
+			PlayerID:    playerID,
+			ContainerID: containerID,
+			Reason:      reason,
+			Tick:        h.tick,
+		})
+	}
+}
+
+func (h *worldHub) drainContainerCloses() []runtimeContainerClosed {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := h.containerCloseOutbox
+	h.containerCloseOutbox = nil
+	return drained
+}
+
+// flushContainerCloses tells players which of their containers the server
+// closed since the last flush.
+func (h *worldHub) flushContainerCloses() {
+	for _, closed := range h.drainContainerCloses() {
+		h.sendToPlayerOwnedRecipients(closed.PlayerID, serverEnvelope{
+			Type:    "container_closed",
+			Payload: closed,
+		})
+	}
+}
+
+// selectContainerSubscribers returns the clients controlling a player who has
+// the container open, plus the clients of actorID when set, without
+// duplicates.
+func (h *worldHub) selectContainerSubscribers(containerID string, actorID string) []*clientConn {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	recipients := make([]*clientConn, 0, 2)
+	for client := range h.clients {
+		for playerID := range client.playerIDs {
+			if playerID == actorID || h.openContainers[playerID] == containerID {
+				recipients = append(recipients, client)
+				break
+			}
+		}
+	}
+	return recipients
+}
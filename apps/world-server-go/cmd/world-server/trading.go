@@ -0,0 +1,561 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	tradeRange          = 6.0
+	maxTradeOfferStacks = 8
+	tradeInviteTTLTicks = 20 * 30
+)
+
+// runtimeTradeOffer holds the stacks a participant has escrowed out of their
+// inventory. Confirmed is cleared whenever either offer changes.
+type runtimeTradeOffer struct {
+	PlayerID  string             `json:"playerId"`
+	Items     []runtimeItemStack `json:"items"`
+	Confirmed bool               `json:"confirmed"`
+}
+
+// runtimeTradeSession is a two-party trade. It starts "pending" until the
+// partner answers with their own trade_request, is "open" while offers are
+// negotiated, and ends "settled" or "cancelled".
+type runtimeTradeSession struct {
+	TradeID     string              `json:"tradeId"`
+	InitiatorID string              `json:"initiatorId"`
+	PartnerID   string              `json:"partnerId"`
+	State       string              `json:"state"`
+	Reason      string              `json:"reason,omitempty"`
+	Revision    int                 `json:"revision"`
+	Offers      []runtimeTradeOffer `json:"offers"`
+	Tick        int64               `json:"tick"`
+}
+
+type tradeRequestPayload struct {
+	PlayerID  string `json:"playerId"`
+	ActionID  string `json:"actionId"`
+	PartnerID string `json:"partnerId"`
+}
+
+type tradeOfferUpdatePayload struct {
+	PlayerID string         `json:"playerId"`
+	ActionID string         `json:"actionId"`
+	TradeID  string         `json:"tradeId"`
+	Items    map[string]int `json:"items"`
+}
+
+type tradeConfirmPayload struct {
+	PlayerID string `json:"playerId"`
+	ActionID string `json:"actionId"`
+	TradeID  string `json:"tradeId"`
+	Revision int    `json:"revision"`
+}
+
+type tradeCancelPayload struct {
+	PlayerID string `json:"playerId"`
+	ActionID string `json:"actionId"`
+	TradeID  string `json:"tradeId"`
+}
+
+type runtimeTradeResult struct {
+	ActionID  string `json:"actionId"`
+	PlayerID  string `json:"playerId"`
+	TradeID   string `json:"tradeId,omitempty"`
+	Operation string `json:"operation"`
+	Accepted  bool   `json:"accepted"`
+	Reason    string `json:"reason,omitempty"`
+	Replayed  bool   `json:"replayed,omitempty"`
+	Tick      int64  `json:"tick"`
+}
+
+// tradeUpdates carries the session and inventories a trade message changed.
+type tradeUpdates struct {
+	session     *runtimeTradeSession
+	inventories []runtimeInventoryState
+}
+
+func cloneTradeSession(session runtimeTradeSession) runtimeTradeSession {
+	offers := make([]runtimeTradeOffer, 0, len(session.Offers))
+	for _, offer := range session.Offers {
+		offers = append(offers, runtimeTradeOffer{
+			PlayerID:  offer.PlayerID,
+			Items:     cloneItemStacks(offer.Items),
+			Confirmed: offer.Confirmed,
+		})
+	}
+	session.Offers = offers
+	return session
+}
+
+func (s *runtimeTradeSession) offerFor(playerID string) *runtimeTradeOffer {
+	for index := range s.Offers {
+		if s.Offers[index].PlayerID == playerID {
+			return &s.Offers[index]
+		}
+	}
+	return nil
+}
+
+func (s *runtimeTradeSession) counterpartOf(playerID string) string {
+	if s.InitiatorID == playerID {
+		return s.PartnerID
+	}
+	return s.InitiatorID
+}
+
+func (h *worldHub) tradeForPlayerLocked(playerID string) (runtimeTradeSession, bool) {
+	for _, tradeID := range h.sortedTradeIDsLocked() {
+		session := h.trades[tradeID]
+		if session.InitiatorID == playerID || session.PartnerID == playerID {
+			return session, true
+		}
+	}
+	return runtimeTradeSession{}, false
+}
+
+func (h *worldHub) sortedTradeIDsLocked() []string {
+	tradeIDs := make([]string, 0, len(h.trades))
+	for tradeID := range h.trades {
+		tradeIDs = append(tradeIDs, tradeID)
+	}
+	sort.Strings(tradeIDs)
+	return tradeIDs
+}
+
+func (h *worldHub) playersInTradeRangeLocked(leftID string, rightID string) bool {
+	left, leftOK := h.players[leftID]
+	right, rightOK := h.players[rightID]
+	if !leftOK || !rightOK {
+		return false
+	}
+	return math.Hypot(left.X-right.X, left.Z-right.Z) <= tradeRange
+}
+
+// applyTradeRequest invites a partner, or opens the session when the partner
+// already invited the requester.
+func (h *worldHub) applyTradeRequest(payload tradeRequestPayload) (runtimeTradeResult, tradeUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := h.newTradeResultLocked(payload.ActionID, payload.PlayerID, "", "request")
+	if h.replaySeenActionLocked(payload.PlayerID, "trade_request", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, tradeUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "trade_request", payload.ActionID, result.Accepted, result)
+	}()
+	partnerID := strings.TrimSpace(payload.PartnerID)
+	if payload.PlayerID == "" || payload.ActionID == "" || partnerID == "" {
+		return h.rejectTradeLocked(result, "invalid_payload")
+	}
+	if partnerID == payload.PlayerID {
+		return h.rejectTradeLocked(result, "self_trade")
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		return h.rejectTradeLocked(result, "player_not_found")
+	}
+	if _, ok := h.players[partnerID]; !ok {
+		return h.rejectTradeLocked(result, "partner_not_found")
+	}
+	if !h.playersInTradeRangeLocked(payload.PlayerID, partnerID) {
+		return h.rejectTradeLocked(result, "partner_out_of_range")
+	}
+
+	if session, ok := h.tradeForPlayerLocked(payload.PlayerID); ok {
+		if session.State != "pending" || session.InitiatorID != partnerID || session.PartnerID != payload.PlayerID {
+			result.TradeID = session.TradeID
+			return h.rejectTradeLocked(result, "trade_busy")
+		}
+		session.State = "open"
+		session.Tick = h.tick
+		h.trades[session.TradeID] = session
+		result.TradeID = session.TradeID
+		result.Accepted = true
+		h.recordTradeEventLocked("trade_opened", payload.PlayerID, session, nil)
+		sessionCopy := cloneTradeSession(session)
+		return result, tradeUpdates{session: &sessionCopy}
+	}
+	if _, busy := h.tradeForPlayerLocked(partnerID); busy {
+		return h.rejectTradeLocked(result, "trade_busy")
+	}
+
+	h.tradeSeq++
+	session := runtimeTradeSession{
+		TradeID:     "trade-" + strconv.FormatInt(h.tradeSeq, 10),
+		InitiatorID: payload.PlayerID,
+		PartnerID:   partnerID,
+		State:       "pending",
+		Offers: []runtimeTradeOffer{
+			{PlayerID: payload.PlayerID, Items: []runtimeItemStack{}},
+			{PlayerID: partnerID, Items: []runtimeItemStack{}},
+		},
+		Tick: h.tick,
+	}
+	h.trades[session.TradeID] = session
+	result.TradeID = session.TradeID
+	result.Accepted = true
+	h.recordTradeEventLocked("trade_requested", payload.PlayerID, session, nil)
+	sessionCopy := cloneTradeSession(session)
+	return result, tradeUpdates{session: &sessionCopy}
+}
+
+// applyTradeOfferUpdate replaces the player's offer. The previous escrow is
+// returned to their inventory before the new offer is taken out of it, and
+// both confirmations are cleared.
+func (h *worldHub) applyTradeOfferUpdate(payload tradeOfferUpdatePayload) (runtimeTradeResult, tradeUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := h.newTradeResultLocked(payload.ActionID, payload.PlayerID, payload.TradeID, "offer")
+	if h.replaySeenActionLocked(payload.PlayerID, "trade_offer_update", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, tradeUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "trade_offer_update", payload.ActionID, result.Accepted, result)
+	}()
+	session, reason := h.openTradeForLocked(payload.PlayerID, payload.ActionID, payload.TradeID)
+	if reason != "" {
+		return h.rejectTradeLocked(result, reason)
+	}
+	itemIDs := sortedResourceKeys(payload.Items)
+	for _, itemID := range itemIDs {
+		if payload.Items[itemID] < 0 || !h.content.isKnownItem(itemID) {
+			return h.rejectTradeLocked(result, "invalid_payload")
+		}
+	}
+
+	offer := session.offerFor(payload.PlayerID)
+	inventoryState := cloneInventoryState(h.ensureInventoryStateLocked(payload.PlayerID))
+	for _, stack := range offer.Items {
+		if h.content.addItemStack(inventoryState.Slots, stack) != stack.Quantity {
+			return h.rejectTradeLocked(result, "inventory_full")
+		}
+	}
+	escrow := make([]runtimeItemStack, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		taken, ok := takeItems(inventoryState.Slots, itemID, payload.Items[itemID])
+		if !ok {
+			return h.rejectTradeLocked(result, "insufficient_items")
+		}
+		escrow = append(escrow, taken...)
+	}
+	if len(escrow) > maxTradeOfferStacks {
+		return h.rejectTradeLocked(result, "offer_too_large")
+	}
+
+	offer.Items = escrow
+	for index := range session.Offers {
+		session.Offers[index].Confirmed = false
+	}
+	session.Revision++
+	session.Tick = h.tick
+	h.trades[session.TradeID] = session
+	h.syncInventoryLocked(&inventoryState)
+
+	result.Accepted = true
+	h.recordTradeEventLocked("trade_offer_updated", payload.PlayerID, session, map[string]any{
+		"items": payload.Items,
+	})
+	sessionCopy := cloneTradeSession(session)
+	return result, tradeUpdates{
+		session:     &sessionCopy,
+		inventories: []runtimeInventoryState{cloneInventoryState(inventoryState)},
+	}
+}
+
+// applyTradeConfirm confirms the offer at the given revision. Once both sides
+// have confirmed the same revision the trade settles atomically.
+func (h *worldHub) applyTradeConfirm(payload tradeConfirmPayload) (runtimeTradeResult, tradeUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := h.newTradeResultLocked(payload.ActionID, payload.PlayerID, payload.TradeID, "confirm")
+	if h.replaySeenActionLocked(payload.PlayerID, "trade_confirm", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, tradeUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "trade_confirm", payload.ActionID, result.Accepted, result)
+	}()
+	session, reason := h.openTradeForLocked(payload.PlayerID, payload.ActionID, payload.TradeID)
+	if reason != "" {
+		return h.rejectTradeLocked(result, reason)
+	}
+	if payload.Revision != session.Revision {
+		return h.rejectTradeLocked(result, "stale_revision")
+	}
+	if !h.playersInTradeRangeLocked(session.InitiatorID, session.PartnerID) {
+		return h.rejectTradeLocked(result, "partner_out_of_range")
+	}
+
+	session.offerFor(payload.PlayerID).Confirmed = true
+	session.Tick = h.tick
+	counterpartID := session.counterpartOf(payload.PlayerID)
+	if !session.offerFor(counterpartID).Confirmed {
+		h.trades[session.TradeID] = session
+		result.Accepted = true
+		h.recordTradeEventLocked("trade_confirmed", payload.PlayerID, session, nil)
+		sessionCopy := cloneTradeSession(session)
+		return result, tradeUpdates{session: &sessionCopy}
+	}
+
+	inventories := make([]runtimeInventoryState, 0, len(session.Offers))
+	for _, offer := range session.Offers {
+		inventoryState := cloneInventoryState(h.ensureInventoryStateLocked(offer.PlayerID))
+		for _, stack := range session.offerFor(session.counterpartOf(offer.PlayerID)).Items {
+			if h.content.addItemStack(inventoryState.Slots, stack) != stack.Quantity {
+				return h.rejectTradeLocked(result, "inventory_full")
+			}
+		}
+		inventories = append(inventories, inventoryState)
+	}
+	for index := range inventories {
+		h.syncInventoryLocked(&inventories[index])
+		inventories[index] = cloneInventoryState(inventories[index])
+	}
+
+	delete(h.trades, session.TradeID)
+	session.State = "settled"
+	result.Accepted = true
+	h.recordTradeEventLocked("trade_confirmed", payload.PlayerID, session, nil)
+	h.recordTradeEventLocked("trade_settled", payload.PlayerID, session, tradeOfferSummary(session))
+	sessionCopy := cloneTradeSession(session)
+	return result, tradeUpdates{session: &sessionCopy, inventories: inventories}
+}
+
+// applyTradeCancel ends the session and returns every escrowed stack.
+func (h *worldHub) applyTradeCancel(payload tradeCancelPayload) (runtimeTradeResult, tradeUpdates) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := h.newTradeResultLocked(payload.ActionID, payload.PlayerID, payload.TradeID, "cancel")
+	if h.replaySeenActionLocked(payload.PlayerID, "trade_cancel", payload.ActionID, &result) {
+		result.Replayed = true
+		return result, tradeUpdates{}
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "trade_cancel", payload.ActionID, result.Accepted, result)
+	}()
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.TradeID == "" {
+		return h.rejectTradeLocked(result, "invalid_payload")
+	}
+	session, ok := h.trades[payload.TradeID]
+	if !ok || session.offerFor(payload.PlayerID) == nil {
+		return h.rejectTradeLocked(result, "trade_not_found")
+	}
+	result.Accepted = true
+	return result, h.cancelTradeLocked(session, payload.PlayerID, "cancelled")
+}
+
+func (h *worldHub) cancelTradeLocked(session runtimeTradeSession, playerID string, reason string) tradeUpdates {
+	inventories := make([]runtimeInventoryState, 0, len(session.Offers))
+	for _, offer := range session.Offers {
+		if len(offer.Items) == 0 {
+			continue
+		}
+		if _, present := h.players[offer.PlayerID]; !present {
+			h.returnEscrowToAbsentPlayerLocked(offer.PlayerID, offer.Items)
+			continue
+		}
+		inventoryState := h.ensureInventoryStateLocked(offer.PlayerID)
+		for _, stack := range offer.Items {
+			added := h.content.addItemStack(inventoryState.Slots, stack)
+			h.dropInventoryOverflowLocked(offer.PlayerID, stack.ItemID, stack.Quantity-added)
+		}
+		h.syncInventoryLocked(&inventoryState)
+		inventories = append(inventories, cloneInventoryState(inventoryState))
+	}
+	delete(h.trades, session.TradeID)
+	session.State = "cancelled"
+	session.Reason = reason
+	session.Tick = h.tick
+	h.recordTradeEventLocked("trade_cancelled", playerID, session, tradeOfferSummary(session))
+	sessionCopy := cloneTradeSession(session)
+	return tradeUpdates{session: &sessionCopy, inventories: inventories}
+}
+
+// returnEscrowToAbsentPlayerLocked gives escrow back to a participant who is
+// not in the world, such as one restored from a state file without its
+// player. A departed player gets it in their parked inventory, with anything
+// that does not fit dropped where they left; otherwise it is dropped at the
+// camp anchor, reserved for them like any overflow.
+func (h *worldHub) returnEscrowToAbsentPlayerLocked(playerID string, items []runtimeItemStack) {
+	departed, parked := h.departedPlayers[playerID]
+	x, z := campAnchorX, campAnchorZ
+	if parked {
+		x, z = departed.X, departed.Z
+		if departed.Inventory.PlayerID != playerID {
+			departed.Inventory = runtimeInventoryState{PlayerID: playerID}
+		}
+		departed.Inventory.Capacity = playerInventoryCapacity
+		departed.Inventory.Slots = h.content.normalizeItemSlots(departed.Inventory.Slots, playerInventoryCapacity)
+	}
+	groundItemIDs := make([]string, 0)
+	for _, stack := range items {
+		if parked {
+			stack.Quantity -= h.content.addItemStack(departed.Inventory.Slots, stack)
+		}
+		if stack.Quantity > 0 {
+			item := h.spawnGroundItemLocked(stack, x, z, "trade_escrow", playerID, playerID)
+			groundItemIDs = append(groundItemIDs, item.GroundItemID)
+		}
+	}
+	if parked {
+		departed.Inventory.Resources = h.content.projectResources(departed.Inventory.Slots)
+		h.departedPlayers[playerID] = departed
+	}
+	h.recordWorldEventLocked("trade_escrow_returned", playerID, map[string]any{
+		"parked":        parked,
+		"groundItemIds": groundItemIDs,
+	})
+}
+
+// cancelPlayerTradeLocked cancels the player's session, if any, queueing the
+// updates for flushTradeUpdates.
+func (h *worldHub) cancelPlayerTradeLocked(playerID string, reason string) {
+	session, ok := h.tradeForPlayerLocked(playerID)
+	if !ok {
+		return
+	}
+	h.tradeOutbox = append(h.tradeOutbox, h.cancelTradeLocked(session, playerID, reason))
+}
+
+// expirePendingTradesLocked cancels invites the partner has not answered
+// within tradeInviteTTLTicks, queueing the updates for flushTradeUpdates. A
+// pending session's Tick is the tick it was requested on.
+func (h *worldHub) expirePendingTradesLocked() {
+	for _, tradeID := range h.sortedTradeIDsLocked() {
+		session := h.trades[tradeID]
+		if session.State != "pending" || h.tick-session.Tick < tradeInviteTTLTicks {
+			continue
+		}
+		h.tradeOutbox = append(h.tradeOutbox, h.cancelTradeLocked(session, session.InitiatorID, "invite_expired"))
+	}
+}
+
+func (h *worldHub) openTradeForLocked(playerID string, actionID string, tradeID string) (runtimeTradeSession, string) {
+	if playerID == "" || actionID == "" || tradeID == "" {
+		return runtimeTradeSession{}, "invalid_payload"
+	}
+	session, ok := h.trades[tradeID]
+	if !ok || session.offerFor(playerID) == nil {
+		return runtimeTradeSession{}, "trade_not_found"
+	}
+	if session.State != "open" {
+		return runtimeTradeSession{}, "trade_not_open"
+	}
+	return cloneTradeSession(session), ""
+}
+
+func (h *worldHub) newTradeResultLocked(actionID string, playerID string, tradeID string, operation string) runtimeTradeResult {
+	return runtimeTradeResult{
+		ActionID:  actionID,
+		PlayerID:  playerID,
+		TradeID:   tradeID,
+		Operation: operation,
+		Tick:      h.tick,
+	}
+}
+
+func (h *worldHub) rejectTradeLocked(result runtimeTradeResult, reason string) (runtimeTradeResult, tradeUpdates) {
+	result.Accepted = false
+	result.Reason = reason
+	h.recordWorldEventLocked("trade_rejected", result.PlayerID, map[string]any{
+		"actionId":  result.ActionID,
+		"tradeId":   result.TradeID,
+		"operation": result.Operation,
+		"reason":    reason,
+	})
+	return result, tradeUpdates{}
+}
+
+func (h *worldHub) recordTradeEventLocked(eventType string, playerID string, session runtimeTradeSession, extra map[string]any) {
+	payload := map[string]any{
+		"tradeId":     session.TradeID,
+		"initiatorId": session.InitiatorID,
+		"partnerId":   session.PartnerID,
+		"state":       session.State,
+		"revision":    session.Revision,
+	}
+	if session.Reason != "" {
+		payload["reason"] = session.Reason
+	}
+	for key, value := range extra {
+		payload[key] = value
+	}
+	h.recordWorldEventLocked(eventType, playerID, payload)
+}
+
+// tradeOfferSummary lists each participant's escrowed units for audit events.
+func tradeOfferSummary(session runtimeTradeSession) map[string]any {
+	offers := make(map[string]any, len(session.Offers))
+	for _, offer := range session.Offers {
+		units := make(map[string]int, len(offer.Items))
+		for _, stack := range offer.Items {
+			units[stack.ItemID] += stack.Quantity
+		}
+		offers[offer.PlayerID] = units
+	}
+	return map[string]any{"offers": offers}
+}
+
+func (h *worldHub) drainTradeUpdates() []tradeUpdates {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := h.tradeOutbox
+	h.tradeOutbox = nil
+	return drained
+}
+
+// sendTradeUpdates sends trade_state to both participants and fresh inventory
+// state to every player whose inventory changed.
+func (h *worldHub) sendTradeUpdates(updates tradeUpdates) {
+	if updates.session != nil {
+		for _, playerID := range []string{updates.session.InitiatorID, updates.session.PartnerID} {
+			h.sendToPlayerOwnedRecipients(playerID, serverEnvelope{
+				Type:    "trade_state",
+				Payload: *updates.session,
+			})
+		}
+	}
+	for _, inventoryState := range updates.inventories {
+		h.sendToPlayerOwnedRecipients(inventoryState.PlayerID, serverEnvelope{
+			Type:    "inventory_state",
+			Payload: inventoryState,
+		})
+	}
+}
+
+func (h *worldHub) flushTradeUpdates() {
+	for _, updates := range h.drainTradeUpdates() {
+		h.sendTradeUpdates(updates)
+	}
+}
+
+func (h *worldHub) exportTradesLocked() []runtimeTradeSession {
+	sessions := make([]runtimeTradeSession, 0, len(h.trades))
+	for _, tradeID := range h.sortedTradeIDsLocked() {
+		sessions = append(sessions, cloneTradeSession(h.trades[tradeID]))
+	}
+	return sessions
+}
+
+func importTrades(sessions []runtimeTradeSession) map[string]runtimeTradeSession {
+	imported := make(map[string]runtimeTradeSession, len(sessions))
+	for _, session := range sessions {
+		if strings.TrimSpace(session.TradeID) == "" || len(session.Offers) != 2 {
+			continue
+		}
+		if session.State != "pending" && session.State != "open" {
+			continue
+		}
+		imported[session.TradeID] = cloneTradeSession(session)
+	}
+	return imported
+}
@@ -0,0 +1,176 @@
+package main
+
+const maxContainerTransactionLines = 16
+
+type containerTransactionLine struct {
+	Operation  string `json:"operation"`
+	ResourceID string `json:"resourceId"`
+	Amount     int    `json:"amount"`
+}
+
+// containerTransactionPayload moves several resources between a player's
+// inventory and one container. Either every line applies or none does.
+type containerTransactionPayload struct {
+	PlayerID    string                     `json:"playerId"`
+	ActionID    string                     `json:"actionId"`
+	ContainerID string                     `json:"containerId"`
+	Lines       []containerTransactionLine `json:"lines"`
+}
+
+type runtimeContainerLineResult struct {
+	Operation  string `json:"operation"`
+	ResourceID string `json:"resourceId"`
+	Amount     int    `json:"amount"`
+	Accepted   bool   `json:"accepted"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// applyContainerTransaction validates every line against working copies of
+// the inventory and container and commits them only when all lines succeed.
+// Lines after a failing one are still evaluated so the result reports every
+// problem at once.
+func (h *worldHub) applyContainerTransaction(
+	payload containerTransactionPayload,
+) (runtimeContainerActionResult, *runtimeInventoryState, *runtimeContainerState) {
+	result := runtimeContainerActionResult{
+		ActionID:    payload.ActionID,
+		PlayerID:    payload.PlayerID,
+		ContainerID: payload.ContainerID,
+		Operation:   "transaction",
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	result.Tick = h.tick
+
+	// A retried transaction replays its result along with the current state so
+	// a reconnecting client can resynchronise. The container comes from the
+	// stored result, not the retry, and is only sent if the player may still
+	// see it.
+	if h.replaySeenActionLocked(payload.PlayerID, "container_transaction", payload.ActionID, &result) {
+		result.Replayed = true
+		inventoryCopy := cloneInventoryState(h.ensureInventoryStateLocked(payload.PlayerID))
+		if h.containerAccessReasonLocked(payload.PlayerID, result.ContainerID) != "" {
+			return result, &inventoryCopy, nil
+		}
+		containerCopy := cloneContainerState(h.ensureContainerStateLocked(result.ContainerID))
+		return result, &inventoryCopy, &containerCopy
+	}
+	defer func() {
+		h.rememberSeenActionLocked(payload.PlayerID, "container_transaction", payload.ActionID, result.Accepted, result)
+	}()
+
+	reject := func(reason string) (runtimeContainerActionResult, *runtimeInventoryState, *runtimeContainerState) {
+		result.Accepted = false
+		result.Reason = reason
+		h.recordContainerTransactionEventLocked(result)
+		return result, nil, nil
+	}
+
+	if payload.PlayerID == "" || payload.ActionID == "" || payload.ContainerID == "" || len(payload.Lines) == 0 {
+		return reject("invalid_payload")
+	}
+	if len(payload.Lines) > maxContainerTransactionLines {
+		return reject("too_many_lines")
+	}
+	if _, ok := h.players[payload.PlayerID]; !ok {
+		return reject("player_not_found")
+	}
+	if reason := h.containerAccessReasonLocked(payload.PlayerID, payload.ContainerID); reason != "" {
+		return reject(reason)
+	}
+
+	inventoryState := cloneInventoryState(h.ensureInventoryStateLocked(payload.PlayerID))
+	containerState := cloneContainerState(h.ensureContainerStateLocked(payload.ContainerID))
+	failed := false
+	result.Lines = make([]runtimeContainerLineResult, 0, len(payload.Lines))
+	for _, line := range payload.Lines {
+		lineResult := runtimeContainerLineResult{
+			Operation:  line.Operation,
+			ResourceID: line.ResourceID,
+			Amount:     line.Amount,
+		}
+		lineResult.Reason = h.applyContainerTransactionLineLocked(line, inventoryState.Slots, containerState.Slots)
+		lineResult.Accepted = lineResult.Reason == ""
+		if !lineResult.Accepted {
+			failed = true
+		}
+		result.Lines = append(result.Lines, lineResult)
+	}
+	if failed {
+		return reject("line_rejected")
+	}
+
+	h.syncInventoryLocked(&inventoryState)
+	h.syncContainerLocked(&containerState)
+	result.Accepted = true
+	h.recordContainerTransactionEventLocked(result)
+
+	inventoryCopy := cloneInventoryState(inventoryState)
+	containerCopy := cloneContainerState(containerState)
+	return result, &inventoryCopy, &containerCopy
+}
+
+// applyContainerTransactionLineLocked moves one line's items between the
+// working slot copies, leaving both untouched when the line fails.
+func (h *worldHub) applyContainerTransactionLineLocked(line containerTransactionLine, inventory []runtimeItemStack, container []runtimeItemStack) string {
+	if line.ResourceID == "" || line.Amount <= 0 {
+		return "invalid_line"
+	}
+	if !h.content.isKnownItem(line.ResourceID) {
+		return "unknown_item"
+	}
+	from, to := inventory, container
+	insufficient, full := "insufficient_resources", "container_full"
+	switch line.Operation {
+	case "deposit":
+	case "withdraw":
+		from, to = container, inventory
+		insufficient, full = "container_insufficient_resources", "inventory_full"
+	default:
+		return "invalid_operation"
+	}
+
+	fromBefore := cloneItemStacks(from)
+	toBefore := cloneItemStacks(to)
+	taken, ok := takeItems(from, line.ResourceID, line.Amount)
+	if !ok {
+		return insufficient
+	}
+	for _, stack := range taken {
+		if h.content.addItemStack(to, stack) != stack.Quantity {
+			copy(from, fromBefore)
+			copy(to, toBefore)
+			return full
+		}
+	}
+	return ""
+}
+
+func (h *worldHub) recordContainerTransactionEventLocked(result runtimeContainerActionResult) {
+	eventType := "container_transaction_rejected"
+	if result.Accepted {
+		eventType = "container_transaction_applied"
+	}
+	lines := make([]map[string]any, 0, len(result.Lines))
+	for _, line := range result.Lines {
+		entry := map[string]any{
+			"operation":  line.Operation,
+			"resourceId": line.ResourceID,
+			"amount":     line.Amount,
+		}
+		if line.Reason != "" {
+			entry["reason"] = line.Reason
+		}
+		lines = append(lines, entry)
+	}
+	payload := map[string]any{
+		"actionId":    result.ActionID,
+		"containerId": result.ContainerID,
+		"lines":       lines,
+	}
+	if result.Reason != "" {
+		payload["reason"] = result.Reason
+	}
+	h.recordWorldEventLocked(eventType, result.PlayerID, payload)
+}
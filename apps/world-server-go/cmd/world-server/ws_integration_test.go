@@ -1,13 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,8 +21,9 @@ import (
 )
 
 type rawServerEnvelope struct {
-	Type    string          `json:"type"`
-	Payload json.RawMessage `json:"payload"`
+	Type      string          `json:"type"`
+	RequestID string          `json:"requestId"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
@@ -48,6 +55,10 @@ func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
 		_, ok := snapshot.Players["p-reconnect"]
 		return ok
 	})
+	grant, ok := hub.sessionGrant("p-reconnect")
+	if !ok {
+		t.Fatalf("expected a session for the joined player")
+	}
 
 	writeClientEnvelope(t, connA, "input", inputPayload{
 		PlayerID: "p-reconnect",
@@ -76,9 +87,9 @@ func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
 		Action:    "place",
 		ChunkX:    0,
 		ChunkZ:    0,
-		X:         3,
+		X:         9,
 		Y:         4,
-		Z:         5,
+		Z:         7,
 		BlockType: "wood",
 	})
 
@@ -86,9 +97,9 @@ func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
 		return delta.Action == "place" &&
 			delta.ChunkX == 0 &&
 			delta.ChunkZ == 0 &&
-			delta.X == 3 &&
+			delta.X == 9 &&
 			delta.Y == 4 &&
-			delta.Z == 5 &&
+			delta.Z == 7 &&
 			delta.BlockType == "wood"
 	})
 
@@ -102,6 +113,11 @@ func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
 		t.Fatalf("dial B failed: %v", err)
 	}
 	defer connB.Close()
+	writeClientEnvelope(t, connB, "join", joinRuntimeRequest{
+		WorldSeed:   "seed-reconnect",
+		PlayerID:    "p-reconnect",
+		ResumeToken: grant.ResumeToken,
+	})
 
 	reconnectedSnapshot := waitForSnapshot(t, connB, func(snapshot worldRuntimeSnapshot) bool {
 		player, ok := snapshot.Players["p-reconnect"]
@@ -116,9 +132,9 @@ func TestWebSocketReconnectResumesMovementAndBlockState(t *testing.T) {
 		return delta.Action == "place" &&
 			delta.ChunkX == 0 &&
 			delta.ChunkZ == 0 &&
-			delta.X == 3 &&
+			delta.X == 9 &&
 			delta.Y == 4 &&
-			delta.Z == 5 &&
+			delta.Z == 7 &&
 			delta.BlockType == "wood"
 	})
 
@@ -258,7 +274,7 @@ func TestCombatReplicationTargetsActorAndNearbyPlayers(t *testing.T) {
 		return ok
 	})
 
-	writeClientEnvelope(t, actorConn, "combat_action", combatActionPayload{
+	writeRequestEnvelope(t, actorConn, "combat_action", "req-actor-1", combatActionPayload{
 		PlayerID:     "actor",
 		ActionID:     "a-actor-1",
 		SlotID:       "slot-2-ember-bolt",
@@ -269,18 +285,22 @@ func TestCombatReplicationTargetsActorAndNearbyPlayers(t *testing.T) {
 		TargetWorldZ: floatPtr(0),
 	})
 
-	actorResult := waitForCombatResult(t, actorConn, func(result runtimeCombatResult) bool {
-		return result.ActionID == "a-actor-1"
-	})
-	if !actorResult.Accepted {
+	actorEnvelope := waitForEnvelopeWithRequestID(t, actorConn, "combat_result", "req-actor-1")
+	var actorResult runtimeCombatResult
+	if err := json.Unmarshal(actorEnvelope.Payload, &actorResult); err != nil {
+		t.Fatalf("decode actor combat result failed: %v", err)
+	}
+	if !actorResult.Accepted || actorResult.ActionID != "a-actor-1" {
 		t.Fatalf("expected actor result accepted, got %#v", actorResult)
 	}
 
-	nearResult := waitForCombatResult(t, nearConn, func(result runtimeCombatResult) bool {
-		return result.ActionID == "a-actor-1"
-	})
-	if !nearResult.Accepted {
-		t.Fatalf("expected near result accepted, got %#v", nearResult)
+	nearEnvelope := waitForEnvelopeWithRequestID(t, nearConn, "combat_result", "")
+	var nearResult runtimeCombatResult
+	if err := json.Unmarshal(nearEnvelope.Payload, &nearResult); err != nil {
+		t.Fatalf("decode near combat result failed: %v", err)
+	}
+	if !nearResult.Accepted || nearResult.ActionID != "a-actor-1" {
+		t.Fatalf("expected near result accepted without the actor's request id, got %#v", nearResult)
 	}
 
 	assertNoCombatResultWithin(t, farConn, 500*time.Millisecond)
@@ -411,7 +431,7 @@ func TestEntityDefeatedEmitsWorldEventAndLoot(t *testing.T) {
 		return result.ActionID == "entity-hit-1" && result.Accepted
 	})
 
-	for tick := int64(0); tick < combatSlotConfigs["slot-5-bomb"].cooldownTicks; tick++ {
+	for tick := int64(0); tick < hub.content.combatSlots["slot-5-bomb"].cooldownTicks; tick++ {
 		hub.advanceOneTick()
 	}
 
@@ -929,13 +949,13 @@ func TestBlockBreakReplicatesInventoryStateToOwnerOnly(t *testing.T) {
 		Action:   "break",
 		ChunkX:   0,
 		ChunkZ:   0,
-		X:        1,
+		X:        8,
 		Y:        1,
-		Z:        1,
+		Z:        8,
 	})
 
 	_ = waitForBlockDelta(t, actorConn, func(delta runtimeBlockDelta) bool {
-		return delta.Action == "break" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 1 && delta.Y == 1 && delta.Z == 1
+		return delta.Action == "break" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 8 && delta.Y == 1 && delta.Z == 8
 	})
 
 	actorInventory := waitForInventoryState(t, actorConn, func(state runtimeInventoryState) bool {
@@ -1013,17 +1033,17 @@ func TestBlockDeltaReplicationScopesByChunkDistance(t *testing.T) {
 		Action:    "place",
 		ChunkX:    0,
 		ChunkZ:    0,
-		X:         2,
+		X:         8,
 		Y:         1,
-		Z:         2,
+		Z:         8,
 		BlockType: "stone",
 	})
 
 	_ = waitForBlockDelta(t, actorConn, func(delta runtimeBlockDelta) bool {
-		return delta.Action == "place" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 2 && delta.Y == 1 && delta.Z == 2
+		return delta.Action == "place" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 8 && delta.Y == 1 && delta.Z == 8
 	})
 	_ = waitForBlockDelta(t, nearConn, func(delta runtimeBlockDelta) bool {
-		return delta.Action == "place" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 2 && delta.Y == 1 && delta.Z == 2
+		return delta.Action == "place" && delta.ChunkX == 0 && delta.ChunkZ == 0 && delta.X == 8 && delta.Y == 1 && delta.Z == 8
 	})
 
 	assertNoEnvelopeTypeWithin(t, farConn, "block_delta", 500*time.Millisecond)
@@ -1240,7 +1260,12 @@ func TestContainerActionReplicatesStateUpdates(t *testing.T) {
 		_, ok := snapshot.Players["peer-container"]
 		return ok
 	})
-	_ = waitForContainerState(t, actorConn, func(state runtimeContainerState) bool {
+	writeClientEnvelope(t, peerConn, "container_open", containerSubscriptionPayload{
+		PlayerID:    "peer-container",
+		ActionID:    "container-sync-open",
+		ContainerID: worldSharedContainerID,
+	})
+	_ = waitForContainerState(t, peerConn, func(state runtimeContainerState) bool {
 		return state.ContainerID == worldSharedContainerID && state.Resources["salvage"] == 0
 	})
 
@@ -1249,9 +1274,9 @@ func TestContainerActionReplicatesStateUpdates(t *testing.T) {
 		Action:   "break",
 		ChunkX:   0,
 		ChunkZ:   0,
-		X:        1,
+		X:        8,
 		Y:        1,
-		Z:        1,
+		Z:        8,
 	})
 	_ = waitForInventoryState(t, actorConn, func(state runtimeInventoryState) bool {
 		return state.PlayerID == "actor-container" && state.Resources["salvage"] == 1
@@ -1375,151 +1400,1885 @@ func TestPrivateContainerAccessIsOwnerOnly(t *testing.T) {
 	}
 }
 
-func writeClientEnvelope(t *testing.T, conn *websocket.Conn, messageType string, payload any) {
-	t.Helper()
-	if err := conn.WriteJSON(clientEnvelope{
-		Type:    messageType,
-		Payload: mustMarshalRawMessage(t, payload),
-	}); err != nil {
-		t.Fatalf("write %s failed: %v", messageType, err)
+func TestBlockActionRejectionReplicatesResultToOwnerOnly(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	actorConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial actor failed: %v", err)
+	}
+	defer actorConn.Close()
+	peerConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial peer failed: %v", err)
+	}
+	defer peerConn.Close()
+
+	_ = waitForSnapshot(t, actorConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, peerConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+
+	writeClientEnvelope(t, actorConn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-block-result",
+		PlayerID:  "actor-reach",
+		StartX:    0,
+		StartZ:    0,
+	})
+	writeClientEnvelope(t, peerConn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-block-result",
+		PlayerID:  "peer-reach",
+		StartX:    4,
+		StartZ:    0,
+	})
+	_ = waitForSnapshot(t, actorConn, func(snapshot worldRuntimeSnapshot) bool {
+		_, ok := snapshot.Players["actor-reach"]
+		return ok
+	})
+	_ = waitForSnapshot(t, peerConn, func(snapshot worldRuntimeSnapshot) bool {
+		_, ok := snapshot.Players["peer-reach"]
+		return ok
+	})
+
+	writeClientEnvelope(t, actorConn, "block_action", blockActionPayload{
+		PlayerID:  "actor-reach",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         0,
+		Y:         1,
+		Z:         0,
+		BlockType: "stone",
+	})
+
+	rejected := waitForBlockActionResult(t, actorConn, func(result runtimeBlockActionResult) bool {
+		return result.PlayerID == "actor-reach" && result.X == 0 && result.Z == 0
+	})
+	if rejected.Accepted || rejected.Reason != "out_of_reach" {
+		t.Fatalf("expected out_of_reach block_action_result, got %#v", rejected)
+	}
+	assertNoEnvelopeTypeWithin(t, peerConn, "block_action_result", 300*time.Millisecond)
+
+	writeClientEnvelope(t, actorConn, "block_action", blockActionPayload{
+		PlayerID:  "actor-reach",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         8,
+		Y:         1,
+		Z:         8,
+		BlockType: "stone",
+	})
+	accepted := waitForBlockActionResult(t, actorConn, func(result runtimeBlockActionResult) bool {
+		return result.PlayerID == "actor-reach" && result.X == 8 && result.Z == 8
+	})
+	if !accepted.Accepted || accepted.BlockType != "stone" {
+		t.Fatalf("expected accepted block_action_result, got %#v", accepted)
 	}
 }
 
-func mustMarshalRawMessage(t *testing.T, payload any) json.RawMessage {
-	t.Helper()
-	encoded, err := json.Marshal(payload)
+func TestClaimCreateReplicatesClaimStateAndProtectsBlocks(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	ownerConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	if err != nil {
-		t.Fatalf("marshal payload failed: %v", err)
+		t.Fatalf("dial owner failed: %v", err)
+	}
+	defer ownerConn.Close()
+	visitorConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial visitor failed: %v", err)
+	}
+	defer visitorConn.Close()
+
+	_ = waitForSnapshot(t, ownerConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, visitorConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+
+	writeClientEnvelope(t, ownerConn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-claim-ws",
+		PlayerID:  "claim-owner",
+		StartX:    0,
+		StartZ:    0,
+	})
+	writeClientEnvelope(t, visitorConn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-claim-ws",
+		PlayerID:  "claim-visitor",
+		StartX:    2,
+		StartZ:    0,
+	})
+	_ = waitForClaimState(t, ownerConn, func(state runtimeClaimState) bool { return len(state.Claims) == 0 })
+	_ = waitForClaimState(t, visitorConn, func(state runtimeClaimState) bool { return len(state.Claims) == 0 })
+
+	writeClientEnvelope(t, ownerConn, "claim_create", claimCreatePayload{
+		PlayerID:  "claim-owner",
+		ActionID:  "claim-ws-1",
+		ClaimID:   "owner-home",
+		Alignment: "chunk",
+	})
+	result := waitForClaimResult(t, ownerConn, func(result runtimeClaimResult) bool {
+		return result.ActionID == "claim-ws-1"
+	})
+	if !result.Accepted {
+		t.Fatalf("expected claim_create accepted, got %#v", result)
+	}
+	visitorState := waitForClaimState(t, visitorConn, func(state runtimeClaimState) bool {
+		return len(state.Claims) == 1
+	})
+	if visitorState.Claims[0].ClaimID != "owner-home" || visitorState.Claims[0].OwnerID != "claim-owner" {
+		t.Fatalf("unexpected replicated claim state %#v", visitorState)
+	}
+
+	writeClientEnvelope(t, visitorConn, "block_action", blockActionPayload{
+		PlayerID: "claim-visitor",
+		Action:   "break",
+		ChunkX:   0,
+		ChunkZ:   0,
+		X:        8,
+		Y:        1,
+		Z:        8,
+	})
+	rejected := waitForBlockActionResult(t, visitorConn, func(result runtimeBlockActionResult) bool {
+		return result.PlayerID == "claim-visitor"
+	})
+	if rejected.Accepted || rejected.Reason != "claim_protected" {
+		t.Fatalf("expected claim_protected block_action_result, got %#v", rejected)
+	}
+
+	writeClientEnvelope(t, visitorConn, "claim_list", claimListPayload{PlayerID: "claim-visitor"})
+	listed := waitForClaimState(t, visitorConn, func(state runtimeClaimState) bool { return len(state.Claims) == 1 })
+	if listed.Claims[0].MaxX != chunkGridCells-1 {
+		t.Fatalf("expected chunk-aligned claim bounds, got %#v", listed.Claims[0])
 	}
-	return encoded
 }
 
-func waitForSnapshot(
-	t *testing.T,
-	conn *websocket.Conn,
-	predicate func(snapshot worldRuntimeSnapshot) bool,
-) worldRuntimeSnapshot {
-	t.Helper()
+func TestJoinSendsContentCatalog(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-catalog",
+		PlayerID:  "catalog-player",
+	})
+
 	deadline := time.Now().Add(2 * time.Second)
 	for time.Now().Before(deadline) {
 		envelope, ok := readServerEnvelope(t, conn)
-		if !ok {
+		if !ok || envelope.Type != "content_catalog" {
 			continue
 		}
-		if envelope.Type != "snapshot" {
-			continue
+		var catalog runtimeContentCatalog
+		if err := json.Unmarshal(envelope.Payload, &catalog); err != nil {
+			t.Fatalf("decode content catalog failed: %v", err)
 		}
-		var snapshot worldRuntimeSnapshot
-		if err := json.Unmarshal(envelope.Payload, &snapshot); err != nil {
-			t.Fatalf("decode snapshot failed: %v", err)
+		if catalog.Hash != builtinContent.catalog.Hash || len(catalog.CombatSlots) != len(builtinContent.catalog.CombatSlots) {
+			t.Fatalf("unexpected content catalog %#v", catalog)
 		}
-		if predicate(snapshot) {
-			return snapshot
+		if len(catalog.Recipes) == 0 || catalog.Recipes[0].ID != "craft-bandage" || catalog.Recipes[0].Keybind != "6" {
+			t.Fatalf("expected recipe metadata in catalog, got %#v", catalog.Recipes)
 		}
+		return
 	}
-	t.Fatalf("timed out waiting for matching snapshot")
-	return worldRuntimeSnapshot{}
+	t.Fatalf("timed out waiting for content_catalog")
 }
 
-func waitForBlockDelta(
-	t *testing.T,
-	conn *websocket.Conn,
-	predicate func(delta runtimeBlockDelta) bool,
-) runtimeBlockDelta {
-	t.Helper()
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		envelope, ok := readServerEnvelope(t, conn)
-		if !ok {
-			continue
-		}
-		if envelope.Type != "block_delta" {
-			continue
+func TestReloadContentBroadcastsCatalogAndMigratedHotbar(t *testing.T) {
+	dir := t.TempDir()
+	writeTestContentPack(t, dir, nil)
+
+	hub := newWorldHub()
+	hub.contentDir = dir
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-reload-ws",
+		PlayerID:  "reload-player",
+	})
+	_ = waitForHotbarState(t, conn, func(state runtimeHotbarState) bool { return len(state.SlotIDs) == 5 })
+
+	writeTestContentPack(t, dir, func(name string, raw string) string {
+		if name != contentCombatSlotsFile {
+			return raw
 		}
-		var delta runtimeBlockDelta
-		if err := json.Unmarshal(envelope.Payload, &delta); err != nil {
-			t.Fatalf("decode block delta failed: %v", err)
+		var slots contentCombatSlotsFileData
+		if err := json.Unmarshal([]byte(raw), &slots); err != nil {
+			t.Fatalf("decode combat slots failed: %v", err)
 		}
-		if predicate(delta) {
-			return delta
+		slots.Slots = append(slots.Slots[:2], slots.Slots[3:]...)
+		slots.DefaultHotbar = append(slots.DefaultHotbar[:2], slots.DefaultHotbar[3:]...)
+		encoded, err := json.Marshal(slots)
+		if err != nil {
+			t.Fatalf("encode combat slots failed: %v", err)
 		}
+		return string(encoded)
+	})
+	ack := hub.reloadContent()
+	if !ack.Accepted {
+		t.Fatalf("expected reload accepted, got %#v", ack)
 	}
-	t.Fatalf("timed out waiting for matching block delta")
-	return runtimeBlockDelta{}
-}
 
-func waitForCombatResult(
-	t *testing.T,
-	conn *websocket.Conn,
-	predicate func(result runtimeCombatResult) bool,
-) runtimeCombatResult {
-	t.Helper()
 	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
+	sawCatalog := false
+	for time.Now().Before(deadline) && !sawCatalog {
 		envelope, ok := readServerEnvelope(t, conn)
-		if !ok {
-			continue
-		}
-		if envelope.Type != "combat_result" {
+		if !ok || envelope.Type != "content_catalog" {
 			continue
 		}
-		var result runtimeCombatResult
-		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
-			t.Fatalf("decode combat result failed: %v", err)
-		}
-		if predicate(result) {
-			return result
+		var catalog runtimeContentCatalog
+		if err := json.Unmarshal(envelope.Payload, &catalog); err != nil {
+			t.Fatalf("decode content catalog failed: %v", err)
 		}
+		sawCatalog = catalog.Hash == ack.Hash && len(catalog.CombatSlots) == 4
 	}
-	t.Fatalf("timed out waiting for matching combat result")
-	return runtimeCombatResult{}
-}
-
-func waitForPlayerInput(
-	t *testing.T,
-	hub *worldHub,
-	playerID string,
-	predicate func(state runtimeInputState) bool,
-) runtimeInputState {
-	t.Helper()
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		hub.mu.Lock()
-		player := hub.players[playerID]
-		var state runtimeInputState
-		if player != nil {
-			state = player.Input
-		}
-		hub.mu.Unlock()
-		if player != nil && predicate(state) {
-			return state
-		}
-		time.Sleep(10 * time.Millisecond)
+	if !sawCatalog {
+		t.Fatalf("timed out waiting for reloaded content_catalog")
 	}
-	t.Fatalf("timed out waiting for matching input state")
-	return runtimeInputState{}
-}
-
-func waitForPlayerRemoval(t *testing.T, hub *worldHub, playerID string) {
-	t.Helper()
-	deadline := time.Now().Add(2 * time.Second)
-	for time.Now().Before(deadline) {
-		hub.mu.Lock()
-		_, ok := hub.players[playerID]
-		hub.mu.Unlock()
-		if !ok {
-			return
-		}
-		time.Sleep(10 * time.Millisecond)
+	migrated := waitForHotbarState(t, conn, func(state runtimeHotbarState) bool { return len(state.SlotIDs) == 4 })
+	if hotbarSlotIndex(migrated, "slot-3-frost-bind") >= 0 {
+		t.Fatalf("expected slot-3-frost-bind removed from migrated hotbar, got %#v", migrated.SlotIDs)
 	}
-	t.Fatalf("timed out waiting for player removal")
 }
 
-func waitForInteractResult(
-	t *testing.T,
-	conn *websocket.Conn,
-	predicate func(result runtimeInteractResult) bool,
-) runtimeInteractResult {
+func TestCraftQueueReplicatesProgressAndCancelRefund(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-craft-queue-ws",
+		PlayerID:  "queue-crafter",
+	})
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool { return state.PlayerID == "queue-crafter" })
+	hub.awardInventoryResources("queue-crafter", map[string]int{"iron_ore": 2, "coal": 1, "furnace": 1})
+	hub.applyBlockAction(blockActionPayload{
+		PlayerID:  "queue-crafter",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         8,
+		Y:         1,
+		Z:         8,
+		BlockType: "furnace",
+	})
+
+	writeClientEnvelope(t, conn, "craft_request", craftRequestPayload{
+		PlayerID: "queue-crafter",
+		ActionID: "queue-smelt-1",
+		RecipeID: "craft-iron-ingot",
+		Count:    1,
+	})
+	result := waitForCraftResult(t, conn, func(result runtimeCraftResult) bool {
+		return result.ActionID == "queue-smelt-1"
+	})
+	if !result.Accepted || !result.Queued || result.JobID != "queue-smelt-1" {
+		t.Fatalf("expected queued craft_result, got %#v", result)
+	}
+	queued := waitForCraftProgress(t, conn, func(progress runtimeCraftProgress) bool {
+		return progress.JobID == "queue-smelt-1"
+	})
+	if queued.State != "queued" || queued.DurationTicks != 60 {
+		t.Fatalf("expected queued craft_progress, got %#v", queued)
+	}
+
+	writeClientEnvelope(t, conn, "craft_cancel", craftCancelPayload{
+		PlayerID: "queue-crafter",
+		ActionID: "queue-cancel-1",
+		JobID:    "queue-smelt-1",
+	})
+	cancelled := waitForCraftProgress(t, conn, func(progress runtimeCraftProgress) bool {
+		return progress.JobID == "queue-smelt-1" && progress.State != "queued"
+	})
+	if cancelled.State != "cancelled" {
+		t.Fatalf("expected cancelled craft_progress, got %#v", cancelled)
+	}
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool {
+		return state.Resources["iron_ore"] == 2 && state.Resources["coal"] == 1
+	})
+}
+
+func TestInventorySlotOpReplicatesResultAndStates(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-slot-ws",
+		PlayerID:  "slot-player",
+	})
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool { return state.PlayerID == "slot-player" })
+	hub.awardInventoryResources("slot-player", map[string]int{"fiber": 5})
+
+	writeClientEnvelope(t, conn, "inventory_slot_op", inventorySlotOpPayload{
+		PlayerID:  "slot-player",
+		ActionID:  "slot-ws-1",
+		Operation: "move",
+		From:      inventorySlotRef{Area: "inventory", Index: 0},
+		To:        inventorySlotRef{Area: "container", ContainerID: worldSharedContainerID, Index: 0},
+		Quantity:  3,
+	})
+
+	deadline := time.Now().Add(2 * time.Second)
+	var result runtimeInventorySlotResult
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok || envelope.Type != "inventory_slot_result" {
+			continue
+		}
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode inventory slot result failed: %v", err)
+		}
+		break
+	}
+	if !result.Accepted || result.ActionID != "slot-ws-1" {
+		t.Fatalf("expected accepted inventory_slot_result, got %#v", result)
+	}
+	inventory := waitForInventoryState(t, conn, func(state runtimeInventoryState) bool {
+		return state.Resources["fiber"] == 2
+	})
+	if inventory.Slots[0].ItemID != "fiber" || inventory.Slots[0].Quantity != 2 {
+		t.Fatalf("expected fiber slot reduced, got %#v", inventory.Slots[0])
+	}
+	container := waitForContainerState(t, conn, func(state runtimeContainerState) bool {
+		return state.ContainerID == worldSharedContainerID && state.Resources["fiber"] == 3
+	})
+	if container.Slots[0].ItemID != "fiber" {
+		t.Fatalf("expected fiber in shared container slot 0, got %#v", container.Slots[0])
+	}
+}
+
+func TestHotbarSwapAndAssignReplicateHotbarState(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{
+		WorldSeed: "seed-hotbar-ws",
+		PlayerID:  "hotbar-player",
+	})
+	_ = waitForHotbarState(t, conn, func(state runtimeHotbarState) bool { return state.PlayerID == "hotbar-player" })
+
+	writeClientEnvelope(t, conn, "hotbar_swap", hotbarSwapPayload{
+		PlayerID:  "hotbar-player",
+		ActionID:  "swap-ws-1",
+		FromIndex: 0,
+		ToIndex:   3,
+	})
+	swap := waitForHotbarResult(t, conn, func(result runtimeHotbarResult) bool { return result.ActionID == "swap-ws-1" })
+	if !swap.Accepted || swap.SlotID != "slot-1-rust-blade" {
+		t.Fatalf("expected accepted swap moving the blade, got %#v", swap)
+	}
+	swapped := waitForHotbarState(t, conn, func(state runtimeHotbarState) bool {
+		return len(state.SlotIDs) > 3 && state.SlotIDs[3] == "slot-1-rust-blade"
+	})
+	if swapped.SlotIDs[0] != "slot-4-bandage" || swapped.StackCounts[0] != 3 {
+		t.Fatalf("expected bandage stack moved to position 0, got %#v", swapped)
+	}
+
+	writeClientEnvelope(t, conn, "hotbar_assign", hotbarAssignPayload{
+		PlayerID:  "hotbar-player",
+		ActionID:  "assign-ws-1",
+		SlotIndex: 0,
+	})
+	unequip := waitForHotbarResult(t, conn, func(result runtimeHotbarResult) bool { return result.ActionID == "assign-ws-1" })
+	if !unequip.Accepted || unequip.Operation != "unequip" {
+		t.Fatalf("expected accepted unequip, got %#v", unequip)
+	}
+	inventory := waitForInventoryState(t, conn, func(state runtimeInventoryState) bool {
+		return countItems(state.Slots, "slot-4-bandage") == 3
+	})
+	if inventory.PlayerID != "hotbar-player" {
+		t.Fatalf("unexpected inventory state %#v", inventory)
+	}
+}
+
+func TestDropItemReplicatesGroundItemAndInteractPickup(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	dropperConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial dropper failed: %v", err)
+	}
+	defer dropperConn.Close()
+	mateConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial mate failed: %v", err)
+	}
+	defer mateConn.Close()
+
+	_ = waitForSnapshot(t, dropperConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, mateConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, dropperConn, "join", joinRuntimeRequest{WorldSeed: "seed-ground-ws", PlayerID: "ground-dropper"})
+	writeClientEnvelope(t, mateConn, "join", joinRuntimeRequest{WorldSeed: "seed-ground-ws", PlayerID: "ground-mate", StartX: 2})
+	_ = waitForInventoryState(t, dropperConn, func(state runtimeInventoryState) bool { return state.PlayerID == "ground-dropper" })
+	_ = waitForInventoryState(t, mateConn, func(state runtimeInventoryState) bool { return state.PlayerID == "ground-mate" })
+	hub.awardInventoryResources("ground-dropper", map[string]int{"wood": 4})
+
+	writeClientEnvelope(t, dropperConn, "drop_item", dropItemPayload{
+		PlayerID:    "ground-dropper",
+		ActionID:    "drop-ws-1",
+		From:        inventorySlotRef{Area: "inventory", Index: 0},
+		RecipientID: "ground-mate",
+	})
+	var drop runtimeDropItemResult
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && drop.ActionID == "" {
+		envelope, ok := readServerEnvelope(t, dropperConn)
+		if !ok || envelope.Type != "drop_item_result" {
+			continue
+		}
+		if err := json.Unmarshal(envelope.Payload, &drop); err != nil {
+			t.Fatalf("decode drop item result failed: %v", err)
+		}
+	}
+	if !drop.Accepted || drop.GroundItemID == "" {
+		t.Fatalf("expected accepted drop_item_result, got %#v", drop)
+	}
+
+	hub.broadcastSnapshots(snapshotReplicationRadius)
+	snapshot := waitForSnapshot(t, mateConn, func(snapshot worldRuntimeSnapshot) bool { return len(snapshot.GroundItems) > 0 })
+	if item := snapshot.GroundItems[0]; item.GroundItemID != drop.GroundItemID || item.OwnerID != "ground-mate" || item.Stack.Quantity != 4 {
+		t.Fatalf("expected replicated ground item reserved for mate, got %#v", item)
+	}
+
+	writeClientEnvelope(t, mateConn, "interact_action", interactActionPayload{
+		PlayerID: "ground-mate",
+		ActionID: "pickup-ws-1",
+		TargetID: drop.GroundItemID,
+	})
+	result := waitForInteractResult(t, mateConn, func(result runtimeInteractResult) bool { return result.ActionID == "pickup-ws-1" })
+	if !result.Accepted {
+		t.Fatalf("expected interact pickup accepted, got %#v", result)
+	}
+	_ = waitForInventoryState(t, mateConn, func(state runtimeInventoryState) bool { return state.Resources["wood"] == 4 })
+}
+
+func TestTradeFlowReplicatesResultsAndStateToBothPlayers(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	initiatorConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial initiator failed: %v", err)
+	}
+	defer initiatorConn.Close()
+	partnerConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial partner failed: %v", err)
+	}
+	defer partnerConn.Close()
+
+	_ = waitForSnapshot(t, initiatorConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, partnerConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, initiatorConn, "join", joinRuntimeRequest{WorldSeed: "seed-trade-ws", PlayerID: "trade-ann"})
+	writeClientEnvelope(t, partnerConn, "join", joinRuntimeRequest{WorldSeed: "seed-trade-ws", PlayerID: "trade-bo", StartX: 2})
+	_ = waitForInventoryState(t, initiatorConn, func(state runtimeInventoryState) bool { return state.PlayerID == "trade-ann" })
+	_ = waitForInventoryState(t, partnerConn, func(state runtimeInventoryState) bool { return state.PlayerID == "trade-bo" })
+	hub.awardInventoryResources("trade-ann", map[string]int{"fiber": 2})
+
+	writeClientEnvelope(t, initiatorConn, "trade_request", tradeRequestPayload{PlayerID: "trade-ann", ActionID: "trade-ws-1", PartnerID: "trade-bo"})
+	invite := waitForTradeResult(t, initiatorConn, func(result runtimeTradeResult) bool { return result.ActionID == "trade-ws-1" })
+	if !invite.Accepted || invite.TradeID == "" {
+		t.Fatalf("expected accepted trade request, got %#v", invite)
+	}
+	pending := waitForTradeState(t, partnerConn, func(session runtimeTradeSession) bool { return session.TradeID == invite.TradeID })
+	if pending.State != "pending" || pending.InitiatorID != "trade-ann" {
+		t.Fatalf("expected partner to see pending invite, got %#v", pending)
+	}
+
+	writeClientEnvelope(t, partnerConn, "trade_request", tradeRequestPayload{PlayerID: "trade-bo", ActionID: "trade-ws-2", PartnerID: "trade-ann"})
+	_ = waitForTradeState(t, initiatorConn, func(session runtimeTradeSession) bool { return session.State == "open" })
+
+	writeClientEnvelope(t, initiatorConn, "trade_offer_update", tradeOfferUpdatePayload{
+		PlayerID: "trade-ann",
+		ActionID: "trade-ws-3",
+		TradeID:  invite.TradeID,
+		Items:    map[string]int{"fiber": 2},
+	})
+	_ = waitForInventoryState(t, initiatorConn, func(state runtimeInventoryState) bool {
+		return state.PlayerID == "trade-ann" && state.Resources["fiber"] == 0
+	})
+	offered := waitForTradeState(t, partnerConn, func(session runtimeTradeSession) bool { return session.Revision == 1 })
+
+	writeClientEnvelope(t, initiatorConn, "trade_confirm", tradeConfirmPayload{PlayerID: "trade-ann", ActionID: "trade-ws-4", TradeID: invite.TradeID, Revision: offered.Revision})
+	writeClientEnvelope(t, partnerConn, "trade_confirm", tradeConfirmPayload{PlayerID: "trade-bo", ActionID: "trade-ws-5", TradeID: invite.TradeID, Revision: offered.Revision})
+	settled := waitForTradeState(t, partnerConn, func(session runtimeTradeSession) bool { return session.State == "settled" })
+	if settled.TradeID != invite.TradeID {
+		t.Fatalf("expected settled trade %q, got %#v", invite.TradeID, settled)
+	}
+	_ = waitForInventoryState(t, partnerConn, func(state runtimeInventoryState) bool {
+		return state.PlayerID == "trade-bo" && state.Resources["fiber"] == 2
+	})
+}
+
+func TestChestContainerStateOnlyReachesPlayersTheACLAdmits(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	ownerConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial owner failed: %v", err)
+	}
+	defer ownerConn.Close()
+	visitorConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial visitor failed: %v", err)
+	}
+	defer visitorConn.Close()
+
+	blockX, blockZ := blockWorldPosition(0, 0, 8, 8)
+	_ = waitForSnapshot(t, ownerConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, visitorConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, ownerConn, "join", joinRuntimeRequest{WorldSeed: "seed-chest-ws", PlayerID: "chest-owner", StartX: blockX, StartZ: blockZ + 1})
+	writeClientEnvelope(t, visitorConn, "join", joinRuntimeRequest{WorldSeed: "seed-chest-ws", PlayerID: "chest-visitor", StartX: blockX + 1, StartZ: blockZ})
+	_ = waitForInventoryState(t, ownerConn, func(state runtimeInventoryState) bool { return state.PlayerID == "chest-owner" })
+	_ = waitForInventoryState(t, visitorConn, func(state runtimeInventoryState) bool { return state.PlayerID == "chest-visitor" })
+	hub.awardInventoryResources("chest-owner", map[string]int{"chest": 1})
+
+	writeClientEnvelope(t, ownerConn, "block_action", blockActionPayload{
+		PlayerID:  "chest-owner",
+		Action:    "place",
+		ChunkX:    0,
+		ChunkZ:    0,
+		X:         8,
+		Y:         2,
+		Z:         8,
+		BlockType: chestBlockType,
+	})
+	placed := waitForBlockActionResult(t, ownerConn, func(result runtimeBlockActionResult) bool { return result.Action == "place" })
+	if !placed.Accepted || placed.ContainerID == "" {
+		t.Fatalf("expected chest placement, got %#v", placed)
+	}
+	private := waitForContainerState(t, ownerConn, func(state runtimeContainerState) bool { return state.ContainerID == placed.ContainerID })
+	if private.Chest == nil || private.Chest.Access != "private" {
+		t.Fatalf("expected owner to receive private chest state, got %#v", private)
+	}
+
+	openChest := func(actionID string) {
+		writeClientEnvelope(t, visitorConn, "container_open", containerSubscriptionPayload{
+			PlayerID:    "chest-visitor",
+			ActionID:    actionID,
+			ContainerID: placed.ContainerID,
+		})
+	}
+	openChest("chest-open-ws-1")
+	forbidden := waitForContainerSubscriptionResult(t, visitorConn, func(result runtimeContainerSubscriptionResult) bool {
+		return result.ActionID == "chest-open-ws-1"
+	})
+	if forbidden.Accepted || forbidden.Reason != "container_forbidden" {
+		t.Fatalf("expected private chest open forbidden, got %#v", forbidden)
+	}
+
+	writeClientEnvelope(t, ownerConn, "container_acl", containerACLPayload{
+		PlayerID:    "chest-owner",
+		ActionID:    "chest-acl-ws-1",
+		ContainerID: placed.ContainerID,
+		Access:      "public",
+	})
+	_ = waitForContainerState(t, ownerConn, func(state runtimeContainerState) bool {
+		return state.ContainerID == placed.ContainerID && state.Chest != nil && state.Chest.Access == "public"
+	})
+	openChest("chest-open-ws-2")
+	// The visitor's first state for this chest must be the public one; the
+	// private placement state was never sent to them.
+	public := waitForContainerState(t, visitorConn, func(state runtimeContainerState) bool { return state.ContainerID == placed.ContainerID })
+	if public.Chest == nil || public.Chest.Access != "public" {
+		t.Fatalf("expected visitor to first see public chest state, got %#v", public)
+	}
+}
+
+func TestContainerUpdatesReachSubscribersOnlyAndCloseOutOfRange(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	actorConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial actor failed: %v", err)
+	}
+	defer actorConn.Close()
+	watcherConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial watcher failed: %v", err)
+	}
+	defer watcherConn.Close()
+	bystanderConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial bystander failed: %v", err)
+	}
+	defer bystanderConn.Close()
+
+	_ = waitForSnapshot(t, actorConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, watcherConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	_ = waitForSnapshot(t, bystanderConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, actorConn, "join", joinRuntimeRequest{WorldSeed: "seed-container-sub", PlayerID: "sub-actor"})
+	writeClientEnvelope(t, watcherConn, "join", joinRuntimeRequest{WorldSeed: "seed-container-sub", PlayerID: "sub-watcher", StartX: 2})
+	writeClientEnvelope(t, bystanderConn, "join", joinRuntimeRequest{WorldSeed: "seed-container-sub", PlayerID: "sub-bystander", StartX: 20})
+	_ = waitForInventoryState(t, actorConn, func(state runtimeInventoryState) bool { return state.PlayerID == "sub-actor" })
+	_ = waitForInventoryState(t, watcherConn, func(state runtimeInventoryState) bool { return state.PlayerID == "sub-watcher" })
+	_ = waitForInventoryState(t, bystanderConn, func(state runtimeInventoryState) bool { return state.PlayerID == "sub-bystander" })
+	hub.awardInventoryResources("sub-actor", map[string]int{"fiber": 2})
+
+	writeClientEnvelope(t, bystanderConn, "container_open", containerSubscriptionPayload{
+		PlayerID:    "sub-bystander",
+		ActionID:    "sub-open-far",
+		ContainerID: worldSharedContainerID,
+	})
+	if result := waitForContainerSubscriptionResult(t, bystanderConn, func(result runtimeContainerSubscriptionResult) bool {
+		return result.ActionID == "sub-open-far"
+	}); result.Accepted || result.Reason != "container_out_of_range" {
+		t.Fatalf("expected far open rejected, got %#v", result)
+	}
+
+	writeClientEnvelope(t, watcherConn, "container_open", containerSubscriptionPayload{
+		PlayerID:    "sub-watcher",
+		ActionID:    "sub-open-1",
+		ContainerID: worldSharedContainerID,
+	})
+	if result := waitForContainerSubscriptionResult(t, watcherConn, func(result runtimeContainerSubscriptionResult) bool {
+		return result.ActionID == "sub-open-1"
+	}); !result.Accepted {
+		t.Fatalf("expected watcher open accepted, got %#v", result)
+	}
+
+	writeClientEnvelope(t, actorConn, "container_action", containerActionPayload{
+		PlayerID:    "sub-actor",
+		ActionID:    "sub-deposit-1",
+		ContainerID: worldSharedContainerID,
+		Operation:   "deposit",
+		ResourceID:  "fiber",
+		Amount:      2,
+	})
+	_ = waitForContainerState(t, actorConn, func(state runtimeContainerState) bool { return state.Resources["fiber"] == 2 })
+	_ = waitForContainerState(t, watcherConn, func(state runtimeContainerState) bool { return state.Resources["fiber"] == 2 })
+
+	hub.mu.Lock()
+	hub.players["sub-watcher"].X = containerSubscriptionRange + 5
+	hub.mu.Unlock()
+	hub.advanceOneTick()
+	hub.flushContainerCloses()
+	var closed runtimeContainerClosed
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && closed.ContainerID == "" {
+		envelope, ok := readServerEnvelope(t, watcherConn)
+		if !ok || envelope.Type != "container_closed" {
+			continue
+		}
+		if err := json.Unmarshal(envelope.Payload, &closed); err != nil {
+			t.Fatalf("decode container closed failed: %v", err)
+		}
+	}
+	if closed.ContainerID != worldSharedContainerID || closed.Reason != "out_of_range" {
+		t.Fatalf("expected out_of_range close, got %#v", closed)
+	}
+
+	assertNoEnvelopeTypeWithin(t, bystanderConn, "container_state", 150*time.Millisecond)
+}
+
+func TestContainerTransactionRepliesWithLineOutcomes(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-container-tx-ws", PlayerID: "tx-player"})
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool { return state.PlayerID == "tx-player" })
+	hub.awardInventoryResources("tx-player", map[string]int{"wood": 2, "fiber": 1})
+
+	writeClientEnvelope(t, conn, "container_transaction", containerTransactionPayload{
+		PlayerID:    "tx-player",
+		ActionID:    "tx-ws-1",
+		ContainerID: worldSharedContainerID,
+		Lines: []containerTransactionLine{
+			{Operation: "deposit", ResourceID: "wood", Amount: 2},
+			{Operation: "deposit", ResourceID: "fiber", Amount: 1},
+		},
+	})
+	result := waitForContainerResult(t, conn, func(result runtimeContainerActionResult) bool { return result.ActionID == "tx-ws-1" })
+	if !result.Accepted || result.Operation != "transaction" || len(result.Lines) != 2 || !result.Lines[1].Accepted {
+		t.Fatalf("expected accepted transaction with line outcomes, got %#v", result)
+	}
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool {
+		return state.Resources["wood"] == 0 && state.Resources["fiber"] == 0
+	})
+	container := waitForContainerState(t, conn, func(state runtimeContainerState) bool { return state.ContainerID == worldSharedContainerID })
+	if container.Resources["wood"] != 2 || container.Resources["fiber"] != 1 {
+		t.Fatalf("expected deposited resources in container, got %#v", container.Resources)
+	}
+}
+
+func TestRetriedCraftAfterReconnectReplaysOriginalResult(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	firstConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial first connection failed: %v", err)
+	}
+	_ = waitForSnapshot(t, firstConn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+	writeClientEnvelope(t, firstConn, "join", joinRuntimeRequest{WorldSeed: "seed-retry-ws", PlayerID: "retry-crafter"})
+	_ = waitForInventoryState(t, firstConn, func(state runtimeInventoryState) bool { return state.PlayerID == "retry-crafter" })
+	hub.awardInventoryResources("retry-crafter", map[string]int{"salvage": 4, "fiber": 3})
+
+	craft := craftRequestPayload{PlayerID: "retry-crafter", ActionID: "retry-craft-1", RecipeID: "craft-bandage", Count: 1}
+	writeClientEnvelope(t, firstConn, "craft_request", craft)
+	first := waitForCraftResult(t, firstConn, func(result runtimeCraftResult) bool { return result.ActionID == "retry-craft-1" })
+	if !first.Accepted || first.Replayed {
+		t.Fatalf("expected first craft applied, got %#v", first)
+	}
+	afterFirst, _ := hub.inventoryStateForPlayer("retry-crafter")
+	grant, _ := hub.sessionGrant("retry-crafter")
+	_ = firstConn.Close()
+
+	secondConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial second connection failed: %v", err)
+	}
+	defer secondConn.Close()
+	_ = waitForSnapshot(t, secondConn, func(snapshot worldRuntimeSnapshot) bool { return true })
+	writeClientEnvelope(t, secondConn, "join", joinRuntimeRequest{WorldSeed: "seed-retry-ws", PlayerID: "retry-crafter", ResumeToken: grant.ResumeToken})
+	_ = waitForInventoryState(t, secondConn, func(state runtimeInventoryState) bool { return state.PlayerID == "retry-crafter" })
+
+	writeClientEnvelope(t, secondConn, "craft_request", craft)
+	retried := waitForCraftResult(t, secondConn, func(result runtimeCraftResult) bool { return result.ActionID == "retry-craft-1" })
+	if !retried.Accepted || !retried.Replayed || retried.Tick != first.Tick {
+		t.Fatalf("expected replayed original craft result, got %#v", retried)
+	}
+	afterRetry, _ := hub.inventoryStateForPlayer("retry-crafter")
+	if afterFirst.Resources["salvage"] != afterRetry.Resources["salvage"] || afterFirst.Resources["fiber"] != afterRetry.Resources["fiber"] {
+		t.Fatalf("expected retry not to craft again\nfirst=%#v\nretry=%#v", afterFirst.Resources, afterRetry.Resources)
+	}
+}
+
+func TestClientMessagesGetTypedErrorsAndEchoRequestIDs(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("{not json")); err != nil {
+		t.Fatalf("write malformed message failed: %v", err)
+	}
+	malformed := waitForClientError(t, conn, func(clientError runtimeClientError) bool { return true })
+	if malformed.Code != "malformed_envelope" || malformed.MessageType != "" {
+		t.Fatalf("expected malformed_envelope error, got %#v", malformed)
+	}
+
+	writeRequestEnvelope(t, conn, "teleport", "req-unknown", map[string]any{"playerId": "err-player"})
+	unknown := waitForClientError(t, conn, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-unknown" })
+	if unknown.Code != "unknown_message_type" || unknown.MessageType != "teleport" {
+		t.Fatalf("expected unknown_message_type error, got %#v", unknown)
+	}
+
+	writeRequestEnvelope(t, conn, "craft_request", "req-bad-payload", map[string]any{"playerId": 42})
+	badPayload := waitForClientError(t, conn, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-bad-payload" })
+	if badPayload.Code != "invalid_payload" || badPayload.MessageType != "craft_request" {
+		t.Fatalf("expected invalid_payload error, got %#v", badPayload)
+	}
+
+	writeRequestEnvelope(t, conn, "block_action", "req-not-owned", blockActionPayload{
+		PlayerID:  "err-stranger",
+		Action:    "place",
+		X:         3,
+		Y:         4,
+		Z:         3,
+		BlockType: "wood",
+	})
+	notOwned := waitForClientError(t, conn, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-not-owned" })
+	if notOwned.Code != "player_not_owned" || notOwned.MessageType != "block_action" {
+		t.Fatalf("expected player_not_owned error, got %#v", notOwned)
+	}
+
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-errors", PlayerID: "err-player"})
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool { return state.PlayerID == "err-player" })
+	writeRequestEnvelope(t, conn, "block_action", "req-block", blockActionPayload{
+		PlayerID:  "err-player",
+		Action:    "break",
+		X:         200,
+		Y:         4,
+		Z:         200,
+		BlockType: "wood",
+	})
+	echoed := waitForEnvelopeWithRequestID(t, conn, "block_action_result", "req-block")
+	var result runtimeBlockActionResult
+	if err := json.Unmarshal(echoed.Payload, &result); err != nil {
+		t.Fatalf("decode block action result failed: %v", err)
+	}
+	if result.Accepted || result.Reason == "" {
+		t.Fatalf("expected rejected block action with a reason, got %#v", result)
+	}
+}
+
+func TestHotbarSelectInputAndLeaveAnswerWithRequestIDs(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return snapshot.Tick == 0 })
+
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-acks", PlayerID: "ack-player"})
+	_ = waitForInventoryState(t, conn, func(state runtimeInventoryState) bool { return state.PlayerID == "ack-player" })
+
+	writeRequestEnvelope(t, conn, "hotbar_select", "req-select-bad", hotbarSelectPayload{PlayerID: "ack-player", SlotIndex: 99})
+	var rejected runtimeHotbarResult
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, conn, "hotbar_result", "req-select-bad").Payload, &rejected); err != nil {
+		t.Fatalf("decode rejected hotbar result failed: %v", err)
+	}
+	if rejected.Accepted || rejected.Operation != "select" || rejected.Reason != "invalid_slot" {
+		t.Fatalf("expected rejected hotbar selection, got %#v", rejected)
+	}
+
+	writeRequestEnvelope(t, conn, "hotbar_select", "req-select", hotbarSelectPayload{PlayerID: "ack-player", SlotIndex: 1})
+	var accepted runtimeHotbarResult
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, conn, "hotbar_result", "req-select").Payload, &accepted); err != nil {
+		t.Fatalf("decode hotbar result failed: %v", err)
+	}
+	if !accepted.Accepted || accepted.SlotIndex != 1 {
+		t.Fatalf("expected accepted hotbar selection, got %#v", accepted)
+	}
+	_ = waitForHotbarState(t, conn, func(state runtimeHotbarState) bool { return state.SelectedIndex == 1 })
+
+	// Only the input that carries a requestId is acknowledged.
+	writeClientEnvelope(t, conn, "input", inputPayload{PlayerID: "ack-player"})
+	writeRequestEnvelope(t, conn, "input", "req-input", inputPayload{PlayerID: "ack-player", Input: runtimeInputState{MoveX: 1}})
+	var inputEnvelope rawServerEnvelope
+	for inputEnvelope.Type != "input_result" {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			t.Fatalf("timed out waiting for input_result")
+		}
+		inputEnvelope = envelope
+	}
+	if inputEnvelope.RequestID != "req-input" {
+		t.Fatalf("expected only the correlated input acknowledged, got request id %q", inputEnvelope.RequestID)
+	}
+	var inputAck runtimeRequestAck
+	if err := json.Unmarshal(inputEnvelope.Payload, &inputAck); err != nil {
+		t.Fatalf("decode input result failed: %v", err)
+	}
+	if !inputAck.Accepted || inputAck.PlayerID != "ack-player" {
+		t.Fatalf("expected accepted input, got %#v", inputAck)
+	}
+
+	writeRequestEnvelope(t, conn, "leave", "req-leave", leavePayload{PlayerID: "ack-player"})
+	var leaveAck runtimeRequestAck
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, conn, "leave_result", "req-leave").Payload, &leaveAck); err != nil {
+		t.Fatalf("decode leave result failed: %v", err)
+	}
+	if !leaveAck.Accepted || leaveAck.PlayerID != "ack-player" {
+		t.Fatalf("expected accepted leave, got %#v", leaveAck)
+	}
+
+	writeRequestEnvelope(t, conn, "leave", "req-leave-again", leavePayload{PlayerID: "ack-player"})
+	notOwned := waitForClientError(t, conn, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-leave-again" })
+	if notOwned.Code != "player_not_owned" {
+		t.Fatalf("expected player_not_owned error, got %#v", notOwned)
+	}
+}
+
+func TestHelloHandshakeWelcomesCompatibleClientsAndClosesOthers(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	compatibleConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial compatible client failed: %v", err)
+	}
+	defer compatibleConn.Close()
+	writeRequestEnvelope(t, compatibleConn, "hello", "hello-1", helloPayload{ProtocolVersion: protocolVersion, Client: "test"})
+	envelope := waitForEnvelopeWithRequestID(t, compatibleConn, "welcome", "hello-1")
+	var welcome runtimeWelcome
+	if err := json.Unmarshal(envelope.Payload, &welcome); err != nil {
+		t.Fatalf("decode welcome failed: %v", err)
+	}
+	if welcome.ProtocolVersion != protocolVersion || welcome.ServerBuild != serverBuild || welcome.Encoding != "json" {
+		t.Fatalf("unexpected welcome %#v", welcome)
+	}
+
+	incompatibleConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial incompatible client failed: %v", err)
+	}
+	defer incompatibleConn.Close()
+	writeClientEnvelope(t, incompatibleConn, "hello", helloPayload{ProtocolVersion: "99.0"})
+	closeErr := waitForCloseError(t, incompatibleConn)
+	if closeErr.Code != protocolMismatchCloseCode || !strings.HasPrefix(closeErr.Text, "unsupported_protocol_version") {
+		t.Fatalf("unexpected close %d %q", closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestResumeTokenReattachesPlayerAndReplaysMissedEnvelopes(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	firstConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial first connection failed: %v", err)
+	}
+	writeClientEnvelope(t, firstConn, "join", joinRuntimeRequest{WorldSeed: "seed-resume", PlayerID: "resumer"})
+	sessionEnvelope := waitForEnvelopeWithRequestID(t, firstConn, "session", "")
+	var grant runtimeSessionGrant
+	if err := json.Unmarshal(sessionEnvelope.Payload, &grant); err != nil {
+		t.Fatalf("decode session grant failed: %v", err)
+	}
+	if grant.PlayerID != "resumer" || grant.ResumeToken == "" {
+		t.Fatalf("expected resume token on join, got %#v", grant)
+	}
+
+	hub.awardInventoryResources("resumer", map[string]int{"iron_ore": 2, "coal": 1, "furnace": 1})
+	if placed, _ := hub.applyBlockAction(blockActionPayload{
+		PlayerID:  "resumer",
+		Action:    "place",
+		X:         8,
+		Y:         1,
+		Z:         8,
+		BlockType: "furnace",
+	}); !placed.Accepted {
+		t.Fatalf("expected furnace placed, got %#v", placed)
+	}
+	if queued, _, _ := hub.applyCraftRequest(craftRequestPayload{
+		PlayerID: "resumer",
+		ActionID: "resume-smelt",
+		RecipeID: "craft-iron-ingot",
+		Count:    1,
+	}); !queued.Queued {
+		t.Fatalf("expected queued craft, got %#v", queued)
+	}
+	_ = firstConn.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mu.Lock()
+		detached := hub.sessions["resumer"] != nil && hub.sessions["resumer"].Detached
+		hub.mu.Unlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for session detach")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	missedInventory, _ := hub.awardInventoryResources("resumer", map[string]int{"fiber": 5})
+	hub.sendToPlayerOwnedRecipients("resumer", serverEnvelope{Type: "inventory_state", Payload: missedInventory})
+
+	secondConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial second connection failed: %v", err)
+	}
+	defer secondConn.Close()
+	writeRequestEnvelope(t, secondConn, "resume", "resume-bad", resumePayload{PlayerID: "resumer", ResumeToken: "not-the-token"})
+	rejectedEnvelope := waitForEnvelopeWithRequestID(t, secondConn, "resume_result", "resume-bad")
+	var rejected runtimeResumeResult
+	if err := json.Unmarshal(rejectedEnvelope.Payload, &rejected); err != nil {
+		t.Fatalf("decode rejected resume failed: %v", err)
+	}
+	if rejected.Accepted || rejected.Reason != "invalid_resume_token" {
+		t.Fatalf("expected wrong token refused, got %#v", rejected)
+	}
+
+	writeRequestEnvelope(t, secondConn, "resume", "resume-ok", resumePayload{PlayerID: "resumer", ResumeToken: grant.ResumeToken})
+	resumedEnvelope := waitForEnvelopeWithRequestID(t, secondConn, "resume_result", "resume-ok")
+	var resumed runtimeResumeResult
+	if err := json.Unmarshal(resumedEnvelope.Payload, &resumed); err != nil {
+		t.Fatalf("decode resume result failed: %v", err)
+	}
+	if !resumed.Accepted || resumed.Replayed != 1 || resumed.Resynced {
+		t.Fatalf("expected resume replaying one envelope, got %#v", resumed)
+	}
+	replayed := waitForInventoryState(t, secondConn, func(state runtimeInventoryState) bool { return state.PlayerID == "resumer" })
+	if replayed.Resources["fiber"] != 5 {
+		t.Fatalf("expected missed inventory update replayed first, got %#v", replayed.Resources)
+	}
+	_ = waitForSnapshot(t, secondConn, func(snapshot worldRuntimeSnapshot) bool {
+		_, ok := snapshot.Players["resumer"]
+		return ok
+	})
+	if queue := hub.craftQueueState("resumer"); len(queue.Jobs) != 1 {
+		t.Fatalf("expected queued craft kept across resume, got %#v", queue.Jobs)
+	}
+
+	writeClientEnvelope(t, secondConn, "hotbar_select", hotbarSelectPayload{PlayerID: "resumer", SlotIndex: 1})
+	_ = waitForHotbarState(t, secondConn, func(state runtimeHotbarState) bool {
+		return state.PlayerID == "resumer" && state.SelectedIndex == 1
+	})
+}
+
+func TestConnectionLimitsCloseIdleOversizedAndFloodingClients(t *testing.T) {
+	hub := newWorldHub()
+	hub.connLimits.HeartbeatInterval = 20 * time.Millisecond
+	hub.connLimits.IdleTimeout = 150 * time.Millisecond
+	hub.connLimits.MaxMessageBytes = 512
+	hub.connLimits.DefaultRate = messageRate{Rate: 1, Burst: 3}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		return conn
+	}
+
+	// Reading answers the heartbeat pings, which keeps a quiet client alive
+	// well past the idle timeout.
+	heartbeatConn := dial()
+	defer heartbeatConn.Close()
+	assertNoEnvelopeTypeWithin(t, heartbeatConn, "error", 400*time.Millisecond)
+	if metrics := hub.connectionMetricsSnapshot(); metrics.Closes["idle_timeout"] != 0 {
+		t.Fatalf("expected ponging client kept alive, got %#v", metrics)
+	}
+	_ = heartbeatConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+
+	oversizedConn := dial()
+	defer oversizedConn.Close()
+	writeClientEnvelope(t, oversizedConn, "hello", helloPayload{ProtocolVersion: protocolVersion, Client: strings.Repeat("x", 1024)})
+	if closeErr := waitForCloseError(t, oversizedConn); closeErr.Code != websocket.CloseMessageTooBig {
+		t.Fatalf("expected message too big close, got %d %q", closeErr.Code, closeErr.Text)
+	}
+
+	floodConn := dial()
+	defer floodConn.Close()
+	writeClientEnvelope(t, floodConn, "join", joinRuntimeRequest{WorldSeed: "seed-limits", PlayerID: "flooder"})
+	// Invented types share the default bucket, and the close reason and
+	// metrics name the bucket rather than echoing the client's type.
+	writeClientEnvelope(t, floodConn, "hotbar_select", hotbarSelectPayload{PlayerID: "flooder", SlotIndex: 1})
+	for index := 0; index < 4; index++ {
+		writeClientEnvelope(t, floodConn, strings.Repeat("t", 200), map[string]any{})
+	}
+	if closeErr := waitForCloseError(t, floodConn); closeErr.Code != rateLimitedCloseCode || closeErr.Text != "rate_limited: *" {
+		t.Fatalf("expected rate limited close, got %d %q", closeErr.Code, closeErr.Text)
+	}
+
+	idleConn := dial()
+	defer idleConn.Close()
+	idleConn.SetPingHandler(func(string) error { return nil })
+	if closeErr := waitForCloseError(t, idleConn); closeErr.Code != idleTimeoutCloseCode {
+		t.Fatalf("expected idle timeout close, got %d %q", closeErr.Code, closeErr.Text)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	var metrics connectionMetrics
+	for time.Now().Before(deadline) {
+		metrics = hub.connectionMetricsSnapshot()
+		if metrics.Open == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if metrics.Closes["idle_timeout"] != 1 || metrics.Closes["rate_limited"] != 1 || metrics.Closes["message_too_large"] != 1 {
+		t.Fatalf("expected each forced close counted once, got %#v", metrics.Closes)
+	}
+	if metrics.RateLimited["*"] != 1 || len(metrics.RateLimited) != 1 || metrics.Accepted != 4 || metrics.Closes["client_closed"] != 1 {
+		t.Fatalf("unexpected connection metrics %#v", metrics)
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	rateLimitedEvent := false
+	for _, event := range hub.eventLog {
+		if event.Type == "connection_closed" && event.Payload["reason"] == "rate_limited" {
+			rateLimitedEvent = true
+		}
+	}
+	if !rateLimitedEvent {
+		t.Fatalf("expected rate limited close recorded in the event log")
+	}
+}
+
+func TestWriteDeadlineDropsClientsThatStopReading(t *testing.T) {
+	hub := newWorldHub()
+	hub.connLimits.WriteTimeout = 100 * time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// The client never reads, so its TCP buffers fill and server writes block.
+	stalled, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer stalled.Close()
+	for deadline := time.Now().Add(2 * time.Second); hub.clientCount() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	filler := serverEnvelope{Type: "filler", Payload: strings.Repeat("x", 256*1024)}
+	started := time.Now()
+	for index := 0; index < 400 && hub.clientCount() > 0; index++ {
+		hub.broadcast(filler)
+	}
+	if hub.clientCount() != 0 {
+		t.Fatalf("expected the stalled client dropped after its writes timed out")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("expected a stalled client to cost about one write timeout, took %s", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && hub.connectionMetricsSnapshot().Closes["write_timeout"] == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if metrics := hub.connectionMetricsSnapshot(); metrics.Closes["write_timeout"] != 1 || metrics.Open != 0 {
+		t.Fatalf("expected one write_timeout close, got %#v", metrics)
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, event := range hub.eventLog {
+		if event.Type == "connection_closed" && event.Payload["reason"] == "write_timeout" {
+			return
+		}
+	}
+	t.Fatalf("expected write_timeout close recorded in the event log")
+}
+
+func TestWebSocketUpgradeEnforcesOriginAllowlist(t *testing.T) {
+	hub := newWorldHub()
+	hub.allowedOrigins = parseOriginAllowlist("https://play.example.com,https://*.example.org")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	_, response, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected disallowed origin refused with 403, got err=%v response=%v", err, response)
+	}
+	for _, header := range []http.Header{
+		{"Origin": {"https://play.example.com"}},
+		{"Origin": {"https://eu.example.org"}},
+		nil,
+	} {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err != nil {
+			t.Fatalf("expected origin %v allowed, got %v", header, err)
+		}
+		_ = waitForSnapshot(t, conn, func(snapshot worldRuntimeSnapshot) bool { return true })
+		_ = conn.Close()
+	}
+}
+
+func TestShutdownMeetsItsDeadlineWithAClientThatNeverReads(t *testing.T) {
+	hub := newWorldHub()
+	// No write deadline, so only the shutdown deadline can free a write to
+	// the stalled client.
+	hub.connLimits.WriteTimeout = 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	stalled, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer stalled.Close()
+	for deadline := time.Now().Add(2 * time.Second); hub.clientCount() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	loop := startTickLoop(hub)
+	filler := serverEnvelope{Type: "filler", Payload: strings.Repeat("x", 256*1024)}
+	go func() {
+		for index := 0; index < 400 && hub.clientCount() > 0; index++ {
+			hub.broadcast(filler)
+		}
+	}()
+	time.Sleep(300 * time.Millisecond)
+
+	stateFile := filepath.Join(t.TempDir(), "world-state.json")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	err = hub.shutdown(ctx, []*http.Server{server.Config}, loop, shutdownOptions{Reason: "server_restarting", StateFile: stateFile})
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("expected shutdown to finish near its deadline, took %s", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the missed deadline reported, got %v", err)
+	}
+	if _, statErr := os.Stat(stateFile); statErr != nil {
+		t.Fatalf("expected the world saved despite the missed deadline: %v", statErr)
+	}
+	for deadline := time.Now().Add(2 * time.Second); hub.clientCount() > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := hub.clientCount(); count != 0 {
+		t.Fatalf("expected the stalled client force-closed, %d still connected", count)
+	}
+}
+
+func TestGracefulShutdownNotifiesClientsBeforeCloseAndSavesState(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conns := make([]*websocket.Conn, 0, 2)
+	for _, playerID := range []string{"stayer-a", "stayer-b"} {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("dial %s failed: %v", playerID, err)
+		}
+		defer conn.Close()
+		writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-shutdown", PlayerID: playerID})
+		waitForEnvelopeWithRequestID(t, conn, "session", "")
+		conns = append(conns, conn)
+	}
+
+	loop := startTickLoop(hub)
+	time.Sleep(120 * time.Millisecond)
+
+	stateFile := filepath.Join(t.TempDir(), "world-state.json")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- hub.shutdown(ctx, []*http.Server{server.Config}, loop, shutdownOptions{
+			Reason:         "server_restarting",
+			ReconnectAfter: 2 * time.Second,
+			StateFile:      stateFile,
+		})
+	}()
+
+	for index, conn := range conns {
+		var notice *runtimeServerShutdown
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for {
+			_, payload, err := conn.ReadMessage()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if !errors.As(err, &closeErr) {
+					t.Fatalf("client %d: expected close frame, got %v", index, err)
+				}
+				if notice == nil {
+					t.Fatalf("client %d: closed before server_shutdown notice", index)
+				}
+				if closeErr.Code != websocket.CloseServiceRestart {
+					t.Fatalf("client %d: expected service restart close, got %d %q", index, closeErr.Code, closeErr.Text)
+				}
+				break
+			}
+			var envelope rawServerEnvelope
+			if err := json.Unmarshal(payload, &envelope); err != nil {
+				t.Fatalf("decode envelope failed: %v", err)
+			}
+			if notice != nil {
+				t.Fatalf("client %d: got %s after server_shutdown", index, envelope.Type)
+			}
+			if envelope.Type == "server_shutdown" {
+				notice = &runtimeServerShutdown{}
+				if err := json.Unmarshal(envelope.Payload, notice); err != nil {
+					t.Fatalf("decode server_shutdown failed: %v", err)
+				}
+				if notice.Reason != "server_restarting" || notice.ReconnectAfterMs != 2000 || notice.Tick <= 0 {
+					t.Fatalf("unexpected shutdown notice %#v", notice)
+				}
+			}
+		}
+	}
+
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Fatalf("shutdown failed: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	finalTick := hub.exportState().Snapshot.Tick
+	time.Sleep(120 * time.Millisecond)
+	if tick := hub.exportState().Snapshot.Tick; tick != finalTick {
+		t.Fatalf("tick loop kept running after shutdown: %d -> %d", finalTick, tick)
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(wsURL, nil); err == nil {
+		t.Fatal("expected new connections to be refused after shutdown")
+	}
+	if metrics := hub.connectionMetricsSnapshot(); metrics.Closes["server_shutdown"] != 2 {
+		t.Fatalf("expected two server_shutdown closes, got %#v", metrics.Closes)
+	}
+
+	restored := newWorldHub()
+	loaded, err := restored.loadStateFile(stateFile)
+	if err != nil || !loaded {
+		t.Fatalf("load saved state failed: loaded=%v err=%v", loaded, err)
+	}
+	state := restored.exportState()
+	if state.Snapshot.Tick != finalTick || state.Snapshot.WorldSeed != "seed-shutdown" {
+		t.Fatalf("unexpected restored snapshot tick=%d seed=%q", state.Snapshot.Tick, state.Snapshot.WorldSeed)
+	}
+	if _, ok := state.Snapshot.Players["stayer-a"]; !ok {
+		t.Fatalf("expected saved players, got %#v", state.Snapshot.Players)
+	}
+}
+
+func TestMessageLogsCarryConnectionPlayerActionAndTick(t *testing.T) {
+	hub := newWorldHub()
+	logs := &lockedBuffer{}
+	logger, err := newLogger(logs, "debug", "json")
+	if err != nil {
+		t.Fatalf("newLogger failed: %v", err)
+	}
+	hub.logger = logger
+	hub.advanceOneTick()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-logs", PlayerID: "tracer"})
+	waitForEnvelopeWithRequestID(t, conn, "session", "")
+	writeRequestEnvelope(t, conn, "craft_request", "craft-req", craftRequestPayload{
+		PlayerID: "tracer",
+		ActionID: "craft-missing",
+		RecipeID: "no_such_recipe",
+		Count:    1,
+	})
+	waitForEnvelopeWithRequestID(t, conn, "craft_result", "craft-req")
+	writeRequestEnvelope(t, conn, "craft_request", "stolen-req", craftRequestPayload{PlayerID: "someone-else", ActionID: "craft-stolen"})
+	waitForClientError(t, conn, func(clientError runtimeClientError) bool {
+		return clientError.Code == "player_not_owned"
+	})
+
+	lines := make([]map[string]any, 0)
+	for _, raw := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("log line is not JSON: %q", raw)
+		}
+		lines = append(lines, line)
+	}
+	findLine := func(message string, actionID string) map[string]any {
+		for _, line := range lines {
+			if line["msg"] == message && (actionID == "" || line["actionId"] == actionID) {
+				return line
+			}
+		}
+		t.Fatalf("no %q log line for action %q in %v", message, actionID, lines)
+		return nil
+	}
+
+	opened := findLine("connection opened", "")
+	connID, _ := opened["connId"].(string)
+	if connID == "" {
+		t.Fatalf("expected a connection id, got %#v", opened)
+	}
+	joined := findLine("player joined", "")
+	if joined["connId"] != connID || joined["playerId"] != "tracer" || joined["level"] != "INFO" {
+		t.Fatalf("unexpected join log %#v", joined)
+	}
+	rejected := findLine("action rejected", "craft-missing")
+	if rejected["connId"] != connID ||
+		rejected["playerId"] != "tracer" ||
+		rejected["messageType"] != "craft_request" ||
+		rejected["requestId"] != "craft-req" ||
+		rejected["tick"] != float64(1) ||
+		rejected["level"] != "DEBUG" ||
+		rejected["reason"] == "" {
+		t.Fatalf("unexpected rejection log %#v", rejected)
+	}
+	notOwned := findLine("message rejected", "craft-stolen")
+	if notOwned["connId"] != connID || notOwned["playerId"] != "someone-else" || notOwned["reason"] != "player_not_owned" {
+		t.Fatalf("unexpected ownership rejection log %#v", notOwned)
+	}
+	if _, err := newLogger(logs, "loud", "json"); err == nil {
+		t.Fatal("expected an unknown log level to be rejected")
+	}
+	if _, err := newLogger(logs, "info", "xml"); err == nil {
+		t.Fatal("expected an unknown log format to be rejected")
+	}
+}
+
+// lockedBuffer collects log output written from server goroutines.
+type lockedBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(payload []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(payload)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+func TestShuttingDownRefusesMutatingMessagesAndWaitsForInFlightOnes(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	writeClientEnvelope(t, conn, "join", joinRuntimeRequest{WorldSeed: "seed-draining", PlayerID: "late"})
+	waitForEnvelopeWithRequestID(t, conn, "session", "")
+	hub.awardInventoryResources("late", map[string]int{"wood": 2})
+
+	if !hub.beginDispatch("container_action") {
+		t.Fatal("expected dispatch to be admitted before shutdown")
+	}
+	waited := make(chan struct{})
+	go func() {
+		hub.beginShutdown()
+		hub.waitForDispatches()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("expected shutdown to wait for the in-flight message")
+	case <-time.After(50 * time.Millisecond):
+	}
+	hub.endDispatch()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown kept waiting after the in-flight message finished")
+	}
+
+	writeRequestEnvelope(t, conn, "container_action", "late-deposit", containerActionPayload{
+		PlayerID:    "late",
+		ActionID:    "late-deposit",
+		ContainerID: playerPrivateContainerID("late"),
+		Operation:   "deposit",
+		ResourceID:  "wood",
+		Amount:      2,
+	})
+	refused := waitForClientError(t, conn, func(clientError runtimeClientError) bool {
+		return clientError.RequestID == "late-deposit"
+	})
+	if refused.Code != "server_shutting_down" {
+		t.Fatalf("expected server_shutting_down, got %#v", refused)
+	}
+	if state, _ := hub.inventoryStateForPlayer("late"); state.Resources["wood"] != 2 {
+		t.Fatalf("expected refused deposit to change nothing, got %#v", state.Resources)
+	}
+	writeRequestEnvelope(t, conn, "claim_list", "late-claims", claimListPayload{})
+	waitForEnvelopeWithRequestID(t, conn, "claim_state", "late-claims")
+}
+
+func TestJoinRequiresResumeTokenToTakeOverPlayer(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	owner, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial owner failed: %v", err)
+	}
+	defer owner.Close()
+	intruder, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial intruder failed: %v", err)
+	}
+	defer intruder.Close()
+
+	writeClientEnvelope(t, owner, "join", joinRuntimeRequest{WorldSeed: "seed-identity", PlayerID: "owned", StartX: 4})
+	var grant runtimeSessionGrant
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, owner, "session", "").Payload, &grant); err != nil {
+		t.Fatalf("decode session grant failed: %v", err)
+	}
+
+	writeRequestEnvelope(t, intruder, "join", "req-hijack", joinRuntimeRequest{WorldSeed: "seed-identity", PlayerID: "owned"})
+	hijack := waitForClientError(t, intruder, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-hijack" })
+	if hijack.Code != "invalid_resume_token" || hijack.MessageType != "join" {
+		t.Fatalf("expected join without the resume token rejected, got %#v", hijack)
+	}
+	writeRequestEnvelope(t, intruder, "input", "req-input", inputPayload{PlayerID: "owned", Input: runtimeInputState{MoveX: 1}})
+	if rejected := waitForClientError(t, intruder, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-input" }); rejected.Code != "player_not_owned" {
+		t.Fatalf("expected input for another player rejected, got %#v", rejected)
+	}
+	writeRequestEnvelope(t, intruder, "leave", "req-leave", leavePayload{PlayerID: "owned"})
+	if rejected := waitForClientError(t, intruder, func(clientError runtimeClientError) bool { return clientError.RequestID == "req-leave" }); rejected.Code != "player_not_owned" {
+		t.Fatalf("expected leave for another player rejected, got %#v", rejected)
+	}
+	hub.mu.Lock()
+	player, ok := hub.players["owned"]
+	untouched := ok && player.X == 4 && player.Input.MoveX == 0
+	hub.mu.Unlock()
+	if !untouched {
+		t.Fatalf("expected owned player untouched, got %#v", player)
+	}
+
+	writeClientEnvelope(t, intruder, "join", joinRuntimeRequest{WorldSeed: "seed-identity", PlayerID: "owned", ResumeToken: grant.ResumeToken})
+	var rotated runtimeSessionGrant
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, intruder, "session", "").Payload, &rotated); err != nil {
+		t.Fatalf("decode rotated session grant failed: %v", err)
+	}
+	if rotated.PlayerID != "owned" || rotated.ResumeToken == grant.ResumeToken {
+		t.Fatalf("expected join with the resume token accepted and the token rotated, got %#v", rotated)
+	}
+}
+
+func TestResumeSkipsBroadcastsTheConnectionReceivedLive(t *testing.T) {
+	hub := newWorldHub()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", buildWSHandler(hub))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	firstConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial first connection failed: %v", err)
+	}
+	writeClientEnvelope(t, firstConn, "join", joinRuntimeRequest{WorldSeed: "seed-live", PlayerID: "echo"})
+	var grant runtimeSessionGrant
+	if err := json.Unmarshal(waitForEnvelopeWithRequestID(t, firstConn, "session", "").Payload, &grant); err != nil {
+		t.Fatalf("decode session grant failed: %v", err)
+	}
+	_ = firstConn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		hub.mu.Lock()
+		detached := hub.playerAwayLocked("echo")
+		hub.mu.Unlock()
+		if detached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for session detach")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	flagBroadcast := func(beat string) serverEnvelope {
+		return serverEnvelope{Type: "world_flag_state", Payload: runtimeWorldFlagState{Flags: map[string]string{"beat": beat}}}
+	}
+	hub.broadcast(flagBroadcast("before"))
+
+	secondConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial second connection failed: %v", err)
+	}
+	defer secondConn.Close()
+	_ = waitForSnapshot(t, secondConn, func(snapshot worldRuntimeSnapshot) bool { return true })
+	hub.broadcast(flagBroadcast("during"))
+	_ = waitForWorldFlagState(t, secondConn, func(state runtimeWorldFlagState) bool { return state.Flags["beat"] == "during" })
+
+	writeRequestEnvelope(t, secondConn, "resume", "resume-live", resumePayload{PlayerID: "echo", ResumeToken: grant.ResumeToken})
+	var resumed runtimeResumeResult
+	seen := map[string]int{}
+	for {
+		envelope, ok := readServerEnvelope(t, secondConn)
+		if !ok {
+			break
+		}
+		switch envelope.Type {
+		case "resume_result":
+			if err := json.Unmarshal(envelope.Payload, &resumed); err != nil {
+				t.Fatalf("decode resume result failed: %v", err)
+			}
+		case "world_flag_state":
+			var state runtimeWorldFlagState
+			if err := json.Unmarshal(envelope.Payload, &state); err != nil {
+				t.Fatalf("decode world flag state failed: %v", err)
+			}
+			seen[state.Flags["beat"]]++
+		}
+	}
+	if !resumed.Accepted || resumed.Replayed != 1 {
+		t.Fatalf("expected only the broadcast sent before connecting replayed, got %#v", resumed)
+	}
+	if seen["before"] != 1 || seen["during"] != 0 {
+		t.Fatalf("expected each broadcast delivered once, got %#v", seen)
+	}
+}
+
+func writeClientEnvelope(t *testing.T, conn *websocket.Conn, messageType string, payload any) {
+	t.Helper()
+	if err := conn.WriteJSON(clientEnvelope{
+		Type:    messageType,
+		Payload: mustMarshalRawMessage(t, payload),
+	}); err != nil {
+		t.Fatalf("write %s failed: %v", messageType, err)
+	}
+}
+
+func writeRequestEnvelope(t *testing.T, conn *websocket.Conn, messageType string, requestID string, payload any) {
+	t.Helper()
+	if err := conn.WriteJSON(clientEnvelope{
+		Type:      messageType,
+		RequestID: requestID,
+		Payload:   mustMarshalRawMessage(t, payload),
+	}); err != nil {
+		t.Fatalf("write %s failed: %v", messageType, err)
+	}
+}
+
+func mustMarshalRawMessage(t *testing.T, payload any) json.RawMessage {
+	t.Helper()
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal payload failed: %v", err)
+	}
+	return encoded
+}
+
+func waitForSnapshot(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(snapshot worldRuntimeSnapshot) bool,
+) worldRuntimeSnapshot {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "snapshot" {
+			continue
+		}
+		var snapshot worldRuntimeSnapshot
+		if err := json.Unmarshal(envelope.Payload, &snapshot); err != nil {
+			t.Fatalf("decode snapshot failed: %v", err)
+		}
+		if predicate(snapshot) {
+			return snapshot
+		}
+	}
+	t.Fatalf("timed out waiting for matching snapshot")
+	return worldRuntimeSnapshot{}
+}
+
+func waitForBlockDelta(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(delta runtimeBlockDelta) bool,
+) runtimeBlockDelta {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "block_delta" {
+			continue
+		}
+		var delta runtimeBlockDelta
+		if err := json.Unmarshal(envelope.Payload, &delta); err != nil {
+			t.Fatalf("decode block delta failed: %v", err)
+		}
+		if predicate(delta) {
+			return delta
+		}
+	}
+	t.Fatalf("timed out waiting for matching block delta")
+	return runtimeBlockDelta{}
+}
+
+func waitForCombatResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeCombatResult) bool,
+) runtimeCombatResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "combat_result" {
+			continue
+		}
+		var result runtimeCombatResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode combat result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching combat result")
+	return runtimeCombatResult{}
+}
+
+func waitForPlayerInput(
+	t *testing.T,
+	hub *worldHub,
+	playerID string,
+	predicate func(state runtimeInputState) bool,
+) runtimeInputState {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.Lock()
+		player := hub.players[playerID]
+		var state runtimeInputState
+		if player != nil {
+			state = player.Input
+		}
+		hub.mu.Unlock()
+		if player != nil && predicate(state) {
+			return state
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for matching input state")
+	return runtimeInputState{}
+}
+
+func waitForPlayerRemoval(t *testing.T, hub *worldHub, playerID string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hub.mu.Lock()
+		_, ok := hub.players[playerID]
+		hub.mu.Unlock()
+		if !ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for player removal")
+}
+
+func waitForInteractResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeInteractResult) bool,
+) runtimeInteractResult {
 	t.Helper()
 	deadline := time.Now().Add(2 * time.Second)
 	for time.Now().Before(deadline) {
@@ -1785,6 +3544,286 @@ func waitForContainerResult(
 	return runtimeContainerActionResult{}
 }
 
+func waitForBlockActionResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeBlockActionResult) bool,
+) runtimeBlockActionResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "block_action_result" {
+			continue
+		}
+		var result runtimeBlockActionResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode block action result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching block action result")
+	return runtimeBlockActionResult{}
+}
+
+func waitForClaimState(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(state runtimeClaimState) bool,
+) runtimeClaimState {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "claim_state" {
+			continue
+		}
+		var state runtimeClaimState
+		if err := json.Unmarshal(envelope.Payload, &state); err != nil {
+			t.Fatalf("decode claim state failed: %v", err)
+		}
+		if predicate(state) {
+			return state
+		}
+	}
+	t.Fatalf("timed out waiting for matching claim state")
+	return runtimeClaimState{}
+}
+
+func waitForClaimResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeClaimResult) bool,
+) runtimeClaimResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "claim_result" {
+			continue
+		}
+		var result runtimeClaimResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode claim result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching claim result")
+	return runtimeClaimResult{}
+}
+
+func waitForCraftProgress(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(progress runtimeCraftProgress) bool,
+) runtimeCraftProgress {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "craft_progress" {
+			continue
+		}
+		var progress runtimeCraftProgress
+		if err := json.Unmarshal(envelope.Payload, &progress); err != nil {
+			t.Fatalf("decode craft progress failed: %v", err)
+		}
+		if predicate(progress) {
+			return progress
+		}
+	}
+	t.Fatalf("timed out waiting for matching craft progress")
+	return runtimeCraftProgress{}
+}
+
+func waitForHotbarResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeHotbarResult) bool,
+) runtimeHotbarResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "hotbar_result" {
+			continue
+		}
+		var result runtimeHotbarResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode hotbar result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching hotbar result")
+	return runtimeHotbarResult{}
+}
+
+func waitForTradeResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeTradeResult) bool,
+) runtimeTradeResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "trade_result" {
+			continue
+		}
+		var result runtimeTradeResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode trade result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching trade result")
+	return runtimeTradeResult{}
+}
+
+func waitForTradeState(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(session runtimeTradeSession) bool,
+) runtimeTradeSession {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "trade_state" {
+			continue
+		}
+		var session runtimeTradeSession
+		if err := json.Unmarshal(envelope.Payload, &session); err != nil {
+			t.Fatalf("decode trade state failed: %v", err)
+		}
+		if predicate(session) {
+			return session
+		}
+	}
+	t.Fatalf("timed out waiting for matching trade state")
+	return runtimeTradeSession{}
+}
+
+func waitForContainerSubscriptionResult(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(result runtimeContainerSubscriptionResult) bool,
+) runtimeContainerSubscriptionResult {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "container_subscription_result" {
+			continue
+		}
+		var result runtimeContainerSubscriptionResult
+		if err := json.Unmarshal(envelope.Payload, &result); err != nil {
+			t.Fatalf("decode container subscription result failed: %v", err)
+		}
+		if predicate(result) {
+			return result
+		}
+	}
+	t.Fatalf("timed out waiting for matching container subscription result")
+	return runtimeContainerSubscriptionResult{}
+}
+
+func waitForClientError(
+	t *testing.T,
+	conn *websocket.Conn,
+	predicate func(clientError runtimeClientError) bool,
+) runtimeClientError {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type != "error" {
+			continue
+		}
+		var clientError runtimeClientError
+		if err := json.Unmarshal(envelope.Payload, &clientError); err != nil {
+			t.Fatalf("decode client error failed: %v", err)
+		}
+		if envelope.RequestID != clientError.RequestID {
+			t.Fatalf("expected envelope and payload request ids to match, got %q and %q", envelope.RequestID, clientError.RequestID)
+		}
+		if predicate(clientError) {
+			return clientError
+		}
+	}
+	t.Fatalf("timed out waiting for matching client error")
+	return runtimeClientError{}
+}
+
+func waitForEnvelopeWithRequestID(t *testing.T, conn *websocket.Conn, envelopeType string, requestID string) rawServerEnvelope {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		envelope, ok := readServerEnvelope(t, conn)
+		if !ok {
+			continue
+		}
+		if envelope.Type == envelopeType && envelope.RequestID == requestID {
+			return envelope
+		}
+	}
+	t.Fatalf("timed out waiting for %s with request id %q", envelopeType, requestID)
+	return rawServerEnvelope{}
+}
+
+// waitForCloseError reads until the server closes the connection and returns
+// the close frame it sent.
+func waitForCloseError(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("expected close frame, got %v", err)
+		}
+		return closeErr
+	}
+}
+
 func assertNoCombatResultWithin(t *testing.T, conn *websocket.Conn, duration time.Duration) {
 	t.Helper()
 	assertNoEnvelopeTypeWithin(t, conn, "combat_result", duration)
@@ -11,7 +11,7 @@ const sourceUrl = urlArg ?? "http://localhost:8787/debug/state";
 const timestamp = new Date().toISOString().replace(/[:.]/g, "-");
 const outputPath = resolve(outArg ?? `data/debug/world-state-${timestamp}.json`);
 
-const response = await fetch(sourceUrl);
+const response = await fetch(sourceUrl, { headers: authHeaders() });
 if (!response.ok) {
   console.error(`Failed to fetch debug state (${response.status} ${response.statusText}) from ${sourceUrl}`);
   process.exit(1);
@@ -33,6 +33,12 @@ const summary = {
 console.log(`Wrote world state snapshot to ${outputPath}`);
 console.log(JSON.stringify(summary, null, 2));
 
+// WORLD_SERVER_API_KEY is "<keyId>:<secret>" for a key with the admin:state scope.
+function authHeaders() {
+  const apiKey = process.env.WORLD_SERVER_API_KEY;
+  return apiKey ? { authorization: `ApiKey ${apiKey}` } : {};
+}
+
 function readArg(values, key) {
   const index = values.findIndex((value) => value === key);
   if (index < 0) {
@@ -29,6 +29,7 @@ try {
     method: "POST",
     headers: {
       "content-type": "application/json",
+      ...authHeaders(),
     },
     body: payload,
   });
@@ -54,6 +55,12 @@ if (!response.ok) {
 console.log(`Loaded world state from ${inputPath} -> ${targetUrl}`);
 console.log(JSON.stringify(ack, null, 2));
 
+// WORLD_SERVER_API_KEY is "<keyId>:<secret>" for a key with the admin:state scope.
+function authHeaders() {
+  const apiKey = process.env.WORLD_SERVER_API_KEY;
+  return apiKey ? { authorization: `ApiKey ${apiKey}` } : {};
+}
+
 function readArg(values, key) {
   const index = values.findIndex((value) => value === key);
   if (index < 0) {